@@ -4,6 +4,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"os/signal"
+	"slices"
+	"syscall"
 	"time"
 
 	"github.com/briandowns/spinner"
@@ -18,6 +21,12 @@ func newCPUInfoCmd() *cobra.Command {
 	var rounds int
 	var iterations int
 	var quiet bool
+	var numaWeight float64
+	var format string
+	var sampling string
+	var kernelList string
+	var kernel string
+	var normalize float64
 
 	// Load config to get defaults
 	defaultCfg := config.Load(config.ConstantConfigFilename)
@@ -26,6 +35,39 @@ func newCPUInfoCmd() *cobra.Command {
 		Use:   "cpuinfo",
 		Short: "Calculate and show CPU topology ranking",
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if numaWeight < 0 || numaWeight > 1 {
+				return fmt.Errorf("--numa-weight must be between 0.0 and 1.0, got %v", numaWeight)
+			}
+			if err := validateFormat(format, formatJSON, formatCSV, formatMatrixJSON, formatTopology); err != nil {
+				return err
+			}
+			// The topology is read from sysfs, nothing is measured
+			if format == formatTopology {
+				topology, err := cpuinfo.ScanTopology()
+				if err != nil {
+					return err
+				}
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				return enc.Encode(topology)
+			}
+			if summary && format != formatJSON {
+				return fmt.Errorf("--summary can't be combined with --format %s", format)
+			}
+			if normalize < 0 || normalize > 1 {
+				return fmt.Errorf("--normalize must be between 0.0 and 1.0, got %v", normalize)
+			}
+			kernels, err := cpuinfo.ParseKernelWeights(kernelList)
+			if err != nil {
+				return fmt.Errorf("invalid --kernels: %w", err)
+			}
+			if kernel != "" && format == formatJSON {
+				return fmt.Errorf("--kernel requires --format %s or %s", formatCSV, formatMatrixJSON)
+			}
+			if !slices.Contains([]string{config.SamplingAuto, config.SamplingAlways, config.SamplingNever}, sampling) {
+				return fmt.Errorf("invalid --sampling %q, expected auto, always or never", sampling)
+			}
+
 			var onProgress func(int, int)
 			if verbose {
 				onProgress = func(round, total int) {
@@ -44,8 +86,26 @@ func newCPUInfoCmd() *cobra.Command {
 				s.Start()
 			}
 
-			ci := cpuinfo.New()
-			err := ci.Update(rounds, iterations, onProgress)
+			opts := []cpuinfo.Option{cpuinfo.WithNUMAWeight(numaWeight), cpuinfo.WithKernels(kernels)}
+			if samplingCfg, ok := cpuinfo.NewSampling(sampling, defaultCfg.SamplingPerClass, defaultCfg.SamplingSpotChecks); ok {
+				opts = append(opts, cpuinfo.WithSampling(samplingCfg))
+			}
+			if normalize > 0 {
+				opts = append(opts, cpuinfo.WithNormalization(normalize))
+			}
+			// Measure anyway, it was asked for explicitly
+			if virt := cpuinfo.DetectVirtualization(); virt.Virtualized {
+				opts = append(opts, cpuinfo.WithVirtualization(false))
+				if !quiet {
+					fmt.Fprintln(os.Stderr, "Warning: this host is a virtual machine, latencies between vCPUs are unreliable")
+				}
+			}
+			ci := cpuinfo.New(opts...)
+			// Ctrl-C stops the measurement and releases the CPUs
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			err = ci.Update(ctx, rounds, iterations, onProgress)
 
 			if s != nil {
 				s.Stop()
@@ -58,10 +118,21 @@ func newCPUInfoCmd() *cobra.Command {
 				fmt.Fprintln(os.Stderr, "Done.")
 			}
 
-			rankings, err := ci.GetCoreRanking()
+			snapshot, err := ci.GetSnapshot()
 			if err != nil {
 				return err
 			}
+			if format != formatJSON {
+				matrix := snapshot.Matrix()
+				if kernel != "" {
+					var ok bool
+					if matrix, ok = snapshot.KernelMatrix(kernel); !ok {
+						return fmt.Errorf("kernel %q was not measured (--kernels %s)", kernel, kernelList)
+					}
+				}
+				return writeMatrix(os.Stdout, format, matrix)
+			}
+			rankings := snapshot.Rankings
 
 			var output interface{} = rankings
 			if summary {
@@ -77,5 +148,11 @@ func newCPUInfoCmd() *cobra.Command {
 	cmd.Flags().IntVar(&rounds, "rounds", defaultCfg.Rounds, "Number of rounds")
 	cmd.Flags().IntVar(&iterations, "iterations", defaultCfg.Iterations, "Number of iterations")
 	cmd.Flags().BoolVarP(&quiet, "quiet", "q", false, "Disable progress spinner")
+	cmd.Flags().StringVar(&format, "format", formatJSON, "Output format: json (ranking), csv (core-to-core-latency matrix), matrix-json, topology (detected CPU topology as JSON)")
+	cmd.Flags().StringVar(&sampling, "sampling", defaultCfg.Sampling, "Measure only a sample of the CPU pairs: auto (more than 128 CPUs), always, never")
+	cmd.Flags().StringVar(&kernelList, "kernels", defaultCfg.Kernels, "Measurement kernels and their weights in the ranking, e.g. ping-pong=1,cas=0.5 (ping-pong, cas, stream)")
+	cmd.Flags().StringVar(&kernel, "kernel", "", "Export the matrix of a single kernel instead of the blend (csv and matrix-json)")
+	cmd.Flags().Float64Var(&normalize, "normalize", defaultCfg.NormalizeTolerance, "Snap latencies within this relative tolerance of their topology class median to the median (0.0 = off)")
+	cmd.Flags().Float64Var(&numaWeight, "numa-weight", defaultCfg.NUMAWeight, "Weight of the NUMA distance in the ranking (0.0 - 1.0)")
 	return cmd
 }
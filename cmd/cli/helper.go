@@ -4,15 +4,16 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"net"
+	"io"
 	"os"
 	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
-	"time"
 
+	"github.com/egandro/proxmox-cpu-affinity/pkg/client"
 	"github.com/egandro/proxmox-cpu-affinity/pkg/config"
+	"github.com/egandro/proxmox-cpu-affinity/pkg/cpuinfo"
 	"github.com/egandro/proxmox-cpu-affinity/pkg/executor"
 )
 
@@ -41,17 +42,32 @@ func ensureProxmoxHost() error {
 	return nil
 }
 
-// SocketRequest represents the JSON request structure for the service.
-type SocketRequest struct {
-	Command string `json:"command"`
-	VMID    int    `json:"vmid,omitempty"`
+// Output formats of the latency data.
+const (
+	formatTable      = "table"
+	formatJSON       = "json"
+	formatCSV        = "csv"
+	formatMatrixJSON = "matrix-json"
+	formatTopology   = "topology"
+)
+
+func validateFormat(format string, allowed ...string) error {
+	for _, f := range allowed {
+		if format == f {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid format %q, allowed: %s", format, strings.Join(allowed, ", "))
 }
 
-// SocketResponse represents the JSON response structure from the service.
-type SocketResponse struct {
-	Status string      `json:"status"`
-	Data   interface{} `json:"data,omitempty"`
-	Error  string      `json:"error,omitempty"`
+// writeMatrix writes the latency matrix as core-to-core-latency CSV or as matrix JSON.
+func writeMatrix(w io.Writer, format string, matrix cpuinfo.LatencyMatrix) error {
+	if format == formatCSV {
+		return matrix.WriteCSV(w)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(matrix.Export())
 }
 
 func resolveSocketPath(flagSocket string) string {
@@ -62,27 +78,9 @@ func resolveSocketPath(flagSocket string) string {
 	return cfg.SocketFile
 }
 
-func sendSocketRequest(socketPath string, req SocketRequest) (*SocketResponse, error) {
-	conn, err := net.DialTimeout("unix", socketPath, 2*time.Second)
-	if err != nil {
-		return nil, fmt.Errorf("service is not reachable: %w", err)
-	}
-	defer func() {
-		_ = conn.Close()
-	}()
-
-	_ = conn.SetDeadline(time.Now().Add(2 * time.Second))
-
-	if err := json.NewEncoder(conn).Encode(req); err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
-	}
-
-	var resp SocketResponse
-	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
-		return nil, fmt.Errorf("failed to decode response: %w", err)
-	}
-
-	return &resp, nil
+// newClient returns a client for the socket of the flag or the config.
+func newClient(flagSocket string) *client.Client {
+	return client.New(resolveSocketPath(flagSocket))
 }
 
 func getCPUModelName(path string) string {
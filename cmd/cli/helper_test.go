@@ -1,14 +1,19 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
-	"net"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/egandro/proxmox-cpu-affinity/pkg/audit"
 	"github.com/egandro/proxmox-cpu-affinity/pkg/config"
+	"github.com/egandro/proxmox-cpu-affinity/pkg/cpuinfo"
+	"github.com/egandro/proxmox-cpu-affinity/pkg/events"
+	"github.com/egandro/proxmox-cpu-affinity/pkg/health"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -96,46 +101,96 @@ func TestResolveSocketPath(t *testing.T) {
 	assert.Equal(t, expectedEnvPath, resolveSocketPath(""))
 }
 
-func TestSendSocketRequest(t *testing.T) {
-	// Create a temp socket
-	tmpDir := t.TempDir()
-	socketPath := filepath.Join(tmpDir, "test.sock")
+func TestFormatEvent(t *testing.T) {
+	ts := time.Date(2026, 1, 2, 3, 4, 5, 0, time.Local)
+	assert.Equal(t, "2026-01-02 03:04:05 affinity-applied     vmid=100 cpus=0,1",
+		formatEvent(events.Event{Time: ts, Type: events.TypeAffinityApplied, VMID: 100, CPUs: []int{0, 1}}))
+	assert.Equal(t, "2026-01-02 03:04:05 ranking-recalculated generation=3 source=measured",
+		formatEvent(events.Event{Time: ts, Type: events.TypeRankingRecalculated, Generation: 3, Source: "measured"}))
+	assert.Equal(t, `2026-01-02 03:04:05 error                vmid=101 reason=vm-pid error="no such process"`,
+		formatEvent(events.Event{Time: ts, Type: events.TypeError, VMID: 101, Reason: "vm-pid", Error: "no such process"}))
+}
 
-	listener, err := net.Listen("unix", socketPath)
-	require.NoError(t, err)
-	defer func() { _ = listener.Close() }()
+func TestFormatRecalculation(t *testing.T) {
+	assert.Equal(t, "measurement pending (waiting for a quiet host)",
+		formatRecalculation(&cpuinfo.Recalculation{State: cpuinfo.RecalculationRunning, Rounds: 3}))
+	assert.Equal(t, "measuring: round 2/3 (50%)",
+		formatRecalculation(&cpuinfo.Recalculation{State: cpuinfo.RecalculationRunning, Round: 2, Rounds: 3, Progress: 0.5}))
+	assert.Equal(t, "done", formatRecalculation(&cpuinfo.Recalculation{State: cpuinfo.RecalculationDone}))
+}
 
-	// Start a dummy server
-	go func() {
-		conn, err := listener.Accept()
-		if err != nil {
-			return
-		}
-		defer func() { _ = conn.Close() }()
+func TestFormatReloadResult(t *testing.T) {
+	assert.Equal(t, "Configuration reloaded, no settings changed\n", formatReloadResult(&config.ReloadResult{}))
+	assert.Equal(t, "Applied: PCA_LOG_LEVEL, PCA_ROUNDS\nRestart required: PCA_KERNELS\n",
+		formatReloadResult(&config.ReloadResult{Applied: []string{"PCA_LOG_LEVEL", "PCA_ROUNDS"}, RestartRequired: []string{"PCA_KERNELS"}}))
+}
 
-		var req SocketRequest
-		if err := json.NewDecoder(conn).Decode(&req); err != nil {
-			return
-		}
+func TestFormatHealth(t *testing.T) {
+	out := formatHealth(&health.Health{
+		State:             health.StateReady,
+		Ranking:           &cpuinfo.RankingMeta{Generation: 3, Source: cpuinfo.SourceMeasured, Rounds: 10, Iterations: 100000},
+		RankingAgeSeconds: 7500,
+		Calculation:       cpuinfo.RankingStatus{State: cpuinfo.StateReady},
+		Rounds:            10,
+		Iterations:        100000,
+		ManagedVMs:        4,
+		UptimeSeconds:     10920.4,
+		Version:           "0.0.10",
+		Problems:          []string{"CPU hotplug watchdog is not running"},
+	})
+	assert.Equal(t, `Service is running: ready
+Ranking:          generation 3, measured, 2h5m0s old (rounds=10, iterations=100000)
+Measurement:      rounds=10, iterations=100000
+Hotplug watchdog: not running
+Managed VMs:      4
+Uptime:           3h2m0s
+Version:          0.0.10
+Problem:          CPU hotplug watchdog is not running
+`, out)
+
+	out = formatHealth(&health.Health{State: health.StateReady, Healthy: true, HotplugWatchdog: true})
+	assert.Contains(t, out, "Service is running: ready (healthy)\n")
+	assert.Contains(t, out, "Hotplug watchdog: running\n")
+}
 
-		resp := SocketResponse{
-			Status: "ok",
-			Data:   "pong",
-		}
-		_ = json.NewEncoder(conn).Encode(resp)
-	}()
+func TestParseSince(t *testing.T) {
+	now := time.Date(2026, 1, 2, 12, 0, 0, 0, time.Local)
+	since, err := parseSince("24h", now)
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(-24*time.Hour), since)
+
+	since, err = parseSince("2026-01-01", now)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2026, 1, 1, 0, 0, 0, 0, time.Local), since)
+
+	since, err = parseSince("2026-01-01 08:30", now)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2026, 1, 1, 8, 30, 0, 0, time.Local), since)
 
-	// Test successful request
-	resp, err := sendSocketRequest(socketPath, SocketRequest{Command: "ping"})
-	assert.NoError(t, err)
-	assert.Equal(t, "ok", resp.Status)
-	assert.Equal(t, "pong", resp.Data)
+	since, err = parseSince("2026-01-01T08:30:00Z", now)
+	require.NoError(t, err)
+	assert.True(t, time.Date(2026, 1, 1, 8, 30, 0, 0, time.UTC).Equal(since))
 
-	// Test connection failure
-	_, err = sendSocketRequest(filepath.Join(tmpDir, "nonexistent.sock"), SocketRequest{Command: "ping"})
+	_, err = parseSince("yesterday", now)
 	assert.Error(t, err)
 }
 
+func TestFormatHistory(t *testing.T) {
+	ts := time.Date(2026, 1, 2, 3, 4, 5, 0, time.Local)
+	created := ts.Add(-time.Hour)
+	primary := 4
+	out := formatHistory([]audit.Entry{
+		{Time: ts, VMID: 100, RequestedCPUs: 2, PrimaryCPU: &primary, CPUs: []int{4, 5}, Generation: 3, RankingCreatedAt: &created, PreviousMask: "0-7", Result: audit.ResultApplied},
+		{Time: ts, VMID: 101, Result: audit.ResultFailed, Reason: "vm-pid", Error: "no such process"},
+		{Time: ts, VMID: 102, RequestedCPUs: 1, PrimaryCPU: &primary, CPUs: []int{4}, Generation: 2, Result: audit.ResultPartial, Reason: "set-affinity", Error: "no such process", FailedThreads: []int{200, 201}},
+	})
+	assert.Equal(t, `TIME                 VMID  RESULT   REQUESTED  PRIMARY  CPUS  PREVIOUS  RANKING                  DETAILS
+2026-01-02 03:04:05  100   applied  2          4        4,5   0-7       3 (2026-01-02 02:04:05)  -
+2026-01-02 03:04:05  101   failed   -          -        -     -         -                        vm-pid: no such process
+2026-01-02 03:04:05  102   partial  1          4        4     -         2                        set-affinity: no such process (threads 200,201)
+`, out)
+}
+
 func TestGetCPUModelName(t *testing.T) {
 	tmpDir := t.TempDir()
 	cpuInfoPath := filepath.Join(tmpDir, "cpuinfo")
@@ -154,3 +209,36 @@ microcode	: 0x2000069
 	assert.Equal(t, "Intel(R) Xeon(R) Gold 6130 CPU @ 2.10GHz", getCPUModelName(cpuInfoPath))
 	assert.Equal(t, "Unknown CPU", getCPUModelName(filepath.Join(tmpDir, "missing")))
 }
+
+func TestSameGeneration(t *testing.T) {
+	gen := func(g uint64) *cpuinfo.RankingMeta { return &cpuinfo.RankingMeta{Generation: g} }
+
+	assert.True(t, sameGeneration())
+	assert.True(t, sameGeneration(gen(1), gen(1), gen(1)))
+	assert.True(t, sameGeneration(nil, gen(2), nil), "missing metadata is ignored")
+	assert.False(t, sameGeneration(gen(1), gen(2), gen(1)))
+	assert.False(t, sameGeneration(gen(1), nil, gen(2)))
+}
+
+func TestValidateFormat(t *testing.T) {
+	assert.NoError(t, validateFormat("csv", formatJSON, formatCSV))
+	err := validateFormat("xml", formatJSON, formatCSV)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "json, csv")
+}
+
+func TestWriteMatrix(t *testing.T) {
+	m := make(cpuinfo.LatencyMatrix)
+	m.Set(0, 1, 20)
+	m.Set(1, 0, 22)
+
+	var buf bytes.Buffer
+	require.NoError(t, writeMatrix(&buf, formatCSV, m))
+	assert.Equal(t, "0.0,20.0\n22.0,0.0\n", buf.String())
+
+	buf.Reset()
+	require.NoError(t, writeMatrix(&buf, formatMatrixJSON, m))
+	var export cpuinfo.MatrixExport
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &export))
+	assert.Equal(t, []int{0, 1}, export.CPUs)
+}
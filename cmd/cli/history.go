@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/egandro/proxmox-cpu-affinity/pkg/audit"
+	"github.com/spf13/cobra"
+)
+
+func newHistoryCmd() *cobra.Command {
+	var socketFile string
+	var since string
+	var jsonOutput bool
+	cmd := &cobra.Command{
+		Use:   "history [vmid]",
+		Short: "Show the placement decisions of the audit journal",
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) > 1 {
+				return fmt.Errorf("accepts at most 1 arg(s), received %d", len(args))
+			}
+			if len(args) == 1 && !isNumeric(args[0]) {
+				return fmt.Errorf("invalid VMID: %s", args[0])
+			}
+			return nil
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			var vmid int
+			if len(args) == 1 {
+				vmid, _ = strconv.Atoi(args[0])
+			}
+			var from time.Time
+			if since != "" {
+				var err error
+				if from, err = parseSince(since, time.Now()); err != nil {
+					fmt.Printf("Error: %v\n", err)
+					os.Exit(1)
+				}
+			}
+
+			entries, err := newClient(socketFile).History(cmd.Context(), vmid, from)
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+
+			if jsonOutput {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				_ = enc.Encode(entries)
+				return
+			}
+			if len(entries) == 0 {
+				fmt.Println("No placements recorded")
+				return
+			}
+			fmt.Print(formatHistory(entries))
+		},
+	}
+	cmd.Flags().StringVar(&since, "since", "", "Show entries since a time (2006-01-02, \"2006-01-02 15:04\", RFC 3339) or a duration ago (e.g. 24h)")
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Output in JSON format")
+	cmd.Flags().StringVar(&socketFile, "socket", "", "Path to unix socket")
+	return cmd
+}
+
+// parseSince parses the --since flag, a duration is relative to now.
+func parseSince(value string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{time.DateTime, "2006-01-02 15:04", time.DateOnly} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid --since %q: expected a date, a time or a duration", value)
+}
+
+// formatHistory formats the entries of the audit journal as a table.
+func formatHistory(entries []audit.Entry) string {
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "TIME\tVMID\tRESULT\tREQUESTED\tPRIMARY\tCPUS\tPREVIOUS\tRANKING\tDETAILS")
+	for _, e := range entries {
+		requested, primary, ranking := "-", "-", "-"
+		if e.RequestedCPUs > 0 {
+			requested = strconv.Itoa(e.RequestedCPUs)
+		}
+		if e.PrimaryCPU != nil {
+			primary = strconv.Itoa(*e.PrimaryCPU)
+		}
+		switch {
+		case e.RankingCreatedAt != nil:
+			ranking = fmt.Sprintf("%d (%s)", e.Generation, e.RankingCreatedAt.Local().Format(time.DateTime))
+		case e.Generation > 0:
+			ranking = strconv.FormatUint(e.Generation, 10)
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", e.Time.Local().Format(time.DateTime), e.VMID, e.Result,
+			requested, primary, orDash(formatCPUs(e.CPUs)), orDash(e.PreviousMask), ranking, orDash(historyDetails(e)))
+	}
+	_ = w.Flush()
+	return b.String()
+}
+
+// historyDetails returns the reason, error and failed threads of an entry.
+func historyDetails(e audit.Entry) string {
+	var parts []string
+	if e.Reason != "" {
+		parts = append(parts, e.Reason)
+	}
+	if e.Error != "" {
+		parts = append(parts, e.Error)
+	}
+	details := strings.Join(parts, ": ")
+	if len(e.FailedThreads) > 0 {
+		details += fmt.Sprintf(" (threads %s)", formatCPUs(e.FailedThreads))
+	}
+	return details
+}
+
+// formatCPUs joins CPU IDs with commas.
+func formatCPUs(cpus []int) string {
+	parts := make([]string, len(cpus))
+	for i, cpu := range cpus {
+		parts[i] = strconv.Itoa(cpu)
+	}
+	return strings.Join(parts, ",")
+}
+
+// orDash returns "-" for an empty column.
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -25,6 +25,7 @@ func main() {
 	rootCmd.AddCommand(newPSCmd())
 	rootCmd.AddCommand(newHookscriptCmd())
 	rootCmd.AddCommand(newReassignCmd())
+	rootCmd.AddCommand(newHistoryCmd())
 
 	if err := rootCmd.Execute(); err != nil {
 		os.Exit(1)
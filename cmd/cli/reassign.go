@@ -1,10 +1,12 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"strconv"
 
+	"github.com/egandro/proxmox-cpu-affinity/pkg/client"
 	"github.com/egandro/proxmox-cpu-affinity/pkg/executor"
 	"github.com/spf13/cobra"
 )
@@ -46,7 +48,7 @@ func newReassignCmd() *cobra.Command {
 
 			ctx := cmd.Context()
 			exec := &executor.DefaultExecutor{}
-			targetSocket := resolveSocketPath(socketFile)
+			c := newClient(socketFile)
 
 			var vmidsToProcess []uint64
 			if len(args) == 1 {
@@ -93,17 +95,13 @@ func newReassignCmd() *cobra.Command {
 				}
 
 				// #nosec G115 -- VMID is always a positive integer within int range
-				resp, err := sendSocketRequest(targetSocket, SocketRequest{Command: "update-affinity", VMID: int(vmid)})
-				if err != nil {
-					res.Status = "failed"
-					res.Error = fmt.Sprintf("service call failed: %v", err)
-					printReassignResult(res)
-					continue
-				}
-
-				if resp.Status != "ok" {
+				if _, err := c.UpdateAffinity(ctx, int(vmid)); err != nil {
 					res.Status = "failed"
-					res.Error = resp.Error
+					res.Error = err.Error()
+					var serviceErr *client.ServiceError
+					if !errors.As(err, &serviceErr) {
+						res.Error = fmt.Sprintf("service call failed: %v", err)
+					}
 					printReassignResult(res)
 					continue
 				}
@@ -1,49 +1,134 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"os/signal"
 	"sort"
 	"strings"
+	"syscall"
 	"text/tabwriter"
+	"time"
 
+	"github.com/egandro/proxmox-cpu-affinity/pkg/client"
 	"github.com/egandro/proxmox-cpu-affinity/pkg/config"
 	"github.com/egandro/proxmox-cpu-affinity/pkg/cpuinfo"
+	"github.com/egandro/proxmox-cpu-affinity/pkg/events"
+	"github.com/egandro/proxmox-cpu-affinity/pkg/health"
 	"github.com/egandro/proxmox-cpu-affinity/pkg/svg"
 	"github.com/spf13/cobra"
 )
 
 func newStatusCmd() *cobra.Command {
 	var socketFile string
+	var jsonOutput bool
 	cmd := &cobra.Command{
 		Use:   "status",
 		Short: "Check the status of the service",
 		Run: func(cmd *cobra.Command, args []string) {
-			targetSocket := resolveSocketPath(socketFile)
-
-			resp, err := sendSocketRequest(targetSocket, SocketRequest{Command: "ping"})
+			c := newClient(socketFile)
+			h, err := c.Health(cmd.Context())
+			var serviceErr *client.ServiceError
+			if errors.As(err, &serviceErr) && !jsonOutput {
+				// The service predates the health command
+				printPingStatus(cmd.Context(), c)
+				return
+			}
 			if err != nil {
 				fmt.Printf("Error: %v\n", err)
 				os.Exit(1)
 			}
 
-			if resp.Status != "ok" || resp.Data != "pong" {
-				fmt.Printf("Error: Service did not respond with pong (status=%s, data=%v, err=%s)\n", resp.Status, resp.Data, resp.Error)
-				os.Exit(1)
+			if jsonOutput {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				_ = enc.Encode(h)
+				return
 			}
-			fmt.Println("Service is running (pong received)")
+			fmt.Print(formatHealth(h))
 		},
 	}
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Output the health report in JSON format")
 	cmd.PersistentFlags().StringVar(&socketFile, "socket", "", "Path to unix socket")
 	cmd.AddCommand(newPingCmd(&socketFile))
 	cmd.AddCommand(newCoreRankingCmd(&socketFile))
 	cmd.AddCommand(newCoreRankingSummaryCmd(&socketFile))
+	cmd.AddCommand(newCoreClustersCmd(&socketFile))
+	cmd.AddCommand(newCoreTopologyCmd(&socketFile))
 	cmd.AddCommand(newCoreVMAffinityCmd(&socketFile))
 	cmd.AddCommand(newSvgCmd(&socketFile))
+	cmd.AddCommand(newWatchCmd(&socketFile))
+	cmd.AddCommand(newRecalculateCmd(&socketFile))
+	cmd.AddCommand(newReloadConfigCmd(&socketFile))
 	return cmd
 }
 
+// printPingStatus prints the result of a ping.
+func printPingStatus(ctx context.Context, c *client.Client) {
+	ping, err := c.Ping(ctx)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if ping.WarmingUp {
+		fmt.Printf("Service is running (warming up: %s)\n", formatWarmingUp(ping.Status))
+		printVirtualized(ping)
+		return
+	}
+	fmt.Println("Service is running (pong received)")
+	printVirtualized(ping)
+}
+
+// formatHealth formats the health report of the service.
+func formatHealth(h *health.Health) string {
+	var b strings.Builder
+	state := h.State
+	if h.Healthy {
+		state += " (healthy)"
+	}
+	fmt.Fprintf(&b, "Service is running: %s\n", state)
+
+	w := tabwriter.NewWriter(&b, 0, 0, 1, ' ', 0)
+	if h.Ranking != nil {
+		_, _ = fmt.Fprintf(w, "Ranking:\tgeneration %d, %s, %s old (rounds=%d, iterations=%d)\n", h.Ranking.Generation, h.Ranking.Source,
+			formatSeconds(h.RankingAgeSeconds), h.Ranking.Rounds, h.Ranking.Iterations)
+	}
+	if h.Calculation.State == cpuinfo.StateWarmingUp {
+		_, _ = fmt.Fprintf(w, "Warming up:\t%s\n", formatWarmingUp(&h.Calculation))
+	} else if h.Calculation.Running {
+		_, _ = fmt.Fprintf(w, "Calculation:\trunning, round %d/%d\n", h.Calculation.Round, h.Calculation.Rounds)
+	}
+	_, _ = fmt.Fprintf(w, "Measurement:\trounds=%d, iterations=%d\n", h.Rounds, h.Iterations)
+	watchdog := "not running"
+	if h.HotplugWatchdog {
+		watchdog = "running"
+	}
+	_, _ = fmt.Fprintf(w, "Hotplug watchdog:\t%s\n", watchdog)
+	_, _ = fmt.Fprintf(w, "Managed VMs:\t%d\n", h.ManagedVMs)
+	if h.LastError != nil {
+		_, _ = fmt.Fprintf(w, "Last error:\t%s\n", formatEvent(*h.LastError))
+	}
+	_, _ = fmt.Fprintf(w, "Uptime:\t%s\n", formatSeconds(h.UptimeSeconds))
+	_, _ = fmt.Fprintf(w, "Version:\t%s\n", h.Version)
+	if h.Calculation.Virtualized {
+		_, _ = fmt.Fprintln(w, "Virtualized:\tthe host is a virtual machine, latencies between vCPUs are unreliable")
+	}
+	for _, problem := range h.Problems {
+		_, _ = fmt.Fprintf(w, "Problem:\t%s\n", problem)
+	}
+	_ = w.Flush()
+	return b.String()
+}
+
+// formatSeconds formats a duration in seconds, rounded to seconds.
+func formatSeconds(seconds float64) string {
+	return (time.Duration(seconds * float64(time.Second))).Round(time.Second).String()
+}
+
 func newPingCmd(socketFile *string) *cobra.Command {
 	var jsonOutput bool
 
@@ -51,56 +136,109 @@ func newPingCmd(socketFile *string) *cobra.Command {
 		Use:   "ping",
 		Short: "Ping the service",
 		Run: func(cmd *cobra.Command, args []string) {
-			targetSocket := resolveSocketPath(*socketFile)
-			resp, err := sendSocketRequest(targetSocket, SocketRequest{Command: "ping"})
+			ping, err := newClient(*socketFile).Ping(cmd.Context())
 			if err != nil {
 				fmt.Printf("Error: %v\n", err)
-				fmt.Println("Hint: The proxmox-cpu-affinity-service might not be running or is currently starting.")
-				os.Exit(1)
-			}
-			if resp.Status != "ok" {
-				fmt.Printf("Error: %s\n", resp.Error)
+				if errors.Is(err, client.ErrUnavailable) {
+					fmt.Println("Hint: The proxmox-cpu-affinity-service might not be running or is currently starting.")
+				}
 				os.Exit(1)
 			}
 
 			if jsonOutput {
 				enc := json.NewEncoder(os.Stdout)
 				enc.SetIndent("", "  ")
-				_ = enc.Encode(resp.Data)
+				if ping.WarmingUp {
+					_ = enc.Encode(ping.Status)
+				} else {
+					_ = enc.Encode("pong")
+				}
 				return
 			}
 
-			fmt.Printf("%v\n", resp.Data)
+			if ping.WarmingUp {
+				fmt.Printf("warming up: %s\n", formatWarmingUp(ping.Status))
+			} else {
+				fmt.Println("pong")
+			}
+			printVirtualized(ping)
 		},
 	}
 	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Output in JSON format")
 	return cmd
 }
 
+// printVirtualized prints a note if the service runs inside a virtual
+// machine, taken from the ranking meta or the ranking status of a ping.
+func printVirtualized(ping *client.Ping) {
+	virtualized := ping.Meta != nil && ping.Meta.Virtualized
+	if ping.Status != nil {
+		virtualized = ping.Status.Virtualized
+	}
+	if !virtualized {
+		return
+	}
+	source := ""
+	if ping.Meta != nil {
+		source = fmt.Sprintf(", ranking source %s", ping.Meta.Source)
+	}
+	fmt.Printf("virtualized: true (latencies between vCPUs are unreliable%s)\n", source)
+}
+
+// formatWarmingUp formats the ranking status of a service that is warming up.
+func formatWarmingUp(status *cpuinfo.RankingStatus) string {
+	if status != nil && status.Waiting {
+		return "ranked by topology, measurement postponed while the host is busy"
+	}
+	if status == nil || status.Total == 0 {
+		return "ranked by topology, measurement pending"
+	}
+	return fmt.Sprintf("ranked by topology, measured %d/%d pairs (%.0f%%)", status.Measured, status.Total, status.Progress*100)
+}
+
 func newCoreRankingCmd(socketFile *string) *cobra.Command {
 	var jsonOutput bool
+	var format string
 
 	cmd := &cobra.Command{
 		Use:   "core-ranking",
 		Short: "Get the current core ranking",
 		Run: func(cmd *cobra.Command, args []string) {
-			var rankings []cpuinfo.CoreRanking
-			if err := fetchServiceData(*socketFile, "core-ranking", &rankings); err != nil {
+			if jsonOutput {
+				format = formatJSON
+			}
+			if err := validateFormat(format, formatTable, formatJSON, formatCSV, formatMatrixJSON); err != nil {
 				fmt.Printf("Error: %v\n", err)
 				os.Exit(1)
 			}
 
-			if jsonOutput {
+			rankings, meta, err := newClient(*socketFile).CoreRanking(cmd.Context())
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+
+			if format == formatCSV || format == formatMatrixJSON {
+				if err := writeMatrix(os.Stdout, format, cpuinfo.MatrixFromRankings(rankings)); err != nil {
+					fmt.Printf("Error: %v\n", err)
+					os.Exit(1)
+				}
+				return
+			}
+
+			if format == formatJSON {
 				enc := json.NewEncoder(os.Stdout)
 				enc.SetIndent("", "  ")
 				_ = enc.Encode(rankings)
 				return
 			}
 
+			printRankingMeta(meta)
 			printCoreRankings(rankings)
 		},
 	}
-	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Output in JSON format")
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Output in JSON format (same as --format json)")
+	cmd.Flags().StringVar(&format, "format", formatTable, "Output format: table, json, csv (core-to-core-latency matrix), matrix-json")
 	return cmd
 }
 
@@ -125,20 +263,8 @@ func newSvgCmd(socketFile *string) *cobra.Command {
 		Use:   "svg",
 		Short: "Export current status as SVG",
 		Run: func(cmd *cobra.Command, args []string) {
-			var rankings []cpuinfo.CoreRanking
-			if err := fetchServiceData(*socketFile, "core-ranking", &rankings); err != nil {
-				fmt.Printf("Error: %v\n", err)
-				os.Exit(1)
-			}
-
-			var stats cpuinfo.TopologyStats
-			if err := fetchServiceData(*socketFile, "core-ranking-summary", &stats); err != nil {
-				fmt.Printf("Error: %v\n", err)
-				os.Exit(1)
-			}
-
-			var selections map[int][]int
-			if err := fetchServiceData(*socketFile, "core-vm-affinity", &selections); err != nil {
+			rankings, stats, selections, err := fetchConsistentSnapshot(cmd.Context(), newClient(*socketFile))
+			if err != nil {
 				fmt.Printf("Error: %v\n", err)
 				os.Exit(1)
 			}
@@ -177,8 +303,8 @@ func newCoreRankingSummaryCmd(socketFile *string) *cobra.Command {
 		Use:   "core-ranking-summary",
 		Short: "Get the core ranking summary",
 		Run: func(cmd *cobra.Command, args []string) {
-			var stats cpuinfo.TopologyStats
-			if err := fetchServiceData(*socketFile, "core-ranking-summary", &stats); err != nil {
+			stats, meta, err := newClient(*socketFile).CoreRankingSummary(cmd.Context())
+			if err != nil {
 				fmt.Printf("Error: %v\n", err)
 				os.Exit(1)
 			}
@@ -190,6 +316,7 @@ func newCoreRankingSummaryCmd(socketFile *string) *cobra.Command {
 				return
 			}
 
+			printRankingMeta(meta)
 			printCoreRankingSummary(stats)
 		},
 	}
@@ -209,6 +336,105 @@ func printCoreRankingSummary(stats cpuinfo.TopologyStats) {
 	_ = w.Flush()
 }
 
+func newCoreClustersCmd(socketFile *string) *cobra.Command {
+	var jsonOutput bool
+
+	cmd := &cobra.Command{
+		Use:   "core-clusters",
+		Short: "Get the latency domains derived from the core ranking",
+		Run: func(cmd *cobra.Command, args []string) {
+			levels, meta, err := newClient(*socketFile).CoreClusters(cmd.Context())
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+
+			if jsonOutput {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				_ = enc.Encode(levels)
+				return
+			}
+
+			printRankingMeta(meta)
+			if meta != nil && (meta.Source == cpuinfo.SourceTopology || meta.Source == cpuinfo.SourceDerived) {
+				fmt.Printf("No latency domains, the %s ranking is not measured.\n", meta.Source)
+				return
+			}
+			printCoreClusters(levels)
+		},
+	}
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Output in JSON format")
+	return cmd
+}
+
+func printCoreClusters(levels []cpuinfo.ClusterLevel) {
+	if len(levels) == 0 {
+		fmt.Println("No latency domains found (all CPUs are equally close).")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	_, _ = fmt.Fprintln(w, "Level\tMax Latency (ns)\tCluster\tLatency (ns)\tCPUs")
+	_, _ = fmt.Fprintln(w, "-----\t----------------\t-------\t------------\t----")
+
+	for _, level := range levels {
+		for i, c := range level.Clusters {
+			var cpuStrs []string
+			for _, cpu := range c.CPUs {
+				cpuStrs = append(cpuStrs, fmt.Sprintf("%d", cpu))
+			}
+			_, _ = fmt.Fprintf(w, "%d\t%.2f\t%d\t%.2f\t%s\n", level.Level, level.MaxLatencyNS, i, c.LatencyNS, strings.Join(cpuStrs, ","))
+		}
+	}
+	_ = w.Flush()
+}
+
+func newCoreTopologyCmd(socketFile *string) *cobra.Command {
+	var jsonOutput bool
+
+	cmd := &cobra.Command{
+		Use:   "core-topology",
+		Short: "Get the detected CPU topology (sockets, dies, caches, NUMA nodes, online state)",
+		Run: func(cmd *cobra.Command, args []string) {
+			topology, err := newClient(*socketFile).CoreTopology(cmd.Context())
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+
+			if jsonOutput {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				_ = enc.Encode(topology)
+				return
+			}
+
+			printCoreTopology(topology)
+		},
+	}
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Output in JSON format")
+	return cmd
+}
+
+func printCoreTopology(topology []cpuinfo.CoreInfo) {
+	// -1 means unknown
+	id := func(v int) string {
+		if v < 0 {
+			return "-"
+		}
+		return fmt.Sprintf("%d", v)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	_, _ = fmt.Fprintln(w, "CPU	Socket	Die	Node	Cluster	Core	L2	L3	Online")
+	_, _ = fmt.Fprintln(w, "---	------	---	----	-------	----	--	--	------")
+	for _, c := range topology {
+		_, _ = fmt.Fprintf(w, "%d	%s	%s	%s	%s	%s	%s	%s	%t\n", c.CPU, id(c.Socket), id(c.Die), id(c.Node), id(c.Cluster), id(c.Core), id(c.L2), id(c.L3), c.Online)
+	}
+	_ = w.Flush()
+}
+
 func newCoreVMAffinityCmd(socketFile *string) *cobra.Command {
 	var jsonOutput bool
 
@@ -216,8 +442,8 @@ func newCoreVMAffinityCmd(socketFile *string) *cobra.Command {
 		Use:   "core-vm-affinity",
 		Short: "Get the current CPU affinity selections by VMID",
 		Run: func(cmd *cobra.Command, args []string) {
-			var selections map[int][]int
-			if err := fetchServiceData(*socketFile, "core-vm-affinity", &selections); err != nil {
+			selections, meta, err := newClient(*socketFile).CoreVMAffinity(cmd.Context())
+			if err != nil {
 				fmt.Printf("Error: %v\n", err)
 				os.Exit(1)
 			}
@@ -229,6 +455,7 @@ func newCoreVMAffinityCmd(socketFile *string) *cobra.Command {
 				return
 			}
 
+			printRankingMeta(meta)
 			printCoreVMAffinity(selections)
 		},
 	}
@@ -236,10 +463,10 @@ func newCoreVMAffinityCmd(socketFile *string) *cobra.Command {
 	return cmd
 }
 
-func printCoreVMAffinity(selections map[int][]int) {
+func printCoreVMAffinity(selections map[int]cpuinfo.Selection) {
 	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
-	_, _ = fmt.Fprintln(w, "VMID\tSelected CPUs")
-	_, _ = fmt.Fprintln(w, "----\t-------------")
+	_, _ = fmt.Fprintln(w, "VMID\tSelected CPUs\tGeneration")
+	_, _ = fmt.Fprintln(w, "----\t-------------\t----------")
 
 	// Sort keys
 	var vmids []int
@@ -249,32 +476,263 @@ func printCoreVMAffinity(selections map[int][]int) {
 	sort.Ints(vmids)
 
 	for _, vmid := range vmids {
-		cpus := selections[vmid]
+		sel := selections[vmid]
 		// Convert ints to strings for joining
 		var cpuStrs []string
-		for _, cpu := range cpus {
+		for _, cpu := range sel.CPUs {
 			cpuStrs = append(cpuStrs, fmt.Sprintf("%d", cpu))
 		}
-		_, _ = fmt.Fprintf(w, "%d\t%s\n", vmid, strings.Join(cpuStrs, ","))
+		_, _ = fmt.Fprintf(w, "%d\t%s\t%d\n", vmid, strings.Join(cpuStrs, ","), sel.Generation)
 	}
 	_ = w.Flush()
 }
 
-func fetchServiceData(socketFile string, command string, target interface{}) error {
-	targetSocket := resolveSocketPath(socketFile)
-	resp, err := sendSocketRequest(targetSocket, SocketRequest{Command: command})
-	if err != nil {
-		return err
+// printRankingMeta prints the generation of the ranking a response is based on.
+func printRankingMeta(meta *cpuinfo.RankingMeta) {
+	if meta == nil {
+		return
+	}
+	fmt.Printf("Ranking generation %d (%s, created %s)\n", meta.Generation, meta.Source, meta.CreatedAt.Format(time.RFC3339))
+	if meta.Virtualized {
+		fmt.Println("Virtualized: the host is a virtual machine, latencies between vCPUs are unreliable")
+	}
+	if meta.Load != nil && meta.Load.LowConfidence {
+		fmt.Printf("Warning: low confidence, the host was busy during the measurement (busy %.0f%%, cpu pressure %.1f%%)\n", meta.Load.Busy*100, meta.Load.Pressure)
+	}
+	if len(meta.Kernels) > 1 {
+		parts := make([]string, len(meta.Kernels))
+		for i, k := range meta.Kernels {
+			parts[i] = fmt.Sprintf("%s=%g", k.Kernel, k.Weight)
+		}
+		fmt.Printf("Kernels: %s\n", strings.Join(parts, ", "))
+	}
+	if n := meta.Normalization; n != nil {
+		fmt.Printf("Normalized: %d pairs snapped to their class median (tolerance %.0f%%), %d outliers kept\n", n.Snapped, n.Tolerance*100, n.Outliers)
+	}
+	if s := meta.Sampling; s != nil {
+		fmt.Printf("Sampled: %d of %d pairs measured, residual error %.1f%% (max %.1f%%, %d spot checks)\n", s.Measured, s.Pairs, s.ResidualError*100, s.MaxError*100, s.SpotChecks)
 	}
+	fmt.Println()
+}
+
+// fetchConsistentSnapshot fetches the ranking, the summary and the selections
+// and retries if the ranking generation changed between the requests.
+func fetchConsistentSnapshot(ctx context.Context, c *client.Client) ([]cpuinfo.CoreRanking, cpuinfo.TopologyStats, map[int][]int, error) {
+	const attempts = 3
+
+	for i := 0; i < attempts; i++ {
+		rankings, rankingMeta, err := c.CoreRanking(ctx)
+		if err != nil {
+			return nil, cpuinfo.TopologyStats{}, nil, err
+		}
 
-	if resp.Status != "ok" {
-		return fmt.Errorf("%s", resp.Error)
+		stats, statsMeta, err := c.CoreRankingSummary(ctx)
+		if err != nil {
+			return nil, cpuinfo.TopologyStats{}, nil, err
+		}
+
+		selections, selectionsMeta, err := c.CoreVMAffinity(ctx)
+		if err != nil {
+			return nil, cpuinfo.TopologyStats{}, nil, err
+		}
+
+		if sameGeneration(rankingMeta, statsMeta, selectionsMeta) {
+			cpus := make(map[int][]int, len(selections))
+			for vmid, sel := range selections {
+				cpus[vmid] = sel.CPUs
+			}
+			return rankings, stats, cpus, nil
+		}
 	}
+	return nil, cpuinfo.TopologyStats{}, nil, fmt.Errorf("ranking changed %d times while fetching, try again later", attempts)
+}
 
-	dataBytes, err := json.Marshal(resp.Data)
-	if err != nil {
-		return fmt.Errorf("processing response data: %w", err)
+// sameGeneration reports whether all metas describe the same ranking generation.
+// Responses without metadata (older services) are accepted.
+func sameGeneration(metas ...*cpuinfo.RankingMeta) bool {
+	var gen *uint64
+	for _, m := range metas {
+		if m == nil {
+			continue
+		}
+		if gen != nil && *gen != m.Generation {
+			return false
+		}
+		gen = &m.Generation
+	}
+	return true
+}
+
+func newWatchCmd(socketFile *string) *cobra.Command {
+	var jsonOutput bool
+
+	cmd := &cobra.Command{
+		Use:   "watch",
+		Short: "Stream the events of the service (placements, rankings, hotplug, errors)",
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			enc := json.NewEncoder(os.Stdout)
+			err := newClient(*socketFile).Watch(ctx, func(e events.Event) {
+				if jsonOutput {
+					_ = enc.Encode(e)
+					return
+				}
+				fmt.Println(formatEvent(e))
+			})
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+		},
+	}
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Output the events as newline-delimited JSON")
+	return cmd
+}
+
+// formatEvent formats an event as a single line.
+func formatEvent(e events.Event) string {
+	parts := []string{e.Time.Local().Format(time.DateTime), fmt.Sprintf("%-20s", e.Type)}
+	if e.VMID != 0 {
+		parts = append(parts, fmt.Sprintf("vmid=%d", e.VMID))
+	}
+	if len(e.CPUs) > 0 {
+		cpus := make([]string, len(e.CPUs))
+		for i, cpu := range e.CPUs {
+			cpus[i] = fmt.Sprintf("%d", cpu)
+		}
+		parts = append(parts, "cpus="+strings.Join(cpus, ","))
+	}
+	if e.Generation != 0 {
+		parts = append(parts, fmt.Sprintf("generation=%d", e.Generation))
+	}
+	if e.Source != "" {
+		parts = append(parts, "source="+e.Source)
 	}
+	if e.Reason != "" {
+		parts = append(parts, "reason="+e.Reason)
+	}
+	if e.Error != "" {
+		parts = append(parts, fmt.Sprintf("error=%q", e.Error))
+	}
+	return strings.TrimSpace(strings.Join(parts, " "))
+}
+
+func newRecalculateCmd(socketFile *string) *cobra.Command {
+	var rounds, iterations int
+	var wait bool
 
-	return json.Unmarshal(dataBytes, target)
+	cmd := &cobra.Command{
+		Use:   "recalculate",
+		Short: "Measure a new core ranking, VMs with selected CPUs are re-placed",
+		Run: func(cmd *cobra.Command, args []string) {
+			c := newClient(*socketFile)
+			recalc, err := c.Recalculate(cmd.Context(), rounds, iterations)
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("Recalculation started (rounds=%d, iterations=%d)\n", recalc.Rounds, recalc.Iterations)
+			if !wait {
+				fmt.Println("Use 'status recalculate --wait' or 'status watch' to follow the progress.")
+				return
+			}
+
+			recalc, err = waitForRecalculation(cmd.Context(), c, time.Second, func(r *cpuinfo.Recalculation) {
+				fmt.Printf("\r%s", formatRecalculation(r))
+			})
+			fmt.Println()
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			if recalc.State == cpuinfo.RecalculationFailed {
+				fmt.Printf("Error: recalculation failed: %s\n", recalc.Error)
+				os.Exit(1)
+			}
+			fmt.Printf("Ranking generation %d is in use", recalc.Generation)
+			if len(recalc.Replaced) > 0 {
+				fmt.Printf(", re-placed VMs: %v", recalc.Replaced)
+			}
+			fmt.Println()
+		},
+	}
+	cmd.Flags().IntVar(&rounds, "rounds", 0, "Measurement rounds (default: PCA_ROUNDS of the service)")
+	cmd.Flags().IntVar(&iterations, "iterations", 0, "Iterations per measurement (default: PCA_ITERATIONS of the service)")
+	cmd.Flags().BoolVar(&wait, "wait", false, "Wait for the recalculation and show the progress")
+	return cmd
+}
+
+// waitForRecalculation polls the recalculation until it is no longer running.
+func waitForRecalculation(ctx context.Context, c *client.Client, interval time.Duration, onProgress func(*cpuinfo.Recalculation)) (*cpuinfo.Recalculation, error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		recalc, err := c.RecalculationStatus(ctx)
+		if err != nil {
+			return nil, err
+		}
+		onProgress(recalc)
+		if recalc.State != cpuinfo.RecalculationRunning {
+			return recalc, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// formatRecalculation formats the progress of a recalculation.
+func formatRecalculation(r *cpuinfo.Recalculation) string {
+	if r.State != cpuinfo.RecalculationRunning {
+		return r.State
+	}
+	if r.Round == 0 {
+		return "measurement pending (waiting for a quiet host)"
+	}
+	return fmt.Sprintf("measuring: round %d/%d (%.0f%%)", r.Round, r.Rounds, r.Progress*100)
+}
+
+func newReloadConfigCmd(socketFile *string) *cobra.Command {
+	var jsonOutput bool
+
+	cmd := &cobra.Command{
+		Use:   "reload-config",
+		Short: "Reload the config file of the service (like SIGHUP)",
+		Run: func(cmd *cobra.Command, args []string) {
+			result, err := newClient(*socketFile).ReloadConfig(cmd.Context())
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			if jsonOutput {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				_ = enc.Encode(result)
+				return
+			}
+			fmt.Print(formatReloadResult(result))
+		},
+	}
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Output in JSON format")
+	return cmd
+}
+
+// formatReloadResult lists the settings applied by a reload and the ones that
+// need a restart.
+func formatReloadResult(result *config.ReloadResult) string {
+	if len(result.Applied) == 0 && len(result.RestartRequired) == 0 {
+		return "Configuration reloaded, no settings changed\n"
+	}
+	var b strings.Builder
+	if len(result.Applied) > 0 {
+		fmt.Fprintf(&b, "Applied: %s\n", strings.Join(result.Applied, ", "))
+	}
+	if len(result.RestartRequired) > 0 {
+		fmt.Fprintf(&b, "Restart required: %s\n", strings.Join(result.RestartRequired, ", "))
+	}
+	return b.String()
 }
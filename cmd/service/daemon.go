@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+
+	"github.com/egandro/proxmox-cpu-affinity/pkg/config"
+	"github.com/egandro/proxmox-cpu-affinity/pkg/cpuinfo"
+	"github.com/egandro/proxmox-cpu-affinity/pkg/logger"
+)
+
+// daemon holds the state of the service that a reload of the config file
+// (SIGHUP or the reload-config command) changes.
+type daemon struct {
+	mu         sync.Mutex
+	configFile string
+	// adjust applies the command line flags and the virtualization mode
+	adjust   func(*config.Config)
+	cfg      *config.Config
+	toStdout bool
+	logF     *os.File
+
+	cpuInfo cpuinfo.Provider
+	applier cpuinfo.AffinityApplier
+	service interface {
+		SetMeasurement(rounds, iterations int)
+		SetHotplugWatchdog(enabled, running bool)
+	}
+	hotplug         cpuinfo.HotplugController
+	watchdogRunning bool
+	// the initial calculation is done, the watchdog may run
+	ready bool
+}
+
+// loadConfig reads the config file, reload selects config.Reload.
+func (d *daemon) loadConfig(reload bool) (*config.Config, error) {
+	var cfg *config.Config
+	if reload {
+		var err error
+		if cfg, err = config.Reload(d.configFile); err != nil {
+			return nil, err
+		}
+	} else {
+		cfg = config.Load(d.configFile)
+	}
+	d.adjust(cfg)
+	return cfg, nil
+}
+
+// openLog (re)opens the log file and sets the default logger. It is called
+// with d.mu held or before the daemon is shared.
+func (d *daemon) openLog() {
+	var output io.Writer = os.Stdout
+	if !d.toStdout {
+		f, err := os.OpenFile(d.cfg.LogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			if d.logF == nil {
+				fmt.Fprintf(os.Stderr, "Failed to open log file %s: %v. Logging to stdout.\n", d.cfg.LogFile, err)
+			} else {
+				// keep logging to the old file
+				slog.Error("Failed to open log file", "file", d.cfg.LogFile, "error", err)
+				return
+			}
+		} else {
+			if d.logF != nil {
+				_ = d.logF.Close()
+			}
+			d.logF = f
+			output = f
+		}
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(d.cfg.LogLevel)); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid log level '%s': %v, defaulting to INFO\n", d.cfg.LogLevel, err)
+		level = slog.LevelInfo
+	}
+
+	handler := &logger.SimpleHandler{Output: output, Level: level}
+	slog.SetDefault(slog.New(handler))
+}
+
+// startWatchdog is called once the initial calculation is done, it starts
+// the hotplug watchdog if it is enabled.
+func (d *daemon) startWatchdog() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.ready = true
+	if d.cfg.CPUHotplugWatchdog {
+		d.enableWatchdog()
+	}
+	d.reportWatchdog()
+}
+
+// stopWatchdog stops the hotplug watchdog if it is running.
+func (d *daemon) stopWatchdog() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.disableWatchdog()
+	d.reportWatchdog()
+}
+
+// reportWatchdog passes the watchdog state to the health command.
+func (d *daemon) reportWatchdog() {
+	d.service.SetHotplugWatchdog(d.cfg.CPUHotplugWatchdog, d.watchdogRunning)
+}
+
+func (d *daemon) enableWatchdog() {
+	if d.hotplug != nil {
+		return
+	}
+	d.hotplug = cpuinfo.NewHotplug(d.cpuInfo, d.cfg, d.applier)
+	if err := d.hotplug.StartWatchdog(); err != nil {
+		slog.Warn("Failed to start CPU hotplug watchdog", "error", err)
+		return
+	}
+	d.watchdogRunning = true
+}
+
+func (d *daemon) disableWatchdog() {
+	if d.hotplug == nil {
+		return
+	}
+	if err := d.hotplug.StopWatchdog(); err != nil {
+		slog.Error("Failed to stop hotplug watchdog", "error", err)
+	}
+	d.hotplug = nil
+	d.watchdogRunning = false
+}
+
+// reload reads the config file again and applies the log settings, the
+// hotplug watchdog and the measurement parameters, the hookscript reads the
+// socket settings on every run. Other changed settings need a restart, they
+// are not taken over and reported by every reload until then.
+func (d *daemon) reload() (*config.ReloadResult, error) {
+	cfg, err := d.loadConfig(true)
+	if err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	result := config.Compare(d.cfg, cfg)
+
+	running := *d.cfg
+	running.LogLevel = cfg.LogLevel
+	running.LogFile = cfg.LogFile
+	running.Rounds = cfg.Rounds
+	running.Iterations = cfg.Iterations
+	running.SocketRetry = cfg.SocketRetry
+	running.SocketSleep = cfg.SocketSleep
+	running.SocketTimeout = cfg.SocketTimeout
+	running.SocketPingOnPreStart = cfg.SocketPingOnPreStart
+	running.CPUHotplugWatchdog = cfg.CPUHotplugWatchdog
+	d.cfg = &running
+
+	// Reopens the log file, e.g. after logrotate
+	d.openLog()
+
+	d.service.SetMeasurement(running.Rounds, running.Iterations)
+	if d.hotplug != nil {
+		d.hotplug.SetMeasurement(running.Rounds, running.Iterations)
+	}
+	if d.ready {
+		if running.CPUHotplugWatchdog {
+			d.enableWatchdog()
+		} else {
+			d.disableWatchdog()
+		}
+	}
+	d.reportWatchdog()
+
+	slog.Info("Configuration reloaded", "applied", result.Applied)
+	if len(result.RestartRequired) > 0 {
+		slog.Warn("Changed settings need a restart", "settings", result.RestartRequired)
+	}
+	return &result, nil
+}
@@ -3,19 +3,19 @@ package main
 import (
 	"context"
 	"flag"
-	"fmt"
-	"io"
 	"log/slog"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
 	"time"
 
+	"github.com/egandro/proxmox-cpu-affinity/pkg/audit"
 	"github.com/egandro/proxmox-cpu-affinity/pkg/config"
 	"github.com/egandro/proxmox-cpu-affinity/pkg/cpuinfo"
-	"github.com/egandro/proxmox-cpu-affinity/pkg/logger"
 	"github.com/egandro/proxmox-cpu-affinity/pkg/scheduler"
 	"github.com/egandro/proxmox-cpu-affinity/pkg/service"
+	"github.com/egandro/proxmox-cpu-affinity/pkg/systemd"
 )
 
 func main() {
@@ -28,59 +28,89 @@ func main() {
 
 	flag.Parse()
 
-	cfg := config.Load(*configFile)
+	// Virtualization is detected once, the mode is applied on every reload
+	virt := cpuinfo.DetectVirtualization()
 
-	// Override config with flags if provided
-	if *socketFlag != "" {
-		cfg.SocketFile = *socketFlag
+	d := &daemon{
+		configFile: *configFile,
+		toStdout:   *toStdout,
+		adjust: func(cfg *config.Config) {
+			// Override config with flags if provided
+			if *socketFlag != "" {
+				cfg.SocketFile = *socketFlag
+			}
+			if *logFileFlag != "" {
+				cfg.LogFile = *logFileFlag
+			}
+			if *logLevelFlag != "" {
+				cfg.LogLevel = *logLevelFlag
+			}
+			if *disableCpuHotplugWatchdog {
+				cfg.CPUHotplugWatchdog = false
+			}
+			if virt.Virtualized && cfg.VirtualizedMode == config.VirtualizedNoiseTolerant {
+				cfg.Rounds *= config.ConstantVirtualizedRoundsFactor
+				if cfg.NormalizeTolerance == 0 {
+					cfg.NormalizeTolerance = config.ConstantVirtualizedNormalizeTolerance
+				}
+			}
+		},
 	}
-	if *logFileFlag != "" {
-		cfg.LogFile = *logFileFlag
+	cfg, _ := d.loadConfig(false)
+	d.cfg = cfg
+	d.openLog()
+
+	slog.Info("Proxmox CPU affinity service starting")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Handle signals (installed early, so SIGTERM can interrupt the initial calculation)
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+
+	cpuInfoOpts := []cpuinfo.Option{cpuinfo.WithNUMAWeight(cfg.NUMAWeight)}
+	// Latencies between vCPUs are random, e.g. in a nested Proxmox lab
+	if virt.Virtualized {
+		slog.Warn("Host is virtualized, measured latencies are unreliable", "mode", cfg.VirtualizedMode,
+			"hypervisor", virt.Hypervisor, "hypervisor_flag", virt.HypervisorFlag, "vendor", virt.Vendor, "product", virt.Product)
+		cpuInfoOpts = append(cpuInfoOpts, cpuinfo.WithVirtualization(cfg.VirtualizedMode == config.VirtualizedTopology))
 	}
-	if *logLevelFlag != "" {
-		cfg.LogLevel = *logLevelFlag
+	if cfg.NoiseGuard {
+		cpuInfoOpts = append(cpuInfoOpts, cpuinfo.WithNoiseGuard(cpuinfo.NoiseGuard{
+			MaxBusy:     cfg.NoiseMaxBusy,
+			MaxPressure: cfg.NoiseMaxPressure,
+			MaxWait:     time.Duration(cfg.NoiseMaxWait) * time.Second,
+			Interval:    config.ConstantNoiseSampleInterval,
+		}))
 	}
-	if *disableCpuHotplugWatchdog {
-		cfg.CPUHotplugWatchdog = false
+	if sampling, ok := cpuinfo.NewSampling(cfg.Sampling, cfg.SamplingPerClass, cfg.SamplingSpotChecks); ok {
+		cpuInfoOpts = append(cpuInfoOpts, cpuinfo.WithSampling(sampling))
 	}
-
-	var logF *os.File
-	var output io.Writer = os.Stdout
-
-	if !*toStdout {
-		f, err := os.OpenFile(cfg.LogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to open log file %s: %v. Logging to stdout.\n", cfg.LogFile, err)
-		} else {
-			logF = f
-			output = f
-		}
+	if kernels, err := cpuinfo.ParseKernelWeights(cfg.Kernels); err != nil {
+		slog.Warn("Invalid PCA_KERNELS, measuring ping-pong only", "kernels", cfg.Kernels, "error", err)
+	} else {
+		cpuInfoOpts = append(cpuInfoOpts, cpuinfo.WithKernels(kernels))
 	}
-
-	// Configure slog level
-	var level slog.Level
-	if err := level.UnmarshalText([]byte(cfg.LogLevel)); err != nil {
-		fmt.Fprintf(os.Stderr, "Invalid log level '%s': %v, defaulting to INFO\n", cfg.LogLevel, err)
-		level = slog.LevelInfo
+	if cfg.NormalizeTolerance > 0 {
+		cpuInfoOpts = append(cpuInfoOpts, cpuinfo.WithNormalization(cfg.NormalizeTolerance))
 	}
+	if cfg.LatencyMatrixFile != "" {
+		cpuInfoOpts = append(cpuInfoOpts, cpuinfo.WithImportedMatrix(cfg.LatencyMatrixFile))
+	}
+	cpuInfo := cpuinfo.New(cpuInfoOpts...)
 
-	handler := &logger.SimpleHandler{Output: output, Level: level}
-	slog.SetDefault(slog.New(handler))
-
-	slog.Info("Proxmox CPU affinity service starting")
-
-	cpuInfo := cpuinfo.New()
-
-	if err := cpuInfo.CalculateRanking(cfg.Rounds, cfg.Iterations, config.ConstantMaxCalculationRankingDuration); err != nil {
-		slog.Error("Failed to calculate ranking", "error", err)
-		os.Exit(1)
+	// Serve requests from a topology-only ranking until the measurement is done
+	if err := cpuInfo.UseTopologyFallback(); err != nil {
+		slog.Warn("Failed to create topology fallback ranking", "error", err)
 	}
 
-	var hotplugController cpuinfo.HotplugController
-	if cfg.CPUHotplugWatchdog {
-		hotplugController = cpuinfo.NewHotplug(cpuInfo, cfg)
-		if err := hotplugController.StartWatchdog(); err != nil {
-			slog.Warn("Failed to start CPU hotplug watchdog", "error", err)
+	// Placement decisions are kept in the audit journal, independent of the log
+	if cfg.AuditFile != "" {
+		if err := audit.Default.Open(cfg.AuditFile, int64(cfg.AuditMaxSize)*1024*1024); err != nil {
+			slog.Warn("Failed to open audit journal, placements are not recorded", "error", err)
+		} else {
+			defer func() { _ = audit.Default.Close() }()
 		}
 	}
 
@@ -90,10 +120,41 @@ func main() {
 		os.Exit(1)
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
 	s := service.New(ctx, cfg.SocketFile, sched, cpuInfo)
+	s.SetMeasurement(cfg.Rounds, cfg.Iterations)
+	d.cpuInfo = cpuInfo
+	d.applier = sched
+	d.service = s
+	d.reportWatchdog()
+	s.SetReloader(d.reload)
+
+	// Socket activation, early hookscripts wait in the queue of the socket
+	listeners, err := systemd.Listeners()
+	if err != nil {
+		slog.Warn("Failed to use the sockets passed by systemd", "error", err)
+	}
+	if len(listeners) > 0 && !sameSocket(listeners[0].Addr().String(), cfg.SocketFile) {
+		// The hookscript and the CLI connect to PCA_SOCKET_FILE
+		slog.Warn("The socket passed by systemd is not PCA_SOCKET_FILE, ignoring it (adjust ListenStream of the socket unit)",
+			"socket", listeners[0].Addr().String(), "socket_file", cfg.SocketFile)
+		for _, l := range listeners {
+			_ = l.Close()
+		}
+		listeners = nil
+	}
+	if len(listeners) > 0 {
+		slog.Info("Using the socket passed by systemd", "socket", listeners[0].Addr().String())
+		s.UseListener(listeners[0])
+		for _, l := range listeners[1:] {
+			slog.Warn("Ignoring additional socket passed by systemd", "socket", l.Addr().String())
+			_ = l.Close()
+		}
+	}
+
+	go func() {
+		<-s.Listening()
+		notify(systemd.Ready)
+	}()
 
 	go func() {
 		if err := s.Start(); err != nil {
@@ -102,42 +163,130 @@ func main() {
 		}
 	}()
 
-	// Handle signals
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+	// The local socket keeps working if the remote listener fails
+	if cfg.RemoteListen != "" {
+		go func() {
+			err := s.StartRemote(service.RemoteConfig{
+				Listen:       cfg.RemoteListen,
+				CertFile:     cfg.RemoteTLSCert,
+				KeyFile:      cfg.RemoteTLSKey,
+				ClientCAFile: cfg.RemoteClientCA,
+				TokensFile:   cfg.RemoteTokensFile,
+				Admins:       cfg.RemoteAdmins,
+			})
+			if err != nil {
+				slog.Error("Remote listener failed", "error", err)
+			}
+		}()
+	}
+
+	if cfg.MetricsListen != "" {
+		go func() {
+			if err := s.StartMetrics(cfg.MetricsListen); err != nil {
+				slog.Error("Metrics listener failed", "error", err)
+			}
+		}()
+	}
 
-	for sig := range sigChan {
-		switch sig {
-		case syscall.SIGHUP:
-			if logF != nil {
-				newF, err := os.OpenFile(cfg.LogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
-				if err == nil {
-					_ = logF.Close()
-					logF = newF
-
-					// Re-create slog handler with new file
-					handler := &logger.SimpleHandler{Output: logF, Level: level}
-					slog.SetDefault(slog.New(handler))
-
-					slog.Info("Log file rotated")
-				} else {
-					slog.Error("Failed to rotate log", "error", err)
+	calcDone := make(chan error, 1)
+	go func() {
+		calcDone <- cpuInfo.CalculateRanking(ctx, cfg.Rounds, cfg.Iterations, config.ConstantMaxCalculationRankingDuration)
+	}()
+
+	// Keepalives have their own goroutine, the main loop blocks while VMs
+	// are re-placed or the measurement is cancelled on shutdown
+	if interval, err := systemd.WatchdogInterval(); err != nil {
+		slog.Warn("Ignoring systemd watchdog", "error", err)
+	} else if interval > 0 {
+		stopKeepalive := make(chan struct{})
+		defer close(stopKeepalive)
+		go func() {
+			ticker := time.NewTicker(interval / 2)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					notify(systemd.Watchdog)
+				case <-stopKeepalive:
+					return
 				}
 			}
-		case syscall.SIGINT, syscall.SIGTERM:
-			slog.Info("Shutting down service...")
-			cancel()
-			if hotplugController != nil {
-				if err := hotplugController.StopWatchdog(); err != nil {
-					slog.Error("Failed to stop hotplug watchdog", "error", err)
+		}()
+	}
+	var statusTick <-chan time.Time
+	if systemd.NotifyEnabled() {
+		ticker := time.NewTicker(config.ConstantNotifyStatusInterval)
+		defer ticker.Stop()
+		statusTick = ticker.C
+	}
+	var lastStatus string
+
+	for {
+		select {
+		case <-statusTick:
+			if status := statusText(cpuInfo.Status()); status != lastStatus {
+				notify(systemd.Status(status))
+				lastStatus = status
+			}
+		case err := <-calcDone:
+			calcDone = nil
+			if err != nil {
+				// VMs are still placed by topology, the failure is reported
+				// by health and the systemd status. A recalculation may succeed.
+				slog.Error("Failed to calculate ranking, serving the topology ranking", "error", err)
+				if status := statusText(cpuInfo.Status()); status != lastStatus {
+					notify(systemd.Status(status))
+					lastStatus = status
+				}
+			}
+
+			// VMs started while warming up were placed by topology only
+			for _, vmid := range cpuInfo.TakeProvisional() {
+				slog.Info("Re-placing VM with measured ranking", "vmid", vmid)
+				if _, err := sched.UpdateAffinity(ctx, vmid); err != nil {
+					slog.Error("Failed to re-place VM", "vmid", vmid, "error", err)
 				}
 			}
-			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-			defer cancel()
-			if err := s.Shutdown(ctx); err != nil {
-				slog.Error("Shutdown error", "error", err)
+
+			d.startWatchdog()
+		case sig := <-sigChan:
+			switch sig {
+			case syscall.SIGHUP:
+				if _, err := d.reload(); err != nil {
+					slog.Error("Failed to reload configuration", "error", err)
+				}
+			case syscall.SIGINT, syscall.SIGTERM:
+				slog.Info("Shutting down service...")
+				notify(systemd.Stopping)
+				cancel()
+				if calcDone != nil {
+					// Wait for the measurement goroutines to release the CPUs
+					<-calcDone
+				}
+				d.stopWatchdog()
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				if err := s.Shutdown(ctx); err != nil {
+					slog.Error("Shutdown error", "error", err)
+				}
+				return
 			}
-			return
 		}
 	}
 }
+
+// sameSocket reports whether the unix socket paths a and b are the same,
+// e.g. /var/run/x.sock and /run/x.sock if /var/run links to /run.
+func sameSocket(a, b string) bool {
+	return resolveSocket(a) == resolveSocket(b)
+}
+
+// resolveSocket resolves the links of the directory of path, the socket
+// itself is not resolved.
+func resolveSocket(path string) string {
+	dir, err := filepath.EvalSymlinks(filepath.Dir(path))
+	if err != nil {
+		return filepath.Clean(path)
+	}
+	return filepath.Join(dir, filepath.Base(path))
+}
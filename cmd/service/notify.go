@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/egandro/proxmox-cpu-affinity/pkg/cpuinfo"
+	"github.com/egandro/proxmox-cpu-affinity/pkg/systemd"
+)
+
+// notify sends state to systemd, if the service runs as Type=notify.
+func notify(state string) {
+	if _, err := systemd.Notify(state); err != nil {
+		slog.Warn("Failed to notify systemd", "state", state, "error", err)
+	}
+}
+
+// statusText describes the ranking for the status shown by systemctl.
+func statusText(status cpuinfo.RankingStatus) string {
+	switch {
+	case status.State == cpuinfo.StateWarmingUp && status.Error != "" && !status.Running:
+		return fmt.Sprintf("Degraded: measurement failed (%s), placing VMs by topology", status.Error)
+	case status.State == cpuinfo.StateWarmingUp && status.Waiting:
+		return "Warming up: measurement postponed, the host is busy, placing VMs by topology"
+	case status.State == cpuinfo.StateWarmingUp:
+		return fmt.Sprintf("Warming up: %d/%d pairs measured (%.0f%%), placing VMs by topology",
+			status.Measured, status.Total, status.Progress*100)
+	case status.Error != "":
+		return fmt.Sprintf("Ready (%s ranking), last calculation failed: %s", status.Source, status.Error)
+	case status.Running && status.Round > 0:
+		return fmt.Sprintf("Ready (%s ranking), recalculating: round %d/%d", status.Source, status.Round, status.Rounds)
+	case status.Running:
+		return fmt.Sprintf("Ready (%s ranking), recalculating", status.Source)
+	default:
+		return fmt.Sprintf("Ready (%s ranking)", status.Source)
+	}
+}
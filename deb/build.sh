@@ -29,6 +29,7 @@ fi
 
 # Create systemd service
 install -m 644 deb/proxmox-cpu-affinity.service dist/etc/systemd/system/
+install -m 644 deb/proxmox-cpu-affinity.socket dist/etc/systemd/system/
 
 # Create default config file
 install -m 644 deb/proxmox-cpu-affinity.default dist/etc/default/proxmox-cpu-affinity
@@ -0,0 +1,232 @@
+// Package audit writes the placement decisions of the service to an
+// append-only JSONL journal, so the reason a VM got its CPUs is still known
+// after the log was rotated. It is queried by the `history` command.
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// Results of a placement.
+const (
+	ResultApplied = "applied" // the affinity was set
+	ResultPartial = "partial" // the affinity was set, except for FailedThreads
+	ResultSkipped = "skipped" // the VM was left alone, see Reason
+	ResultFailed  = "failed"  // see Reason and Error
+)
+
+// Entry is a line of the journal.
+type Entry struct {
+	Time          time.Time `json:"time"`
+	VMID          int       `json:"vmid"`
+	RequestedCPUs int       `json:"requested_cpus,omitempty"` // cores * sockets of the VM
+	PrimaryCPU    *int      `json:"primary_cpu,omitempty"`    // the CPU the others were ranked from
+	CPUs          []int     `json:"cpus,omitempty"`
+	// the ranking the CPUs were selected from
+	Generation       uint64     `json:"generation,omitempty"`
+	RankingCreatedAt *time.Time `json:"ranking_created_at,omitempty"`
+	PreviousMask     string     `json:"previous_mask,omitempty"` // CPU list of the VM process before, e.g. "0-63"
+	Result           string     `json:"result"`
+	Reason           string     `json:"reason,omitempty"` // a metrics reason or why the VM was skipped
+	Error            string     `json:"error,omitempty"`
+	FailedThreads    []int      `json:"failed_threads,omitempty"` // threads whose affinity could not be set
+}
+
+// Query selects entries of the journal.
+type Query struct {
+	VMID  int       // 0 selects all VMs
+	Since time.Time // zero selects all entries
+}
+
+// Match reports whether e is selected by q.
+func (q Query) Match(e Entry) bool {
+	if q.VMID != 0 && e.VMID != q.VMID {
+		return false
+	}
+	return q.Since.IsZero() || !e.Time.Before(q.Since)
+}
+
+// maxLineSize is the longest line Query reads.
+const maxLineSize = 1024 * 1024
+
+// Journal appends entries to a file. Once the file would exceed the size
+// cap, it is moved to <path>.1 (replacing the previous one) and a new file is
+// started, so the journal takes at most twice the cap on disk.
+type Journal struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	f       *os.File
+	size    int64
+}
+
+// Open starts writing to path, an existing journal is continued. maxSize is
+// in bytes, 0 disables the cap. A journal that is not open drops entries.
+func (j *Journal) Open(path string, maxSize int64) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit journal %s: %w", path, err)
+	}
+	size, err := terminate(f)
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf("failed to open audit journal %s: %w", path, err)
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.f != nil {
+		_ = j.f.Close()
+	}
+	j.path = path
+	j.maxSize = maxSize
+	j.f = f
+	j.size = size
+	return nil
+}
+
+// terminate ends a line cut off by a crash, so the next entry starts on a
+// new line. It returns the size of the file.
+func terminate(f *os.File) (int64, error) {
+	info, err := f.Stat()
+	if err != nil || info.Size() == 0 {
+		return 0, err
+	}
+	last := make([]byte, 1)
+	if _, err := f.ReadAt(last, info.Size()-1); err != nil {
+		return 0, err
+	}
+	if last[0] == '\n' {
+		return info.Size(), nil
+	}
+	n, err := f.Write([]byte{'\n'})
+	return info.Size() + int64(n), err
+}
+
+// Close stops writing, later entries are dropped.
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.f == nil {
+		return nil
+	}
+	err := j.f.Close()
+	j.f = nil
+	return err
+}
+
+// Enabled reports whether the journal is open.
+func (j *Journal) Enabled() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.f != nil
+}
+
+// Record appends e, the time is set if it is zero. Write errors are logged,
+// a placement never fails because of the journal.
+func (j *Journal) Record(e Entry) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		slog.Error("Failed to encode audit entry", "vmid", e.VMID, "error", err)
+		return
+	}
+	line = append(line, '\n')
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.f == nil {
+		return
+	}
+	if j.maxSize > 0 && j.size > 0 && j.size+int64(len(line)) > j.maxSize {
+		if err := j.rotate(); err != nil {
+			slog.Error("Failed to rotate audit journal", "file", j.path, "error", err)
+		}
+	}
+	n, err := j.f.Write(line)
+	j.size += int64(n)
+	if err != nil {
+		slog.Error("Failed to write audit entry", "file", j.path, "vmid", e.VMID, "error", err)
+	}
+}
+
+// rotate moves the journal to <path>.1 and starts a new one, it is called
+// with j.mu held.
+func (j *Journal) rotate() error {
+	if err := os.Rename(j.path, j.path+".1"); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(j.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	_ = j.f.Close()
+	j.f = f
+	j.size = 0
+	return nil
+}
+
+// Query returns the entries selected by q, oldest first.
+func (j *Journal) Query(q Query) ([]Entry, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.f == nil {
+		return nil, errors.New("the audit journal is disabled")
+	}
+
+	entries := []Entry{}
+	for _, path := range []string{j.path + ".1", j.path} {
+		var err error
+		if entries, err = readEntries(path, q, entries); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// readEntries appends the entries of path selected by q to entries. Lines
+// that can't be decoded (e.g. cut off by a crash) are skipped.
+func readEntries(path string, q Query, entries []Entry) ([]Entry, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit journal: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue
+		}
+		if q.Match(e) {
+			entries = append(entries, e)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read audit journal: %w", err)
+	}
+	return entries, nil
+}
+
+// Default is the journal of the service, it is opened on start if PCA_AUDIT_FILE is set.
+var Default = &Journal{}
+
+// Record appends e to the Default journal.
+func Record(e Entry) {
+	Default.Record(e)
+}
@@ -0,0 +1,103 @@
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJournal_RecordQuery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	j := &Journal{}
+	// Not open, entries are dropped
+	j.Record(Entry{VMID: 100, Result: ResultApplied})
+	_, err := j.Query(Query{})
+	assert.Error(t, err)
+
+	require.NoError(t, j.Open(path, 0))
+	defer func() { _ = j.Close() }()
+	assert.True(t, j.Enabled())
+
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	primary := 2
+	j.Record(Entry{Time: start, VMID: 100, RequestedCPUs: 2, PrimaryCPU: &primary, CPUs: []int{2, 3}, PreviousMask: "0-7", Result: ResultApplied})
+	j.Record(Entry{Time: start.Add(time.Hour), VMID: 101, Result: ResultFailed, Reason: "vm-pid", Error: "no such process"})
+	j.Record(Entry{Time: start.Add(2 * time.Hour), VMID: 100, Result: ResultSkipped, Reason: "affinity-configured"})
+	j.Record(Entry{VMID: 102, Result: ResultApplied})
+
+	entries, err := j.Query(Query{})
+	require.NoError(t, err)
+	require.Len(t, entries, 4)
+	assert.Equal(t, 2, *entries[0].PrimaryCPU)
+	assert.Equal(t, []int{2, 3}, entries[0].CPUs)
+	assert.Equal(t, "0-7", entries[0].PreviousMask)
+	assert.False(t, entries[3].Time.IsZero())
+
+	entries, err = j.Query(Query{VMID: 100})
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, ResultSkipped, entries[1].Result)
+
+	entries, err = j.Query(Query{Since: start.Add(time.Hour)})
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	assert.Equal(t, 101, entries[0].VMID)
+
+	// The journal is continued after a restart
+	require.NoError(t, j.Close())
+	require.NoError(t, j.Open(path, 0))
+	j.Record(Entry{VMID: 103, Result: ResultApplied})
+	entries, err = j.Query(Query{})
+	require.NoError(t, err)
+	assert.Len(t, entries, 5)
+}
+
+func TestJournal_SizeCap(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	j := &Journal{}
+	require.NoError(t, j.Open(path, 300))
+	defer func() { _ = j.Close() }()
+
+	for vmid := 100; vmid < 120; vmid++ {
+		j.Record(Entry{VMID: vmid, CPUs: []int{0, 1}, Result: ResultApplied})
+	}
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, info.Size(), int64(300))
+	old, err := os.Stat(path + ".1")
+	require.NoError(t, err)
+	assert.LessOrEqual(t, old.Size(), int64(300))
+
+	// The oldest entries are dropped, the rest is returned in order
+	entries, err := j.Query(Query{})
+	require.NoError(t, err)
+	require.NotEmpty(t, entries)
+	assert.Less(t, len(entries), 20)
+	assert.Equal(t, 119, entries[len(entries)-1].VMID)
+	for i := 1; i < len(entries); i++ {
+		assert.Equal(t, entries[i-1].VMID+1, entries[i].VMID)
+	}
+}
+
+func TestJournal_SkipsBrokenLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	require.NoError(t, os.WriteFile(path, []byte(`{"vmid":100,"result":"applied"}`+"\n"+`{"vmid":101,"res`), 0600))
+
+	j := &Journal{}
+	require.NoError(t, j.Open(path, 0))
+	defer func() { _ = j.Close() }()
+
+	// The next entry starts on a new line
+	j.Record(Entry{VMID: 102, Result: ResultApplied})
+
+	entries, err := j.Query(Query{})
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, 100, entries[0].VMID)
+	assert.Equal(t, 102, entries[1].VMID)
+}
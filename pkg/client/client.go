@@ -0,0 +1,424 @@
+// Package client implements the JSON protocol of the service socket. It is
+// used by the CLI and the hookscript and can be imported by other Go tools.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/egandro/proxmox-cpu-affinity/pkg/audit"
+	"github.com/egandro/proxmox-cpu-affinity/pkg/config"
+	"github.com/egandro/proxmox-cpu-affinity/pkg/cpuinfo"
+	"github.com/egandro/proxmox-cpu-affinity/pkg/events"
+	"github.com/egandro/proxmox-cpu-affinity/pkg/health"
+)
+
+// Status values of a Response.
+const (
+	StatusOK    = "ok"
+	StatusError = "error"
+)
+
+// Defaults of a Client, no retries.
+const (
+	DefaultTimeout    = 2 * time.Second
+	DefaultBackoff    = time.Second
+	DefaultMaxBackoff = 30 * time.Second
+)
+
+var (
+	// ErrUnavailable is returned (wrapped) if the service can't be reached or
+	// the connection failed, e.g. it is not running or still starting.
+	// Only these errors are retried, see Do.
+	ErrUnavailable = errors.New("service is not reachable")
+	// ErrProtocol is returned (wrapped) if the response can't be decoded.
+	ErrProtocol = errors.New("invalid response")
+)
+
+// ServiceError is returned if the service answered with an error status.
+type ServiceError struct {
+	Command string
+	Message string
+}
+
+func (e *ServiceError) Error() string {
+	return e.Message
+}
+
+// Request is a command of the socket protocol.
+type Request struct {
+	Command    string     `json:"command"`
+	VMID       int        `json:"vmid,omitempty"`
+	Rounds     int        `json:"rounds,omitempty"`
+	Iterations int        `json:"iterations,omitempty"`
+	Since      *time.Time `json:"since,omitempty"`
+}
+
+// Response is the answer to a Request. Meta describes the ranking generation
+// the data is based on.
+type Response struct {
+	Status string               `json:"status"`
+	Data   json.RawMessage      `json:"data,omitempty"`
+	Meta   *cpuinfo.RankingMeta `json:"meta,omitempty"`
+	Error  string               `json:"error,omitempty"`
+}
+
+// WarmingUp reports whether the service answered from the topology-only
+// ranking while the measurement is still running.
+func (r *Response) WarmingUp() bool {
+	return r.Meta != nil && r.Meta.Source == cpuinfo.SourceTopology
+}
+
+// Decode decodes the data of the response into target.
+func (r *Response) Decode(target interface{}) error {
+	if len(r.Data) == 0 {
+		return fmt.Errorf("%w: no data", ErrProtocol)
+	}
+	if err := json.Unmarshal(r.Data, target); err != nil {
+		return fmt.Errorf("%w: %v", ErrProtocol, err)
+	}
+	return nil
+}
+
+// Client sends requests to the service socket.
+type Client struct {
+	socketPath string
+	timeout    time.Duration
+	retries    int
+	backoff    time.Duration
+	maxBackoff time.Duration
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithTimeout sets the timeout of a single attempt (connect, send, receive).
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.timeout = timeout
+	}
+}
+
+// WithRetry retries a request up to retries times if the service is
+// unavailable (see Do). The wait starts at backoff and doubles up to maxBackoff.
+func WithRetry(retries int, backoff, maxBackoff time.Duration) Option {
+	return func(c *Client) {
+		c.retries = retries
+		c.backoff = backoff
+		c.maxBackoff = maxBackoff
+	}
+}
+
+// New returns a client for the socket, config.ConstantSocketFile if empty.
+func New(socketPath string, opts ...Option) *Client {
+	if socketPath == "" {
+		socketPath = config.ConstantSocketFile
+	}
+	c := &Client{
+		socketPath: socketPath,
+		timeout:    DefaultTimeout,
+		backoff:    DefaultBackoff,
+		maxBackoff: DefaultMaxBackoff,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// SocketPath returns the path of the socket.
+func (c *Client) SocketPath() string {
+	return c.socketPath
+}
+
+// idempotent are the commands that may be sent again if the response was
+// lost, the others (e.g. update-affinity, recalculate) could run twice.
+var idempotent = map[string]bool{
+	"ping":                 true,
+	"health":               true,
+	"core-ranking":         true,
+	"core-ranking-summary": true,
+	"core-clusters":        true,
+	"core-topology":        true,
+	"core-vm-affinity":     true,
+	"recalculate-status":   true,
+	"history":              true,
+}
+
+// Do sends req and returns the response. A response with an error status is
+// returned together with a *ServiceError.
+// Requests that could not be sent are retried. Once sent, only idempotent
+// commands are retried if the response is lost.
+func (c *Client) Do(ctx context.Context, req Request) (*Response, error) {
+	wait := c.backoff
+	for attempt := 0; ; attempt++ {
+		resp, sent, err := c.do(ctx, req)
+		if err == nil || !errors.Is(err, ErrUnavailable) || attempt >= c.retries {
+			return resp, err
+		}
+		if sent && !idempotent[req.Command] {
+			return resp, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%w: %w", ErrUnavailable, ctx.Err())
+		case <-time.After(wait):
+		}
+		wait = min(wait*2, c.maxBackoff)
+	}
+}
+
+// do sends req once, sent reports whether the request was written.
+func (c *Client) do(ctx context.Context, req Request) (resp *Response, sent bool, err error) {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	deadline := time.Now().Add(c.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	_ = conn.SetDeadline(deadline)
+	// Unblock reads and writes if ctx is cancelled
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, false, fmt.Errorf("%w: failed to send request: %w", ErrUnavailable, err)
+	}
+
+	resp = &Response{}
+	if err := json.NewDecoder(conn).Decode(resp); err != nil {
+		return nil, true, decodeError(err)
+	}
+	if resp.Status != StatusOK {
+		return resp, true, &ServiceError{Command: req.Command, Message: resp.Error}
+	}
+	return resp, true, nil
+}
+
+func (c *Client) dial(ctx context.Context) (net.Conn, error) {
+	conn, err := (&net.Dialer{Timeout: c.timeout}).DialContext(ctx, "unix", c.socketPath)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+	return conn, nil
+}
+
+// decodeError tells malformed responses from broken connections.
+func decodeError(err error) error {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+		return fmt.Errorf("%w: %w", ErrProtocol, err)
+	}
+	if errors.Is(err, io.EOF) {
+		return fmt.Errorf("%w: connection closed by the service", ErrUnavailable)
+	}
+	return fmt.Errorf("%w: failed to receive response: %w", ErrUnavailable, err)
+}
+
+// fetch sends command and decodes the data into target, it returns the meta
+// of the response (nil if not sent).
+func (c *Client) fetch(ctx context.Context, command string, target interface{}) (*cpuinfo.RankingMeta, error) {
+	resp, err := c.Do(ctx, Request{Command: command})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Meta, resp.Decode(target)
+}
+
+// Health returns the health report of the service, the ranking meta is
+// part of it.
+func (c *Client) Health(ctx context.Context) (*health.Health, error) {
+	var h health.Health
+	if _, err := c.fetch(ctx, "health", &h); err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
+
+// Ping is the result of a ping.
+type Ping struct {
+	// WarmingUp is set while the ranking is measured, Status holds the progress.
+	WarmingUp bool
+	Status    *cpuinfo.RankingStatus
+	Meta      *cpuinfo.RankingMeta
+}
+
+// Ping checks whether the service is up.
+func (c *Client) Ping(ctx context.Context) (*Ping, error) {
+	resp, err := c.Do(ctx, Request{Command: "ping"})
+	if err != nil {
+		return nil, err
+	}
+	var pong string
+	if err := resp.Decode(&pong); err != nil || pong != "pong" {
+		return nil, fmt.Errorf("%w: service did not respond with pong (data=%s)", ErrProtocol, resp.Data)
+	}
+	ping := &Ping{WarmingUp: resp.WarmingUp(), Meta: resp.Meta}
+	if ping.WarmingUp {
+		// The progress is not part of the legacy pong, it is left out if the
+		// service predates the health command
+		if h, err := c.Health(ctx); err == nil {
+			ping.Status = &h.Calculation
+		}
+	}
+	return ping, nil
+}
+
+// Affinity is the result of UpdateAffinity.
+type Affinity struct {
+	// Action describes what the service did.
+	Action string `json:"action"`
+	// WarmingUp is set if the CPUs were selected from the topology-only
+	// ranking, the VM is re-placed once the measurement is done.
+	WarmingUp bool `json:"-"`
+}
+
+// UpdateAffinity selects CPUs for a running VM and applies the affinity.
+func (c *Client) UpdateAffinity(ctx context.Context, vmid int) (*Affinity, error) {
+	resp, err := c.Do(ctx, Request{Command: "update-affinity", VMID: vmid})
+	if err != nil {
+		return nil, err
+	}
+	affinity := &Affinity{WarmingUp: resp.WarmingUp()}
+	if len(resp.Data) > 0 {
+		if err := resp.Decode(affinity); err != nil {
+			return nil, err
+		}
+	}
+	return affinity, nil
+}
+
+// CoreRanking returns the neighbors of every CPU ordered by latency.
+func (c *Client) CoreRanking(ctx context.Context) ([]cpuinfo.CoreRanking, *cpuinfo.RankingMeta, error) {
+	var rankings []cpuinfo.CoreRanking
+	meta, err := c.fetch(ctx, "core-ranking", &rankings)
+	return rankings, meta, err
+}
+
+// CoreRankingSummary returns the summary of the ranking.
+func (c *Client) CoreRankingSummary(ctx context.Context) (cpuinfo.TopologyStats, *cpuinfo.RankingMeta, error) {
+	var stats cpuinfo.TopologyStats
+	meta, err := c.fetch(ctx, "core-ranking-summary", &stats)
+	return stats, meta, err
+}
+
+// CoreClusters returns the latency domains derived from the ranking.
+func (c *Client) CoreClusters(ctx context.Context) ([]cpuinfo.ClusterLevel, *cpuinfo.RankingMeta, error) {
+	var levels []cpuinfo.ClusterLevel
+	meta, err := c.fetch(ctx, "core-clusters", &levels)
+	return levels, meta, err
+}
+
+// CoreTopology returns the detected topology of all present CPUs.
+func (c *Client) CoreTopology(ctx context.Context) ([]cpuinfo.CoreInfo, error) {
+	var topology []cpuinfo.CoreInfo
+	_, err := c.fetch(ctx, "core-topology", &topology)
+	return topology, err
+}
+
+// CoreVMAffinity returns the CPUs selected for the VMs by VMID.
+func (c *Client) CoreVMAffinity(ctx context.Context) (map[int]cpuinfo.Selection, *cpuinfo.RankingMeta, error) {
+	var selections map[int]cpuinfo.Selection
+	meta, err := c.fetch(ctx, "core-vm-affinity", &selections)
+	return selections, meta, err
+}
+
+// Recalculate starts a full measurement in the background, rounds and
+// iterations of 0 use the configuration of the service. It fails with a
+// *ServiceError if a calculation is already running.
+func (c *Client) Recalculate(ctx context.Context, rounds, iterations int) (*cpuinfo.Recalculation, error) {
+	resp, err := c.Do(ctx, Request{Command: "recalculate", Rounds: rounds, Iterations: iterations})
+	if err != nil {
+		return nil, err
+	}
+	var recalc cpuinfo.Recalculation
+	return &recalc, resp.Decode(&recalc)
+}
+
+// RecalculationStatus returns the state and progress of the last recalculation.
+func (c *Client) RecalculationStatus(ctx context.Context) (*cpuinfo.Recalculation, error) {
+	var recalc cpuinfo.Recalculation
+	if _, err := c.fetch(ctx, "recalculate-status", &recalc); err != nil {
+		return nil, err
+	}
+	return &recalc, nil
+}
+
+// History returns the placements of the audit journal, oldest first. vmid 0
+// selects all VMs, a zero since all entries.
+func (c *Client) History(ctx context.Context, vmid int, since time.Time) ([]audit.Entry, error) {
+	req := Request{Command: "history", VMID: vmid}
+	if !since.IsZero() {
+		req.Since = &since
+	}
+	resp, err := c.Do(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	var entries []audit.Entry
+	return entries, resp.Decode(&entries)
+}
+
+// ReloadConfig makes the service read its config file again and returns the
+// changed settings.
+func (c *Client) ReloadConfig(ctx context.Context) (*config.ReloadResult, error) {
+	var result config.ReloadResult
+	if _, err := c.fetch(ctx, "reload-config", &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Watch subscribes to the event stream and calls handle for every event
+// until ctx is done (it returns nil then) or the connection fails.
+func (c *Client) Watch(ctx context.Context, handle func(events.Event)) error {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+
+	_ = conn.SetDeadline(time.Now().Add(c.timeout))
+	if err := json.NewEncoder(conn).Encode(Request{Command: "watch"}); err != nil {
+		return fmt.Errorf("%w: failed to send request: %w", ErrUnavailable, err)
+	}
+
+	dec := json.NewDecoder(conn)
+	var resp Response
+	if err := dec.Decode(&resp); err != nil {
+		return decodeError(err)
+	}
+	if resp.Status != StatusOK {
+		return &ServiceError{Command: "watch", Message: resp.Error}
+	}
+
+	// Events may be minutes apart
+	_ = conn.SetDeadline(time.Time{})
+	for {
+		var e events.Event
+		if err := dec.Decode(&e); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return decodeError(err)
+		}
+		handle(e)
+	}
+}
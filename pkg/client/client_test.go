@@ -0,0 +1,339 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/egandro/proxmox-cpu-affinity/pkg/audit"
+	"github.com/egandro/proxmox-cpu-affinity/pkg/config"
+	"github.com/egandro/proxmox-cpu-affinity/pkg/cpuinfo"
+	"github.com/egandro/proxmox-cpu-affinity/pkg/events"
+	"github.com/egandro/proxmox-cpu-affinity/pkg/health"
+)
+
+// serve answers every request on a unix socket with the lines reply returns.
+func serve(t *testing.T, socketPath string, reply func(Request) []interface{}) {
+	t.Helper()
+	listener, err := net.Listen("unix", socketPath)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer func() { _ = conn.Close() }()
+				var req Request
+				if err := json.NewDecoder(conn).Decode(&req); err != nil {
+					return
+				}
+				enc := json.NewEncoder(conn)
+				for _, line := range reply(req) {
+					_ = enc.Encode(line)
+				}
+			}()
+		}
+	}()
+}
+
+func testSocket(t *testing.T) string {
+	return filepath.Join(t.TempDir(), "client-test.sock")
+}
+
+func TestClient_Ping(t *testing.T) {
+	socketPath := testSocket(t)
+	warmingUp := false
+	serve(t, socketPath, func(req Request) []interface{} {
+		if req.Command == "health" {
+			return []interface{}{map[string]interface{}{"status": "ok", "data": health.Health{Calculation: cpuinfo.RankingStatus{State: cpuinfo.StateWarmingUp, Measured: 3, Total: 6}}}}
+		}
+		assert.Equal(t, "ping", req.Command)
+		if warmingUp {
+			return []interface{}{map[string]interface{}{"status": "ok", "data": "pong", "meta": cpuinfo.RankingMeta{Generation: 1, Source: cpuinfo.SourceTopology}}}
+		}
+		return []interface{}{map[string]interface{}{"status": "ok", "data": "pong", "meta": cpuinfo.RankingMeta{Generation: 2}}}
+	})
+
+	c := New(socketPath)
+	ping, err := c.Ping(context.Background())
+	require.NoError(t, err)
+	assert.False(t, ping.WarmingUp)
+	assert.Equal(t, uint64(2), ping.Meta.Generation)
+
+	warmingUp = true
+	ping, err = c.Ping(context.Background())
+	require.NoError(t, err)
+	assert.True(t, ping.WarmingUp)
+	assert.Equal(t, 6, ping.Status.Total)
+}
+
+func TestClient_TypedMethods(t *testing.T) {
+	socketPath := testSocket(t)
+	serve(t, socketPath, func(req Request) []interface{} {
+		meta := cpuinfo.RankingMeta{Generation: 5}
+		switch req.Command {
+		case "core-ranking":
+			return []interface{}{map[string]interface{}{"status": "ok", "meta": meta, "data": []cpuinfo.CoreRanking{{CPU: 0}, {CPU: 1}}}}
+		case "core-ranking-summary":
+			return []interface{}{map[string]interface{}{"status": "ok", "meta": meta, "data": cpuinfo.TopologyStats{CPUCount: 2}}}
+		case "core-clusters":
+			return []interface{}{map[string]interface{}{"status": "ok", "meta": meta, "data": []cpuinfo.ClusterLevel{{Level: 1}}}}
+		case "core-topology":
+			return []interface{}{map[string]interface{}{"status": "ok", "data": []cpuinfo.CoreInfo{{CPU: 0, Online: true}}}}
+		case "core-vm-affinity":
+			return []interface{}{map[string]interface{}{"status": "ok", "meta": meta, "data": map[int]cpuinfo.Selection{100: {CPUs: []int{0, 1}, Generation: 5}}}}
+		case "update-affinity":
+			return []interface{}{map[string]interface{}{"status": "ok", "meta": cpuinfo.RankingMeta{Source: cpuinfo.SourceTopology}, "data": map[string]string{"action": "new affinity: 0,1"}}}
+		}
+		return []interface{}{map[string]interface{}{"status": "error", "error": "unknown command: " + req.Command}}
+	})
+
+	ctx := context.Background()
+	c := New(socketPath)
+
+	rankings, meta, err := c.CoreRanking(ctx)
+	require.NoError(t, err)
+	assert.Len(t, rankings, 2)
+	assert.Equal(t, uint64(5), meta.Generation)
+
+	stats, _, err := c.CoreRankingSummary(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, stats.CPUCount)
+
+	levels, _, err := c.CoreClusters(ctx)
+	require.NoError(t, err)
+	assert.Len(t, levels, 1)
+
+	topology, err := c.CoreTopology(ctx)
+	require.NoError(t, err)
+	assert.True(t, topology[0].Online)
+
+	selections, _, err := c.CoreVMAffinity(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []int{0, 1}, selections[100].CPUs)
+
+	affinity, err := c.UpdateAffinity(ctx, 100)
+	require.NoError(t, err)
+	assert.True(t, affinity.WarmingUp)
+	assert.Equal(t, "new affinity: 0,1", affinity.Action)
+
+	_, err = c.Do(ctx, Request{Command: "bogus"})
+	var serviceErr *ServiceError
+	require.ErrorAs(t, err, &serviceErr)
+	assert.Equal(t, "bogus", serviceErr.Command)
+	assert.EqualError(t, err, "unknown command: bogus")
+	assert.False(t, errors.Is(err, ErrUnavailable))
+}
+
+func TestClient_Unavailable(t *testing.T) {
+	c := New(testSocket(t), WithTimeout(100*time.Millisecond))
+	_, err := c.Ping(context.Background())
+	assert.ErrorIs(t, err, ErrUnavailable)
+}
+
+func TestClient_Retry(t *testing.T) {
+	socketPath := testSocket(t)
+	c := New(socketPath, WithRetry(5, 20*time.Millisecond, 50*time.Millisecond))
+
+	// The service comes up while the client retries
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		serve(t, socketPath, func(Request) []interface{} {
+			return []interface{}{map[string]interface{}{"status": "ok", "data": "pong"}}
+		})
+	}()
+
+	_, err := c.Ping(context.Background())
+	assert.NoError(t, err)
+}
+
+func TestClient_NoRetryOnServiceError(t *testing.T) {
+	socketPath := testSocket(t)
+	calls := 0
+	serve(t, socketPath, func(Request) []interface{} {
+		calls++
+		return []interface{}{map[string]interface{}{"status": "error", "error": "VM 100 is not running"}}
+	})
+
+	c := New(socketPath, WithRetry(3, time.Millisecond, time.Millisecond))
+	_, err := c.UpdateAffinity(context.Background(), 100)
+	var serviceErr *ServiceError
+	assert.ErrorAs(t, err, &serviceErr)
+	assert.Equal(t, 1, calls)
+}
+
+func TestClient_RetryLostResponse(t *testing.T) {
+	socketPath := testSocket(t)
+	var calls atomic.Int32
+	// The request is read, but the connection is closed without a response
+	serve(t, socketPath, func(Request) []interface{} {
+		calls.Add(1)
+		return nil
+	})
+
+	c := New(socketPath, WithRetry(2, time.Millisecond, time.Millisecond))
+	_, err := c.UpdateAffinity(context.Background(), 100)
+	assert.ErrorIs(t, err, ErrUnavailable)
+	assert.Equal(t, int32(1), calls.Load(), "update-affinity must not run twice")
+
+	calls.Store(0)
+	_, err = c.Ping(context.Background())
+	assert.ErrorIs(t, err, ErrUnavailable)
+	assert.Equal(t, int32(3), calls.Load(), "ping is idempotent")
+}
+
+func TestClient_RetryCancelled(t *testing.T) {
+	c := New(testSocket(t), WithRetry(10, time.Hour, time.Hour))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := c.Ping(ctx)
+	assert.ErrorIs(t, err, ErrUnavailable)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestClient_Protocol(t *testing.T) {
+	socketPath := testSocket(t)
+	serve(t, socketPath, func(Request) []interface{} {
+		return []interface{}{map[string]interface{}{"status": "ok", "data": "not a list"}}
+	})
+
+	_, _, err := New(socketPath).CoreRanking(context.Background())
+	assert.ErrorIs(t, err, ErrProtocol)
+	_, err = New(socketPath).Ping(context.Background())
+	assert.ErrorIs(t, err, ErrProtocol)
+}
+
+func TestClient_Watch(t *testing.T) {
+	socketPath := testSocket(t)
+	serve(t, socketPath, func(req Request) []interface{} {
+		if req.Command != "watch" {
+			return []interface{}{map[string]interface{}{"status": "error", "error": "unknown command"}}
+		}
+		return []interface{}{
+			map[string]interface{}{"status": "ok", "data": "watching"},
+			events.Event{Type: events.TypeAffinityApplied, VMID: 100, CPUs: []int{0, 1}},
+			events.Event{Type: events.TypeReleased, VMID: 100},
+		}
+	})
+
+	var received []events.Event
+	err := New(socketPath).Watch(context.Background(), func(e events.Event) { received = append(received, e) })
+	assert.ErrorIs(t, err, ErrUnavailable) // the test server closes the connection
+	require.Len(t, received, 2)
+	assert.Equal(t, events.TypeAffinityApplied, received[0].Type)
+	assert.Equal(t, []int{0, 1}, received[0].CPUs)
+	assert.Equal(t, events.TypeReleased, received[1].Type)
+}
+
+func TestClient_WatchCancel(t *testing.T) {
+	socketPath := testSocket(t)
+	listener, err := net.Listen("unix", socketPath)
+	require.NoError(t, err)
+	defer func() { _ = listener.Close() }()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		_ = json.NewEncoder(conn).Encode(map[string]string{"status": "ok"})
+		// keep the connection open
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.NoError(t, New(socketPath).Watch(ctx, func(events.Event) {}))
+}
+
+func TestClient_Recalculate(t *testing.T) {
+	socketPath := testSocket(t)
+	serve(t, socketPath, func(req Request) []interface{} {
+		switch req.Command {
+		case "recalculate":
+			if req.Rounds == 1 {
+				return []interface{}{map[string]interface{}{"status": "error", "error": cpuinfo.ErrCalculationRunning.Error()}}
+			}
+			return []interface{}{map[string]interface{}{"status": "ok", "data": cpuinfo.Recalculation{State: cpuinfo.RecalculationRunning, Rounds: req.Rounds, Iterations: req.Iterations}}}
+		case "recalculate-status":
+			return []interface{}{map[string]interface{}{"status": "ok", "data": cpuinfo.Recalculation{State: cpuinfo.RecalculationDone, Generation: 3, Replaced: []int{100}}}}
+		}
+		return []interface{}{map[string]interface{}{"status": "error", "error": "unknown command"}}
+	})
+
+	ctx := context.Background()
+	c := New(socketPath)
+
+	recalc, err := c.Recalculate(ctx, 3, 50)
+	require.NoError(t, err)
+	assert.Equal(t, cpuinfo.RecalculationRunning, recalc.State)
+	assert.Equal(t, 3, recalc.Rounds)
+	assert.Equal(t, 50, recalc.Iterations)
+
+	_, err = c.Recalculate(ctx, 1, 0)
+	var serviceErr *ServiceError
+	require.ErrorAs(t, err, &serviceErr)
+
+	recalc, err = c.RecalculationStatus(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), recalc.Generation)
+	assert.Equal(t, []int{100}, recalc.Replaced)
+}
+
+func TestClient_ReloadConfig(t *testing.T) {
+	socketPath := testSocket(t)
+	serve(t, socketPath, func(req Request) []interface{} {
+		assert.Equal(t, "reload-config", req.Command)
+		return []interface{}{map[string]interface{}{"status": "ok", "data": config.ReloadResult{Applied: []string{"PCA_LOG_LEVEL"}, RestartRequired: []string{"PCA_KERNELS"}}}}
+	})
+
+	result, err := New(socketPath).ReloadConfig(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"PCA_LOG_LEVEL"}, result.Applied)
+	assert.Equal(t, []string{"PCA_KERNELS"}, result.RestartRequired)
+}
+
+func TestClient_History(t *testing.T) {
+	since := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	socketPath := testSocket(t)
+	serve(t, socketPath, func(req Request) []interface{} {
+		assert.Equal(t, "history", req.Command)
+		assert.Equal(t, 100, req.VMID)
+		require.NotNil(t, req.Since)
+		assert.True(t, since.Equal(*req.Since))
+		return []interface{}{map[string]interface{}{"status": "ok", "data": []audit.Entry{{Time: since, VMID: 100, CPUs: []int{0, 1}, Result: audit.ResultApplied}}}}
+	})
+
+	entries, err := New(socketPath).History(context.Background(), 100, since)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, []int{0, 1}, entries[0].CPUs)
+	assert.Equal(t, audit.ResultApplied, entries[0].Result)
+}
+
+func TestClient_Health(t *testing.T) {
+	socketPath := testSocket(t)
+	serve(t, socketPath, func(req Request) []interface{} {
+		assert.Equal(t, "health", req.Command)
+		meta := cpuinfo.RankingMeta{Generation: 4}
+		return []interface{}{map[string]interface{}{"status": "ok", "meta": meta, "data": health.Health{State: health.StateReady, Healthy: true, Ranking: &meta, ManagedVMs: 3}}}
+	})
+
+	h, err := New(socketPath).Health(context.Background())
+	require.NoError(t, err)
+	assert.True(t, h.Healthy)
+	assert.Equal(t, 3, h.ManagedVMs)
+	require.NotNil(t, h.Ranking)
+	assert.Equal(t, uint64(4), h.Ranking.Generation)
+}
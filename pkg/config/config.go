@@ -4,10 +4,10 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
-
-	"github.com/joho/godotenv"
 )
 
 const (
@@ -16,6 +16,10 @@ const (
 	ConstantLogFilename = "proxmox-cpu-affinity.log"
 	ConstantLogFile     = ConstantLogDir + "/" + ConstantLogFilename
 
+	// Audit journal defaults
+	ConstantAuditFilename = "proxmox-cpu-affinity-audit.jsonl"
+	ConstantAuditFile     = ConstantLogDir + "/" + ConstantAuditFilename
+
 	// Proxmox defaults
 	ConstantQemuServerPidDir   = "/var/run/qemu-server"
 	ConstantConfigFilename     = "/etc/default/proxmox-cpu-affinity"
@@ -39,8 +43,41 @@ const (
 
 	ConstantMaxCalculationRankingDuration = 2 * time.Minute
 
+	// ConstantRecalculationMaxFactor caps the rounds and iterations of a
+	// requested recalculation at this multiple of the adaptive defaults.
+	ConstantRecalculationMaxFactor = 10
+
 	ConstantSocketTimeout = 5 * time.Second
 
+	// ConstantNotifyStatusInterval is the interval the ranking progress is
+	// reported to systemd in (the status shown by systemctl).
+	ConstantNotifyStatusInterval = 2 * time.Second
+
+	// ConstantNoiseSampleInterval is the interval the host load is sampled
+	// at while a measurement is postponed.
+	ConstantNoiseSampleInterval = 2 * time.Second
+
+	// ConstantSamplingMinCPUs is the CPU count above which SamplingAuto
+	// measures only a sample of the CPU pairs.
+	ConstantSamplingMinCPUs = 128
+
+	// Sampling modes
+	SamplingAuto   = "auto"   // sample on hosts with more than ConstantSamplingMinCPUs CPUs
+	SamplingAlways = "always" // always sample
+	SamplingNever  = "never"  // always measure all pairs
+
+	// Modes on a virtualized host (e.g. a nested Proxmox lab)
+	VirtualizedTopology      = "topology"       // derive the ranking from the topology, don't measure
+	VirtualizedNoiseTolerant = "noise-tolerant" // measure with more rounds and normalization
+	VirtualizedMeasure       = "measure"        // measure like on bare metal
+
+	// ConstantVirtualizedRoundsFactor multiplies the rounds in the
+	// VirtualizedNoiseTolerant mode.
+	ConstantVirtualizedRoundsFactor = 3
+	// ConstantVirtualizedNormalizeTolerance is the normalization tolerance of
+	// the VirtualizedNoiseTolerant mode if PCA_NORMALIZE_TOLERANCE is not set.
+	ConstantVirtualizedNormalizeTolerance = 0.25
+
 	// Socket defaults
 	DefaultSocketRetry          = 10
 	DefaultSocketSleep          = 10 // in seconds
@@ -62,6 +99,44 @@ const (
 	DefaultLogLevel = "info"
 
 	DefaultCPUHotplugWatchdog = true
+
+	// DefaultNUMAWeight controls how much the NUMA (ACPI SLIT) memory distance
+	// influences the ranking compared to the measured cache-line latency.
+	// 0.0 ranks by latency only, 1.0 ranks by memory distance only.
+	DefaultNUMAWeight = 0.0
+
+	// Noise guard defaults: the measurement is postponed (up to
+	// DefaultNoiseMaxWait seconds) while more than half of the CPU time is
+	// used or tasks wait for a CPU more than 10% of the time.
+	DefaultNoiseGuard       = true
+	DefaultNoiseMaxBusy     = 0.5
+	DefaultNoiseMaxPressure = 10.0 // in percent
+	DefaultNoiseMaxWait     = 60   // in seconds
+
+	// Sampling defaults: 16 pairs per topology class and 64 random spot
+	// checks, a dual socket host with 256 CPUs measures 144 of 65,280 pairs.
+	DefaultSampling           = SamplingAuto
+	DefaultSamplingPerClass   = 16
+	DefaultSamplingSpotChecks = 64
+
+	// DefaultKernels measures the cache-line ping-pong round trip only.
+	// Other kernels (cas, stream) and their weights in the blended ranking
+	// are added like "ping-pong=1,cas=0.5".
+	DefaultKernels = "ping-pong"
+
+	// DefaultNormalizeTolerance disables the topology class normalization.
+	// With e.g. 0.2, latencies within 20% of the median of their class
+	// (same core, same node, same socket, socket-to-socket) are set to the median.
+	DefaultNormalizeTolerance = 0.0
+
+	// DefaultVirtualizedMode ranks by topology inside a virtual machine:
+	// vCPU-to-vCPU latencies depend on where the hypervisor runs the vCPUs
+	// at the moment, measuring them gives random results.
+	DefaultVirtualizedMode = VirtualizedTopology
+
+	// DefaultAuditMaxSize caps the audit journal, the previous file is kept,
+	// so the journal takes at most twice as much.
+	DefaultAuditMaxSize = 10 // in MiB
 )
 
 // AdaptiveCpuInfoParameters calculates measurement parameters based on CPU count.
@@ -101,14 +176,39 @@ type Config struct {
 	SocketTimeout        int // in seconds
 	SocketPingOnPreStart bool
 	CPUHotplugWatchdog   bool
+	NUMAWeight           float64
+	LatencyMatrixFile    string
+	NoiseGuard           bool
+	NoiseMaxBusy         float64
+	NoiseMaxPressure     float64 // in percent
+	NoiseMaxWait         int     // in seconds
+	Sampling             string  // SamplingAuto, SamplingAlways or SamplingNever
+	SamplingPerClass     int
+	SamplingSpotChecks   int
+	Kernels              string // kernel=weight list
+	NormalizeTolerance   float64
+	VirtualizedMode      string // VirtualizedTopology, VirtualizedNoiseTolerant or VirtualizedMeasure
+	RemoteListen         string // TCP address of the remote listener, empty disables it
+	RemoteTLSCert        string
+	RemoteTLSKey         string
+	RemoteClientCA       string
+	RemoteTokensFile     string
+	RemoteAdmins         []string // client certificate common names with the admin role
+	MetricsListen        string   // TCP address of the metrics listener, empty disables it
+	AuditFile            string   // placement journal, empty disables it
+	AuditMaxSize         int      // in MiB
 }
 
 func Load(filename string) *Config {
 	if filename == "" {
 		filename = ConstantConfigFilename
 	}
-	_ = godotenv.Load(filename)
+	_ = loadFile(filename)
+	return fromEnv()
+}
 
+// fromEnv reads the configuration from the environment.
+func fromEnv() *Config {
 	// Get adaptive defaults based on CPU count, allowing user overrides
 	// TODO: reload this after a CPU hotplug event
 	defaultRounds, defaultIterations := AdaptiveCpuInfoParameters()
@@ -124,6 +224,27 @@ func Load(filename string) *Config {
 		SocketTimeout:        getEnvInt("PCA_SOCKET_TIMEOUT", DefaultSocketTimeout),
 		SocketPingOnPreStart: getEnvBool("PCA_SOCKET_PING_ON_PRESTART", DefaultSocketPingOnPreStart),
 		CPUHotplugWatchdog:   getEnvBool("PCA_CPU_HOTPLUG_WATCHDOG", DefaultCPUHotplugWatchdog),
+		NUMAWeight:           getEnvWeight("PCA_NUMA_WEIGHT", DefaultNUMAWeight),
+		LatencyMatrixFile:    getEnv("PCA_LATENCY_MATRIX_FILE", ""),
+		NoiseGuard:           getEnvBool("PCA_NOISE_GUARD", DefaultNoiseGuard),
+		NoiseMaxBusy:         getEnvWeight("PCA_NOISE_MAX_BUSY", DefaultNoiseMaxBusy),
+		NoiseMaxPressure:     getEnvFloat("PCA_NOISE_MAX_PRESSURE", DefaultNoiseMaxPressure),
+		NoiseMaxWait:         getEnvInt("PCA_NOISE_MAX_WAIT", DefaultNoiseMaxWait),
+		Sampling:             getEnvChoice("PCA_SAMPLING", DefaultSampling, SamplingAuto, SamplingAlways, SamplingNever),
+		SamplingPerClass:     getEnvInt("PCA_SAMPLING_PER_CLASS", DefaultSamplingPerClass),
+		SamplingSpotChecks:   getEnvInt("PCA_SAMPLING_SPOT_CHECKS", DefaultSamplingSpotChecks),
+		Kernels:              getEnv("PCA_KERNELS", DefaultKernels),
+		NormalizeTolerance:   getEnvWeight("PCA_NORMALIZE_TOLERANCE", DefaultNormalizeTolerance),
+		VirtualizedMode:      getEnvChoice("PCA_VIRTUALIZED_MODE", DefaultVirtualizedMode, VirtualizedTopology, VirtualizedNoiseTolerant, VirtualizedMeasure),
+		RemoteListen:         getEnv("PCA_REMOTE_LISTEN", ""),
+		RemoteTLSCert:        getEnv("PCA_REMOTE_TLS_CERT", ""),
+		RemoteTLSKey:         getEnv("PCA_REMOTE_TLS_KEY", ""),
+		RemoteClientCA:       getEnv("PCA_REMOTE_CLIENT_CA", ""),
+		RemoteTokensFile:     getEnv("PCA_REMOTE_TOKENS_FILE", ""),
+		RemoteAdmins:         getEnvList("PCA_REMOTE_ADMINS"),
+		MetricsListen:        getEnv("PCA_METRICS_LISTEN", ""),
+		AuditFile:            getEnv("PCA_AUDIT_FILE", ConstantAuditFile),
+		AuditMaxSize:         getEnvInt("PCA_AUDIT_MAX_SIZE", DefaultAuditMaxSize),
 	}
 }
 
@@ -161,3 +282,41 @@ func getEnvInt(key string, fallback int) int {
 	}
 	return fallback
 }
+
+func getEnvFloat(key string, fallback float64) float64 {
+	if value, ok := os.LookupEnv(key); ok {
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f
+		}
+	}
+	return fallback
+}
+
+// getEnvWeight reads a blending weight, which must be within [0.0, 1.0].
+func getEnvWeight(key string, fallback float64) float64 {
+	w := getEnvFloat(key, fallback)
+	if !(w >= 0 && w <= 1) { // also rejects NaN
+		return fallback
+	}
+	return w
+}
+
+// getEnvChoice reads a value that must be one of allowed.
+func getEnvChoice(key, fallback string, allowed ...string) string {
+	value := getEnv(key, fallback)
+	if !slices.Contains(allowed, value) {
+		return fallback
+	}
+	return value
+}
+
+// getEnvList reads a comma separated list, empty entries are dropped.
+func getEnvList(key string) []string {
+	var list []string
+	for _, item := range strings.Split(getEnv(key, ""), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -49,3 +49,23 @@ func FuzzGetEnvBool(f *testing.F) {
 		_ = getEnvBool(key, false)
 	})
 }
+
+func FuzzGetEnvWeight(f *testing.F) {
+	f.Add("0")
+	f.Add("1")
+	f.Add("0.5")
+	f.Add("-1")
+	f.Add("NaN")
+	f.Add("Inf")
+	f.Add("")
+
+	f.Fuzz(func(t *testing.T, input string) {
+		key := "FUZZ_TEST_WEIGHT"
+		t.Setenv(key, input)
+
+		w := getEnvWeight(key, 0)
+		if !(w >= 0 && w <= 1) {
+			t.Errorf("weight %v out of range for input %q", w, input)
+		}
+	})
+}
@@ -56,6 +56,23 @@ func TestLoad(t *testing.T) {
 	assert.Equal(t, DefaultSocketSleep, cfg.SocketSleep)
 	assert.Equal(t, DefaultSocketTimeout, cfg.SocketTimeout)
 	assert.Equal(t, DefaultSocketPingOnPreStart, cfg.SocketPingOnPreStart)
+	assert.Equal(t, DefaultNUMAWeight, cfg.NUMAWeight)
+	assert.Empty(t, cfg.LatencyMatrixFile)
+	assert.Equal(t, DefaultNoiseGuard, cfg.NoiseGuard)
+	assert.Equal(t, DefaultNoiseMaxBusy, cfg.NoiseMaxBusy)
+	assert.Equal(t, DefaultNoiseMaxPressure, cfg.NoiseMaxPressure)
+	assert.Equal(t, DefaultNoiseMaxWait, cfg.NoiseMaxWait)
+	assert.Equal(t, DefaultSampling, cfg.Sampling)
+	assert.Equal(t, DefaultSamplingPerClass, cfg.SamplingPerClass)
+	assert.Equal(t, DefaultSamplingSpotChecks, cfg.SamplingSpotChecks)
+	assert.Equal(t, DefaultKernels, cfg.Kernels)
+	assert.Equal(t, DefaultNormalizeTolerance, cfg.NormalizeTolerance)
+	assert.Equal(t, DefaultVirtualizedMode, cfg.VirtualizedMode)
+	assert.Empty(t, cfg.RemoteListen)
+	assert.Empty(t, cfg.RemoteAdmins)
+	assert.Empty(t, cfg.MetricsListen)
+	assert.Equal(t, ConstantAuditFile, cfg.AuditFile)
+	assert.Equal(t, DefaultAuditMaxSize, cfg.AuditMaxSize)
 
 	// Rounds and iterations should match adaptive defaults
 	expectedRounds, expectedIterations := AdaptiveCpuInfoParameters()
@@ -126,12 +143,70 @@ func TestGetEnvInt(t *testing.T) {
 	}
 }
 
+func TestGetEnvFloat(t *testing.T) {
+	tests := []struct {
+		name     string
+		envValue string
+		setEnv   bool
+		fallback float64
+		expected float64
+	}{
+		{"Valid float", "0.25", true, 0, 0.25},
+		{"Int is valid float", "1", true, 0, 1},
+		{"Invalid uses fallback", "not-a-number", true, 0.5, 0.5},
+		{"Empty uses fallback", "", true, 0.7, 0.7},
+		{"Unset uses fallback", "", false, 0.3, 0.3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := "TEST_FLOAT_VAR"
+			_ = os.Unsetenv(key)
+			if tt.setEnv {
+				_ = os.Setenv(key, tt.envValue)
+				defer func() { _ = os.Unsetenv(key) }()
+			}
+			result := getEnvFloat(key, tt.fallback)
+			assert.Equal(t, tt.expected, result)
+		})
+	}
+}
+
+func TestGetEnvWeight(t *testing.T) {
+	tests := []struct {
+		name     string
+		envValue string
+		expected float64
+	}{
+		{"Zero", "0", 0},
+		{"One", "1", 1},
+		{"Between", "0.6", 0.6},
+		{"Negative uses fallback", "-0.1", 0.5},
+		{"Above one uses fallback", "1.5", 0.5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := "TEST_WEIGHT_VAR"
+			_ = os.Setenv(key, tt.envValue)
+			defer func() { _ = os.Unsetenv(key) }()
+			assert.Equal(t, tt.expected, getEnvWeight(key, 0.5))
+		})
+	}
+}
+
 func TestLoadWithEnvVars(t *testing.T) {
 	// Save current env and restore after test
 	envVars := []string{
 		"PCA_LOG_LEVEL", "PCA_LOG_FILE", "PCA_SOCKET_FILE", "PCA_ROUNDS", "PCA_ITERATIONS",
 		"PCA_SOCKET_RETRY", "PCA_SOCKET_SLEEP", "PCA_SOCKET_TIMEOUT",
-		"PCA_SOCKET_PING_ON_PRESTART", "PCA_CPU_HOTPLUG_WATCHDOG",
+		"PCA_SOCKET_PING_ON_PRESTART", "PCA_CPU_HOTPLUG_WATCHDOG", "PCA_NUMA_WEIGHT",
+		"PCA_LATENCY_MATRIX_FILE", "PCA_NOISE_GUARD", "PCA_NOISE_MAX_BUSY", "PCA_NOISE_MAX_PRESSURE",
+		"PCA_NOISE_MAX_WAIT", "PCA_SAMPLING", "PCA_SAMPLING_PER_CLASS", "PCA_SAMPLING_SPOT_CHECKS",
+		"PCA_KERNELS", "PCA_NORMALIZE_TOLERANCE", "PCA_VIRTUALIZED_MODE",
+		"PCA_REMOTE_LISTEN", "PCA_REMOTE_TLS_CERT", "PCA_REMOTE_TLS_KEY", "PCA_REMOTE_CLIENT_CA",
+		"PCA_REMOTE_TOKENS_FILE", "PCA_REMOTE_ADMINS", "PCA_METRICS_LISTEN",
+		"PCA_AUDIT_FILE", "PCA_AUDIT_MAX_SIZE",
 	}
 	savedEnv := make(map[string]string)
 	for _, key := range envVars {
@@ -159,6 +234,27 @@ func TestLoadWithEnvVars(t *testing.T) {
 	_ = os.Setenv("PCA_SOCKET_TIMEOUT", "60")
 	_ = os.Setenv("PCA_SOCKET_PING_ON_PRESTART", "false")
 	_ = os.Setenv("PCA_CPU_HOTPLUG_WATCHDOG", "false")
+	_ = os.Setenv("PCA_NUMA_WEIGHT", "0.4")
+	_ = os.Setenv("PCA_LATENCY_MATRIX_FILE", "/tmp/matrix.csv")
+	_ = os.Setenv("PCA_NOISE_GUARD", "false")
+	_ = os.Setenv("PCA_NOISE_MAX_BUSY", "0.8")
+	_ = os.Setenv("PCA_NOISE_MAX_PRESSURE", "25")
+	_ = os.Setenv("PCA_NOISE_MAX_WAIT", "300")
+	_ = os.Setenv("PCA_SAMPLING", "always")
+	_ = os.Setenv("PCA_SAMPLING_PER_CLASS", "8")
+	_ = os.Setenv("PCA_SAMPLING_SPOT_CHECKS", "32")
+	_ = os.Setenv("PCA_KERNELS", "ping-pong=1,cas=0.5")
+	_ = os.Setenv("PCA_NORMALIZE_TOLERANCE", "0.2")
+	_ = os.Setenv("PCA_VIRTUALIZED_MODE", "noise-tolerant")
+	_ = os.Setenv("PCA_REMOTE_LISTEN", ":8245")
+	_ = os.Setenv("PCA_REMOTE_TLS_CERT", "/etc/pca/cert.pem")
+	_ = os.Setenv("PCA_REMOTE_TLS_KEY", "/etc/pca/key.pem")
+	_ = os.Setenv("PCA_REMOTE_CLIENT_CA", "/etc/pca/ca.pem")
+	_ = os.Setenv("PCA_REMOTE_TOKENS_FILE", "/etc/pca/tokens")
+	_ = os.Setenv("PCA_REMOTE_ADMINS", "ops, admin,")
+	_ = os.Setenv("PCA_METRICS_LISTEN", "127.0.0.1:9245")
+	_ = os.Setenv("PCA_AUDIT_FILE", "/tmp/audit.jsonl")
+	_ = os.Setenv("PCA_AUDIT_MAX_SIZE", "1")
 
 	cfg := Load("")
 
@@ -172,6 +268,27 @@ func TestLoadWithEnvVars(t *testing.T) {
 	assert.Equal(t, 60, cfg.SocketTimeout)
 	assert.False(t, cfg.SocketPingOnPreStart)
 	assert.False(t, cfg.CPUHotplugWatchdog)
+	assert.Equal(t, 0.4, cfg.NUMAWeight)
+	assert.Equal(t, "/tmp/matrix.csv", cfg.LatencyMatrixFile)
+	assert.False(t, cfg.NoiseGuard)
+	assert.Equal(t, 0.8, cfg.NoiseMaxBusy)
+	assert.Equal(t, 25.0, cfg.NoiseMaxPressure)
+	assert.Equal(t, 300, cfg.NoiseMaxWait)
+	assert.Equal(t, SamplingAlways, cfg.Sampling)
+	assert.Equal(t, 8, cfg.SamplingPerClass)
+	assert.Equal(t, 32, cfg.SamplingSpotChecks)
+	assert.Equal(t, "ping-pong=1,cas=0.5", cfg.Kernels)
+	assert.Equal(t, 0.2, cfg.NormalizeTolerance)
+	assert.Equal(t, VirtualizedNoiseTolerant, cfg.VirtualizedMode)
+	assert.Equal(t, ":8245", cfg.RemoteListen)
+	assert.Equal(t, "/etc/pca/cert.pem", cfg.RemoteTLSCert)
+	assert.Equal(t, "/etc/pca/key.pem", cfg.RemoteTLSKey)
+	assert.Equal(t, "/etc/pca/ca.pem", cfg.RemoteClientCA)
+	assert.Equal(t, "/etc/pca/tokens", cfg.RemoteTokensFile)
+	assert.Equal(t, []string{"ops", "admin"}, cfg.RemoteAdmins)
+	assert.Equal(t, "127.0.0.1:9245", cfg.MetricsListen)
+	assert.Equal(t, "/tmp/audit.jsonl", cfg.AuditFile)
+	assert.Equal(t, 1, cfg.AuditMaxSize)
 }
 
 func TestGetEnv(t *testing.T) {
@@ -188,3 +305,16 @@ func TestGetEnv(t *testing.T) {
 	result = getEnv(key, "fallback_value")
 	assert.Equal(t, "actual_value", result)
 }
+
+func TestGetEnvChoice(t *testing.T) {
+	key := "TEST_CHOICE_VAR"
+	_ = os.Unsetenv(key)
+	assert.Equal(t, "a", getEnvChoice(key, "a", "a", "b"))
+
+	_ = os.Setenv(key, "b")
+	defer func() { _ = os.Unsetenv(key) }()
+	assert.Equal(t, "b", getEnvChoice(key, "a", "a", "b"))
+
+	_ = os.Setenv(key, "c")
+	assert.Equal(t, "a", getEnvChoice(key, "a", "a", "b"))
+}
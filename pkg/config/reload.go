@@ -0,0 +1,128 @@
+package config
+
+import (
+	"os"
+	"reflect"
+	"sync"
+
+	"github.com/joho/godotenv"
+)
+
+// ReloadResult lists the settings changed by a reload by their variable names.
+type ReloadResult struct {
+	Applied         []string `json:"applied"`          // applied by the running service
+	RestartRequired []string `json:"restart_required"` // read on start only
+}
+
+// setting is a variable of the config file, live settings are applied on a reload.
+type setting struct {
+	key   string
+	value func(*Config) interface{}
+	live  bool
+}
+
+var settings = []setting{
+	{"PCA_LOG_LEVEL", func(c *Config) interface{} { return c.LogLevel }, true},
+	{"PCA_LOG_FILE", func(c *Config) interface{} { return c.LogFile }, true},
+	{"PCA_SOCKET_FILE", func(c *Config) interface{} { return c.SocketFile }, false},
+	{"PCA_ROUNDS", func(c *Config) interface{} { return c.Rounds }, true},
+	{"PCA_ITERATIONS", func(c *Config) interface{} { return c.Iterations }, true},
+	// read by every run of the hookscript
+	{"PCA_SOCKET_RETRY", func(c *Config) interface{} { return c.SocketRetry }, true},
+	{"PCA_SOCKET_SLEEP", func(c *Config) interface{} { return c.SocketSleep }, true},
+	{"PCA_SOCKET_TIMEOUT", func(c *Config) interface{} { return c.SocketTimeout }, true},
+	{"PCA_SOCKET_PING_ON_PRESTART", func(c *Config) interface{} { return c.SocketPingOnPreStart }, true},
+	{"PCA_CPU_HOTPLUG_WATCHDOG", func(c *Config) interface{} { return c.CPUHotplugWatchdog }, true},
+	{"PCA_NUMA_WEIGHT", func(c *Config) interface{} { return c.NUMAWeight }, false},
+	{"PCA_LATENCY_MATRIX_FILE", func(c *Config) interface{} { return c.LatencyMatrixFile }, false},
+	{"PCA_NOISE_GUARD", func(c *Config) interface{} { return c.NoiseGuard }, false},
+	{"PCA_NOISE_MAX_BUSY", func(c *Config) interface{} { return c.NoiseMaxBusy }, false},
+	{"PCA_NOISE_MAX_PRESSURE", func(c *Config) interface{} { return c.NoiseMaxPressure }, false},
+	{"PCA_NOISE_MAX_WAIT", func(c *Config) interface{} { return c.NoiseMaxWait }, false},
+	{"PCA_SAMPLING", func(c *Config) interface{} { return c.Sampling }, false},
+	{"PCA_SAMPLING_PER_CLASS", func(c *Config) interface{} { return c.SamplingPerClass }, false},
+	{"PCA_SAMPLING_SPOT_CHECKS", func(c *Config) interface{} { return c.SamplingSpotChecks }, false},
+	{"PCA_KERNELS", func(c *Config) interface{} { return c.Kernels }, false},
+	{"PCA_NORMALIZE_TOLERANCE", func(c *Config) interface{} { return c.NormalizeTolerance }, false},
+	{"PCA_VIRTUALIZED_MODE", func(c *Config) interface{} { return c.VirtualizedMode }, false},
+	{"PCA_REMOTE_LISTEN", func(c *Config) interface{} { return c.RemoteListen }, false},
+	{"PCA_REMOTE_TLS_CERT", func(c *Config) interface{} { return c.RemoteTLSCert }, false},
+	{"PCA_REMOTE_TLS_KEY", func(c *Config) interface{} { return c.RemoteTLSKey }, false},
+	{"PCA_REMOTE_CLIENT_CA", func(c *Config) interface{} { return c.RemoteClientCA }, false},
+	{"PCA_REMOTE_TOKENS_FILE", func(c *Config) interface{} { return c.RemoteTokensFile }, false},
+	{"PCA_REMOTE_ADMINS", func(c *Config) interface{} { return c.RemoteAdmins }, false},
+	{"PCA_METRICS_LISTEN", func(c *Config) interface{} { return c.MetricsListen }, false},
+	{"PCA_AUDIT_FILE", func(c *Config) interface{} { return c.AuditFile }, false},
+	{"PCA_AUDIT_MAX_SIZE", func(c *Config) interface{} { return c.AuditMaxSize }, false},
+}
+
+// Compare returns the settings that differ between the running configuration
+// and the reloaded one.
+func Compare(running, reloaded *Config) ReloadResult {
+	result := ReloadResult{Applied: []string{}, RestartRequired: []string{}}
+	for _, s := range settings {
+		if reflect.DeepEqual(s.value(running), s.value(reloaded)) {
+			continue
+		}
+		if s.live {
+			result.Applied = append(result.Applied, s.key)
+		} else {
+			result.RestartRequired = append(result.RestartRequired, s.key)
+		}
+	}
+	return result
+}
+
+// Reload reads filename again. Unlike on the first Load, values that changed
+// in the file replace the ones read before and settings removed from the file
+// fall back to their defaults. Variables set in the environment of the
+// process still take precedence over the file.
+func Reload(filename string) (*Config, error) {
+	if filename == "" {
+		filename = ConstantConfigFilename
+	}
+	if err := loadFile(filename); err != nil {
+		return nil, err
+	}
+	return fromEnv(), nil
+}
+
+var (
+	fileMu sync.Mutex
+	// fileValues are the variables set from the config file
+	fileValues = map[string]string{}
+)
+
+// loadFile sets the variables of filename in the environment, like
+// godotenv.Load. Variables that were set from the file before are updated or
+// unset, unless the environment was changed in the meantime.
+func loadFile(filename string) error {
+	values, err := godotenv.Read(filename)
+	if err != nil {
+		return err
+	}
+
+	fileMu.Lock()
+	defer fileMu.Unlock()
+	for key, previous := range fileValues {
+		if _, ok := values[key]; ok {
+			continue
+		}
+		if current, ok := os.LookupEnv(key); ok && current == previous {
+			_ = os.Unsetenv(key)
+		}
+		delete(fileValues, key)
+	}
+	for key, value := range values {
+		current, set := os.LookupEnv(key)
+		if previous, fromFile := fileValues[key]; set && (!fromFile || current != previous) {
+			// set by the environment of the process
+			continue
+		}
+		if err := os.Setenv(key, value); err != nil {
+			return err
+		}
+		fileValues[key] = value
+	}
+	return nil
+}
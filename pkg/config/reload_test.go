@@ -0,0 +1,52 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReload(t *testing.T) {
+	// restored by t.Setenv after the test
+	t.Setenv("PCA_LOG_LEVEL", "")
+	t.Setenv("PCA_ROUNDS", "")
+	t.Setenv("PCA_ITERATIONS", "7")
+	for _, key := range []string{"PCA_LOG_LEVEL", "PCA_ROUNDS"} {
+		require.NoError(t, os.Unsetenv(key))
+	}
+
+	filename := filepath.Join(t.TempDir(), "proxmox-cpu-affinity")
+	require.NoError(t, os.WriteFile(filename, []byte("PCA_LOG_LEVEL=debug\nPCA_ROUNDS=3\nPCA_ITERATIONS=100\n"), 0600))
+	cfg := Load(filename)
+	assert.Equal(t, "debug", cfg.LogLevel)
+	assert.Equal(t, 3, cfg.Rounds)
+	assert.Equal(t, 7, cfg.Iterations) // the environment takes precedence
+
+	// Changed values replace the ones read before, removed ones fall back to the default
+	require.NoError(t, os.WriteFile(filename, []byte("PCA_ROUNDS=5\nPCA_ITERATIONS=200\n"), 0600))
+	cfg, err := Reload(filename)
+	require.NoError(t, err)
+	assert.Equal(t, DefaultLogLevel, cfg.LogLevel)
+	assert.Equal(t, 5, cfg.Rounds)
+	assert.Equal(t, 7, cfg.Iterations)
+
+	_, err = Reload(filepath.Join(t.TempDir(), "missing"))
+	assert.Error(t, err)
+}
+
+func TestCompare(t *testing.T) {
+	running := &Config{LogLevel: "info", Rounds: 3, NUMAWeight: 0.5, RemoteAdmins: []string{"admin"}}
+	reloaded := *running
+	assert.Equal(t, ReloadResult{Applied: []string{}, RestartRequired: []string{}}, Compare(running, &reloaded))
+
+	reloaded.LogLevel = "debug"
+	reloaded.Rounds = 5
+	reloaded.NUMAWeight = 0.3
+	reloaded.RemoteAdmins = []string{"admin", "ops"}
+	result := Compare(running, &reloaded)
+	assert.Equal(t, []string{"PCA_LOG_LEVEL", "PCA_ROUNDS"}, result.Applied)
+	assert.Equal(t, []string{"PCA_NUMA_WEIGHT", "PCA_REMOTE_ADMINS"}, result.RestartRequired)
+}
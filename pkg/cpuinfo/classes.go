@@ -0,0 +1,96 @@
+package cpuinfo
+
+import "fmt"
+
+// relation describes how two logical CPUs are connected.
+type relation int
+
+const (
+	relationSMT    relation = iota // same physical core
+	relationL3                     // same L3 cache
+	relationNode                   // same socket and NUMA node
+	relationDie                    // same die
+	relationSocket                 // same socket
+	relationRemote                 // different sockets
+)
+
+func (r relation) String() string {
+	switch r {
+	case relationSMT:
+		return "smt"
+	case relationL3:
+		return "l3"
+	case relationNode:
+		return "node"
+	case relationDie:
+		return "die"
+	case relationSocket:
+		return "socket"
+	case relationRemote:
+		return "remote"
+	}
+	return "unknown"
+}
+
+// pairClass groups CPU pairs that are expected to have about the same
+// latency: the relation plus the (sorted) sockets involved, so every
+// socket-to-socket link is a class of its own.
+type pairClass struct {
+	relation relation
+	a, b     int
+}
+
+func (c pairClass) String() string {
+	if c.a == c.b {
+		return fmt.Sprintf("%s/%d", c.relation, c.a)
+	}
+	return fmt.Sprintf("%s/%d-%d", c.relation, c.a, c.b)
+}
+
+// siblings reports whether a and b are SMT siblings. Core IDs are only
+// unique within a die on some systems, an unknown core is never shared.
+func siblings(a, b CoreInfo) bool {
+	return a.Core >= 0 && a.Core == b.Core && a.Socket == b.Socket && a.Die == b.Die
+}
+
+// classOf returns the topology class of the pair (a, b).
+func classOf(a, b CoreInfo) pairClass {
+	lo, hi := a.Socket, b.Socket
+	if lo > hi {
+		lo, hi = hi, lo
+	}
+	switch {
+	case a.Socket != b.Socket:
+		return pairClass{relationRemote, lo, hi}
+	case siblings(a, b):
+		return pairClass{relationSMT, lo, hi}
+	case a.L3 >= 0 && a.L3 == b.L3:
+		return pairClass{relationL3, lo, hi}
+	case a.Node >= 0 && a.Node == b.Node:
+		return pairClass{relationNode, lo, hi}
+	case a.Die >= 0 && a.Die == b.Die:
+		return pairClass{relationDie, lo, hi}
+	default:
+		return pairClass{relationSocket, lo, hi}
+	}
+}
+
+// classifyPairs groups pairs by their topology class. The pairs of a class
+// keep the order of pairs.
+func classifyPairs(topology []CoreInfo, pairs []cpuPair) (map[pairClass][]cpuPair, []pairClass) {
+	byCPU := make(map[int]CoreInfo, len(topology))
+	for _, core := range topology {
+		byCPU[core.CPU] = core
+	}
+
+	classes := make(map[pairClass][]cpuPair)
+	var order []pairClass
+	for _, p := range pairs {
+		class := classOf(byCPU[p.src], byCPU[p.dst])
+		if _, ok := classes[class]; !ok {
+			order = append(order, class)
+		}
+		classes[class] = append(classes[class], p)
+	}
+	return classes, order
+}
@@ -0,0 +1,144 @@
+package cpuinfo
+
+import (
+	"math"
+	"sort"
+)
+
+// clusterGapRatio separates two cluster levels: a merge starts a new level if
+// its latency is at least this factor above the latency of the previous merge.
+const clusterGapRatio = 1.25
+
+// LatencyCluster is a group of CPUs that are closer to each other than to
+// any CPU outside the group.
+// LatencyNS is the latency the cluster was formed at, the average latency
+// between the two groups that were merged last (0 for a single CPU).
+type LatencyCluster struct {
+	CPUs      []int   `json:"cpus"`
+	LatencyNS float64 `json:"latency_ns"`
+}
+
+// ClusterLevel is one level of latency domains, e.g. SMT siblings, CCX, die or socket.
+// Level 1 is the finest level. All clusters of a level were formed below MaxLatencyNS.
+type ClusterLevel struct {
+	Level        int              `json:"level"`
+	MaxLatencyNS float64          `json:"max_latency_ns"`
+	Clusters     []LatencyCluster `json:"clusters"`
+}
+
+// clusterMerge records one step of the agglomerative clustering.
+type clusterMerge struct {
+	a, b   int // indexes of the merged clusters
+	height float64
+}
+
+// buildClusters derives latency domains from the matrix with average linkage
+// (UPGMA) hierarchical clustering. It only uses the measured latencies, the
+// socket and core labels of the topology are ignored.
+// The dendrogram is cut wherever the merge latency jumps by clusterGapRatio,
+// the trivial levels (every CPU alone, all CPUs together) are omitted.
+func buildClusters(topology []CoreInfo, matrix LatencyMatrix) []ClusterLevel {
+	n := len(topology)
+	if n < 3 {
+		return nil
+	}
+
+	cpus := make([]int, n)
+	for i, core := range topology {
+		cpus[i] = core.CPU
+	}
+	sort.Ints(cpus)
+
+	// Symmetric distances between the active clusters, indexed by cluster
+	dist := make([][]float64, n)
+	for i := range dist {
+		dist[i] = make([]float64, n)
+		for j := range dist[i] {
+			if i != j {
+				dist[i][j] = symmetricLatency(matrix, cpus[i], cpus[j])
+			}
+		}
+	}
+
+	members := make([][]int, n)
+	heights := make([]float64, n)
+	active := make([]bool, n)
+	for i, cpu := range cpus {
+		members[i] = []int{cpu}
+		active[i] = true
+	}
+
+	var levels []ClusterLevel
+	var prev float64
+
+	for step := 0; step < n-1; step++ {
+		// Closest pair of active clusters, ties are broken by index
+		m := clusterMerge{a: -1, b: -1, height: math.Inf(1)}
+		for i := 0; i < n; i++ {
+			if !active[i] {
+				continue
+			}
+			for j := i + 1; j < n; j++ {
+				if active[j] && dist[i][j] < m.height {
+					m = clusterMerge{a: i, b: j, height: dist[i][j]}
+				}
+			}
+		}
+
+		// A jump in latency: everything merged so far is one level. Merges
+		// at the same latency never start a level, even at 0 (unmeasured pairs).
+		if step > 0 && m.height > prev && m.height >= prev*clusterGapRatio {
+			levels = append(levels, snapshotLevel(len(levels)+1, prev, members, heights, active))
+		}
+		prev = m.height
+
+		// Merge b into a, the distance to every other cluster is the size weighted average
+		sa, sb := float64(len(members[m.a])), float64(len(members[m.b]))
+		for k := 0; k < n; k++ {
+			if !active[k] || k == m.a || k == m.b {
+				continue
+			}
+			d := (sa*dist[m.a][k] + sb*dist[m.b][k]) / (sa + sb)
+			dist[m.a][k] = d
+			dist[k][m.a] = d
+		}
+		members[m.a] = append(members[m.a], members[m.b]...)
+		sort.Ints(members[m.a])
+		heights[m.a] = m.height
+		active[m.b] = false
+	}
+
+	return levels
+}
+
+// snapshotLevel returns the current partition as a cluster level.
+func snapshotLevel(level int, maxLatency float64, members [][]int, heights []float64, active []bool) ClusterLevel {
+	res := ClusterLevel{Level: level, MaxLatencyNS: maxLatency}
+	for i := range members {
+		if !active[i] {
+			continue
+		}
+		cpus := make([]int, len(members[i]))
+		copy(cpus, members[i])
+		res.Clusters = append(res.Clusters, LatencyCluster{CPUs: cpus, LatencyNS: heights[i]})
+	}
+	sort.Slice(res.Clusters, func(a, b int) bool {
+		return res.Clusters[a].CPUs[0] < res.Clusters[b].CPUs[0]
+	})
+	return res
+}
+
+// symmetricLatency returns the mean of both directions between a and b.
+// If only one direction is known it is used as is.
+func symmetricLatency(matrix LatencyMatrix, a, b int) float64 {
+	ab, okAB := matrix.Get(a, b)
+	ba, okBA := matrix.Get(b, a)
+	switch {
+	case okAB && okBA:
+		return (ab + ba) / 2
+	case okAB:
+		return ab
+	default:
+		return ba
+	}
+}
@@ -0,0 +1,98 @@
+package cpuinfo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildClusters(t *testing.T) {
+	topology, _ := fallbackTopology()
+	levels := buildClusters(topology, estimateMatrix(topology))
+
+	assert.Len(t, levels, 2)
+
+	// SMT siblings
+	assert.Equal(t, 1, levels[0].Level)
+	assert.Equal(t, fallbackLatencySMT, levels[0].MaxLatencyNS)
+	assert.Equal(t, []LatencyCluster{
+		{CPUs: []int{0, 4}, LatencyNS: fallbackLatencySMT},
+		{CPUs: []int{1, 5}, LatencyNS: fallbackLatencySMT},
+		{CPUs: []int{2, 6}, LatencyNS: fallbackLatencySMT},
+		{CPUs: []int{3, 7}, LatencyNS: fallbackLatencySMT},
+	}, levels[0].Clusters)
+
+	// Sockets
+	assert.Equal(t, 2, levels[1].Level)
+	assert.Len(t, levels[1].Clusters, 2)
+	assert.Equal(t, []int{0, 1, 4, 5}, levels[1].Clusters[0].CPUs)
+	assert.Equal(t, []int{2, 3, 6, 7}, levels[1].Clusters[1].CPUs)
+	assert.Equal(t, fallbackLatencySocket, levels[1].Clusters[0].LatencyNS)
+}
+
+func TestBuildClusters_IgnoresTopologyLabels(t *testing.T) {
+	// Firmware reports a single socket, but CPUs 0,1 and 2,3 form two islands
+	topology := []CoreInfo{{CPU: 0}, {CPU: 1}, {CPU: 2}, {CPU: 3}}
+	matrix := make(LatencyMatrix)
+	for _, a := range topology {
+		for _, b := range topology {
+			if a.CPU == b.CPU {
+				continue
+			}
+			lat := 100.0
+			if a.CPU/2 == b.CPU/2 {
+				lat = 20
+			}
+			matrix.Set(a.CPU, b.CPU, lat)
+		}
+	}
+	// Asymmetric measurement is averaged
+	matrix.Set(0, 1, 18)
+	matrix.Set(1, 0, 22)
+
+	levels := buildClusters(topology, matrix)
+	assert.Len(t, levels, 1)
+	assert.Equal(t, []LatencyCluster{
+		{CPUs: []int{0, 1}, LatencyNS: 20},
+		{CPUs: []int{2, 3}, LatencyNS: 20},
+	}, levels[0].Clusters)
+}
+
+func TestBuildClusters_Flat(t *testing.T) {
+	topology := []CoreInfo{{CPU: 0}, {CPU: 1}, {CPU: 2}, {CPU: 3}}
+	matrix := make(LatencyMatrix)
+	for _, a := range topology {
+		for _, b := range topology {
+			if a.CPU != b.CPU {
+				matrix.Set(a.CPU, b.CPU, 50)
+			}
+		}
+	}
+	assert.Empty(t, buildClusters(topology, matrix))
+	assert.Empty(t, buildClusters(topology[:2], matrix))
+}
+
+func TestBuildClusters_ZeroLatency(t *testing.T) {
+	// Pairs without a latency don't open a level at every merge
+	topology := []CoreInfo{{CPU: 0}, {CPU: 1}, {CPU: 2}, {CPU: 3}, {CPU: 4}, {CPU: 5}}
+	matrix := make(LatencyMatrix)
+	for _, a := range topology {
+		for _, b := range topology {
+			if a.CPU == b.CPU {
+				continue
+			}
+			lat := 0.0
+			if a.CPU/3 != b.CPU/3 {
+				lat = 100
+			}
+			matrix.Set(a.CPU, b.CPU, lat)
+		}
+	}
+
+	levels := buildClusters(topology, matrix)
+	assert.Len(t, levels, 1)
+	assert.Equal(t, []LatencyCluster{
+		{CPUs: []int{0, 1, 2}, LatencyNS: 0},
+		{CPUs: []int{3, 4, 5}, LatencyNS: 0},
+	}, levels[0].Clusters)
+}
@@ -1,18 +1,24 @@
 package cpuinfo
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/egandro/proxmox-cpu-affinity/pkg/events"
+	"github.com/egandro/proxmox-cpu-affinity/pkg/metrics"
 )
 
 // lockToCPU is defined in cpuinfo_linux.go for Linux
@@ -20,55 +26,157 @@ import (
 
 // Provider defines the interface for CPU topology and ranking operations.
 type Provider interface {
-	Update(rounds int, iterations int, onProgress func(int, int)) error
+	Update(ctx context.Context, rounds int, iterations int, onProgress func(int, int)) error
+	UpdateIncremental(ctx context.Context, rounds int, iterations int, onProgress func(int, int)) error
 	GetCoreRanking() ([]CoreRanking, error)
-	CalculateRanking(rounds, iterations int, timeout time.Duration) error
+	CalculateRanking(ctx context.Context, rounds, iterations int, timeout time.Duration) error
+	CalculateRankingIncremental(ctx context.Context, rounds, iterations int, timeout time.Duration) error
 	DetectTopology() ([]CoreInfo, error)
-	SelectCPUs(vmid int, requestedCPUs int) ([]int, error)
-	GetSelections() map[int][]int
+	SelectCPUs(vmid int, requestedCPUs int) (Selection, error)
+	GetSelections() map[int]Selection
+	GetSnapshot() (*Snapshot, error)
+	ReplaceLostCPUs() []SelectionChange
+	UseTopologyFallback() error
+	Status() RankingStatus
+	TakeProvisional() []int
+	Recalculate(ctx context.Context, rounds, iterations int, timeout time.Duration) (<-chan error, error)
 }
 
 // topologyDetector is a function that returns the current CPU topology.
 type topologyDetector func() ([]CoreInfo, error)
 
 // latencyMeasurer is a function that measures latency between two CPUs.
-type latencyMeasurer func(cpuA, cpuB, iter int) (float64, error)
+// It must return ctx.Err() as soon as possible once ctx is done.
+type latencyMeasurer func(ctx context.Context, cpuA, cpuB, iter int) (float64, error)
 
 // CPUInfo handles CPU topology detection and latency measurement.
+// The ranking is an immutable Snapshot that is swapped atomically, mu guards
+// the selections and serializes the swaps.
 type CPUInfo struct {
 	mu         sync.RWMutex
-	cache      []CoreRanking
+	snapshot   atomic.Pointer[Snapshot]
 	lastIndex  int
 	detector   topologyDetector
 	measurer   latencyMeasurer
-	selections map[int][]int
+	distances  distanceReader
+	numaWeight float64
+	importPath string
+	noise      *NoiseGuard
+	loadReader loadReader
+	sampling   *Sampling
+	kernels    []KernelWeight
+	selections map[int]Selection
+
+	// measurers of the kernels besides KernelPingPong
+	kernelMeasurers map[string]latencyMeasurer
+
+	// relative tolerance of the topology class normalization, 0 disables it
+	normalization float64
+
+	// the host is a virtual machine, derived ranks by topology instead of measuring
+	virtualized bool
+	derived     bool
+
+	// seed of the pair selection in sampled measurements, 0 uses the current time
+	samplingSeed uint64
+
+	// VMIDs selected from the topology fallback, dropped when the measured ranking arrived
+	replaced []int
+
+	// progress of the running measurement (pairs in all rounds)
+	measured atomic.Int64
+	total    atomic.Int64
+	// the measurement is postponed because the host is busy
+	waiting atomic.Bool
+
+	// calcMu serializes the ranking calculations, see Recalculate
+	calcMu  sync.Mutex
+	running atomic.Bool
+	round   atomic.Int64
+	rounds  atomic.Int64
+	// error of the last calculation, nil if it succeeded
+	failure atomic.Pointer[string]
+}
+
+// Option configures optional behavior of a CPUInfo instance.
+type Option func(*CPUInfo)
+
+// WithNUMAWeight blends the NUMA memory distance into the ranking.
+// weight is in [0.0, 1.0]: 0.0 ranks by measured latency only,
+// 1.0 ranks by the ACPI SLIT distance only.
+func WithNUMAWeight(weight float64) Option {
+	return func(c *CPUInfo) {
+		c.numaWeight = weight
+	}
+}
+
+// WithImportedMatrix uses the latency matrix stored in path (CSV or JSON,
+// see ReadMatrixFile) instead of measuring. Only pairs that are missing in
+// the file are measured.
+func WithImportedMatrix(path string) Option {
+	return func(c *CPUInfo) {
+		c.importPath = path
+	}
+}
+
+// WithNoiseGuard postpones measurements while the host is busy and flags
+// rankings measured on a busy host as low confidence.
+func WithNoiseGuard(guard NoiseGuard) Option {
+	return func(c *CPUInfo) {
+		c.noise = &guard
+	}
 }
 
 // New creates a new CPUInfo instance.
-func New() Provider {
-	return &CPUInfo{
+func New(opts ...Option) Provider {
+	c := &CPUInfo{
 		detector:   detectTopologySystem,
 		measurer:   measureSingleLink,
-		selections: make(map[int][]int),
+		distances:  readNUMADistancesSystem,
+		loadReader: readLoadSystem,
+		selections: make(map[int]Selection),
+		kernelMeasurers: map[string]latencyMeasurer{
+			KernelCAS:    measureCAS,
+			KernelStream: measureStream,
+		},
 	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
 }
 
 // CoreInfo represents the CPU topology using standard Linux terminology
 // - CPU: The logical processor ID (used by `taskset -c`).
 // - Socket: The physical package ID.
 // - Core: The physical core ID within the socket.
+// - Node: The NUMA node ID (-1 if unknown).
+// - Die: The die ID within the socket (-1 if unknown).
+// - Cluster: The cluster ID, e.g. cores sharing an L2 on ARM (-1 if unknown).
+// - L2, L3: The IDs of the L2 and L3 caches (-1 if unknown).
+// - Online: Whether the CPU is online, only online CPUs are ranked.
 type CoreInfo struct {
-	CPU    int `json:"cpu"`    // Logical Processor
-	Socket int `json:"socket"` // Physical Socket
-	Core   int `json:"core"`   // Physical Core
+	CPU     int  `json:"cpu"`     // Logical Processor
+	Socket  int  `json:"socket"`  // Physical Socket
+	Core    int  `json:"core"`    // Physical Core
+	Node    int  `json:"node"`    // NUMA Node
+	Die     int  `json:"die"`     // Die
+	Cluster int  `json:"cluster"` // Cluster
+	L2      int  `json:"l2"`      // L2 Cache
+	L3      int  `json:"l3"`      // L3 Cache
+	Online  bool `json:"online"`
 }
 
 // Neighbor represents a target core and the cost (latency) to reach it.
+// Distance is the ACPI SLIT distance between the NUMA nodes of the source
+// and the target (0 if unknown).
 type Neighbor struct {
 	CPU       int     `json:"cpu"`
 	Socket    int     `json:"socket"`
 	Core      int     `json:"core"`
+	Node      int     `json:"node"`
 	LatencyNS float64 `json:"latency_ns"`
+	Distance  int     `json:"distance,omitempty"`
 }
 
 // CoreRanking contains a source core and its neighbors sorted by affinity (latency).
@@ -78,27 +186,63 @@ type CoreRanking struct {
 }
 
 // CalculateRanking performs the update with a timeout and logs the summary.
-func (c *CPUInfo) CalculateRanking(rounds, iterations int, timeout time.Duration) error {
+// The calculation stops and releases the CPUs when ctx is cancelled or the timeout expires.
+// It waits for a calculation that is already running.
+func (c *CPUInfo) CalculateRanking(ctx context.Context, rounds, iterations int, timeout time.Duration) error {
+	return c.calculateRanking(ctx, c.Update, rounds, iterations, timeout)
+}
+
+// CalculateRankingIncremental performs the incremental update with a timeout and logs the summary.
+// It is meant to be used after a CPU hotplug event.
+func (c *CPUInfo) CalculateRankingIncremental(ctx context.Context, rounds, iterations int, timeout time.Duration) error {
+	return c.calculateRanking(ctx, c.UpdateIncremental, rounds, iterations, timeout)
+}
+
+func (c *CPUInfo) calculateRanking(ctx context.Context, update func(context.Context, int, int, func(int, int)) error, rounds, iterations int, timeout time.Duration) error {
+	c.calcMu.Lock()
+	defer c.calcMu.Unlock()
+	return c.runCalculation(ctx, update, rounds, iterations, timeout)
+}
+
+// runCalculation runs update, the caller holds calcMu.
+func (c *CPUInfo) runCalculation(ctx context.Context, update func(context.Context, int, int, func(int, int)) error, rounds, iterations int, timeout time.Duration) error {
 	start := time.Now()
+	c.running.Store(true)
+	defer func() {
+		c.running.Store(false)
+		c.round.Store(0)
+		c.rounds.Store(0)
+	}()
 	slog.Info("Calculating core-to-core ranking", "rounds", rounds, "iterations", iterations)
 
-	done := make(chan error, 1)
+	// Waiting for a quiet host does not count against the timeout
+	if c.noise != nil {
+		timeout += c.noise.MaxWait
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
 
 	onProgress := func(round, total int) {
+		c.round.Store(int64(round))
+		c.rounds.Store(int64(total))
 		slog.Debug("Ranking calculation progress", "round", round, "total", total)
 	}
 
-	go func() {
-		done <- c.Update(rounds, iterations, onProgress)
-	}()
-
-	select {
-	case err := <-done:
-		if err != nil {
-			return fmt.Errorf("error calculating ranking: %w", err)
+	// update returns as soon as all measurement goroutines have stopped
+	if err := update(ctx, rounds, iterations, onProgress); err != nil {
+		if !errors.Is(err, context.Canceled) {
+			metrics.Failures.Inc(metrics.ReasonRanking)
+			events.Failure(metrics.ReasonRanking, 0, err)
+			failure := err.Error()
+			c.failure.Store(&failure)
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			return fmt.Errorf("calculation timed out after %v (rounds=%d, iterations=%d). This might be a bug/timing issue. Please adjust PCA_ROUNDS/PCA_ITERATIONS", timeout, rounds, iterations)
 		}
-	case <-time.After(timeout):
-		return fmt.Errorf("calculation timed out after %v (rounds=%d, iterations=%d). This might be a bug/timing issue. Please adjust PCA_ROUNDS/PCA_ITERATIONS", timeout, rounds, iterations)
+		if errors.Is(err, context.Canceled) {
+			return fmt.Errorf("calculation cancelled: %w", err)
+		}
+		return fmt.Errorf("error calculating ranking: %w", err)
 	}
 
 	rankings, err := c.GetCoreRanking()
@@ -106,8 +250,12 @@ func (c *CPUInfo) CalculateRanking(rounds, iterations int, timeout time.Duration
 		return fmt.Errorf("error getting cpuinfo core ranking: %w", err)
 	}
 
+	c.failure.Store(nil)
 	statsJSON, _ := json.Marshal(SummarizeRankings(rankings))
 	slog.Info("CPU topology ranking calculated", "duration", time.Since(start).Round(time.Millisecond), "summary", string(statsJSON))
+	if snapshot, err := c.GetSnapshot(); err == nil {
+		events.Publish(events.Event{Type: events.TypeRankingRecalculated, Generation: snapshot.Meta.Generation, Source: snapshot.Meta.Source})
+	}
 	return nil
 }
 
@@ -115,121 +263,397 @@ func (c *CPUInfo) CalculateRanking(rounds, iterations int, timeout time.Duration
 // rounds: Number of full measurement passes to average.
 // iterations: Ping-pongs per measurement.
 // onProgress: Optional callback function invoked before each round (round, total).
-func (c *CPUInfo) Update(rounds int, iterations int, onProgress func(int, int)) error {
+// The cache is left untouched if ctx is cancelled before the measurement completes.
+// All selections are reset.
+func (c *CPUInfo) Update(ctx context.Context, rounds int, iterations int, onProgress func(int, int)) error {
+	return c.update(ctx, rounds, iterations, onProgress, false)
+}
+
+// update is Update, keepSelections keeps the selections of the previous ranking.
+func (c *CPUInfo) update(ctx context.Context, rounds int, iterations int, onProgress func(int, int), keepSelections bool) error {
+	if c.derived {
+		return c.updateDerived(keepSelections)
+	}
+	start := time.Now()
+
 	// 1. Discover Topology
 	topology, err := c.detector()
 	if err != nil {
 		return fmt.Errorf("error detecting topology: %w", err)
 	}
 
-	numCores := len(topology)
-	latSums := make([]float64, numCores*numCores)
+	// 2. Measure all pairs (or a sample on large hosts), or only those
+	// missing in the imported matrix
+	kernels := c.kernelSet()
+	matrices := newKernelMatrices(kernels, len(topology))
+	pairs := allPairs(topology)
+	source := SourceMeasured
+	if c.importPath != "" {
+		imported, err := ReadMatrixFile(c.importPath)
+		if err != nil {
+			slog.Warn("Failed to read imported latency matrix, measuring instead", "file", c.importPath, "error", err)
+		} else {
+			// The imported matrix replaces the blend, missing pairs are measured with the first kernel only
+			kernels = kernels[:1]
+			var matrix LatencyMatrix
+			matrix, pairs = importedPairs(imported, topology)
+			matrices = kernelMatrices{kernels[0].Kernel: matrix}
+			source = SourceImported
+			slog.Info("Using imported latency matrix", "file", c.importPath, "missing_pairs", len(pairs))
+		}
+	}
+	var load *HostLoad
+	var sampling *SamplingReport
+	if source == SourceMeasured && c.useSampling(len(topology)) {
+		load, sampling, err = c.measureSampled(ctx, matrices, kernels, topology, rounds, iterations, onProgress)
+	} else {
+		load, err = c.measure(ctx, matrices, kernels, pairs, rounds, iterations, onProgress)
+	}
+	if err != nil {
+		return err
+	}
+
+	// 3. Aggregate, Sort and Store Results
+	c.store(topology, blendMatrices(matrices, kernels), matrices, keepSelections, RankingMeta{
+		Source:     source,
+		Kernels:    kernels,
+		Load:       load,
+		Sampling:   sampling,
+		Rounds:     rounds,
+		Iterations: iterations,
+		Duration:   time.Since(start),
+	})
+	return nil
+}
+
+// UpdateIncremental re-detects the topology and only measures what changed
+// since the last update: rows and columns of removed CPUs are dropped and
+// added CPUs are measured against the existing ones. Cached latencies are
+// reused for all other pairs and all selections are kept: use ReplaceLostCPUs
+// afterwards to fix selections that use a removed CPU.
+// Falls back to a full Update if there is no previous measurement or it was
+// measured with other kernels.
+func (c *CPUInfo) UpdateIncremental(ctx context.Context, rounds int, iterations int, onProgress func(int, int)) error {
+	if c.derived {
+		return c.updateDerived(true)
+	}
+	start := time.Now()
+	prev := c.load()
+	kernels := c.kernelSet()
+
+	// Nominal latencies of the topology fallback are never reused
+	if prev.matrix == nil || isEstimate(prev.Meta.Source) || !prev.kernels.sameKernels(kernels) {
+		return c.Update(ctx, rounds, iterations, onProgress)
+	}
+
+	topology, err := c.detector()
+	if err != nil {
+		return fmt.Errorf("error detecting topology: %w", err)
+	}
+
+	added, removed := diffTopology(prev.topology, topology)
+	slog.Info("Incremental ranking update", "added", added, "removed", removed)
+
+	matrices := make(kernelMatrices, len(prev.kernels))
+	for kernel, matrix := range prev.kernels {
+		matrices[kernel] = matrix.Without(removed...)
+	}
+
+	var pairs []cpuPair
+	for _, cpu := range added {
+		for _, other := range topology {
+			if other.CPU == cpu {
+				continue
+			}
+			pairs = append(pairs, cpuPair{cpu, other.CPU})
+			// Pairs between two added CPUs are already covered by the outer loop
+			if !slices.Contains(added, other.CPU) {
+				pairs = append(pairs, cpuPair{other.CPU, cpu})
+			}
+		}
+	}
+
+	load, err := c.measure(ctx, matrices, kernels, pairs, rounds, iterations, onProgress)
+	if err != nil {
+		return err
+	}
+
+	c.store(topology, blendMatrices(matrices, kernels), matrices, true, RankingMeta{
+		Source:      SourceMeasured,
+		Kernels:     kernels,
+		Incremental: true,
+		Load:        load,
+		Rounds:      rounds,
+		Iterations:  iterations,
+		Duration:    time.Since(start),
+	})
+	return nil
+}
+
+// measure postpones the measurement while the host is busy (if a NoiseGuard
+// is configured), measures the pairs with every kernel and returns the load observed.
+func (c *CPUInfo) measure(ctx context.Context, matrices kernelMatrices, kernels []KernelWeight, pairs []cpuPair, rounds int, iterations int, onProgress func(int, int)) (*HostLoad, error) {
+	if len(pairs) == 0 {
+		return nil, c.measurePairs(ctx, matrices, kernels, pairs, rounds, iterations, onProgress)
+	}
+
+	monitor, err := c.waitForQuietHost(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	progress := onProgress
+	if monitor != nil {
+		progress = func(round, total int) {
+			if round > 1 {
+				monitor.sample()
+			}
+			if onProgress != nil {
+				onProgress(round, total)
+			}
+		}
+	}
+
+	if err := c.measurePairs(ctx, matrices, kernels, pairs, rounds, iterations, progress); err != nil {
+		return nil, err
+	}
+	monitor.sample()
+	return monitor.result(), nil
+}
+
+// measurePairs measures each pair `rounds` times with every kernel and
+// stores the averages in the matrix of the kernel.
+func (c *CPUInfo) measurePairs(ctx context.Context, matrices kernelMatrices, kernels []KernelWeight, pairs []cpuPair, rounds int, iterations int, onProgress func(int, int)) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	latSums := make([][]float64, len(kernels))
+	for k := range kernels {
+		latSums[k] = make([]float64, len(pairs))
+	}
+
+	c.measured.Store(0)
+	c.total.Store(int64(len(pairs) * rounds * len(kernels)))
 
-	// 2. Measure Accumulator (Linearized Matrix)
 	for r := 0; r < rounds; r++ {
 		if onProgress != nil {
 			onProgress(r+1, rounds)
 		}
-		for i, src := range topology {
-			for j, dst := range topology {
-				if i == j {
-					continue
+		for idx, p := range pairs {
+			for k, kernel := range kernels {
+				if err := ctx.Err(); err != nil {
+					return err
 				}
-				// Measure latency between logical CPU i and logical CPU j
-				lat, err := c.measurer(src.CPU, dst.CPU, iterations)
+				// Measure latency between logical CPU src and logical CPU dst
+				lat, err := c.measurerFor(kernel.Kernel)(ctx, p.src, p.dst, iterations)
 				if err != nil {
-					return fmt.Errorf("failed to measure latency between CPU %d and %d: %w", src.CPU, dst.CPU, err)
+					if ctxErr := ctx.Err(); ctxErr != nil {
+						return ctxErr
+					}
+					return fmt.Errorf("failed to measure %s latency between CPU %d and %d: %w", kernel.Kernel, p.src, p.dst, err)
 				}
-				latSums[i*numCores+j] += lat
+				latSums[k][idx] += lat
+				c.measured.Add(1)
+			}
+		}
+	}
+
+	for k, kernel := range kernels {
+		for idx, p := range pairs {
+			matrices[kernel.Kernel].Set(p.src, p.dst, latSums[k][idx]/float64(rounds))
+		}
+	}
+	return nil
+}
+
+// store builds the rankings from the (blended) matrix and swaps in a new
+// snapshot, matrices are the per kernel matrices it was blended from.
+// If keepSelections is false, all selections are reset.
+// meta describes the measurement, generation and timestamps are set here.
+func (c *CPUInfo) store(topology []CoreInfo, matrix LatencyMatrix, matrices kernelMatrices, keepSelections bool, meta RankingMeta) {
+	// Nominal latencies of the topology fallback are constant per class already
+	if c.normalization > 0 && !isNominal(meta.Source) {
+		matrix, meta.Normalization = normalizeMatrix(topology, matrix, c.normalization)
+	}
+
+	var distances NUMADistances
+	if c.distances != nil {
+		var err error
+		if distances, err = c.distances(); err != nil {
+			slog.Warn("Failed to read NUMA distances, ranking by latency only", "error", err)
+		}
+	}
+
+	rankings := buildRankings(topology, matrix, distances, c.numaWeight)
+	// The clustering is O(n^3), nominal latencies only repeat the topology
+	var clusters []ClusterLevel
+	if !isNominal(meta.Source) {
+		clusters = buildClusters(topology, matrix)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	prev := c.load()
+	meta.Generation = prev.Meta.Generation + 1
+	meta.CreatedAt = time.Now()
+	meta.NUMAWeight = c.numaWeight
+	meta.Virtualized = c.virtualized
+	c.snapshot.Store(&Snapshot{
+		Meta:     meta,
+		Rankings: rankings,
+		Clusters: clusters,
+		topology: topology,
+		matrix:   matrix,
+		kernels:  matrices,
+	})
+
+	if !keepSelections {
+		if prev.Meta.Source == SourceTopology && !isNominal(meta.Source) {
+			for vmid := range c.selections {
+				c.replaced = append(c.replaced, vmid)
 			}
+			sort.Ints(c.replaced)
 		}
+		c.selections = make(map[int]Selection)
 	}
 
-	// 3. Aggregate and Sort Results
-	var finalResults []CoreRanking
+	// Ensure lastIndex is within bounds if topology shrank
+	if len(rankings) > 0 {
+		c.lastIndex = c.lastIndex % len(rankings)
+	} else {
+		c.lastIndex = 0
+	}
+}
 
-	for i, src := range topology {
-		var neighbors []Neighbor
+// buildRankings creates the sorted neighbor lists for every CPU in topology.
+// If weight > 0 the neighbors are ordered by a blended cost of normalized
+// latency and normalized NUMA distance instead of latency alone.
+func buildRankings(topology []CoreInfo, matrix LatencyMatrix, distances NUMADistances, weight float64) []CoreRanking {
+	numCores := len(topology)
 
-		for j, dst := range topology {
-			if i == j {
+	var maxLat float64
+	var maxDist int
+	for _, src := range topology {
+		for _, dst := range topology {
+			if src.CPU == dst.CPU {
 				continue
 			}
+			if lat, _ := matrix.Get(src.CPU, dst.CPU); lat > maxLat {
+				maxLat = lat
+			}
+			if dist, ok := distances.Distance(src.Node, dst.Node); ok && dist > maxDist {
+				maxDist = dist
+			}
+		}
+	}
 
-			avgLat := latSums[i*numCores+j] / float64(rounds)
+	cost := func(n Neighbor) float64 {
+		var c float64
+		if maxLat > 0 {
+			c += (1 - weight) * n.LatencyNS / maxLat
+		}
+		if maxDist > 0 {
+			c += weight * float64(n.Distance) / float64(maxDist)
+		}
+		return c
+	}
+
+	byCPU := make(map[int]CoreInfo, numCores)
+	for _, core := range topology {
+		byCPU[core.CPU] = core
+	}
 
+	results := make([]CoreRanking, 0, numCores)
+	for _, src := range topology {
+		neighbors := make([]Neighbor, 0, numCores)
+
+		for _, dst := range topology {
+			if src.CPU == dst.CPU {
+				continue
+			}
+
+			lat, _ := matrix.Get(src.CPU, dst.CPU)
+			dist, _ := distances.Distance(src.Node, dst.Node)
 			neighbors = append(neighbors, Neighbor{
 				CPU:       dst.CPU,
 				Socket:    dst.Socket,
 				Core:      dst.Core,
-				LatencyNS: avgLat,
+				Node:      dst.Node,
+				LatencyNS: lat,
+				Distance:  dist,
 			})
 		}
 
-		// Sort: Low Latency (Happy) -> High Latency (Unhappy)
+		// Sort: Low Cost (Happy) -> High Cost (Unhappy)
+		// Ties prefer the same core, then the same socket, then the lower CPU ID,
+		// so equal latencies give the same ranking on every restart.
 		sort.Slice(neighbors, func(a, b int) bool {
-			return neighbors[a].LatencyNS < neighbors[b].LatencyNS
+			na, nb := neighbors[a], neighbors[b]
+			if ca, cb := cost(na), cost(nb); ca != cb {
+				return ca < cb
+			}
+			if sa, sb := siblings(src, byCPU[na.CPU]), siblings(src, byCPU[nb.CPU]); sa != sb {
+				return sa
+			}
+			if sa, sb := na.Socket == src.Socket, nb.Socket == src.Socket; sa != sb {
+				return sa
+			}
+			return na.CPU < nb.CPU
 		})
 
-		finalResults = append(finalResults, CoreRanking{
+		results = append(results, CoreRanking{
 			CPU:     src.CPU,
 			Ranking: neighbors,
 		})
 	}
-
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	c.cache = finalResults
-	c.selections = make(map[int][]int)
-	// Ensure lastIndex is within bounds if topology shrank
-	if len(c.cache) > 0 {
-		c.lastIndex = c.lastIndex % len(c.cache)
-	} else {
-		c.lastIndex = 0
-	}
-	return nil
+	return results
 }
 
-// GetCoreRanking returns the cached core ranking.
+var errEmptyCache = errors.New("cache is empty, you have to call Update() first")
+
+// GetCoreRanking returns the rankings of the current snapshot.
 func (c *CPUInfo) GetCoreRanking() ([]CoreRanking, error) {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-	if len(c.cache) == 0 {
-		return nil, fmt.Errorf("cache is empty, you have to call Update() first")
+	s, err := c.GetSnapshot()
+	if err != nil {
+		return nil, err
 	}
-	return c.cache, nil
+	return s.Rankings, nil
 }
 
-// SelectCPUs returns a list of CPU IDs for the next VM, rotating through available cores.
+// SelectCPUs returns the CPUs for the next VM, rotating through available cores,
+// tagged with the generation of the ranking they were selected from.
 // This method is thread-safe to handle concurrent access, specifically when CPU hotplug
 // events trigger a topology update (changing the cache) while affinity is being requested.
-func (c *CPUInfo) SelectCPUs(vmid int, requestedCPUs int) ([]int, error) {
+func (c *CPUInfo) SelectCPUs(vmid int, requestedCPUs int) (Selection, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	if len(c.cache) == 0 {
-		return nil, fmt.Errorf("core ranking cache is empty")
+	snap := c.load()
+	if len(snap.Rankings) == 0 {
+		return Selection{}, fmt.Errorf("core ranking cache is empty")
 	}
 
-	if cores, ok := c.selections[vmid]; ok {
+	if sel, ok := c.selections[vmid]; ok {
 		// If we already have a selection for this VMID and the size matches, return it.
-		if len(cores) == requestedCPUs {
-			return cores, nil
+		if len(sel.CPUs) == requestedCPUs {
+			return sel, nil
 		}
 	}
 
 	if requestedCPUs <= 0 {
-		return nil, fmt.Errorf("requested CPUs must be greater than 0")
+		return Selection{}, fmt.Errorf("requested CPUs must be greater than 0")
 	}
 
-	max := len(c.cache)
+	max := len(snap.Rankings)
 	if requestedCPUs > max {
-		return nil, fmt.Errorf("requested CPUs %d exceed available %d", requestedCPUs, max)
+		return Selection{}, fmt.Errorf("requested CPUs %d exceed available %d", requestedCPUs, max)
 	}
 
 	c.lastIndex = (c.lastIndex + 1) % max
 
-	primary := c.cache[c.lastIndex]
+	primary := snap.Rankings[c.lastIndex]
 	res := make([]int, 0, requestedCPUs)
 	res = append(res, primary.CPU)
 
@@ -237,22 +661,117 @@ func (c *CPUInfo) SelectCPUs(vmid int, requestedCPUs int) ([]int, error) {
 		res = append(res, primary.Ranking[i].CPU)
 	}
 
-	c.selections[vmid] = res
+	sel := Selection{CPUs: res, Generation: snap.Meta.Generation}
+	c.selections[vmid] = sel
+	return sel, nil
+}
+
+// SelectionChange describes a VM selection that was changed because some
+// of its CPUs went away (hotplug remove or offline).
+// New is empty if no replacement was possible and the selection was dropped.
+type SelectionChange struct {
+	VMID int   `json:"vmid"`
+	Old  []int `json:"old"`
+	New  []int `json:"new"`
+	Lost []int `json:"lost"`
+}
+
+// ReplaceLostCPUs finds all selections that use a CPU which is no longer part
+// of the ranking and replaces the missing CPUs. The remaining CPUs of a
+// selection are kept, the replacements are the nearest neighbors of the first
+// remaining CPU that no other selection holds; held CPUs are only used if not
+// enough free ones are left. If no CPU remains, a new primary is picked
+// round-robin.
+func (c *CPUInfo) ReplaceLostCPUs() []SelectionChange {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	snap := c.load()
+	byCPU := make(map[int]CoreRanking, len(snap.Rankings))
+	for _, r := range snap.Rankings {
+		byCPU[r.CPU] = r
+	}
+
+	// Deterministic order for round-robin and logging
+	vmids := make([]int, 0, len(c.selections))
+	for vmid := range c.selections {
+		vmids = append(vmids, vmid)
+	}
+	sort.Ints(vmids)
+
+	var changes []SelectionChange
+	for _, vmid := range vmids {
+		old := c.selections[vmid].CPUs
+
+		var kept, lost []int
+		for _, cpu := range old {
+			if _, ok := byCPU[cpu]; ok {
+				kept = append(kept, cpu)
+			} else {
+				lost = append(lost, cpu)
+			}
+		}
+		if len(lost) == 0 {
+			continue
+		}
+
+		change := SelectionChange{VMID: vmid, Old: old, Lost: lost}
+
+		if len(old) > len(snap.Rankings) {
+			delete(c.selections, vmid)
+			changes = append(changes, change)
+			continue
+		}
+
+		if len(kept) == 0 {
+			c.lastIndex = (c.lastIndex + 1) % len(snap.Rankings)
+			kept = []int{snap.Rankings[c.lastIndex].CPU}
+		}
+
+		// CPUs held by other VMs are only used if not enough free ones are left
+		used := make(map[int]struct{})
+		for other, sel := range c.selections {
+			if other == vmid {
+				continue
+			}
+			for _, cpu := range sel.CPUs {
+				used[cpu] = struct{}{}
+			}
+		}
 
-	return res, nil
+		res := append(make([]int, 0, len(old)), kept...)
+		for _, free := range []bool{true, false} {
+			for _, n := range byCPU[kept[0]].Ranking {
+				if len(res) == len(old) {
+					break
+				}
+				if _, ok := used[n.CPU]; ok && free {
+					continue
+				}
+				if !slices.Contains(res, n.CPU) {
+					res = append(res, n.CPU)
+				}
+			}
+		}
+
+		c.selections[vmid] = Selection{CPUs: res, Generation: snap.Meta.Generation}
+		change.New = res
+		changes = append(changes, change)
+	}
+	return changes
 }
 
 // GetSelections returns a copy of the current CPU selections per VMID.
 // WARNING: this is not accurate as VMs are no longer running
-func (c *CPUInfo) GetSelections() map[int][]int {
+func (c *CPUInfo) GetSelections() map[int]Selection {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
-	result := make(map[int][]int, len(c.selections))
-	for vmid, cpus := range c.selections {
-		cpusCopy := make([]int, len(cpus))
-		copy(cpusCopy, cpus)
-		result[vmid] = cpusCopy
+	result := make(map[int]Selection, len(c.selections))
+	for vmid, sel := range c.selections {
+		cpusCopy := make([]int, len(sel.CPUs))
+		copy(cpusCopy, sel.CPUs)
+		result[vmid] = Selection{CPUs: cpusCopy, Generation: sel.Generation}
 	}
 	return result
 }
@@ -273,116 +792,50 @@ func NumCPU() int {
 	return len(matches)
 }
 
+// detectTopologySystem returns the online CPUs, only those can be measured.
 func detectTopologySystem() ([]CoreInfo, error) {
-	var cores []CoreInfo
-
-	// We look at the sysfs directly to find all present CPUs.
-	matches, err := filepath.Glob("/sys/devices/system/cpu/cpu[0-9]*")
+	all, err := ScanTopology()
 	if err != nil {
 		return nil, err
 	}
-
-	for _, path := range matches {
-		// Extract CPU ID from path (e.g. /sys/devices/system/cpu/cpu0 -> 0)
-		cpuIDStr := strings.TrimPrefix(filepath.Base(path), "cpu")
-		i, err := strconv.Atoi(cpuIDStr)
-		if err != nil {
-			continue
-		}
-
-		// 1. Socket ID (physical_package_id)
-		socketID, err := readSysFSInt(fmt.Sprintf("/sys/devices/system/cpu/cpu%d/topology/physical_package_id", i))
-		if err != nil {
-			// Skip offline/inaccessible CPUs
-			continue
-		}
-
-		// 2. Physical Core ID (core_id)
-		coreID, err := readSysFSInt(fmt.Sprintf("/sys/devices/system/cpu/cpu%d/topology/core_id", i))
-		if err != nil {
-			coreID = -1
+	cores := make([]CoreInfo, 0, len(all))
+	for _, core := range all {
+		if core.Online {
+			cores = append(cores, core)
 		}
-
-		cores = append(cores, CoreInfo{
-			CPU:    i, // This matches `taskset -c` ID
-			Socket: socketID,
-			Core:   coreID,
-		})
 	}
-
-	// Ensure deterministic order
-	sort.Slice(cores, func(i, j int) bool {
-		return cores[i].CPU < cores[j].CPU
-	})
-
 	return cores, nil
 }
 
-func measureSingleLink(cpuA, cpuB, iter int) (float64, error) {
-	var wg sync.WaitGroup
-	wg.Add(2)
+// measureSingleLink measures the round trip of a cache line between two CPUs
+// using an atomic store/load ping-pong (KernelPingPong). Both goroutines
+// busy-wait, so they check the stop flag of runPinned while spinning, to
+// release the CPUs promptly on shutdown or timeout.
+func measureSingleLink(ctx context.Context, cpuA, cpuB, iter int) (float64, error) {
+	var signal atomic.Int32
 
-	var barrier sync.WaitGroup
-	barrier.Add(2)
-
-	var signal int32 = 0
-	var errMutex sync.Mutex
-	var firstErr error
-
-	go func() {
-		defer wg.Done()
-		if err := lockToCPU(cpuA); err != nil {
-			errMutex.Lock()
-			if firstErr == nil {
-				firstErr = err
-			}
-			errMutex.Unlock()
-			barrier.Done()
-			return
-		}
-		barrier.Done()
-		barrier.Wait()
-		if firstErr != nil {
-			return
-		}
+	duration, err := runPinned(ctx, cpuA, cpuB, func(stop *atomic.Bool) {
 		for k := 0; k < iter; k++ {
-			for atomic.LoadInt32(&signal) != 0 {
-			}
-			atomic.StoreInt32(&signal, 1)
-		}
-	}()
-
-	go func() {
-		defer wg.Done()
-		if err := lockToCPU(cpuB); err != nil {
-			errMutex.Lock()
-			if firstErr == nil {
-				firstErr = err
+			for signal.Load() != 0 {
+				if stop.Load() {
+					return
+				}
 			}
-			errMutex.Unlock()
-			barrier.Done()
-			return
-		}
-		barrier.Done()
-		barrier.Wait()
-		if firstErr != nil {
-			return
+			signal.Store(1)
 		}
+	}, func(stop *atomic.Bool) {
 		for k := 0; k < iter; k++ {
-			for atomic.LoadInt32(&signal) != 1 {
+			for signal.Load() != 1 {
+				if stop.Load() {
+					return
+				}
 			}
-			atomic.StoreInt32(&signal, 0)
+			signal.Store(0)
 		}
-	}()
-
-	start := time.Now()
-	wg.Wait()
-	duration := time.Since(start)
-
-	if firstErr != nil {
-		return 0, firstErr
+	})
+	if err != nil {
+		return 0, err
 	}
-
 	return float64(duration.Nanoseconds()) / float64(iter*2), nil
 }
 
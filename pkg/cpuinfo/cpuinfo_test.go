@@ -1,6 +1,7 @@
 package cpuinfo
 
 import (
+	"context"
 	"strings"
 	"sync"
 	"testing"
@@ -12,7 +13,7 @@ import (
 func TestGetCoreRanking_OneIteration(t *testing.T) {
 	c := New()
 	// Run 1 round with 1 iteration to verify the loop logic without heavy load
-	err := c.Update(1, 1, nil)
+	err := c.Update(context.Background(), 1, 1, nil)
 	if err != nil {
 		t.Logf("Update failed (expected in non-Linux/restricted envs): %v", err)
 		return
@@ -31,7 +32,7 @@ func TestGetCoreRanking_OneIteration(t *testing.T) {
 func TestCalculateRanking_Success(t *testing.T) {
 	c := New()
 	// Run with minimal work and generous timeout
-	err := c.CalculateRanking(1, 1, 10*time.Second)
+	err := c.CalculateRanking(context.Background(), 1, 1, 10*time.Second)
 
 	// On supported platforms, this should be nil.
 	// On unsupported platforms, it returns an error, but NOT a timeout.
@@ -53,7 +54,7 @@ func TestCalculateRanking_Timeout(t *testing.T) {
 	c := New()
 	// Run with work that definitely takes > 1ns
 	// Note: On non-Linux, Update returns error immediately, so we might not hit timeout.
-	err := c.CalculateRanking(10, 1000, 1*time.Nanosecond)
+	err := c.CalculateRanking(context.Background(), 10, 1000, 1*time.Nanosecond)
 
 	assert.Error(t, err, "Expected an error (timeout or platform specific)")
 
@@ -67,14 +68,14 @@ func TestSelectCPUs_EdgeCases(t *testing.T) {
 	c := New()
 	// Initialize with dummy data if possible, or run a quick update
 	// Since we can't easily inject cache without Update, we run a quick update
-	if err := c.Update(1, 1, nil); err != nil {
+	if err := c.Update(context.Background(), 1, 1, nil); err != nil {
 		t.Skipf("Skipping affinity test due to update failure (non-Linux?): %v", err)
 	}
 
 	// Request 1 core
-	cpus, err := c.SelectCPUs(100, 1)
+	sel, err := c.SelectCPUs(100, 1)
 	assert.NoError(t, err)
-	assert.Len(t, cpus, 1)
+	assert.Len(t, sel.CPUs, 1)
 
 	// Request too many
 	_, err = c.SelectCPUs(100, 9999)
@@ -90,7 +91,7 @@ func TestSelectCPUs_EdgeCases(t *testing.T) {
 func TestSelectCPUs_Race(t *testing.T) {
 	c := New()
 	// Initial update to ensure we have data
-	if err := c.Update(1, 1, nil); err != nil {
+	if err := c.Update(context.Background(), 1, 1, nil); err != nil {
 		t.Skipf("Skipping race test due to update failure: %v", err)
 	}
 
@@ -107,7 +108,7 @@ func TestSelectCPUs_Race(t *testing.T) {
 				return
 			default:
 				// Run a quick update
-				_ = c.Update(1, 1, nil)
+				_ = c.Update(context.Background(), 1, 1, nil)
 				time.Sleep(1 * time.Millisecond)
 			}
 		}
@@ -119,12 +120,12 @@ func TestSelectCPUs_Race(t *testing.T) {
 		go func() {
 			defer wg.Done()
 			for j := 0; j < 50; j++ {
-				cpus, err := c.SelectCPUs(j, 1)
+				sel, err := c.SelectCPUs(j, 1)
 				// It's possible Update fails on some platforms or transiently,
 				// but SelectCPUs should generally succeed if cache is populated.
 				// We mainly care that it doesn't panic or race.
 				if err == nil {
-					assert.NotEmpty(t, cpus)
+					assert.NotEmpty(t, sel.CPUs)
 				}
 			}
 		}()
@@ -143,7 +144,7 @@ func TestUpdate_TopologyResize(t *testing.T) {
 	}
 
 	// Mock Measurer: Always return 10ns so Update succeeds quickly
-	c.measurer = func(cpuA, cpuB, iter int) (float64, error) {
+	c.measurer = func(_ context.Context, cpuA, cpuB, iter int) (float64, error) {
 		return 10.0, nil
 	}
 
@@ -159,12 +160,12 @@ func TestUpdate_TopologyResize(t *testing.T) {
 	// Run Update
 	// This should reset lastIndex to be within bounds of 5 (0..4)
 	// 8 % 5 = 3
-	err := c.Update(1, 1, nil)
+	err := c.Update(context.Background(), 1, 1, nil)
 	assert.NoError(t, err)
 
 	// Verify Shrink
 	c.mu.RLock()
-	assert.Len(t, c.cache, 5)
+	assert.Len(t, c.load().Rankings, 5)
 	assert.Equal(t, 3, c.lastIndex, "lastIndex should be modulated to fit new cache size")
 	c.mu.RUnlock()
 
@@ -177,36 +178,248 @@ func TestUpdate_TopologyResize(t *testing.T) {
 		return cores, nil
 	}
 
-	err = c.Update(1, 1, nil)
+	err = c.Update(context.Background(), 1, 1, nil)
 	assert.NoError(t, err)
 
 	// Verify Grow
 	c.mu.RLock()
 	defer c.mu.RUnlock()
-	assert.Len(t, c.cache, 10)
+	assert.Len(t, c.load().Rankings, 10)
 	assert.Equal(t, 3, c.lastIndex, "lastIndex should be preserved when growing (3 % 10 = 3)")
 }
 
 func TestGetSelections(t *testing.T) {
 	c := New()
 	// Initialize cache
-	if err := c.Update(1, 1, nil); err != nil {
+	if err := c.Update(context.Background(), 1, 1, nil); err != nil {
 		t.Skipf("Skipping GetSelections test due to update failure: %v", err)
 	}
 
 	// Make a selection
-	cpus, err := c.SelectCPUs(100, 1)
+	sel, err := c.SelectCPUs(100, 1)
 	assert.NoError(t, err)
+	cpus := sel.CPUs
 	assert.NotEmpty(t, cpus)
 
 	// Retrieve selections
 	selections := c.GetSelections()
 	assert.Contains(t, selections, 100)
-	assert.Equal(t, cpus, selections[100])
+	assert.Equal(t, cpus, selections[100].CPUs)
 
 	// Verify it returns a copy
-	selections[100][0] = -1
+	selections[100].CPUs[0] = -1
 	selections2 := c.GetSelections()
-	assert.NotEqual(t, -1, selections2[100][0])
-	assert.Equal(t, cpus[0], selections2[100][0])
+	assert.NotEqual(t, -1, selections2[100].CPUs[0])
+	assert.Equal(t, cpus[0], selections2[100].CPUs[0])
+}
+
+func TestUpdateIncremental(t *testing.T) {
+	topology := func(cpus ...int) []CoreInfo {
+		var cores []CoreInfo
+		for _, cpu := range cpus {
+			cores = append(cores, CoreInfo{CPU: cpu, Socket: 0, Core: cpu, Node: -1})
+		}
+		return cores
+	}
+
+	var measured []cpuPair
+	c := &CPUInfo{
+		detector: func() ([]CoreInfo, error) { return topology(0, 1, 2, 3), nil },
+		measurer: func(_ context.Context, cpuA, cpuB, iter int) (float64, error) {
+			measured = append(measured, cpuPair{cpuA, cpuB})
+			return float64(10 + cpuA + cpuB), nil
+		},
+		selections: make(map[int]Selection),
+	}
+
+	// No previous data: falls back to a full update
+	assert.NoError(t, c.UpdateIncremental(context.Background(), 1, 1, nil))
+	assert.Len(t, measured, 12)
+
+	c.selections[100] = Selection{CPUs: []int{0, 1}}
+	c.selections[101] = Selection{CPUs: []int{2, 3}}
+
+	// Remove CPU 3: nothing needs to be measured
+	measured = nil
+	c.detector = func() ([]CoreInfo, error) { return topology(0, 1, 2), nil }
+	assert.NoError(t, c.UpdateIncremental(context.Background(), 1, 1, nil))
+	assert.Empty(t, measured)
+
+	rankings, err := c.GetCoreRanking()
+	assert.NoError(t, err)
+	assert.Len(t, rankings, 3)
+	assert.Equal(t, []int{0, 1, 2}, c.load().matrix.CPUs())
+
+	selections := c.GetSelections()
+	assert.Contains(t, selections, 100, "unaffected VM keeps its selection")
+	assert.Contains(t, selections, 101, "selections are fixed by ReplaceLostCPUs, not dropped")
+
+	changes := c.ReplaceLostCPUs()
+	assert.Len(t, changes, 1)
+	assert.Equal(t, 101, changes[0].VMID)
+	assert.Equal(t, []int{3}, changes[0].Lost)
+	assert.Len(t, changes[0].New, 2)
+	assert.Equal(t, 2, changes[0].New[0], "surviving CPU is kept")
+	assert.NotContains(t, changes[0].New, 3)
+	assert.Equal(t, changes[0].New, c.GetSelections()[101].CPUs)
+
+	// Add CPU 4: only pairs with CPU 4 are measured
+	measured = nil
+	c.detector = func() ([]CoreInfo, error) { return topology(0, 1, 2, 4), nil }
+	assert.NoError(t, c.UpdateIncremental(context.Background(), 1, 1, nil))
+	assert.Len(t, measured, 6)
+	for _, p := range measured {
+		assert.True(t, p.src == 4 || p.dst == 4, "unexpected pair %v", p)
+	}
+
+	rankings, err = c.GetCoreRanking()
+	assert.NoError(t, err)
+	assert.Len(t, rankings, 4)
+	for _, r := range rankings {
+		assert.Len(t, r.Ranking, 3)
+	}
+	assert.Contains(t, c.GetSelections(), 100)
+}
+
+func TestReplaceLostCPUs(t *testing.T) {
+	c := &CPUInfo{
+		detector: func() ([]CoreInfo, error) {
+			return []CoreInfo{{CPU: 0}, {CPU: 1}, {CPU: 2}}, nil
+		},
+		measurer: func(_ context.Context, cpuA, cpuB, iter int) (float64, error) {
+			// CPU 0 <-> 1 is the closest pair
+			if cpuA+cpuB == 1 {
+				return 5, nil
+			}
+			return 50, nil
+		},
+		selections: make(map[int]Selection),
+	}
+	assert.NoError(t, c.Update(context.Background(), 1, 1, nil))
+
+	c.mu.Lock()
+	c.selections[100] = Selection{CPUs: []int{0, 1}}       // unaffected
+	c.selections[101] = Selection{CPUs: []int{7, 8}}       // everything lost: new primary
+	c.selections[102] = Selection{CPUs: []int{7, 1}}       // partially lost: 1's nearest neighbor is 0
+	c.selections[103] = Selection{CPUs: []int{0, 1, 2, 7}} // larger than the host: dropped
+	c.mu.Unlock()
+
+	changes := c.ReplaceLostCPUs()
+	assert.Len(t, changes, 3)
+
+	byVM := make(map[int]SelectionChange)
+	for _, ch := range changes {
+		byVM[ch.VMID] = ch
+	}
+
+	assert.NotContains(t, byVM, 100)
+	assert.Len(t, byVM[101].New, 2)
+	assert.Equal(t, []int{7, 8}, byVM[101].Lost)
+	assert.Equal(t, []int{1, 0}, byVM[102].New)
+	assert.Empty(t, byVM[103].New)
+
+	selections := c.GetSelections()
+	assert.Equal(t, []int{0, 1}, selections[100].CPUs)
+	assert.Equal(t, []int{1, 0}, selections[102].CPUs)
+	assert.NotContains(t, selections, 103)
+
+	// Running again is a no-op
+	assert.Empty(t, c.ReplaceLostCPUs())
+}
+
+func TestReplaceLostCPUs_SkipsHeldCPUs(t *testing.T) {
+	c := &CPUInfo{
+		detector: func() ([]CoreInfo, error) {
+			return []CoreInfo{{CPU: 0}, {CPU: 1}, {CPU: 2}}, nil
+		},
+		measurer: func(_ context.Context, cpuA, cpuB, iter int) (float64, error) {
+			// CPU 0 <-> 1 is the closest pair
+			if cpuA+cpuB == 1 {
+				return 5, nil
+			}
+			return 50, nil
+		},
+		selections: make(map[int]Selection),
+	}
+	assert.NoError(t, c.Update(context.Background(), 1, 1, nil))
+
+	c.mu.Lock()
+	c.selections[100] = Selection{CPUs: []int{0}}    // holds 1's nearest neighbor
+	c.selections[101] = Selection{CPUs: []int{7, 1}} // 2 is the nearest free CPU
+	c.mu.Unlock()
+
+	assert.Len(t, c.ReplaceLostCPUs(), 1)
+	assert.Equal(t, []int{1, 2}, c.GetSelections()[101].CPUs)
+
+	c.mu.Lock()
+	c.selections[100] = Selection{CPUs: []int{0, 2}} // no free CPU left
+	c.selections[101] = Selection{CPUs: []int{7, 1}} // falls back to the nearest held CPU
+	c.mu.Unlock()
+
+	assert.Len(t, c.ReplaceLostCPUs(), 1)
+	assert.Equal(t, []int{1, 0}, c.GetSelections()[101].CPUs)
+}
+
+func TestUpdate_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	calls := 0
+	c := &CPUInfo{
+		detector: func() ([]CoreInfo, error) {
+			return []CoreInfo{{CPU: 0}, {CPU: 1}, {CPU: 2}}, nil
+		},
+		measurer: func(ctx context.Context, cpuA, cpuB, iter int) (float64, error) {
+			calls++
+			if calls == 2 {
+				cancel()
+			}
+			return 10, nil
+		},
+		selections: make(map[int]Selection),
+	}
+
+	err := c.Update(ctx, 1, 1, nil)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 2, calls, "measurement stops after cancellation")
+
+	_, err = c.GetCoreRanking()
+	assert.Error(t, err, "cache is not touched by a cancelled update")
+}
+
+func TestCalculateRanking_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	c := New()
+	err := c.CalculateRanking(ctx, 1, 1, time.Minute)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "cancelled")
+}
+
+func TestMeasureSingleLink_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	// Far more iterations than could finish in 50ms. On a single CPU host both
+	// goroutines share the CPU, which makes the ping-pong even slower.
+	start := time.Now()
+	_, err := measureSingleLink(ctx, 0, 0, 1<<40)
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), 5*time.Second, "busy-wait loops must observe the cancellation")
+}
+
+func TestBuildRankings_TieBreak(t *testing.T) {
+	topology, _ := fallbackTopology()
+	matrix := make(LatencyMatrix, len(topology))
+	for _, p := range allPairs(topology) {
+		matrix.Set(p.src, p.dst, 50)
+	}
+
+	// All latencies are equal: SMT sibling, same socket, then by CPU ID
+	rankings := buildRankings(topology, matrix, nil, 0)
+	var order []int
+	for _, n := range rankings[1].Ranking {
+		order = append(order, n.CPU)
+	}
+	assert.Equal(t, []int{5, 0, 4, 2, 3, 6, 7}, order)
 }
@@ -0,0 +1,247 @@
+package cpuinfo
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MatrixFormatVersion is the version of the JSON matrix schema.
+const MatrixFormatVersion = 1
+
+// MatrixExport is the JSON representation of a latency matrix:
+//
+//	{
+//	  "version": 1,
+//	  "cpus": [0, 1, 2],
+//	  "latency_ns": [[0, 21.5, 80.1], [21.7, 0, 79.8], [80.3, 79.9, 0]]
+//	}
+//
+// cpus holds the logical CPU IDs, latency_ns[i][j] is the latency in
+// nanoseconds from cpus[i] to cpus[j]. The diagonal is 0.
+type MatrixExport struct {
+	Version   int         `json:"version"`
+	CPUs      []int       `json:"cpus"`
+	LatencyNS [][]float64 `json:"latency_ns"`
+}
+
+// Export converts the matrix into its JSON representation.
+func (m LatencyMatrix) Export() MatrixExport {
+	cpus := m.allCPUs()
+	res := MatrixExport{Version: MatrixFormatVersion, CPUs: cpus, LatencyNS: make([][]float64, len(cpus))}
+	for i, src := range cpus {
+		res.LatencyNS[i] = make([]float64, len(cpus))
+		for j, dst := range cpus {
+			res.LatencyNS[i][j], _ = m.Get(src, dst)
+		}
+	}
+	return res
+}
+
+// Matrix converts the JSON representation back into a latency matrix.
+func (e MatrixExport) Matrix() (LatencyMatrix, error) {
+	if e.Version != MatrixFormatVersion {
+		return nil, fmt.Errorf("unsupported matrix version %d", e.Version)
+	}
+	return squareMatrix(e.CPUs, e.LatencyNS)
+}
+
+// WriteCSV writes the matrix in the core-to-core-latency CSV layout: no
+// header, row and column i belong to logical CPU i, the diagonal is 0.
+// CPUs missing from the matrix (e.g. offline ones) keep their row and
+// column with empty cells, so the grid always spans CPU 0 to the highest
+// CPU.
+func (m LatencyMatrix) WriteCSV(w io.Writer) error {
+	export := m.Export()
+	size := 0
+	if len(export.CPUs) > 0 {
+		size = export.CPUs[len(export.CPUs)-1] + 1
+	}
+	records := make([][]string, size)
+	for i := range records {
+		records[i] = make([]string, size)
+	}
+	for i, src := range export.CPUs {
+		for j, dst := range export.CPUs {
+			records[src][dst] = strconv.FormatFloat(export.LatencyNS[i][j], 'f', 1, 64)
+		}
+	}
+
+	cw := csv.NewWriter(w)
+	if err := cw.WriteAll(records); err != nil {
+		return err
+	}
+	return cw.Error()
+}
+
+// ReadMatrixCSV reads a matrix in the core-to-core-latency CSV layout.
+// Row and column i belong to logical CPU i. Empty or 0 cells outside the
+// diagonal are taken from the opposite direction, so triangular matrices
+// are accepted as well. A CPU whose row and column are completely empty
+// is not part of the matrix.
+func ReadMatrixCSV(r io.Reader) (LatencyMatrix, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read csv: %w", err)
+	}
+
+	n := len(records)
+	empty := make([][]bool, n)
+	values := make([][]float64, n)
+	for i, record := range records {
+		empty[i] = make([]bool, len(record))
+		values[i] = make([]float64, len(record))
+		for j, cell := range record {
+			cell = strings.TrimSpace(cell)
+			if cell == "" {
+				empty[i][j] = true
+				continue
+			}
+			v, err := strconv.ParseFloat(cell, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value %q in row %d, column %d: %w", cell, i+1, j+1, err)
+			}
+			values[i][j] = v
+		}
+	}
+	for i, row := range values {
+		if len(row) != n {
+			return nil, fmt.Errorf("row %d has %d values, expected %d", i+1, len(row), n)
+		}
+	}
+
+	var cpus []int
+	for i := 0; i < n; i++ {
+		absent := true
+		for j := 0; j < n && absent; j++ {
+			absent = empty[i][j] && empty[j][i]
+		}
+		if !absent {
+			cpus = append(cpus, i)
+		}
+	}
+	present := make([][]float64, len(cpus))
+	for i, src := range cpus {
+		present[i] = make([]float64, len(cpus))
+		for j, dst := range cpus {
+			present[i][j] = values[src][dst]
+		}
+	}
+	return squareMatrix(cpus, present)
+}
+
+// ReadMatrixFile reads a matrix file, the format is selected by the
+// extension: .csv for the core-to-core-latency layout, otherwise JSON.
+func ReadMatrixFile(path string) (LatencyMatrix, error) {
+	// #nosec G304 -- path is configured by the admin (PCA_LATENCY_MATRIX_FILE)
+	f, err := os.Open(filepath.Clean(path))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		return ReadMatrixCSV(f)
+	}
+
+	var export MatrixExport
+	if err := json.NewDecoder(f).Decode(&export); err != nil {
+		return nil, fmt.Errorf("failed to decode matrix json: %w", err)
+	}
+	return export.Matrix()
+}
+
+// MatrixFromRankings rebuilds the latency matrix from core rankings.
+func MatrixFromRankings(rankings []CoreRanking) LatencyMatrix {
+	m := make(LatencyMatrix, len(rankings))
+	for _, r := range rankings {
+		for _, n := range r.Ranking {
+			m.Set(r.CPU, n.CPU, n.LatencyNS)
+		}
+	}
+	return m
+}
+
+// importedPairs returns the part of imported that covers the CPUs of
+// topology and the pairs that are missing and must be measured.
+func importedPairs(imported LatencyMatrix, topology []CoreInfo) (LatencyMatrix, []cpuPair) {
+	matrix := make(LatencyMatrix, len(topology))
+	var missing []cpuPair
+	for _, p := range allPairs(topology) {
+		if lat, ok := imported.Get(p.src, p.dst); ok {
+			matrix.Set(p.src, p.dst, lat)
+		} else {
+			missing = append(missing, p)
+		}
+	}
+	return matrix, missing
+}
+
+// allCPUs returns the sorted CPUs that appear as source or destination.
+func (m LatencyMatrix) allCPUs() []int {
+	seen := make(map[int]struct{}, len(m))
+	for src, row := range m {
+		seen[src] = struct{}{}
+		for dst := range row {
+			seen[dst] = struct{}{}
+		}
+	}
+	cpus := make([]int, 0, len(seen))
+	for cpu := range seen {
+		cpus = append(cpus, cpu)
+	}
+	sort.Ints(cpus)
+	return cpus
+}
+
+// squareMatrix validates an n x n table and converts it into a LatencyMatrix.
+// Missing directions are filled from the opposite direction.
+func squareMatrix(cpus []int, values [][]float64) (LatencyMatrix, error) {
+	n := len(cpus)
+	if n == 0 {
+		return nil, fmt.Errorf("matrix is empty")
+	}
+	seen := make(map[int]struct{}, n)
+	for _, cpu := range cpus {
+		if _, ok := seen[cpu]; ok {
+			return nil, fmt.Errorf("duplicate CPU %d", cpu)
+		}
+		seen[cpu] = struct{}{}
+	}
+	if len(values) != n {
+		return nil, fmt.Errorf("matrix has %d rows, expected %d", len(values), n)
+	}
+	for i, row := range values {
+		if len(row) != n {
+			return nil, fmt.Errorf("row %d has %d values, expected %d", i+1, len(row), n)
+		}
+	}
+
+	m := make(LatencyMatrix, n)
+	for i, src := range cpus {
+		for j, dst := range cpus {
+			if i == j {
+				continue
+			}
+			v := values[i][j]
+			if v == 0 {
+				v = values[j][i]
+			}
+			if !(v > 0) { // also rejects NaN
+				return nil, fmt.Errorf("invalid latency %v between CPU %d and %d", v, src, dst)
+			}
+			m.Set(src, dst, v)
+		}
+	}
+	return m, nil
+}
@@ -0,0 +1,190 @@
+package cpuinfo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func exampleMatrix() LatencyMatrix {
+	m := make(LatencyMatrix)
+	m.Set(0, 1, 21.5)
+	m.Set(0, 2, 80.1)
+	m.Set(1, 0, 21.7)
+	m.Set(1, 2, 79.8)
+	m.Set(2, 0, 80.3)
+	m.Set(2, 1, 79.9)
+	return m
+}
+
+func TestLatencyMatrix_CSVRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, exampleMatrix().WriteCSV(&buf))
+	assert.Equal(t, "0.0,21.5,80.1\n21.7,0.0,79.8\n80.3,79.9,0.0\n", buf.String())
+
+	m, err := ReadMatrixCSV(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, exampleMatrix(), m)
+}
+
+func TestLatencyMatrix_CSVRoundTrip_SparseCPUs(t *testing.T) {
+	// CPU 1 and 3 are offline
+	m := make(LatencyMatrix)
+	m.Set(0, 2, 30.5)
+	m.Set(2, 0, 30.5)
+	m.Set(0, 4, 90.0)
+	m.Set(4, 0, 90.0)
+	m.Set(2, 4, 88.5)
+	m.Set(4, 2, 88.5)
+
+	var buf bytes.Buffer
+	require.NoError(t, m.WriteCSV(&buf))
+	assert.Equal(t, "0.0,,30.5,,90.0\n,,,,\n30.5,,0.0,,88.5\n,,,,\n90.0,,88.5,,0.0\n", buf.String())
+
+	res, err := ReadMatrixCSV(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, m, res)
+}
+
+func TestReadMatrixCSV_Triangular(t *testing.T) {
+	// core-to-core-latency style lower triangle, upper triangle left empty
+	m, err := ReadMatrixCSV(strings.NewReader("0,,\n20.0,0,\n80,79.5,0\n"))
+	require.NoError(t, err)
+
+	lat, ok := m.Get(0, 1)
+	assert.True(t, ok)
+	assert.Equal(t, 20.0, lat)
+	lat, _ = m.Get(1, 2)
+	assert.Equal(t, 79.5, lat)
+	lat, _ = m.Get(2, 1)
+	assert.Equal(t, 79.5, lat)
+}
+
+func TestReadMatrixCSV_Invalid(t *testing.T) {
+	tests := map[string]string{
+		"empty":        "",
+		"not square":   "0,1\n1,0\n2,2\n",
+		"not a number": "0,x\n1,0\n",
+		"negative":     "0,-1\n-1,0\n",
+		"missing pair": "0,0\n0,0\n",
+		"nan":          "0,NaN\nNaN,0\n",
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := ReadMatrixCSV(strings.NewReader(data))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestMatrixExport_JSON(t *testing.T) {
+	export := exampleMatrix().Export()
+	assert.Equal(t, MatrixFormatVersion, export.Version)
+	assert.Equal(t, []int{0, 1, 2}, export.CPUs)
+	assert.Equal(t, []float64{21.7, 0, 79.8}, export.LatencyNS[1])
+
+	data, err := json.Marshal(export)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"latency_ns":[[0,21.5,80.1]`)
+
+	var decoded MatrixExport
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	m, err := decoded.Matrix()
+	require.NoError(t, err)
+	assert.Equal(t, exampleMatrix(), m)
+
+	decoded.Version = 99
+	_, err = decoded.Matrix()
+	assert.Error(t, err)
+
+	_, err = MatrixExport{Version: 1, CPUs: []int{1, 1}, LatencyNS: [][]float64{{0, 1}, {1, 0}}}.Matrix()
+	assert.Error(t, err, "duplicate CPUs")
+}
+
+func TestReadMatrixFile(t *testing.T) {
+	dir := t.TempDir()
+
+	csvPath := filepath.Join(dir, "matrix.CSV")
+	var buf bytes.Buffer
+	require.NoError(t, exampleMatrix().WriteCSV(&buf))
+	require.NoError(t, os.WriteFile(csvPath, buf.Bytes(), 0600))
+
+	m, err := ReadMatrixFile(csvPath)
+	require.NoError(t, err)
+	assert.Equal(t, exampleMatrix(), m)
+
+	jsonPath := filepath.Join(dir, "matrix.json")
+	data, err := json.Marshal(exampleMatrix().Export())
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(jsonPath, data, 0600))
+
+	m, err = ReadMatrixFile(jsonPath)
+	require.NoError(t, err)
+	assert.Equal(t, exampleMatrix(), m)
+
+	_, err = ReadMatrixFile(filepath.Join(dir, "missing.csv"))
+	assert.Error(t, err)
+}
+
+func TestMatrixFromRankings(t *testing.T) {
+	topology := []CoreInfo{{CPU: 0}, {CPU: 1}, {CPU: 2}}
+	rankings := buildRankings(topology, exampleMatrix(), nil, 0)
+	assert.Equal(t, exampleMatrix(), MatrixFromRankings(rankings))
+}
+
+func TestUpdate_ImportedMatrix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "matrix.csv")
+	var buf bytes.Buffer
+	require.NoError(t, exampleMatrix().WriteCSV(&buf))
+	require.NoError(t, os.WriteFile(path, buf.Bytes(), 0600))
+
+	var measured []cpuPair
+	c := New(WithImportedMatrix(path)).(*CPUInfo)
+	c.distances = nil
+	// CPU 3 is not part of the imported matrix
+	c.detector = func() ([]CoreInfo, error) {
+		return []CoreInfo{{CPU: 0}, {CPU: 1}, {CPU: 2}, {CPU: 3}}, nil
+	}
+	c.measurer = func(_ context.Context, cpuA, cpuB, iter int) (float64, error) {
+		measured = append(measured, cpuPair{cpuA, cpuB})
+		return 100, nil
+	}
+
+	require.NoError(t, c.Update(context.Background(), 1, 1, nil))
+
+	assert.Len(t, measured, 6, "only the pairs with CPU 3 are measured")
+	for _, p := range measured {
+		assert.True(t, p.src == 3 || p.dst == 3, "unexpected pair %v", p)
+	}
+
+	snapshot, err := c.GetSnapshot()
+	require.NoError(t, err)
+	assert.Equal(t, SourceImported, snapshot.Meta.Source)
+	assert.Equal(t, StateReady, c.Status().State)
+	lat, _ := snapshot.Matrix().Get(0, 1)
+	assert.Equal(t, 21.5, lat)
+	lat, _ = snapshot.Matrix().Get(3, 0)
+	assert.Equal(t, 100.0, lat)
+}
+
+func TestUpdate_ImportedMatrix_Unreadable(t *testing.T) {
+	var calls int
+	c := New(WithImportedMatrix(filepath.Join(t.TempDir(), "missing.json"))).(*CPUInfo)
+	c.distances = nil
+	c.detector = func() ([]CoreInfo, error) { return []CoreInfo{{CPU: 0}, {CPU: 1}}, nil }
+	c.measurer = func(_ context.Context, cpuA, cpuB, iter int) (float64, error) {
+		calls++
+		return 10, nil
+	}
+
+	require.NoError(t, c.Update(context.Background(), 1, 1, nil))
+	assert.Equal(t, 2, calls, "falls back to measuring everything")
+	assert.Equal(t, SourceMeasured, c.Status().Source)
+}
@@ -0,0 +1,135 @@
+package cpuinfo
+
+import "fmt"
+
+// Nominal latencies used for the topology-only fallback ranking. They are
+// no measurements, only their order matters: SMT siblings are closest,
+// then cores of the same socket, then remote sockets.
+const (
+	fallbackLatencySMT    = 10.0
+	fallbackLatencySocket = 50.0
+	fallbackLatencyRemote = 150.0
+)
+
+// Sources of the current ranking.
+const (
+	SourceTopology = "topology" // estimated from the topology, no measurement yet
+	SourceMeasured = "measured" // measured core-to-core latency
+	SourceImported = "imported" // read from PCA_LATENCY_MATRIX_FILE
+	SourceDerived  = "derived"  // derived from the topology on a virtualized host, nothing is measured
+)
+
+// isEstimate reports whether a ranking source is no real latency data (or no ranking at all).
+func isEstimate(source string) bool {
+	return source == "" || source == SourceTopology
+}
+
+// isNominal reports whether a ranking source uses the nominal latencies of
+// estimateMatrix, the final SourceDerived ranking included.
+func isNominal(source string) bool {
+	return isEstimate(source) || source == SourceDerived
+}
+
+// States of the ranking reported by Status.
+const (
+	StateWarmingUp = "warming-up"
+	StateReady     = "ready"
+)
+
+// RankingStatus reports where the current ranking comes from and the
+// progress of the measurement that is running.
+type RankingStatus struct {
+	State    string  `json:"state"`
+	Source   string  `json:"source,omitempty"`
+	Progress float64 `json:"progress"`          // 0.0 - 1.0
+	Measured int     `json:"measured"`          // measured pairs in all rounds
+	Total    int     `json:"total"`             // pairs in all rounds
+	Waiting  bool    `json:"waiting,omitempty"` // postponed, the host is busy
+	Running  bool    `json:"running,omitempty"` // a calculation is running (also after warm-up)
+	Round    int     `json:"round,omitempty"`   // round of the running calculation
+	Rounds   int     `json:"rounds,omitempty"`  // rounds of the running calculation
+	Error    string  `json:"error,omitempty"`   // the last calculation failed, the ranking may be stale
+
+	Virtualized bool `json:"virtualized"` // the host is a virtual machine
+}
+
+// estimateMatrix returns a latency matrix with nominal latencies derived
+// from the topology only.
+func estimateMatrix(topology []CoreInfo) LatencyMatrix {
+	matrix := make(LatencyMatrix, len(topology))
+	for _, src := range topology {
+		for _, dst := range topology {
+			if src.CPU == dst.CPU {
+				continue
+			}
+			lat := fallbackLatencyRemote
+			if src.Socket == dst.Socket {
+				lat = fallbackLatencySocket
+				if siblings(src, dst) {
+					lat = fallbackLatencySMT
+				}
+			}
+			matrix.Set(src.CPU, dst.CPU, lat)
+		}
+	}
+	return matrix
+}
+
+// UseTopologyFallback installs a ranking that is estimated from the
+// topology only, so CPUs can be selected before the first measurement has
+// finished. It does nothing if a measured ranking is already available.
+// Selections made from the fallback are reported by TakeProvisional once
+// the measured ranking replaces it.
+func (c *CPUInfo) UseTopologyFallback() error {
+	topology, err := c.detector()
+	if err != nil {
+		return fmt.Errorf("error detecting topology: %w", err)
+	}
+
+	if !isEstimate(c.load().Meta.Source) {
+		return nil
+	}
+
+	c.store(topology, estimateMatrix(topology), nil, false, RankingMeta{Source: SourceTopology})
+	return nil
+}
+
+// Status returns the state of the ranking and the progress of the running measurement.
+func (c *CPUInfo) Status() RankingStatus {
+	source := c.load().Meta.Source
+
+	status := RankingStatus{
+		State:    StateWarmingUp,
+		Source:   source,
+		Measured: int(c.measured.Load()),
+		Total:    int(c.total.Load()),
+		Waiting:  c.waiting.Load(),
+		Running:  c.running.Load(),
+		Round:    int(c.round.Load()),
+		Rounds:   int(c.rounds.Load()),
+
+		Virtualized: c.virtualized,
+	}
+	if failure := c.failure.Load(); failure != nil {
+		status.Error = *failure
+	}
+	if !isEstimate(source) {
+		status.State = StateReady
+	}
+	if status.Total > 0 {
+		status.Progress = float64(status.Measured) / float64(status.Total)
+	}
+	return status
+}
+
+// TakeProvisional returns the VMIDs whose CPUs were selected from the
+// topology fallback and have been dropped when the measured ranking
+// replaced it. The list is cleared, the caller is expected to re-place
+// these VMs.
+func (c *CPUInfo) TakeProvisional() []int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	vmids := c.replaced
+	c.replaced = nil
+	return vmids
+}
@@ -0,0 +1,105 @@
+package cpuinfo
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func fallbackTopology() ([]CoreInfo, error) {
+	// 2 sockets, 2 cores per socket, 2 threads per core
+	return []CoreInfo{
+		{CPU: 0, Socket: 0, Core: 0},
+		{CPU: 1, Socket: 0, Core: 1},
+		{CPU: 2, Socket: 1, Core: 0},
+		{CPU: 3, Socket: 1, Core: 1},
+		{CPU: 4, Socket: 0, Core: 0},
+		{CPU: 5, Socket: 0, Core: 1},
+		{CPU: 6, Socket: 1, Core: 0},
+		{CPU: 7, Socket: 1, Core: 1},
+	}, nil
+}
+
+func TestEstimateMatrix(t *testing.T) {
+	topology, _ := fallbackTopology()
+	m := estimateMatrix(topology)
+
+	lat, _ := m.Get(0, 4)
+	assert.Equal(t, fallbackLatencySMT, lat)
+	lat, _ = m.Get(0, 1)
+	assert.Equal(t, fallbackLatencySocket, lat)
+	lat, _ = m.Get(0, 2)
+	assert.Equal(t, fallbackLatencyRemote, lat)
+	_, ok := m.Get(0, 0)
+	assert.False(t, ok)
+}
+
+func TestUseTopologyFallback(t *testing.T) {
+	c := New().(*CPUInfo)
+	c.detector = fallbackTopology
+	c.distances = nil
+	c.measurer = func(_ context.Context, cpuA, cpuB, iter int) (float64, error) {
+		return 10.0, nil
+	}
+
+	assert.Equal(t, StateWarmingUp, c.Status().State)
+
+	assert.NoError(t, c.UseTopologyFallback())
+	status := c.Status()
+	assert.Equal(t, StateWarmingUp, status.State)
+	assert.Equal(t, SourceTopology, status.Source)
+
+	rankings, err := c.GetCoreRanking()
+	assert.NoError(t, err)
+	assert.Len(t, rankings, 8)
+	// SMT sibling first, same socket next, remote socket last
+	assert.Equal(t, 4, rankings[0].Ranking[0].CPU)
+	assert.Equal(t, 0, rankings[0].Ranking[1].Socket)
+	assert.Equal(t, 1, rankings[0].Ranking[len(rankings[0].Ranking)-1].Socket)
+
+	_, err = c.SelectCPUs(100, 2)
+	assert.NoError(t, err)
+	_, err = c.SelectCPUs(101, 2)
+	assert.NoError(t, err)
+	assert.Empty(t, c.TakeProvisional(), "nothing to re-place before the measurement")
+
+	assert.NoError(t, c.Update(context.Background(), 2, 1, nil))
+	status = c.Status()
+	assert.Equal(t, StateReady, status.State)
+	assert.Equal(t, SourceMeasured, status.Source)
+	assert.Equal(t, 8*7*2, status.Total)
+	assert.Equal(t, status.Total, status.Measured)
+	assert.Equal(t, 1.0, status.Progress)
+
+	assert.Equal(t, []int{100, 101}, c.TakeProvisional())
+	assert.Empty(t, c.TakeProvisional(), "list is cleared")
+	assert.Empty(t, c.GetSelections())
+
+	// Selections from the measured ranking are not provisional
+	_, err = c.SelectCPUs(102, 2)
+	assert.NoError(t, err)
+	assert.NoError(t, c.Update(context.Background(), 1, 1, nil))
+	assert.Empty(t, c.TakeProvisional())
+
+	// The fallback never replaces a measured ranking
+	assert.NoError(t, c.UseTopologyFallback())
+	assert.Equal(t, SourceMeasured, c.Status().Source)
+}
+
+func TestUpdateIncremental_AfterFallback(t *testing.T) {
+	c := New().(*CPUInfo)
+	c.detector = fallbackTopology
+	c.distances = nil
+	var calls int
+	c.measurer = func(_ context.Context, cpuA, cpuB, iter int) (float64, error) {
+		calls++
+		return 10.0, nil
+	}
+
+	assert.NoError(t, c.UseTopologyFallback())
+	// The estimated matrix is not reused, all pairs are measured
+	assert.NoError(t, c.UpdateIncremental(context.Background(), 1, 1, nil))
+	assert.Equal(t, 8*7, calls)
+	assert.Equal(t, SourceMeasured, c.Status().Source)
+}
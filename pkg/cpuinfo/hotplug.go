@@ -1,13 +1,18 @@
 package cpuinfo
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
-	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/egandro/proxmox-cpu-affinity/pkg/config"
+	"github.com/egandro/proxmox-cpu-affinity/pkg/events"
+	"github.com/egandro/proxmox-cpu-affinity/pkg/metrics"
 	"golang.org/x/sys/unix"
 )
 
@@ -15,15 +20,19 @@ const (
 	// Buffers prevent blocking if bursts occur
 	EventBufferSize = 100
 	JobBufferSize   = 10
-)
 
-var cpuNameRegexp = regexp.MustCompile(`cpu[0-9]+`)
+	// NetlinkReadTimeout bounds a blocking read of the netlink socket, so the
+	// reader notices a stop request.
+	NetlinkReadTimeout = time.Second
+)
 
 type CPUAction int
 
 const (
 	ActionAdd CPUAction = iota
 	ActionRemove
+	ActionOnline
+	ActionOffline
 )
 
 func (a CPUAction) String() string {
@@ -32,6 +41,10 @@ func (a CPUAction) String() string {
 		return "add"
 	case ActionRemove:
 		return "remove"
+	case ActionOnline:
+		return "online"
+	case ActionOffline:
+		return "offline"
 	}
 	return "unknown"
 }
@@ -45,39 +58,152 @@ type CPUEvent struct {
 type HotplugController interface {
 	StartWatchdog() error
 	StopWatchdog() error
+	// SetMeasurement sets the rounds and iterations of the next recalculation.
+	SetMeasurement(rounds, iterations int)
+}
+
+// AffinityApplier re-applies the CPU affinity of a running VM.
+// It is implemented by scheduler.Scheduler.
+type AffinityApplier interface {
+	UpdateAffinity(ctx context.Context, vmid int) (interface{}, error)
 }
 
 // Hotplug holds the CPUInfo provider and manages the watchdog.
 type Hotplug struct {
 	cpuInfo   Provider
 	cfg       *config.Config
+	applier   AffinityApplier
 	netlinkFD int
-	reactor   *hotplugReactor
-	logger    *slog.Logger
+	// netlinkStop asks the reader to exit, netlinkDone is closed once it has
+	netlinkStop chan struct{}
+	netlinkDone chan struct{}
+	reactor     *hotplugReactor
+	logger      *slog.Logger
+	// measurement parameters, initialized from cfg, see SetMeasurement
+	mu         sync.Mutex
+	rounds     int
+	iterations int
 }
 
 // NewHotplug creates a new Hotplug instance.
-func NewHotplug(cpuInfo Provider, cfg *config.Config) HotplugController {
+// applier is optional, if set VMs that lost CPUs are re-placed after a recalculation.
+func NewHotplug(cpuInfo Provider, cfg *config.Config, applier AffinityApplier) HotplugController {
 	return &Hotplug{
-		cpuInfo: cpuInfo,
-		cfg:     cfg,
-		logger:  slog.Default(),
+		cpuInfo:    cpuInfo,
+		cfg:        cfg,
+		applier:    applier,
+		logger:     slog.Default(),
+		rounds:     cfg.Rounds,
+		iterations: cfg.Iterations,
 	}
 }
 
+// SetMeasurement sets the rounds and iterations of the next recalculation.
+func (h *Hotplug) SetMeasurement(rounds, iterations int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.rounds = rounds
+	h.iterations = iterations
+}
+
 // Start starts the hotplug watchdog.
 func (h *Hotplug) StartWatchdog() error {
 	h.logger.Info("[cpu-hotplug] Starting watchdog")
 
-	h.reactor = newHotplugReactor(config.ConstantCPUHotplugBatchWindow, func(batch []string) {
-		h.logger.Info("[cpu-hotplug] Event detected - recalculating ranking", "batch_size", len(batch))
-		if err := h.cpuInfo.CalculateRanking(h.cfg.Rounds, h.cfg.Iterations, config.ConstantMaxCalculationRankingDuration); err != nil {
-			h.logger.Error("[cpu-hotplug] Failed to recalculate ranking after hotplug", "error", err)
-		}
-	}, h.logger)
+	h.reactor = newHotplugReactor(config.ConstantCPUHotplugBatchWindow, h.handleBatch, h.logger)
 	h.reactor.start()
 
-	return h.startNetlink()
+	if err := h.startNetlink(); err != nil {
+		return err
+	}
+
+	// Events that happened while the watchdog was not running are lost,
+	// compare the ranking against sysfs once the listener is up.
+	h.reconcile()
+	return nil
+}
+
+// reconcile compares the CPUs of the current ranking with the CPUs that are
+// online in sysfs and feeds the difference into the reactor.
+func (h *Hotplug) reconcile() {
+	topology, err := h.cpuInfo.DetectTopology()
+	if err != nil {
+		h.logger.Warn("[cpu-hotplug] Failed to detect topology for reconciliation", "error", err)
+		return
+	}
+	rankings, err := h.cpuInfo.GetCoreRanking()
+	if err != nil {
+		// Nothing calculated yet, the initial calculation will see the current state
+		return
+	}
+
+	ranked := make(map[int]struct{}, len(rankings))
+	for _, r := range rankings {
+		ranked[r.CPU] = struct{}{}
+	}
+	online := make(map[int]struct{}, len(topology))
+	for _, core := range topology {
+		online[core.CPU] = struct{}{}
+		if _, ok := ranked[core.CPU]; !ok {
+			h.logger.Info("[cpu-hotplug] Reconciliation: CPU is online but not ranked", "cpu", core.CPU)
+			h.reactor.ingest(CPUEvent{CPU: "cpu" + strconv.Itoa(core.CPU), Action: ActionOnline})
+		}
+	}
+	for cpu := range ranked {
+		if _, ok := online[cpu]; !ok {
+			h.logger.Info("[cpu-hotplug] Reconciliation: CPU is ranked but no longer online", "cpu", cpu)
+			h.reactor.ingest(CPUEvent{CPU: "cpu" + strconv.Itoa(cpu), Action: ActionOffline})
+		}
+	}
+}
+
+// handleBatch recalculates the ranking and re-places VMs that lost CPUs.
+// ctx is cancelled if a newer batch supersedes this one or the watchdog is stopped.
+func (h *Hotplug) handleBatch(ctx context.Context, batch []string) {
+	h.logger.Info("[cpu-hotplug] Event detected - recalculating ranking", "batch_size", len(batch))
+	events.Publish(events.Event{Type: events.TypeHotplugBatch, CPUs: batchCPUs(batch)})
+	h.mu.Lock()
+	rounds, iterations := h.rounds, h.iterations
+	h.mu.Unlock()
+	if err := h.cpuInfo.CalculateRankingIncremental(ctx, rounds, iterations, config.ConstantMaxCalculationRankingDuration); err != nil {
+		h.logger.Error("[cpu-hotplug] Failed to recalculate ranking after hotplug", "error", err)
+		return
+	}
+
+	for _, change := range h.cpuInfo.ReplaceLostCPUs() {
+		if len(change.New) == 0 {
+			h.logger.Warn("[cpu-hotplug] No replacement CPUs available, selection dropped", "vmid", change.VMID, "old", change.Old, "lost", change.Lost)
+			events.Publish(events.Event{Type: events.TypeReleased, VMID: change.VMID, CPUs: change.Old})
+			continue
+		}
+		h.logger.Info("[cpu-hotplug] Re-placing VM", "vmid", change.VMID, "old", change.Old, "new", change.New, "lost", change.Lost)
+		if h.applier == nil {
+			continue
+		}
+		if _, err := h.applier.UpdateAffinity(ctx, change.VMID); err != nil {
+			h.logger.Error("[cpu-hotplug] Failed to re-apply affinity", "vmid", change.VMID, "error", err)
+			metrics.Failures.Inc(metrics.ReasonReapply)
+			events.Failure(metrics.ReasonReapply, change.VMID, err)
+		}
+	}
+}
+
+// batchCPUs returns the sorted CPU numbers of a batch of "cpuN" devices.
+func batchCPUs(batch []string) []int {
+	seen := make(map[int]struct{}, len(batch))
+	cpus := make([]int, 0, len(batch))
+	for _, dev := range batch {
+		cpu, err := strconv.Atoi(strings.TrimPrefix(dev, "cpu"))
+		if err != nil {
+			continue
+		}
+		if _, ok := seen[cpu]; !ok {
+			seen[cpu] = struct{}{}
+			cpus = append(cpus, cpu)
+		}
+	}
+	sort.Ints(cpus)
+	return cpus
 }
 
 // Stop the hotplug watchdog.
@@ -96,7 +222,8 @@ func (h *Hotplug) startNetlink() error {
 
 	// AF_NETLINK: The socket family for communicating between kernel and user space.
 	// NETLINK_KOBJECT_UEVENT: The specific protocol for kernel object events (hotplug).
-	// This allows us to receive notifications when hardware (like CPUs) is added or removed.
+	// This allows us to receive notifications when hardware (like CPUs) is added or removed,
+	// and when a CPU is taken online/offline via /sys/devices/system/cpu/cpuN/online.
 	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_KOBJECT_UEVENT)
 	if err != nil {
 		return fmt.Errorf("failed to create netlink socket: %w", err)
@@ -111,58 +238,78 @@ func (h *Hotplug) startNetlink() error {
 		return fmt.Errorf("failed to bind netlink socket: %w", err)
 	}
 
+	// Closing the fd does not wake up a blocked Recvfrom, the reader polls
+	// for a stop request instead and the fd is closed once it returned.
+	tv := unix.NsecToTimeval(NetlinkReadTimeout.Nanoseconds())
+	if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv); err != nil {
+		_ = unix.Close(fd)
+		return fmt.Errorf("failed to set netlink read timeout: %w", err)
+	}
+
 	h.netlinkFD = fd
+	h.netlinkStop = make(chan struct{})
+	h.netlinkDone = make(chan struct{})
 
-	go func() {
+	go func(stop <-chan struct{}, done chan<- struct{}) {
+		defer close(done)
 		buf := make([]byte, 4096)
 		for {
 			n, _, err := unix.Recvfrom(fd, buf, 0)
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if err == unix.EAGAIN || err == unix.EWOULDBLOCK || err == unix.EINTR {
+				continue
+			}
 			if err != nil {
-				h.logger.Debug("[cpu-hotplug] Netlink socket read error (stopping?)", "error", err)
+				h.logger.Error("[cpu-hotplug] Netlink socket read error, listener stopped", "error", err)
 				return
 			}
-			msg := string(buf[:n])
-
-			if strings.Contains(msg, "SUBSYSTEM=cpu") {
-				var action CPUAction
-				if strings.Contains(msg, "ACTION=add") {
-					action = ActionAdd
-				} else if strings.Contains(msg, "ACTION=remove") {
-					action = ActionRemove
-				} else {
-					continue
-				}
-
-				h.reactor.ingest(CPUEvent{CPU: cpuNameRegexp.FindString(msg), Action: action})
+			if evt, ok := parseUevent(buf[:n]).cpuEvent(); ok {
+				h.reactor.ingest(evt)
 			}
 		}
-	}()
+	}(h.netlinkStop, h.netlinkDone)
 
 	return nil
 }
 
+// stopNetlink stops the reader, waits for it to exit and closes the socket.
 func (h *Hotplug) stopNetlink() error {
 	h.logger.Info("[cpu-hotplug] Stopping Netlink listener")
-	if h.netlinkFD != 0 {
-		err := unix.Close(h.netlinkFD)
-		h.netlinkFD = 0
-		return err
+	if h.netlinkFD == 0 {
+		return nil
 	}
-	return nil
+	close(h.netlinkStop)
+	<-h.netlinkDone
+	err := unix.Close(h.netlinkFD)
+	h.netlinkFD = 0
+	h.netlinkStop, h.netlinkDone = nil, nil
+	return err
 }
 
 // hotplugReactor handles the buffering and dispatching of events.
 // It is separated from Hotplug to allow unit testing of the batching logic.
+// A newer batch supersedes (cancels) the job that is currently running, so
+// recalculations never run against an outdated topology.
 type hotplugReactor struct {
 	events   chan CPUEvent
 	jobs     chan []string
 	stopChan chan struct{}
 	window   time.Duration
-	handler  func([]string)
+	handler  func(context.Context, []string)
 	logger   *slog.Logger
+
+	ctx       context.Context
+	cancel    context.CancelFunc
+	jobMu     sync.Mutex
+	cancelJob context.CancelFunc
 }
 
-func newHotplugReactor(window time.Duration, handler func([]string), logger *slog.Logger) *hotplugReactor {
+func newHotplugReactor(window time.Duration, handler func(context.Context, []string), logger *slog.Logger) *hotplugReactor {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &hotplugReactor{
 		events:   make(chan CPUEvent, EventBufferSize),
 		jobs:     make(chan []string, JobBufferSize),
@@ -170,6 +317,8 @@ func newHotplugReactor(window time.Duration, handler func([]string), logger *slo
 		window:   window,
 		handler:  handler,
 		logger:   logger,
+		ctx:      ctx,
+		cancel:   cancel,
 	}
 }
 
@@ -180,9 +329,22 @@ func (r *hotplugReactor) start() {
 
 func (r *hotplugReactor) stop() {
 	close(r.stopChan)
+	r.cancel()
+}
+
+// supersede cancels the job that is currently running, if any.
+func (r *hotplugReactor) supersede() {
+	r.jobMu.Lock()
+	defer r.jobMu.Unlock()
+	if r.cancelJob != nil {
+		r.logger.Info("[hotplug-reactor] New batch supersedes running job, cancelling it")
+		r.cancelJob()
+		r.cancelJob = nil
+	}
 }
 
 func (r *hotplugReactor) ingest(evt CPUEvent) {
+	metrics.HotplugEvents.Inc(evt.Action.String())
 	select {
 	case r.events <- evt:
 	default:
@@ -224,6 +386,7 @@ func (r *hotplugReactor) processBatches() {
 				job := batch
 				batch = nil
 
+				r.supersede()
 				select {
 				case r.jobs <- job:
 					r.logger.Info("[hotplug-reactor] Batch sent to worker", "events", len(job))
@@ -241,7 +404,17 @@ func (r *hotplugReactor) workerLogic() {
 		case <-r.stopChan:
 			return
 		case batch := <-r.jobs:
-			r.handler(batch)
+			ctx, cancel := context.WithCancel(r.ctx)
+			r.jobMu.Lock()
+			r.cancelJob = cancel
+			r.jobMu.Unlock()
+
+			r.handler(ctx, batch)
+
+			r.jobMu.Lock()
+			r.cancelJob = nil
+			r.jobMu.Unlock()
+			cancel()
 		}
 	}
 }
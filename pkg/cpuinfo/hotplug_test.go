@@ -1,18 +1,22 @@
 package cpuinfo
 
 import (
+	"context"
+	"errors"
 	"log/slog"
 	"testing"
 	"time"
 
 	"github.com/egandro/proxmox-cpu-affinity/pkg/config"
+	"github.com/egandro/proxmox-cpu-affinity/pkg/events"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 func TestHotplugReactor_Batching(t *testing.T) {
 	received := make(chan []string, 1)
-	handler := func(batch []string) {
+	handler := func(_ context.Context, batch []string) {
 		received <- batch
 	}
 
@@ -39,7 +43,7 @@ func TestHotplugReactor_Batching(t *testing.T) {
 
 func TestHotplugReactor_Debounce(t *testing.T) {
 	received := make(chan []string, 1)
-	handler := func(batch []string) {
+	handler := func(_ context.Context, batch []string) {
 		received <- batch
 	}
 
@@ -70,7 +74,7 @@ func TestHotplugReactor_Debounce(t *testing.T) {
 }
 
 func TestHotplugReactor_BufferFull(t *testing.T) {
-	reactor := newHotplugReactor(time.Second, func([]string) {}, slog.Default())
+	reactor := newHotplugReactor(time.Second, func(context.Context, []string) {}, slog.Default())
 	// Do NOT start reactor, so channel fills up.
 
 	// Fill buffer
@@ -92,8 +96,13 @@ type MockProvider struct {
 	mock.Mock
 }
 
-func (m *MockProvider) Update(rounds int, iterations int, onProgress func(int, int)) error {
-	args := m.Called(rounds, iterations, onProgress)
+func (m *MockProvider) Update(ctx context.Context, rounds int, iterations int, onProgress func(int, int)) error {
+	args := m.Called(ctx, rounds, iterations, onProgress)
+	return args.Error(0)
+}
+
+func (m *MockProvider) UpdateIncremental(ctx context.Context, rounds int, iterations int, onProgress func(int, int)) error {
+	args := m.Called(ctx, rounds, iterations, onProgress)
 	return args.Error(0)
 }
 
@@ -102,18 +111,65 @@ func (m *MockProvider) GetCoreRanking() ([]CoreRanking, error) {
 	return args.Get(0).([]CoreRanking), args.Error(1)
 }
 
-func (m *MockProvider) SelectCPUs(vmid int, requestedCPUs int) ([]int, error) {
+func (m *MockProvider) SelectCPUs(vmid int, requestedCPUs int) (Selection, error) {
 	args := m.Called(vmid, requestedCPUs)
-	return args.Get(0).([]int), args.Error(1)
+	return args.Get(0).(Selection), args.Error(1)
+}
+
+func (m *MockProvider) GetSelections() map[int]Selection {
+	args := m.Called()
+	return args.Get(0).(map[int]Selection)
+}
+
+func (m *MockProvider) UseTopologyFallback() error {
+	args := m.Called()
+	return args.Error(0)
+}
+
+func (m *MockProvider) Status() RankingStatus {
+	args := m.Called()
+	return args.Get(0).(RankingStatus)
 }
 
-func (m *MockProvider) GetSelections() map[int][]int {
+func (m *MockProvider) TakeProvisional() []int {
 	args := m.Called()
-	return args.Get(0).(map[int][]int)
+	if args.Get(0) == nil {
+		return nil
+	}
+	return args.Get(0).([]int)
+}
+
+func (m *MockProvider) Recalculate(ctx context.Context, rounds, iterations int, timeout time.Duration) (<-chan error, error) {
+	args := m.Called(ctx, rounds, iterations, timeout)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(chan error), args.Error(1)
+}
+
+func (m *MockProvider) GetSnapshot() (*Snapshot, error) {
+	args := m.Called()
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*Snapshot), args.Error(1)
+}
+
+func (m *MockProvider) ReplaceLostCPUs() []SelectionChange {
+	args := m.Called()
+	if args.Get(0) == nil {
+		return nil
+	}
+	return args.Get(0).([]SelectionChange)
 }
 
-func (m *MockProvider) CalculateRanking(rounds, iterations int, timeout time.Duration) error {
-	args := m.Called(rounds, iterations, timeout)
+func (m *MockProvider) CalculateRanking(ctx context.Context, rounds, iterations int, timeout time.Duration) error {
+	args := m.Called(ctx, rounds, iterations, timeout)
+	return args.Error(0)
+}
+
+func (m *MockProvider) CalculateRankingIncremental(ctx context.Context, rounds, iterations int, timeout time.Duration) error {
+	args := m.Called(ctx, rounds, iterations, timeout)
 	return args.Error(0)
 }
 
@@ -125,13 +181,15 @@ func (m *MockProvider) DetectTopology() ([]CoreInfo, error) {
 func TestCPUAction_String(t *testing.T) {
 	assert.Equal(t, "add", ActionAdd.String())
 	assert.Equal(t, "remove", ActionRemove.String())
+	assert.Equal(t, "online", ActionOnline.String())
+	assert.Equal(t, "offline", ActionOffline.String())
 	assert.Equal(t, "unknown", CPUAction(999).String())
 }
 
 func TestNewHotplug(t *testing.T) {
 	mockCPU := new(MockProvider)
 	cfg := &config.Config{}
-	hc := NewHotplug(mockCPU, cfg)
+	hc := NewHotplug(mockCPU, cfg, nil)
 	assert.NotNil(t, hc)
 
 	h, ok := hc.(*Hotplug)
@@ -146,11 +204,15 @@ func TestHotplug_Integration(t *testing.T) {
 	}
 
 	mockCPU := new(MockProvider)
-	// Expect CalculateRanking to be called with config values
-	mockCPU.On("CalculateRanking", 10, 100, config.ConstantMaxCalculationRankingDuration).Return(nil)
+	// Expect the incremental update to be called with config values
+	mockCPU.On("CalculateRankingIncremental", mock.Anything, 10, 100, config.ConstantMaxCalculationRankingDuration).Return(nil)
+	mockCPU.On("ReplaceLostCPUs").Return(nil)
+	// Reconciliation on start finds nothing to do
+	mockCPU.On("DetectTopology").Return([]CoreInfo{{CPU: 0}}, nil).Maybe()
+	mockCPU.On("GetCoreRanking").Return([]CoreRanking{{CPU: 0}}, nil).Maybe()
 
 	cfg := &config.Config{Rounds: 10, Iterations: 100}
-	hc := NewHotplug(mockCPU, cfg)
+	hc := NewHotplug(mockCPU, cfg, nil)
 	h := hc.(*Hotplug)
 
 	// Start Watchdog (ignore error as Netlink might fail on test env)
@@ -165,3 +227,192 @@ func TestHotplug_Integration(t *testing.T) {
 
 	mockCPU.AssertExpectations(t)
 }
+
+func TestHotplug_StopNetlinkWaitsForReader(t *testing.T) {
+	h := NewHotplug(new(MockProvider), &config.Config{}, nil).(*Hotplug)
+	h.reactor = newHotplugReactor(time.Millisecond, func(context.Context, []string) {}, h.logger)
+	if err := h.startNetlink(); err != nil {
+		t.Skipf("netlink not available: %v", err)
+	}
+	done := h.netlinkDone
+
+	start := time.Now()
+	require.NoError(t, h.stopNetlink())
+	assert.Less(t, time.Since(start), NetlinkReadTimeout+time.Second)
+
+	select {
+	case <-done:
+	default:
+		t.Fatal("netlink reader still running after stop")
+	}
+	assert.Zero(t, h.netlinkFD)
+	assert.NoError(t, h.stopNetlink(), "stopping twice is a no-op")
+}
+
+// MockApplier mocks the AffinityApplier interface.
+type MockApplier struct {
+	mock.Mock
+}
+
+func (m *MockApplier) UpdateAffinity(ctx context.Context, vmid int) (interface{}, error) {
+	args := m.Called(ctx, vmid)
+	return args.Get(0), args.Error(1)
+}
+
+func TestHotplug_HandleBatch_ReplacesLostCPUs(t *testing.T) {
+	mockCPU := new(MockProvider)
+	mockCPU.On("CalculateRankingIncremental", mock.Anything, 1, 1, config.ConstantMaxCalculationRankingDuration).Return(nil)
+	mockCPU.On("ReplaceLostCPUs").Return([]SelectionChange{
+		{VMID: 100, Old: []int{2, 3}, New: []int{2, 1}, Lost: []int{3}},
+		{VMID: 101, Old: []int{3}, New: []int{0}, Lost: []int{3}},
+		{VMID: 102, Old: []int{0, 1, 2, 3}, Lost: []int{3}},
+	})
+
+	applier := new(MockApplier)
+	applier.On("UpdateAffinity", mock.Anything, 100).Return(nil, nil)
+	applier.On("UpdateAffinity", mock.Anything, 101).Return(nil, errors.New("VM 101 is not running"))
+
+	ch, unsubscribe := events.Default.Subscribe()
+	defer unsubscribe()
+
+	h := NewHotplug(mockCPU, &config.Config{Rounds: 1, Iterations: 1}, applier).(*Hotplug)
+	h.handleBatch(context.Background(), []string{"cpu3"})
+
+	mockCPU.AssertExpectations(t)
+	applier.AssertExpectations(t)
+	// Dropped selections are not re-applied
+	applier.AssertNotCalled(t, "UpdateAffinity", mock.Anything, 102)
+
+	batch := <-ch
+	assert.Equal(t, events.TypeHotplugBatch, batch.Type)
+	assert.Equal(t, []int{3}, batch.CPUs)
+	failure := <-ch
+	assert.Equal(t, events.TypeError, failure.Type)
+	assert.Equal(t, 101, failure.VMID)
+	released := <-ch
+	assert.Equal(t, events.TypeReleased, released.Type)
+	assert.Equal(t, 102, released.VMID)
+}
+
+func TestHotplug_SetMeasurement(t *testing.T) {
+	mockCPU := new(MockProvider)
+	mockCPU.On("CalculateRankingIncremental", mock.Anything, 4, 2000, config.ConstantMaxCalculationRankingDuration).Return(errors.New("boom"))
+
+	h := NewHotplug(mockCPU, &config.Config{Rounds: 1, Iterations: 1}, nil).(*Hotplug)
+	h.SetMeasurement(4, 2000)
+	h.handleBatch(context.Background(), []string{"cpu3"})
+
+	mockCPU.AssertExpectations(t)
+}
+
+func TestBatchCPUs(t *testing.T) {
+	assert.Equal(t, []int{1, 3, 12}, batchCPUs([]string{"cpu12", "cpu3", "cpu1", "cpu3", "bogus"}))
+	assert.Empty(t, batchCPUs(nil))
+}
+
+func TestHotplug_HandleBatch_CalculationError(t *testing.T) {
+	mockCPU := new(MockProvider)
+	mockCPU.On("CalculateRankingIncremental", mock.Anything, 1, 1, config.ConstantMaxCalculationRankingDuration).Return(errors.New("boom"))
+
+	h := NewHotplug(mockCPU, &config.Config{Rounds: 1, Iterations: 1}, nil).(*Hotplug)
+	h.handleBatch(context.Background(), []string{"cpu3"})
+
+	mockCPU.AssertExpectations(t)
+	mockCPU.AssertNotCalled(t, "ReplaceLostCPUs")
+}
+
+func TestHotplug_Reconcile(t *testing.T) {
+	mockCPU := new(MockProvider)
+	// CPU 2 was taken offline and CPU 3 came online while the watchdog was down
+	mockCPU.On("DetectTopology").Return([]CoreInfo{{CPU: 0}, {CPU: 1}, {CPU: 3}}, nil)
+	mockCPU.On("GetCoreRanking").Return([]CoreRanking{{CPU: 0}, {CPU: 1}, {CPU: 2}}, nil)
+
+	h := NewHotplug(mockCPU, &config.Config{}, nil).(*Hotplug)
+	h.reactor = newHotplugReactor(time.Second, func(context.Context, []string) {}, slog.Default())
+
+	h.reconcile()
+
+	assert.Len(t, h.reactor.events, 2)
+	events := map[string]CPUAction{}
+	for len(h.reactor.events) > 0 {
+		evt := <-h.reactor.events
+		events[evt.CPU] = evt.Action
+	}
+	assert.Equal(t, map[string]CPUAction{"cpu3": ActionOnline, "cpu2": ActionOffline}, events)
+}
+
+func TestHotplug_Reconcile_InSync(t *testing.T) {
+	mockCPU := new(MockProvider)
+	mockCPU.On("DetectTopology").Return([]CoreInfo{{CPU: 0}, {CPU: 1}}, nil)
+	mockCPU.On("GetCoreRanking").Return([]CoreRanking{{CPU: 1}, {CPU: 0}}, nil)
+
+	h := NewHotplug(mockCPU, &config.Config{}, nil).(*Hotplug)
+	h.reactor = newHotplugReactor(time.Second, func(context.Context, []string) {}, slog.Default())
+
+	h.reconcile()
+
+	assert.Empty(t, h.reactor.events)
+}
+
+func TestHotplugReactor_Supersede(t *testing.T) {
+	started := make(chan struct{}, 2)
+	results := make(chan error, 2)
+	handler := func(ctx context.Context, batch []string) {
+		started <- struct{}{}
+		if batch[0] == "cpu1" {
+			// First job blocks until it is superseded
+			<-ctx.Done()
+			results <- ctx.Err()
+			return
+		}
+		results <- nil
+	}
+
+	reactor := newHotplugReactor(20*time.Millisecond, handler, slog.Default())
+	reactor.start()
+	defer reactor.stop()
+
+	reactor.ingest(CPUEvent{CPU: "cpu1", Action: ActionOffline})
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("first job did not start")
+	}
+
+	reactor.ingest(CPUEvent{CPU: "cpu2", Action: ActionOffline})
+
+	select {
+	case err := <-results:
+		assert.ErrorIs(t, err, context.Canceled, "first job is cancelled")
+	case <-time.After(time.Second):
+		t.Fatal("first job was not superseded")
+	}
+	select {
+	case err := <-results:
+		assert.NoError(t, err, "second job runs to completion")
+	case <-time.After(time.Second):
+		t.Fatal("second job did not run")
+	}
+}
+
+func TestHotplugReactor_StopCancelsJob(t *testing.T) {
+	started := make(chan struct{})
+	done := make(chan error, 1)
+	reactor := newHotplugReactor(10*time.Millisecond, func(ctx context.Context, _ []string) {
+		close(started)
+		<-ctx.Done()
+		done <- ctx.Err()
+	}, slog.Default())
+	reactor.start()
+
+	reactor.ingest(CPUEvent{CPU: "cpu1", Action: ActionAdd})
+	<-started
+	reactor.stop()
+
+	select {
+	case err := <-done:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(time.Second):
+		t.Fatal("stop did not cancel the running job")
+	}
+}
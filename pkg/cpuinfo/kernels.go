@@ -0,0 +1,305 @@
+package cpuinfo
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Measurement kernels
+const (
+	// KernelPingPong measures the round trip of a cache line (atomic store/load ping-pong).
+	KernelPingPong = "ping-pong"
+	// KernelCAS measures compare-and-swap operations on a contended cache line.
+	KernelCAS = "cas"
+	// KernelStream measures the one-way transfer of streamLines cache lines.
+	KernelStream = "stream"
+)
+
+// streamLines is the number of cache lines transferred per KernelStream iteration.
+const streamLines = 16
+
+// cacheLine is an atomic value padded to a cache line.
+type cacheLine struct {
+	v atomic.Uint64
+	_ [56]byte
+}
+
+// KernelWeight selects a measurement kernel and its weight in the blended matrix.
+type KernelWeight struct {
+	Kernel string  `json:"kernel"`
+	Weight float64 `json:"weight"`
+}
+
+// kernelMatrices holds one latency matrix per kernel.
+type kernelMatrices map[string]LatencyMatrix
+
+// defaultKernels measures the ping-pong round trip only.
+var defaultKernels = []KernelWeight{{Kernel: KernelPingPong, Weight: 1}}
+
+// kernelNames lists the available kernels.
+var kernelNames = []string{KernelPingPong, KernelCAS, KernelStream}
+
+// WithKernels measures every pair with the given kernels, the ranking is
+// built from the weighted blend of their matrices (see blendMatrices).
+func WithKernels(kernels []KernelWeight) Option {
+	return func(c *CPUInfo) {
+		c.kernels = kernels
+	}
+}
+
+// ParseKernelWeights parses a list like "ping-pong=1,cas=0.5". A kernel
+// without weight gets the weight 1.
+func ParseKernelWeights(s string) ([]KernelWeight, error) {
+	var res []KernelWeight
+	var sum float64
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, weightStr, hasWeight := strings.Cut(part, "=")
+		name = strings.TrimSpace(name)
+		if !slices.Contains(kernelNames, name) {
+			return nil, fmt.Errorf("unknown kernel %q, expected %s, %s or %s", name, KernelPingPong, KernelCAS, KernelStream)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate kernel %q", name)
+		}
+		seen[name] = true
+
+		weight := 1.0
+		if hasWeight {
+			var err error
+			weight, err = strconv.ParseFloat(strings.TrimSpace(weightStr), 64)
+			if err != nil || !(weight >= 0) { // also rejects NaN
+				return nil, fmt.Errorf("invalid weight %q for kernel %s", weightStr, name)
+			}
+		}
+		sum += weight
+		res = append(res, KernelWeight{Kernel: name, Weight: weight})
+	}
+	if len(res) == 0 {
+		return nil, fmt.Errorf("no kernel selected")
+	}
+	if sum == 0 {
+		return nil, fmt.Errorf("at least one kernel needs a weight > 0")
+	}
+	return res, nil
+}
+
+// kernelSet returns the configured kernels.
+func (c *CPUInfo) kernelSet() []KernelWeight {
+	if len(c.kernels) == 0 {
+		return defaultKernels
+	}
+	return c.kernels
+}
+
+// measurerFor returns the measurer of kernel, KernelPingPong uses c.measurer.
+func (c *CPUInfo) measurerFor(kernel string) latencyMeasurer {
+	if kernel == KernelPingPong {
+		return c.measurer
+	}
+	return c.kernelMeasurers[kernel]
+}
+
+// newKernelMatrices returns an empty matrix for every kernel.
+func newKernelMatrices(kernels []KernelWeight, size int) kernelMatrices {
+	res := make(kernelMatrices, len(kernels))
+	for _, k := range kernels {
+		res[k.Kernel] = make(LatencyMatrix, size)
+	}
+	return res
+}
+
+// sameKernels reports whether matrices holds exactly the given kernels.
+func (m kernelMatrices) sameKernels(kernels []KernelWeight) bool {
+	if len(m) != len(kernels) {
+		return false
+	}
+	for _, k := range kernels {
+		if _, ok := m[k.Kernel]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// blendMatrices combines the kernel matrices into the matrix the ranking is
+// built from. The kernels measure different things, so every matrix is
+// divided by its median before the weighted average is taken. The result is
+// scaled by the median of the first kernel, so it stays in nanoseconds of
+// that kernel. A single kernel is returned unchanged.
+func blendMatrices(matrices kernelMatrices, kernels []KernelWeight) LatencyMatrix {
+	if len(kernels) == 1 {
+		return matrices[kernels[0].Kernel]
+	}
+
+	medians := make(map[string]float64, len(kernels))
+	var weightSum float64
+	for _, k := range kernels {
+		var values []float64
+		for _, row := range matrices[k.Kernel] {
+			for _, lat := range row {
+				values = append(values, lat)
+			}
+		}
+		medians[k.Kernel] = median(values)
+		weightSum += k.Weight
+	}
+	scale := medians[kernels[0].Kernel]
+
+	primary := matrices[kernels[0].Kernel]
+	res := make(LatencyMatrix, len(primary))
+	for src, row := range primary {
+		for dst := range row {
+			var blended float64
+			for _, k := range kernels {
+				lat, ok := matrices[k.Kernel].Get(src, dst)
+				if !ok || medians[k.Kernel] == 0 {
+					continue
+				}
+				blended += k.Weight * lat / medians[k.Kernel]
+			}
+			res.Set(src, dst, scale*blended/weightSum)
+		}
+	}
+	return res
+}
+
+// runPinned runs a on cpuA and b on cpuB and returns how long they took.
+// Both functions start once both goroutines are locked to their CPU. They
+// busy-wait, so they must return as soon as stop is set, which happens when
+// ctx is done.
+func runPinned(ctx context.Context, cpuA, cpuB int, a, b func(stop *atomic.Bool)) (time.Duration, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	var stop atomic.Bool
+	stopWatch := context.AfterFunc(ctx, func() {
+		stop.Store(true)
+	})
+	defer stopWatch()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	var barrier sync.WaitGroup
+	barrier.Add(2)
+
+	var errMutex sync.Mutex
+	var firstErr error
+
+	run := func(cpu int, fn func(stop *atomic.Bool)) {
+		defer wg.Done()
+		if err := lockToCPU(cpu); err != nil {
+			errMutex.Lock()
+			if firstErr == nil {
+				firstErr = err
+			}
+			errMutex.Unlock()
+		}
+		barrier.Done()
+		barrier.Wait()
+
+		errMutex.Lock()
+		failed := firstErr != nil
+		errMutex.Unlock()
+		if failed {
+			return
+		}
+		fn(&stop)
+	}
+
+	start := time.Now()
+	go run(cpuA, a)
+	go run(cpuB, b)
+	wg.Wait()
+	duration := time.Since(start)
+
+	if firstErr != nil {
+		return 0, firstErr
+	}
+	if stop.Load() {
+		return 0, ctx.Err()
+	}
+	return duration, nil
+}
+
+// measureCAS measures the cost of a compare-and-swap on a cache line that
+// both CPUs increment concurrently. It returns the time per successful CAS.
+func measureCAS(ctx context.Context, cpuA, cpuB, iter int) (float64, error) {
+	var counter cacheLine
+	target := uint64(iter) * 2 // #nosec G115 -- iter is positive
+
+	increment := func(stop *atomic.Bool) {
+		for done := 0; done < iter; {
+			if stop.Load() {
+				return
+			}
+			v := counter.v.Load()
+			if v >= target {
+				return
+			}
+			if counter.v.CompareAndSwap(v, v+1) {
+				done++
+			}
+		}
+	}
+
+	duration, err := runPinned(ctx, cpuA, cpuB, increment, increment)
+	if err != nil {
+		return 0, err
+	}
+	return float64(duration.Nanoseconds()) / float64(iter*2), nil
+}
+
+// measureStream measures the one-way transfer of streamLines cache lines:
+// cpuA writes all lines and publishes a sequence number, cpuB reads them
+// and acknowledges. It returns the time per transferred cache line.
+func measureStream(ctx context.Context, cpuA, cpuB, iter int) (float64, error) {
+	var lines [streamLines]cacheLine
+	var seq, ack cacheLine
+
+	writer := func(stop *atomic.Bool) {
+		for k := uint64(1); k <= uint64(iter); k++ { // #nosec G115 -- iter is positive
+			for ack.v.Load() != k-1 {
+				if stop.Load() {
+					return
+				}
+			}
+			for i := range lines {
+				lines[i].v.Store(k)
+			}
+			seq.v.Store(k)
+		}
+	}
+	reader := func(stop *atomic.Bool) {
+		var sum uint64
+		for k := uint64(1); k <= uint64(iter); k++ { // #nosec G115 -- iter is positive
+			for seq.v.Load() != k {
+				if stop.Load() {
+					return
+				}
+			}
+			for i := range lines {
+				sum += lines[i].v.Load()
+			}
+			ack.v.Store(k)
+		}
+		_ = sum
+	}
+
+	duration, err := runPinned(ctx, cpuA, cpuB, writer, reader)
+	if err != nil {
+		return 0, err
+	}
+	return float64(duration.Nanoseconds()) / float64(iter*streamLines), nil
+}
@@ -0,0 +1,169 @@
+package cpuinfo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseKernelWeights(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected []KernelWeight
+		wantErr  bool
+	}{
+		{"Default", "ping-pong", []KernelWeight{{KernelPingPong, 1}}, false},
+		{"Weights", "ping-pong=1, cas=0.5,stream=0", []KernelWeight{{KernelPingPong, 1}, {KernelCAS, 0.5}, {KernelStream, 0}}, false},
+		{"First kernel without weight", "cas,ping-pong=2", []KernelWeight{{KernelCAS, 1}, {KernelPingPong, 2}}, false},
+		{"Unknown kernel", "ping-pong,foo", nil, true},
+		{"Duplicate kernel", "cas,cas=2", nil, true},
+		{"Negative weight", "cas=-1", nil, true},
+		{"Invalid weight", "cas=abc", nil, true},
+		{"All weights zero", "cas=0,stream=0", nil, true},
+		{"Empty", " , ", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := ParseKernelWeights(tt.input)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, res)
+		})
+	}
+}
+
+func TestBlendMatrices(t *testing.T) {
+	pingPong := LatencyMatrix{}
+	pingPong.Set(0, 1, 10)
+	pingPong.Set(0, 2, 30)
+	pingPong.Set(1, 2, 20)
+	cas := LatencyMatrix{}
+	cas.Set(0, 1, 100)
+	cas.Set(0, 2, 20)
+	cas.Set(1, 2, 40)
+
+	// A single kernel is used unchanged
+	single := blendMatrices(kernelMatrices{KernelPingPong: pingPong}, []KernelWeight{{KernelPingPong, 1}})
+	assert.Equal(t, pingPong, single)
+
+	// Medians: ping-pong 20, cas 40. The blend is scaled to the ping-pong median.
+	blended := blendMatrices(kernelMatrices{KernelPingPong: pingPong, KernelCAS: cas},
+		[]KernelWeight{{KernelPingPong, 1}, {KernelCAS, 3}})
+	lat, _ := blended.Get(0, 1)
+	assert.InDelta(t, 20*(1*10.0/20+3*100.0/40)/4, lat, 1e-9)
+	lat, _ = blended.Get(0, 2)
+	assert.InDelta(t, 20*(1*30.0/20+3*20.0/40)/4, lat, 1e-9)
+	lat, _ = blended.Get(1, 2)
+	assert.InDelta(t, 20.0, lat, 1e-9)
+
+	// A kernel with weight 0 doesn't change the result
+	blended = blendMatrices(kernelMatrices{KernelPingPong: pingPong, KernelCAS: cas},
+		[]KernelWeight{{KernelPingPong, 1}, {KernelCAS, 0}})
+	lat, _ = blended.Get(0, 2)
+	assert.InDelta(t, 30.0, lat, 1e-9)
+}
+
+func newKernelTestCPUInfo(kernels []KernelWeight) *CPUInfo {
+	c := New(WithKernels(kernels)).(*CPUInfo)
+	c.distances = nil
+	c.loadReader = nil
+	c.detector = func() ([]CoreInfo, error) {
+		return []CoreInfo{{CPU: 0}, {CPU: 1, Core: 1}, {CPU: 2, Core: 2}}, nil
+	}
+	// ping-pong prefers CPU 1, cas CPU 2
+	c.measurer = func(_ context.Context, cpuA, cpuB, iter int) (float64, error) {
+		if cpuA+cpuB == 1 {
+			return 10, nil
+		}
+		return 30, nil
+	}
+	c.kernelMeasurers[KernelCAS] = func(_ context.Context, cpuA, cpuB, iter int) (float64, error) {
+		if cpuA+cpuB == 2 {
+			return 20, nil
+		}
+		return 100, nil
+	}
+	return c
+}
+
+func TestUpdate_Kernels(t *testing.T) {
+	c := newKernelTestCPUInfo([]KernelWeight{{KernelPingPong, 1}, {KernelCAS, 3}})
+	require.NoError(t, c.Update(context.Background(), 2, 1, nil))
+
+	snapshot, err := c.GetSnapshot()
+	require.NoError(t, err)
+	assert.Equal(t, []KernelWeight{{KernelPingPong, 1}, {KernelCAS, 3}}, snapshot.Meta.Kernels)
+	assert.Equal(t, 6*2*2, c.Status().Total)
+
+	pingPong, ok := snapshot.KernelMatrix(KernelPingPong)
+	require.True(t, ok)
+	lat, _ := pingPong.Get(0, 1)
+	assert.Equal(t, 10.0, lat)
+	cas, ok := snapshot.KernelMatrix(KernelCAS)
+	require.True(t, ok)
+	lat, _ = cas.Get(0, 2)
+	assert.Equal(t, 20.0, lat)
+	_, ok = snapshot.KernelMatrix(KernelStream)
+	assert.False(t, ok)
+
+	// The CAS weight dominates the blend
+	assert.Equal(t, 2, snapshot.Rankings[0].Ranking[0].CPU)
+}
+
+func TestUpdateIncremental_KernelsChanged(t *testing.T) {
+	c := newKernelTestCPUInfo(nil)
+	require.NoError(t, c.Update(context.Background(), 1, 1, nil))
+	snapshot, _ := c.GetSnapshot()
+	assert.Equal(t, 1, snapshot.Rankings[0].Ranking[0].CPU)
+
+	// Other kernels can't reuse the previous matrices
+	c.kernels = []KernelWeight{{KernelPingPong, 1}, {KernelCAS, 3}}
+	require.NoError(t, c.UpdateIncremental(context.Background(), 1, 1, nil))
+
+	snapshot, _ = c.GetSnapshot()
+	assert.False(t, snapshot.Meta.Incremental)
+	assert.Len(t, snapshot.Meta.Kernels, 2)
+	assert.Equal(t, 2, snapshot.Rankings[0].Ranking[0].CPU)
+}
+
+func TestMeasureKernels(t *testing.T) {
+	for name, measurer := range map[string]latencyMeasurer{
+		KernelPingPong: measureSingleLink,
+		KernelCAS:      measureCAS,
+		KernelStream:   measureStream,
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			lat, err := measurer(ctx, 0, 0, 20)
+			require.NoError(t, err)
+			assert.Greater(t, lat, 0.0)
+		})
+	}
+}
+
+func TestMeasureKernels_Cancelled(t *testing.T) {
+	for name, measurer := range map[string]latencyMeasurer{
+		KernelCAS:    measureCAS,
+		KernelStream: measureStream,
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+
+			start := time.Now()
+			_, err := measurer(ctx, 0, 0, 1<<40)
+			assert.Error(t, err)
+			assert.Less(t, time.Since(start), 5*time.Second, "busy-wait loops must observe the cancellation")
+		})
+	}
+}
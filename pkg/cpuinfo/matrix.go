@@ -0,0 +1,101 @@
+package cpuinfo
+
+import "sort"
+
+// LatencyMatrix holds the averaged latency in nanoseconds between pairs of
+// logical CPUs: matrix[src][dst]. The diagonal is never stored.
+type LatencyMatrix map[int]map[int]float64
+
+// Get returns the latency from src to dst.
+func (m LatencyMatrix) Get(src, dst int) (float64, bool) {
+	row, ok := m[src]
+	if !ok {
+		return 0, false
+	}
+	v, ok := row[dst]
+	return v, ok
+}
+
+// Set stores the latency from src to dst.
+func (m LatencyMatrix) Set(src, dst int, latency float64) {
+	row, ok := m[src]
+	if !ok {
+		row = make(map[int]float64)
+		m[src] = row
+	}
+	row[dst] = latency
+}
+
+// CPUs returns the sorted list of CPUs that appear as source in the matrix.
+func (m LatencyMatrix) CPUs() []int {
+	cpus := make([]int, 0, len(m))
+	for cpu := range m {
+		cpus = append(cpus, cpu)
+	}
+	sort.Ints(cpus)
+	return cpus
+}
+
+// Without returns a copy of the matrix with all rows and columns of the given CPUs removed.
+func (m LatencyMatrix) Without(cpus ...int) LatencyMatrix {
+	drop := make(map[int]struct{}, len(cpus))
+	for _, cpu := range cpus {
+		drop[cpu] = struct{}{}
+	}
+
+	res := make(LatencyMatrix, len(m))
+	for src, row := range m {
+		if _, ok := drop[src]; ok {
+			continue
+		}
+		newRow := make(map[int]float64, len(row))
+		for dst, v := range row {
+			if _, ok := drop[dst]; ok {
+				continue
+			}
+			newRow[dst] = v
+		}
+		res[src] = newRow
+	}
+	return res
+}
+
+// cpuPair is an ordered (source, destination) pair of logical CPUs.
+type cpuPair struct {
+	src, dst int
+}
+
+// allPairs returns every ordered pair of distinct CPUs in topology order.
+func allPairs(topology []CoreInfo) []cpuPair {
+	pairs := make([]cpuPair, 0, len(topology)*len(topology))
+	for _, src := range topology {
+		for _, dst := range topology {
+			if src.CPU == dst.CPU {
+				continue
+			}
+			pairs = append(pairs, cpuPair{src.CPU, dst.CPU})
+		}
+	}
+	return pairs
+}
+
+// diffTopology returns the CPU IDs that were added to and removed from prev.
+func diffTopology(prev, next []CoreInfo) (added, removed []int) {
+	prevSet := make(map[int]struct{}, len(prev))
+	for _, c := range prev {
+		prevSet[c.CPU] = struct{}{}
+	}
+	nextSet := make(map[int]struct{}, len(next))
+	for _, c := range next {
+		nextSet[c.CPU] = struct{}{}
+		if _, ok := prevSet[c.CPU]; !ok {
+			added = append(added, c.CPU)
+		}
+	}
+	for _, c := range prev {
+		if _, ok := nextSet[c.CPU]; !ok {
+			removed = append(removed, c.CPU)
+		}
+	}
+	return added, removed
+}
@@ -0,0 +1,64 @@
+package cpuinfo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLatencyMatrix_SetGet(t *testing.T) {
+	m := make(LatencyMatrix)
+	m.Set(0, 1, 42)
+
+	v, ok := m.Get(0, 1)
+	assert.True(t, ok)
+	assert.Equal(t, 42.0, v)
+
+	_, ok = m.Get(1, 0)
+	assert.False(t, ok)
+
+	assert.Equal(t, []int{0}, m.CPUs())
+}
+
+func TestLatencyMatrix_Without(t *testing.T) {
+	m := make(LatencyMatrix)
+	for _, a := range []int{0, 1, 2} {
+		for _, b := range []int{0, 1, 2} {
+			if a != b {
+				m.Set(a, b, float64(a*10+b))
+			}
+		}
+	}
+
+	res := m.Without(1)
+	assert.Equal(t, []int{0, 2}, res.CPUs())
+	_, ok := res.Get(0, 1)
+	assert.False(t, ok)
+	v, ok := res.Get(2, 0)
+	assert.True(t, ok)
+	assert.Equal(t, 20.0, v)
+
+	// Original is untouched
+	_, ok = m.Get(0, 1)
+	assert.True(t, ok)
+}
+
+func TestDiffTopology(t *testing.T) {
+	prev := []CoreInfo{{CPU: 0}, {CPU: 1}, {CPU: 2}}
+	next := []CoreInfo{{CPU: 0}, {CPU: 2}, {CPU: 3}}
+
+	added, removed := diffTopology(prev, next)
+	assert.Equal(t, []int{3}, added)
+	assert.Equal(t, []int{1}, removed)
+
+	added, removed = diffTopology(prev, prev)
+	assert.Empty(t, added)
+	assert.Empty(t, removed)
+}
+
+func TestAllPairs(t *testing.T) {
+	pairs := allPairs([]CoreInfo{{CPU: 0}, {CPU: 1}, {CPU: 2}})
+	assert.Len(t, pairs, 6)
+	assert.Equal(t, cpuPair{0, 1}, pairs[0])
+	assert.NotContains(t, pairs, cpuPair{1, 1})
+}
@@ -0,0 +1,250 @@
+package cpuinfo
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	procStatFile     = "/proc/stat"
+	procPressureFile = "/proc/pressure/cpu"
+)
+
+// NoiseGuard configures how the measurement reacts to a busy host.
+// Running VMs inflate the measured latencies unevenly, so the measurement
+// is postponed until the host is quiet (at most MaxWait) and the result is
+// flagged as low confidence if the host stays or becomes busy.
+type NoiseGuard struct {
+	MaxBusy     float64       // fraction of non-idle CPU time (0.0 - 1.0)
+	MaxPressure float64       // /proc/pressure/cpu "some avg10" in percent
+	MaxWait     time.Duration // how long the measurement is postponed at most
+	Interval    time.Duration // sampling interval while waiting
+}
+
+// HostLoad records the load observed before and during a measurement.
+type HostLoad struct {
+	Busy          float64       `json:"busy"`               // highest fraction of non-idle CPU time
+	Pressure      float64       `json:"pressure,omitempty"` // highest CPU pressure (some avg10, percent)
+	Waited        time.Duration `json:"waited_ns"`
+	LowConfidence bool          `json:"low_confidence"`
+}
+
+// cpuStat holds the aggregated jiffies of the "cpu" line of /proc/stat.
+type cpuStat struct {
+	total, idle uint64
+	cpus        int
+}
+
+// loadSample is a single reading of the host load counters.
+// pressure is -1 if /proc/pressure/cpu is not available.
+type loadSample struct {
+	stat     cpuStat
+	pressure float64
+}
+
+// loadReader returns the current host load counters.
+type loadReader func() (loadSample, error)
+
+func readLoadSystem() (loadSample, error) {
+	return readLoad(procStatFile, procPressureFile)
+}
+
+func readLoad(statPath, pressurePath string) (loadSample, error) {
+	stat, err := readProcStat(statPath)
+	if err != nil {
+		return loadSample{}, err
+	}
+	pressure, err := readCPUPressure(pressurePath)
+	if err != nil {
+		// PSI is optional (CONFIG_PSI, psi=1)
+		pressure = -1
+	}
+	return loadSample{stat: stat, pressure: pressure}, nil
+}
+
+// readProcStat parses the aggregated "cpu" line and counts the "cpuN" lines.
+func readProcStat(path string) (cpuStat, error) {
+	data, err := os.ReadFile(path) // #nosec G304 -- fixed /proc path or test file
+	if err != nil {
+		return cpuStat{}, err
+	}
+
+	var res cpuStat
+	found := false
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || !strings.HasPrefix(fields[0], "cpu") {
+			continue
+		}
+		if fields[0] != "cpu" {
+			res.cpus++
+			continue
+		}
+		// user nice system idle iowait irq softirq steal guest guest_nice
+		for i, f := range fields[1:] {
+			v, err := strconv.ParseUint(f, 10, 64)
+			if err != nil {
+				return cpuStat{}, fmt.Errorf("invalid value %q in %s: %w", f, path, err)
+			}
+			// guest and guest_nice are already part of user and nice
+			if i >= 8 {
+				break
+			}
+			res.total += v
+			if i == 3 || i == 4 { // idle, iowait
+				res.idle += v
+			}
+		}
+		found = true
+	}
+	if !found {
+		return cpuStat{}, fmt.Errorf("no cpu line in %s", path)
+	}
+	return res, nil
+}
+
+// readCPUPressure returns the "some avg10" value of /proc/pressure/cpu.
+func readCPUPressure(path string) (float64, error) {
+	data, err := os.ReadFile(path) // #nosec G304 -- fixed /proc path or test file
+	if err != nil {
+		return 0, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || fields[0] != "some" {
+			continue
+		}
+		for _, f := range fields[1:] {
+			if v, ok := strings.CutPrefix(f, "avg10="); ok {
+				return strconv.ParseFloat(v, 64)
+			}
+		}
+	}
+	return 0, fmt.Errorf("no some avg10 in %s", path)
+}
+
+// busySince returns the fraction of non-idle CPU time between two samples.
+// own is the number of CPUs kept busy by the measurement itself, their share is subtracted.
+func busySince(prev, cur loadSample, own int) float64 {
+	if cur.stat.total <= prev.stat.total {
+		return 0
+	}
+	total := float64(cur.stat.total - prev.stat.total)
+	busy := (total - float64(cur.stat.idle-prev.stat.idle)) / total
+	if cur.stat.cpus > 0 {
+		busy -= float64(own) / float64(cur.stat.cpus)
+	}
+	if busy < 0 {
+		return 0
+	}
+	return busy
+}
+
+// tooBusy reports whether the observed load exceeds the limits.
+func (g *NoiseGuard) tooBusy(busy, pressure float64) bool {
+	return busy > g.MaxBusy || pressure > g.MaxPressure
+}
+
+// loadMonitor samples the host load while a measurement is running.
+type loadMonitor struct {
+	guard *NoiseGuard
+	read  loadReader
+	prev  loadSample
+	load  *HostLoad
+}
+
+// waitForQuietHost postpones the measurement until the host is quiet or
+// MaxWait has passed. It returns a monitor to sample the load while
+// measuring, or nil if no guard is configured or the load can't be read.
+func (c *CPUInfo) waitForQuietHost(ctx context.Context) (*loadMonitor, error) {
+	if c.noise == nil || c.loadReader == nil {
+		return nil, nil
+	}
+
+	prev, err := c.loadReader()
+	if err != nil {
+		slog.Warn("Failed to read host load, measuring without noise guard", "error", err)
+		return nil, nil
+	}
+
+	m := &loadMonitor{guard: c.noise, read: c.loadReader, load: &HostLoad{}}
+	start := time.Now()
+	c.waiting.Store(true)
+	defer c.waiting.Store(false)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(c.noise.Interval):
+		}
+
+		cur, err := c.loadReader()
+		if err != nil {
+			slog.Warn("Failed to read host load, measuring without noise guard", "error", err)
+			return nil, nil
+		}
+		busy := busySince(prev, cur, 0)
+		prev = cur
+
+		if !c.noise.tooBusy(busy, cur.pressure) {
+			m.record(busy, cur.pressure)
+			m.load.Waited = time.Since(start)
+			m.prev = cur
+			return m, nil
+		}
+		if time.Since(start) >= c.noise.MaxWait {
+			slog.Warn("Host is still busy, measuring anyway (low confidence)", "busy", busy, "pressure", cur.pressure, "waited", time.Since(start).Round(time.Second))
+			m.record(busy, cur.pressure)
+			m.load.Waited = time.Since(start)
+			m.prev = cur
+			return m, nil
+		}
+		slog.Info("Host is busy, postponing measurement", "busy", busy, "pressure", cur.pressure, "max_busy", c.noise.MaxBusy, "max_pressure", c.noise.MaxPressure)
+	}
+}
+
+// sample reads the load since the previous sample, the two CPUs used by
+// the measurement are not counted.
+func (m *loadMonitor) sample() {
+	if m == nil {
+		return
+	}
+	cur, err := m.read()
+	if err != nil {
+		return
+	}
+	m.record(busySince(m.prev, cur, 2), cur.pressure)
+	m.prev = cur
+}
+
+func (m *loadMonitor) record(busy, pressure float64) {
+	if busy > m.load.Busy {
+		m.load.Busy = busy
+	}
+	if pressure > m.load.Pressure {
+		m.load.Pressure = pressure
+	}
+	if m.guard.tooBusy(busy, pressure) {
+		m.load.LowConfidence = true
+	}
+}
+
+// result returns the recorded load, nil for a nil monitor.
+func (m *loadMonitor) result() *HostLoad {
+	if m == nil {
+		return nil
+	}
+	if m.load.LowConfidence {
+		slog.Warn("Host was busy during the measurement, ranking has low confidence", "busy", m.load.Busy, "pressure", m.load.Pressure)
+	}
+	return m.load
+}
@@ -0,0 +1,176 @@
+package cpuinfo
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadLoad(t *testing.T) {
+	dir := t.TempDir()
+	statPath := filepath.Join(dir, "stat")
+	pressurePath := filepath.Join(dir, "pressure")
+
+	stat := `cpu  100 10 50 800 40 0 0 0 5 0
+cpu0 50 5 25 400 20 0 0 0 5 0
+cpu1 50 5 25 400 20 0 0 0 0 0
+intr 12345
+ctxt 6789
+`
+	require.NoError(t, os.WriteFile(statPath, []byte(stat), 0600))
+	require.NoError(t, os.WriteFile(pressurePath, []byte("some avg10=12.50 avg60=3.00 avg300=1.00 total=100\nfull avg10=0.00 avg60=0.00 avg300=0.00 total=0\n"), 0600))
+
+	sample, err := readLoad(statPath, pressurePath)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1000), sample.stat.total, "guest is not counted twice")
+	assert.Equal(t, uint64(840), sample.stat.idle)
+	assert.Equal(t, 2, sample.stat.cpus)
+	assert.Equal(t, 12.5, sample.pressure)
+
+	// PSI is optional
+	sample, err = readLoad(statPath, filepath.Join(dir, "missing"))
+	require.NoError(t, err)
+	assert.Equal(t, -1.0, sample.pressure)
+
+	_, err = readLoad(filepath.Join(dir, "missing"), pressurePath)
+	assert.Error(t, err)
+
+	require.NoError(t, os.WriteFile(statPath, []byte("intr 1\n"), 0600))
+	_, err = readLoad(statPath, pressurePath)
+	assert.Error(t, err)
+}
+
+func TestBusySince(t *testing.T) {
+	prev := loadSample{stat: cpuStat{total: 1000, idle: 800, cpus: 4}}
+	cur := loadSample{stat: cpuStat{total: 2000, idle: 1300, cpus: 4}}
+
+	assert.InDelta(t, 0.5, busySince(prev, cur, 0), 1e-9)
+	assert.InDelta(t, 0.0, busySince(prev, cur, 2), 1e-9, "own CPUs are subtracted")
+	assert.Equal(t, 0.0, busySince(cur, prev, 0), "counter reset")
+}
+
+// fakeLoad returns samples with the given busy fractions, the last one repeats.
+type fakeLoad struct {
+	mu       sync.Mutex
+	busy     []float64
+	pressure float64
+	stat     cpuStat
+}
+
+func (f *fakeLoad) read() (loadSample, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	busy := f.busy[0]
+	if len(f.busy) > 1 {
+		f.busy = f.busy[1:]
+	}
+	f.stat.cpus = 100
+	f.stat.total += 1000
+	f.stat.idle += uint64((1 - busy) * 1000)
+	return loadSample{stat: f.stat, pressure: f.pressure}, nil
+}
+
+func newNoiseTestCPUInfo(load *fakeLoad, maxWait time.Duration) *CPUInfo {
+	c := New(WithNoiseGuard(NoiseGuard{
+		MaxBusy:     0.5,
+		MaxPressure: 10,
+		MaxWait:     maxWait,
+		Interval:    time.Millisecond,
+	})).(*CPUInfo)
+	c.distances = nil
+	c.loadReader = load.read
+	c.detector = func() ([]CoreInfo, error) { return []CoreInfo{{CPU: 0}, {CPU: 1}}, nil }
+	c.measurer = func(_ context.Context, cpuA, cpuB, iter int) (float64, error) {
+		return 10, nil
+	}
+	return c
+}
+
+func TestNoiseGuard_Quiet(t *testing.T) {
+	c := newNoiseTestCPUInfo(&fakeLoad{busy: []float64{0, 0.1}}, time.Second)
+	require.NoError(t, c.Update(context.Background(), 2, 1, nil))
+
+	snapshot, err := c.GetSnapshot()
+	require.NoError(t, err)
+	require.NotNil(t, snapshot.Meta.Load)
+	assert.False(t, snapshot.Meta.Load.LowConfidence)
+	assert.InDelta(t, 0.1, snapshot.Meta.Load.Busy, 0.01)
+}
+
+func TestNoiseGuard_Postpones(t *testing.T) {
+	// busy twice, then quiet
+	load := &fakeLoad{busy: []float64{0, 0.9, 0.9, 0.1}}
+	c := newNoiseTestCPUInfo(load, time.Minute)
+	require.NoError(t, c.Update(context.Background(), 1, 1, nil))
+
+	snapshot, err := c.GetSnapshot()
+	require.NoError(t, err)
+	assert.False(t, snapshot.Meta.Load.LowConfidence)
+	assert.Greater(t, snapshot.Meta.Load.Waited, time.Duration(0))
+	assert.False(t, c.Status().Waiting)
+}
+
+func TestNoiseGuard_LowConfidence(t *testing.T) {
+	// stays busy: measured anyway after MaxWait
+	c := newNoiseTestCPUInfo(&fakeLoad{busy: []float64{0.9}}, 5*time.Millisecond)
+	require.NoError(t, c.Update(context.Background(), 1, 1, nil))
+
+	snapshot, err := c.GetSnapshot()
+	require.NoError(t, err)
+	assert.True(t, snapshot.Meta.Load.LowConfidence)
+	assert.GreaterOrEqual(t, snapshot.Meta.Load.Waited, 5*time.Millisecond)
+	assert.InDelta(t, 0.9, snapshot.Meta.Load.Busy, 0.03)
+}
+
+func TestNoiseGuard_BusyDuringMeasurement(t *testing.T) {
+	// quiet before, busy in the second round (2 CPUs of 100 are our own)
+	c := newNoiseTestCPUInfo(&fakeLoad{busy: []float64{0, 0, 0.8}}, time.Second)
+	require.NoError(t, c.Update(context.Background(), 2, 1, nil))
+
+	snapshot, err := c.GetSnapshot()
+	require.NoError(t, err)
+	assert.True(t, snapshot.Meta.Load.LowConfidence)
+}
+
+func TestNoiseGuard_Pressure(t *testing.T) {
+	c := newNoiseTestCPUInfo(&fakeLoad{busy: []float64{0}, pressure: 30}, 5*time.Millisecond)
+	require.NoError(t, c.Update(context.Background(), 1, 1, nil))
+
+	snapshot, err := c.GetSnapshot()
+	require.NoError(t, err)
+	assert.True(t, snapshot.Meta.Load.LowConfidence)
+	assert.Equal(t, 30.0, snapshot.Meta.Load.Pressure)
+}
+
+func TestNoiseGuard_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	c := newNoiseTestCPUInfo(&fakeLoad{busy: []float64{0.9}}, time.Hour)
+	err := c.Update(ctx, 1, 1, nil)
+	assert.ErrorIs(t, err, context.Canceled)
+	_, err = c.GetSnapshot()
+	assert.Error(t, err)
+}
+
+func TestNoiseGuard_Disabled(t *testing.T) {
+	c := New().(*CPUInfo)
+	c.distances = nil
+	c.loadReader = func() (loadSample, error) {
+		t.Fatal("load must not be read without a noise guard")
+		return loadSample{}, nil
+	}
+	c.detector = func() ([]CoreInfo, error) { return []CoreInfo{{CPU: 0}, {CPU: 1}}, nil }
+	c.measurer = func(_ context.Context, cpuA, cpuB, iter int) (float64, error) { return 10, nil }
+
+	require.NoError(t, c.Update(context.Background(), 1, 1, nil))
+	snapshot, err := c.GetSnapshot()
+	require.NoError(t, err)
+	assert.Nil(t, snapshot.Meta.Load)
+}
@@ -0,0 +1,59 @@
+package cpuinfo
+
+import (
+	"log/slog"
+	"math"
+)
+
+// NormalizationReport describes the topology class normalization of a ranking.
+type NormalizationReport struct {
+	Tolerance float64 `json:"tolerance"`
+	Snapped   int     `json:"snapped"`  // pairs set to the median of their class
+	Outliers  int     `json:"outliers"` // pairs kept, they differ more than Tolerance from the median
+}
+
+// WithNormalization snaps the latencies that differ at most tolerance (0.0 -
+// 1.0, relative) from the median of their topology class to the median.
+// Identical pairs (e.g. all cross-socket pairs) differ by measurement noise,
+// which otherwise reorders the rankings between restarts. Larger deviations
+// are kept, they are real differences the classes don't capture.
+func WithNormalization(tolerance float64) Option {
+	return func(c *CPUInfo) {
+		c.normalization = tolerance
+	}
+}
+
+// normalizeMatrix returns a copy of matrix with the latencies within
+// tolerance of their class median set to the median.
+func normalizeMatrix(topology []CoreInfo, matrix LatencyMatrix, tolerance float64) (LatencyMatrix, *NormalizationReport) {
+	report := &NormalizationReport{Tolerance: tolerance}
+	res := make(LatencyMatrix, len(matrix))
+
+	classes, order := classifyPairs(topology, allPairs(topology))
+	for _, class := range order {
+		var values []float64
+		for _, p := range classes[class] {
+			if lat, ok := matrix.Get(p.src, p.dst); ok {
+				values = append(values, lat)
+			}
+		}
+		m := median(values)
+
+		for _, p := range classes[class] {
+			lat, ok := matrix.Get(p.src, p.dst)
+			if !ok {
+				continue
+			}
+			if m > 0 && math.Abs(lat-m)/m <= tolerance {
+				lat = m
+				report.Snapped++
+			} else {
+				report.Outliers++
+			}
+			res.Set(p.src, p.dst, lat)
+		}
+	}
+
+	slog.Debug("Normalized latency matrix", "tolerance", tolerance, "snapped", report.Snapped, "outliers", report.Outliers)
+	return res, report
+}
@@ -0,0 +1,89 @@
+package cpuinfo
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeMatrix(t *testing.T) {
+	topology, _ := fallbackTopology()
+	matrix := make(LatencyMatrix, len(topology))
+	for _, p := range allPairs(topology) {
+		switch classOf(topology[p.src], topology[p.dst]).relation {
+		case relationSMT:
+			matrix.Set(p.src, p.dst, 10)
+		case relationRemote:
+			// up to 15% noise on the cross-socket pairs
+			matrix.Set(p.src, p.dst, 100+float64((p.src*3+p.dst)%4)*5)
+		default:
+			matrix.Set(p.src, p.dst, 40)
+		}
+	}
+	// A real difference within the socket is kept
+	matrix.Set(0, 1, 80)
+
+	normalized, report := normalizeMatrix(topology, matrix, 0.2)
+	assert.Equal(t, 0.2, report.Tolerance)
+	assert.Equal(t, 1, report.Outliers)
+	assert.Equal(t, 8*7-1, report.Snapped)
+
+	remote, _ := normalized.Get(0, 2)
+	for _, p := range allPairs(topology) {
+		lat, ok := normalized.Get(p.src, p.dst)
+		require.True(t, ok)
+		if classOf(topology[p.src], topology[p.dst]).relation == relationRemote {
+			assert.Equal(t, remote, lat, "pair %d -> %d", p.src, p.dst)
+		}
+	}
+	lat, _ := normalized.Get(0, 1)
+	assert.Equal(t, 80.0, lat)
+	lat, _ = normalized.Get(1, 0)
+	assert.Equal(t, 40.0, lat)
+
+	// The input is not modified
+	lat, _ = matrix.Get(0, 3)
+	assert.NotEqual(t, remote, lat)
+}
+
+func TestUpdate_Normalization(t *testing.T) {
+	c := New(WithNormalization(0.2)).(*CPUInfo)
+	c.detector = fallbackTopology
+	c.distances = nil
+	c.loadReader = nil
+	c.measurer = func(_ context.Context, cpuA, cpuB, iter int) (float64, error) {
+		topology, _ := fallbackTopology()
+		if topology[cpuA].Socket != topology[cpuB].Socket {
+			// CPU 3 looks slightly closer due to noise
+			if cpuB == 3 {
+				return 95, nil
+			}
+			return 105, nil
+		}
+		return 40, nil
+	}
+	require.NoError(t, c.Update(context.Background(), 1, 1, nil))
+
+	snapshot, err := c.GetSnapshot()
+	require.NoError(t, err)
+	require.NotNil(t, snapshot.Meta.Normalization)
+	assert.Equal(t, 0, snapshot.Meta.Normalization.Outliers)
+
+	// The remote CPUs are ordered by ID instead of noise
+	ranking := snapshot.Rankings[0].Ranking
+	var remote []int
+	for _, n := range ranking[len(ranking)-4:] {
+		remote = append(remote, n.CPU)
+	}
+	assert.Equal(t, []int{2, 3, 6, 7}, remote)
+
+	// The topology fallback is not normalized
+	c = New(WithNormalization(0.2)).(*CPUInfo)
+	c.detector = fallbackTopology
+	c.distances = nil
+	require.NoError(t, c.UseTopologyFallback())
+	snapshot, _ = c.GetSnapshot()
+	assert.Nil(t, snapshot.Meta.Normalization)
+}
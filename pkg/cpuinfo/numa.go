@@ -0,0 +1,94 @@
+package cpuinfo
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const sysfsNodeDir = "/sys/devices/system/node"
+
+// NUMADistances is the ACPI SLIT distance matrix as exposed by the kernel.
+// It is indexed by NUMA node ID: distances[from][to].
+// By convention the local distance is 10, remote nodes have higher values.
+type NUMADistances map[int]map[int]int
+
+// Distance returns the distance between two NUMA nodes.
+// The boolean is false if one of the nodes is unknown.
+func (d NUMADistances) Distance(from, to int) (int, bool) {
+	row, ok := d[from]
+	if !ok {
+		return 0, false
+	}
+	dist, ok := row[to]
+	return dist, ok
+}
+
+// distanceReader is a function that returns the current NUMA distance matrix.
+type distanceReader func() (NUMADistances, error)
+
+func readNUMADistancesSystem() (NUMADistances, error) {
+	return readNUMADistances(sysfsNodeDir)
+}
+
+// readNUMADistances parses <root>/node*/distance.
+// Each file contains one distance per online node, in ascending node ID order.
+// A missing root (kernel without CONFIG_NUMA) yields an empty matrix.
+func readNUMADistances(root string) (NUMADistances, error) {
+	matches, err := filepath.Glob(filepath.Join(root, "node[0-9]*"))
+	if err != nil {
+		return nil, err
+	}
+
+	var nodes []int
+	for _, path := range matches {
+		id, err := strconv.Atoi(strings.TrimPrefix(filepath.Base(path), "node"))
+		if err != nil {
+			continue
+		}
+		nodes = append(nodes, id)
+	}
+	sort.Ints(nodes)
+
+	distances := make(NUMADistances, len(nodes))
+	for _, from := range nodes {
+		// #nosec G304 -- path is built from a sysfs glob and an integer node ID
+		data, err := os.ReadFile(filepath.Join(root, fmt.Sprintf("node%d", from), "distance"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read distance of node %d: %w", from, err)
+		}
+		fields := strings.Fields(string(data))
+		if len(fields) != len(nodes) {
+			return nil, fmt.Errorf("node %d: expected %d distances, got %d", from, len(nodes), len(fields))
+		}
+		row := make(map[int]int, len(nodes))
+		for idx, f := range fields {
+			dist, err := strconv.Atoi(f)
+			if err != nil {
+				return nil, fmt.Errorf("node %d: invalid distance %q: %w", from, f, err)
+			}
+			row[nodes[idx]] = dist
+		}
+		distances[from] = row
+	}
+	return distances, nil
+}
+
+// detectCPUNode returns the NUMA node of a CPU by looking for the
+// nodeN link inside its sysfs directory (e.g. /sys/devices/system/cpu/cpu3/node1).
+// Returns -1 if the kernel does not expose NUMA information.
+func detectCPUNode(cpuPath string) int {
+	matches, err := filepath.Glob(filepath.Join(cpuPath, "node[0-9]*"))
+	if err != nil {
+		return -1
+	}
+	for _, m := range matches {
+		if id, err := strconv.Atoi(strings.TrimPrefix(filepath.Base(m), "node")); err == nil {
+			return id
+		}
+	}
+	return -1
+}
@@ -0,0 +1,116 @@
+package cpuinfo
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFakeNode(t *testing.T, root string, node int, distance string) {
+	t.Helper()
+	dir := filepath.Join(root, "node"+strconv.Itoa(node))
+	require.NoError(t, os.MkdirAll(dir, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "distance"), []byte(distance), 0o600))
+}
+
+func TestReadNUMADistances(t *testing.T) {
+	root := t.TempDir()
+	writeFakeNode(t, root, 0, "10 21\n")
+	writeFakeNode(t, root, 1, "21 10\n")
+
+	d, err := readNUMADistances(root)
+	require.NoError(t, err)
+
+	dist, ok := d.Distance(0, 1)
+	assert.True(t, ok)
+	assert.Equal(t, 21, dist)
+
+	dist, ok = d.Distance(1, 1)
+	assert.True(t, ok)
+	assert.Equal(t, 10, dist)
+
+	_, ok = d.Distance(0, 7)
+	assert.False(t, ok)
+}
+
+func TestReadNUMADistances_Missing(t *testing.T) {
+	d, err := readNUMADistances(filepath.Join(t.TempDir(), "does-not-exist"))
+	assert.NoError(t, err)
+	assert.Empty(t, d)
+}
+
+func TestReadNUMADistances_Malformed(t *testing.T) {
+	root := t.TempDir()
+	writeFakeNode(t, root, 0, "10 21\n")
+	writeFakeNode(t, root, 1, "21\n")
+
+	_, err := readNUMADistances(root)
+	assert.Error(t, err)
+}
+
+func TestDetectCPUNode(t *testing.T) {
+	cpuDir := t.TempDir()
+	assert.Equal(t, -1, detectCPUNode(cpuDir))
+
+	require.NoError(t, os.Mkdir(filepath.Join(cpuDir, "node1"), 0o755))
+	assert.Equal(t, 1, detectCPUNode(cpuDir))
+}
+
+func TestNUMADistances_NilIsSafe(t *testing.T) {
+	var d NUMADistances
+	_, ok := d.Distance(0, 0)
+	assert.False(t, ok)
+}
+
+func TestUpdate_NUMAWeight(t *testing.T) {
+	// CPU 0 and 1 on node 0, CPU 2 on node 1.
+	// CPU 2 is the fastest to reach from CPU 0 (cache-line wise) but is remote memory.
+	topology := []CoreInfo{
+		{CPU: 0, Socket: 0, Core: 0, Node: 0},
+		{CPU: 1, Socket: 0, Core: 1, Node: 0},
+		{CPU: 2, Socket: 1, Core: 0, Node: 1},
+	}
+	latencies := map[[2]int]float64{
+		{0, 1}: 60, {1, 0}: 60,
+		{0, 2}: 50, {2, 0}: 50,
+		{1, 2}: 80, {2, 1}: 80,
+	}
+	distances := NUMADistances{
+		0: {0: 10, 1: 40},
+		1: {0: 40, 1: 10},
+	}
+
+	newCPUInfo := func(weight float64) *CPUInfo {
+		return &CPUInfo{
+			detector:   func() ([]CoreInfo, error) { return topology, nil },
+			measurer:   func(_ context.Context, a, b, _ int) (float64, error) { return latencies[[2]int{a, b}], nil },
+			distances:  func() (NUMADistances, error) { return distances, nil },
+			numaWeight: weight,
+			selections: make(map[int]Selection),
+		}
+	}
+
+	// Latency only
+	c := newCPUInfo(0)
+	require.NoError(t, c.Update(context.Background(), 1, 1, nil))
+	rankings, err := c.GetCoreRanking()
+	require.NoError(t, err)
+	assert.Equal(t, 2, rankings[0].Ranking[0].CPU)
+	assert.Equal(t, 40, rankings[0].Ranking[0].Distance)
+	assert.Equal(t, 1, rankings[0].Ranking[0].Node)
+
+	// Memory locality dominates
+	c = newCPUInfo(0.8)
+	require.NoError(t, c.Update(context.Background(), 1, 1, nil))
+	rankings, err = c.GetCoreRanking()
+	require.NoError(t, err)
+	assert.Equal(t, 1, rankings[0].Ranking[0].CPU)
+	assert.Equal(t, 10, rankings[0].Ranking[0].Distance)
+	// The measured latency is preserved even if it is not used for ordering
+	assert.Equal(t, 60.0, rankings[0].Ranking[0].LatencyNS)
+}
@@ -0,0 +1,59 @@
+package cpuinfo
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrCalculationRunning is returned by Recalculate while another ranking
+// calculation (the initial one, a hotplug update or a recalculation) is running.
+var ErrCalculationRunning = errors.New("a ranking calculation is already running")
+
+// States of a Recalculation.
+const (
+	RecalculationIdle    = "idle"
+	RecalculationRunning = "running"
+	RecalculationDone    = "done"
+	RecalculationFailed  = "failed"
+)
+
+// Recalculation describes the last recalculation requested on the socket.
+type Recalculation struct {
+	State      string     `json:"state"`
+	Rounds     int        `json:"rounds,omitempty"`
+	Iterations int        `json:"iterations,omitempty"`
+	Round      int        `json:"round,omitempty"` // round of the running measurement
+	Progress   float64    `json:"progress"`        // 0.0 - 1.0
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Generation uint64     `json:"generation,omitempty"` // of the new ranking
+	Replaced   []int      `json:"replaced,omitempty"`   // VMs re-placed with the new ranking
+	Error      string     `json:"error,omitempty"`
+}
+
+// Recalculate starts a full measurement in the background, like
+// CalculateRanking. Instead of waiting for a running calculation it returns
+// ErrCalculationRunning right away, otherwise the result is sent on done.
+// The selections are kept, except those made from the topology fallback
+// (see TakeProvisional): use ReplaceLostCPUs afterwards to fix selections
+// that use a CPU which is gone.
+func (c *CPUInfo) Recalculate(ctx context.Context, rounds, iterations int, timeout time.Duration) (<-chan error, error) {
+	if !c.calcMu.TryLock() {
+		return nil, ErrCalculationRunning
+	}
+	c.running.Store(true)
+
+	done := make(chan error, 1)
+	go func() {
+		defer c.calcMu.Unlock()
+		done <- c.runCalculation(ctx, c.updateKeepingSelections, rounds, iterations, timeout)
+	}()
+	return done, nil
+}
+
+// updateKeepingSelections is Update, the selections are kept unless they
+// were made from the topology fallback.
+func (c *CPUInfo) updateKeepingSelections(ctx context.Context, rounds, iterations int, onProgress func(int, int)) error {
+	return c.update(ctx, rounds, iterations, onProgress, !isEstimate(c.load().Meta.Source))
+}
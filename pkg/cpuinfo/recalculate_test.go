@@ -0,0 +1,135 @@
+package cpuinfo
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecalculate(t *testing.T) {
+	release := make(chan struct{})
+	c := &CPUInfo{
+		detector: func() ([]CoreInfo, error) {
+			return []CoreInfo{{CPU: 0}, {CPU: 1}}, nil
+		},
+		measurer: func(ctx context.Context, cpuA, cpuB, iter int) (float64, error) {
+			select {
+			case <-release:
+				return 10, nil
+			case <-ctx.Done():
+				return 0, ctx.Err()
+			}
+		},
+		selections: make(map[int]Selection),
+	}
+
+	done, err := c.Recalculate(context.Background(), 2, 1, time.Minute)
+	require.NoError(t, err)
+	assert.True(t, c.Status().Running)
+
+	// Concurrent runs are rejected instead of waiting
+	_, err = c.Recalculate(context.Background(), 1, 1, time.Minute)
+	assert.ErrorIs(t, err, ErrCalculationRunning)
+
+	assert.Eventually(t, func() bool { return c.Status().Round == 1 }, time.Second, time.Millisecond)
+	assert.Equal(t, 2, c.Status().Rounds)
+
+	close(release)
+	require.NoError(t, <-done)
+
+	status := c.Status()
+	assert.False(t, status.Running)
+	assert.Zero(t, status.Round)
+	assert.Equal(t, SourceMeasured, status.Source)
+
+	// The lock is released, the selections are kept
+	_, err = c.SelectCPUs(100, 1)
+	require.NoError(t, err)
+	done, err = c.Recalculate(context.Background(), 1, 1, time.Minute)
+	require.NoError(t, err)
+	require.NoError(t, <-done)
+	snapshot, err := c.GetSnapshot()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), snapshot.Meta.Generation)
+	assert.Equal(t, uint64(1), c.GetSelections()[100].Generation)
+	assert.Empty(t, c.TakeProvisional())
+}
+
+func TestRecalculate_ReplacesProvisional(t *testing.T) {
+	c := New().(*CPUInfo)
+	c.detector = fallbackTopology
+	c.distances = nil
+	c.measurer = func(_ context.Context, cpuA, cpuB, iter int) (float64, error) {
+		return 10, nil
+	}
+	require.NoError(t, c.UseTopologyFallback())
+	_, err := c.SelectCPUs(100, 2)
+	require.NoError(t, err)
+
+	// Selections of the topology fallback are not kept
+	done, err := c.Recalculate(context.Background(), 1, 1, time.Minute)
+	require.NoError(t, err)
+	require.NoError(t, <-done)
+	assert.Empty(t, c.GetSelections())
+	assert.Equal(t, []int{100}, c.TakeProvisional())
+}
+
+func TestCalculateRanking_WaitsForRecalculation(t *testing.T) {
+	release := make(chan struct{})
+	c := &CPUInfo{
+		detector: func() ([]CoreInfo, error) {
+			return []CoreInfo{{CPU: 0}, {CPU: 1}}, nil
+		},
+		measurer: func(ctx context.Context, cpuA, cpuB, iter int) (float64, error) {
+			<-release
+			return 10, nil
+		},
+		selections: make(map[int]Selection),
+	}
+
+	done, err := c.Recalculate(context.Background(), 1, 1, time.Minute)
+	require.NoError(t, err)
+
+	calculated := make(chan error, 1)
+	go func() {
+		calculated <- c.CalculateRankingIncremental(context.Background(), 1, 1, time.Minute)
+	}()
+
+	select {
+	case <-calculated:
+		t.Fatal("calculation did not wait for the recalculation")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	require.NoError(t, <-done)
+	require.NoError(t, <-calculated)
+}
+
+func TestStatus_Error(t *testing.T) {
+	fail := true
+	c := &CPUInfo{
+		detector: func() ([]CoreInfo, error) {
+			return []CoreInfo{{CPU: 0}, {CPU: 1}}, nil
+		},
+		measurer: func(ctx context.Context, cpuA, cpuB, iter int) (float64, error) {
+			if fail {
+				return 0, errors.New("measurement failed")
+			}
+			return 10, nil
+		},
+		selections: make(map[int]Selection),
+	}
+
+	require.Error(t, c.CalculateRanking(context.Background(), 1, 1, time.Minute))
+	assert.Contains(t, c.Status().Error, "measurement failed")
+
+	// A successful calculation clears the error
+	fail = false
+	require.NoError(t, c.CalculateRanking(context.Background(), 1, 1, time.Minute))
+	assert.Empty(t, c.Status().Error)
+}
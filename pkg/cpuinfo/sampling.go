@@ -0,0 +1,188 @@
+package cpuinfo
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"math/rand/v2"
+	"sort"
+	"time"
+
+	"github.com/egandro/proxmox-cpu-affinity/pkg/config"
+)
+
+// Sampling configures the sampled measurement for large hosts. Instead of
+// all n² pairs only PerClass pairs of every topology class are measured, the
+// other pairs get the median latency of their class. SpotChecks random
+// inferred pairs are measured as well to report the residual error.
+type Sampling struct {
+	MinCPUs    int // sampling is used if the host has more CPUs
+	PerClass   int // pairs measured per topology class
+	SpotChecks int // inferred pairs measured to verify the result
+}
+
+// SamplingReport describes a sampled measurement.
+type SamplingReport struct {
+	Pairs         int           `json:"pairs"`          // pairs in the matrix
+	Measured      int           `json:"measured"`       // pairs measured, including spot checks
+	SpotChecks    int           `json:"spot_checks"`    // inferred pairs measured for verification
+	ResidualError float64       `json:"residual_error"` // mean relative error of the spot checks
+	MaxError      float64       `json:"max_error"`      // highest relative error of the spot checks
+	Classes       []ClassSample `json:"classes"`
+}
+
+// ClassSample is the inferred latency of a topology class, e.g. "smt/0"
+// (same core on socket 0) or "remote/0-1" (socket 0 to socket 1).
+type ClassSample struct {
+	Class     string  `json:"class"`
+	Pairs     int     `json:"pairs"`
+	Measured  int     `json:"measured"`
+	LatencyNS float64 `json:"latency_ns"` // median of the measured pairs (first kernel)
+}
+
+// WithSampling measures only a subset of the pairs on hosts with more than
+// s.MinCPUs CPUs, see Sampling.
+func WithSampling(s Sampling) Option {
+	return func(c *CPUInfo) {
+		c.sampling = &s
+	}
+}
+
+// NewSampling returns the sampling settings for mode (config.SamplingAuto,
+// config.SamplingAlways or config.SamplingNever). ok is false if sampling
+// is disabled.
+func NewSampling(mode string, perClass, spotChecks int) (s Sampling, ok bool) {
+	s = Sampling{PerClass: perClass, SpotChecks: spotChecks}
+	switch mode {
+	case config.SamplingAlways:
+		return s, true
+	case config.SamplingNever:
+		return s, false
+	default:
+		s.MinCPUs = config.ConstantSamplingMinCPUs
+		return s, true
+	}
+}
+
+// useSampling reports whether a host with numCPUs CPUs is measured sampled.
+func (c *CPUInfo) useSampling(numCPUs int) bool {
+	return c.sampling != nil && numCPUs > c.sampling.MinCPUs
+}
+
+// classifiedPair is a pair together with its topology class.
+type classifiedPair struct {
+	pair  cpuPair
+	class pairClass
+}
+
+// measureSampled measures PerClass pairs of every topology class and a few
+// random spot checks with every kernel, the remaining pairs are filled with
+// the class median of the kernel.
+func (c *CPUInfo) measureSampled(ctx context.Context, matrices kernelMatrices, kernels []KernelWeight, topology []CoreInfo, rounds int, iterations int, onProgress func(int, int)) (*HostLoad, *SamplingReport, error) {
+	seed := c.samplingSeed
+	if seed == 0 {
+		seed = uint64(time.Now().UnixNano()) // #nosec G115 -- only used as random seed
+	}
+	rng := rand.New(rand.NewPCG(seed, seed>>32)) // #nosec G404 -- pair selection, not security relevant
+
+	perClass := max(c.sampling.PerClass, 1)
+	pairs := allPairs(topology)
+	classes, order := classifyPairs(topology, pairs)
+
+	var samples, inferred []classifiedPair
+	for _, class := range order {
+		members := append([]cpuPair(nil), classes[class]...)
+		rng.Shuffle(len(members), func(i, j int) {
+			members[i], members[j] = members[j], members[i]
+		})
+		for i, p := range members {
+			if i < perClass {
+				samples = append(samples, classifiedPair{p, class})
+			} else {
+				inferred = append(inferred, classifiedPair{p, class})
+			}
+		}
+	}
+
+	rng.Shuffle(len(inferred), func(i, j int) {
+		inferred[i], inferred[j] = inferred[j], inferred[i]
+	})
+	spots := inferred[:min(c.sampling.SpotChecks, len(inferred))]
+
+	measured := make([]cpuPair, 0, len(samples)+len(spots))
+	for _, s := range append(samples, spots...) {
+		measured = append(measured, s.pair)
+	}
+	slog.Info("Sampled latency measurement", "pairs", len(pairs), "measured", len(measured), "classes", len(order))
+
+	load, err := c.measure(ctx, matrices, kernels, measured, rounds, iterations, onProgress)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	report := &SamplingReport{Pairs: len(pairs), Measured: len(measured), SpotChecks: len(spots)}
+	var errSum float64
+	var checked int
+	var primary map[pairClass]float64
+	for _, kernel := range kernels {
+		matrix := matrices[kernel.Kernel]
+
+		// The class latency is the median of the sampled pairs only, the
+		// spot checks are kept independent to measure the error.
+		sampled := make(map[pairClass][]float64, len(order))
+		for _, s := range samples {
+			lat, _ := matrix.Get(s.pair.src, s.pair.dst)
+			sampled[s.class] = append(sampled[s.class], lat)
+		}
+		estimates := make(map[pairClass]float64, len(order))
+		for class, lats := range sampled {
+			estimates[class] = median(lats)
+		}
+		if primary == nil {
+			primary = estimates
+		}
+
+		for _, s := range spots {
+			lat, _ := matrix.Get(s.pair.src, s.pair.dst)
+			if lat <= 0 {
+				// No relative error for a pair without a latency
+				continue
+			}
+			relErr := math.Abs(lat-estimates[s.class]) / lat
+			errSum += relErr
+			checked++
+			report.MaxError = max(report.MaxError, relErr)
+		}
+		for _, s := range inferred[len(spots):] {
+			matrix.Set(s.pair.src, s.pair.dst, estimates[s.class])
+		}
+	}
+	if checked > 0 {
+		report.ResidualError = errSum / float64(checked)
+	}
+
+	for _, class := range order {
+		report.Classes = append(report.Classes, ClassSample{
+			Class:     class.String(),
+			Pairs:     len(classes[class]),
+			Measured:  min(perClass, len(classes[class])),
+			LatencyNS: primary[class],
+		})
+	}
+	slog.Info("Sampled latency measurement done", "measured", report.Measured, "pairs", report.Pairs, "residual_error", report.ResidualError, "max_error", report.MaxError)
+	return load, report, nil
+}
+
+// median returns the median of values, 0 for an empty slice.
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
@@ -0,0 +1,176 @@
+package cpuinfo
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+
+	"github.com/egandro/proxmox-cpu-affinity/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func samplingTopology() ([]CoreInfo, error) {
+	// 2 sockets, 4 cores per socket, 2 threads per core
+	var topology []CoreInfo
+	for thread := 0; thread < 2; thread++ {
+		for socket := 0; socket < 2; socket++ {
+			for core := 0; core < 4; core++ {
+				topology = append(topology, CoreInfo{CPU: len(topology), Socket: socket, Core: core, Node: socket, L3: socket})
+			}
+		}
+	}
+	return topology, nil
+}
+
+// newSamplingTestCPUInfo returns a CPUInfo that measures a latency by
+// topology class with up to 2% deviation, calls counts the measurements.
+func newSamplingTestCPUInfo(s Sampling, calls *atomic.Int64) *CPUInfo {
+	c := New(WithSampling(s)).(*CPUInfo)
+	c.detector = samplingTopology
+	c.distances = nil
+	c.loadReader = nil
+	c.samplingSeed = 42
+	topology, _ := samplingTopology()
+	c.measurer = func(_ context.Context, cpuA, cpuB, iter int) (float64, error) {
+		calls.Add(1)
+		a, b := topology[cpuA], topology[cpuB]
+		deviation := 1 + float64((cpuA*7+cpuB*3)%5)/250
+		switch {
+		case a.Socket != b.Socket:
+			return 120 * deviation, nil
+		case a.Core == b.Core:
+			return 10 * deviation, nil
+		default:
+			return 40 * deviation, nil
+		}
+	}
+	return c
+}
+
+func TestClassOf(t *testing.T) {
+	core := func(cpu, socket, core, node, die, l3 int) CoreInfo {
+		return CoreInfo{CPU: cpu, Socket: socket, Core: core, Node: node, Die: die, L3: l3}
+	}
+	a := core(0, 0, 0, 0, 0, 0)
+	assert.Equal(t, pairClass{relationSMT, 0, 0}, classOf(a, core(8, 0, 0, 0, 0, 0)))
+	assert.Equal(t, pairClass{relationL3, 0, 0}, classOf(a, core(1, 0, 1, 0, 0, 0)))
+	assert.Equal(t, pairClass{relationNode, 0, 0}, classOf(a, core(2, 0, 2, 0, 0, 1)))
+	assert.Equal(t, pairClass{relationDie, 0, 0}, classOf(a, core(3, 0, 3, 1, 0, 2)))
+	assert.Equal(t, pairClass{relationSocket, 0, 0}, classOf(a, core(4, 0, 4, 2, 1, 3)))
+	assert.Equal(t, pairClass{relationRemote, 0, 1}, classOf(core(5, 1, 0, 3, 0, 4), a))
+
+	// Unknown IDs are never the same
+	unknown := core(0, 0, 0, -1, -1, -1)
+	assert.Equal(t, relationSocket, classOf(unknown, core(1, 0, 1, -1, -1, -1)).relation)
+	assert.Equal(t, relationSocket, classOf(core(0, 0, -1, -1, -1, -1), core(1, 0, -1, -1, -1, -1)).relation)
+
+	// Core IDs are per die on some systems
+	assert.Equal(t, relationSocket, classOf(core(0, 0, 0, -1, 0, -1), core(1, 0, 0, -1, 1, -1)).relation)
+
+	assert.Equal(t, "smt/0", pairClass{relationSMT, 0, 0}.String())
+	assert.Equal(t, "l3/1", pairClass{relationL3, 1, 1}.String())
+	assert.Equal(t, "remote/0-1", pairClass{relationRemote, 0, 1}.String())
+}
+
+func TestMedian(t *testing.T) {
+	assert.Equal(t, 0.0, median(nil))
+	assert.Equal(t, 2.0, median([]float64{3, 1, 2}))
+	assert.Equal(t, 2.5, median([]float64{4, 1, 3, 2}))
+}
+
+func TestNewSampling(t *testing.T) {
+	s, ok := NewSampling(config.SamplingAuto, 16, 64)
+	assert.True(t, ok)
+	assert.Equal(t, Sampling{MinCPUs: config.ConstantSamplingMinCPUs, PerClass: 16, SpotChecks: 64}, s)
+
+	s, ok = NewSampling(config.SamplingAlways, 8, 32)
+	assert.True(t, ok)
+	assert.Equal(t, 0, s.MinCPUs)
+
+	_, ok = NewSampling(config.SamplingNever, 16, 64)
+	assert.False(t, ok)
+}
+
+func TestUpdate_Sampled(t *testing.T) {
+	var calls atomic.Int64
+	c := newSamplingTestCPUInfo(Sampling{PerClass: 4, SpotChecks: 10}, &calls)
+	require.NoError(t, c.Update(context.Background(), 2, 1, nil))
+
+	snapshot, err := c.GetSnapshot()
+	require.NoError(t, err)
+	report := snapshot.Meta.Sampling
+	require.NotNil(t, report)
+
+	// 5 classes: smt/0, smt/1, l3/0, l3/1, remote/0-1
+	assert.Len(t, report.Classes, 5)
+	assert.Equal(t, 16*15, report.Pairs)
+	assert.Equal(t, 5*4+10, report.Measured)
+	assert.Equal(t, 10, report.SpotChecks)
+	assert.Equal(t, int64(report.Measured*2), calls.Load())
+	assert.Equal(t, report.Measured*2, c.Status().Total)
+
+	// Spot checks deviate at most 2% from the class median
+	assert.Less(t, report.ResidualError, 0.02)
+	assert.Less(t, report.MaxError, 0.02)
+
+	// The matrix is complete and inferred pairs have the latency of their class
+	matrix := snapshot.Matrix()
+	topology, _ := samplingTopology()
+	for _, p := range allPairs(topology) {
+		lat, ok := matrix.Get(p.src, p.dst)
+		require.True(t, ok, "pair %d -> %d", p.src, p.dst)
+		switch classOf(topology[p.src], topology[p.dst]).relation {
+		case relationSMT:
+			assert.InDelta(t, 10, lat, 0.2)
+		case relationL3:
+			assert.InDelta(t, 40, lat, 0.8)
+		case relationRemote:
+			assert.InDelta(t, 120, lat, 2.4)
+		}
+	}
+
+	// SMT sibling first, remote socket last
+	ranking := snapshot.Rankings[0].Ranking
+	assert.Equal(t, 8, ranking[0].CPU)
+	assert.Equal(t, 1, ranking[len(ranking)-1].Socket)
+}
+
+func TestUpdate_SampledSmallHost(t *testing.T) {
+	var calls atomic.Int64
+	c := newSamplingTestCPUInfo(Sampling{MinCPUs: 128, PerClass: 4, SpotChecks: 10}, &calls)
+	require.NoError(t, c.Update(context.Background(), 1, 1, nil))
+
+	snapshot, err := c.GetSnapshot()
+	require.NoError(t, err)
+	assert.Nil(t, snapshot.Meta.Sampling)
+	assert.Equal(t, int64(16*15), calls.Load())
+}
+
+func TestUpdate_SampledLargeClasses(t *testing.T) {
+	// More samples than pairs per class: everything is measured
+	var calls atomic.Int64
+	c := newSamplingTestCPUInfo(Sampling{PerClass: 1000, SpotChecks: 10}, &calls)
+	require.NoError(t, c.Update(context.Background(), 1, 1, nil))
+
+	snapshot, err := c.GetSnapshot()
+	require.NoError(t, err)
+	assert.Equal(t, 16*15, snapshot.Meta.Sampling.Measured)
+	assert.Equal(t, 0, snapshot.Meta.Sampling.SpotChecks)
+	assert.Equal(t, 0.0, snapshot.Meta.Sampling.ResidualError)
+}
+
+func TestUpdate_SampledZeroLatency(t *testing.T) {
+	var calls atomic.Int64
+	c := newSamplingTestCPUInfo(Sampling{PerClass: 4, SpotChecks: 10}, &calls)
+	c.measurer = func(_ context.Context, cpuA, cpuB, iter int) (float64, error) {
+		return 0, nil
+	}
+	require.NoError(t, c.Update(context.Background(), 1, 1, nil))
+
+	snapshot, err := c.GetSnapshot()
+	require.NoError(t, err)
+	// Spot checks without a latency have no relative error
+	assert.Equal(t, 0.0, snapshot.Meta.Sampling.ResidualError)
+	assert.Equal(t, 0.0, snapshot.Meta.Sampling.MaxError)
+}
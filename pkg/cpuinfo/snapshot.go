@@ -0,0 +1,71 @@
+package cpuinfo
+
+import "time"
+
+// RankingMeta describes how and when a ranking was created.
+// Generation increases with every stored ranking, two responses with the
+// same generation are based on the same measurement.
+type RankingMeta struct {
+	Generation    uint64               `json:"generation"`
+	CreatedAt     time.Time            `json:"created_at"`
+	Source        string               `json:"source"` // SourceTopology, SourceMeasured, SourceImported or SourceDerived
+	Kernels       []KernelWeight       `json:"kernels,omitempty"`
+	Incremental   bool                 `json:"incremental,omitempty"`
+	Rounds        int                  `json:"rounds"`
+	Iterations    int                  `json:"iterations"`
+	NUMAWeight    float64              `json:"numa_weight"`
+	Duration      time.Duration        `json:"duration_ns"`
+	Load          *HostLoad            `json:"load,omitempty"`
+	Sampling      *SamplingReport      `json:"sampling,omitempty"`
+	Normalization *NormalizationReport `json:"normalization,omitempty"`
+	Virtualized   bool                 `json:"virtualized,omitempty"`
+}
+
+// Snapshot is an immutable ranking together with the data it was built from.
+// A new snapshot is swapped in atomically on every update, callers must not
+// modify it.
+type Snapshot struct {
+	Meta     RankingMeta
+	Rankings []CoreRanking
+	Clusters []ClusterLevel // only for measured or imported latencies
+
+	topology []CoreInfo
+	matrix   LatencyMatrix
+	kernels  kernelMatrices
+}
+
+// Matrix returns the latency matrix the rankings were built from, the
+// blend of all kernel matrices (normalized if enabled).
+func (s *Snapshot) Matrix() LatencyMatrix {
+	return s.matrix
+}
+
+// KernelMatrix returns the latency matrix measured by kernel.
+func (s *Snapshot) KernelMatrix(kernel string) (LatencyMatrix, bool) {
+	m, ok := s.kernels[kernel]
+	return m, ok
+}
+
+// Selection is the set of CPUs selected for a VM, tagged with the
+// generation of the ranking it was made against.
+type Selection struct {
+	CPUs       []int  `json:"cpus"`
+	Generation uint64 `json:"generation"`
+}
+
+// load returns the current snapshot or an empty one if nothing was stored yet.
+func (c *CPUInfo) load() *Snapshot {
+	if s := c.snapshot.Load(); s != nil {
+		return s
+	}
+	return &Snapshot{}
+}
+
+// GetSnapshot returns the current ranking snapshot.
+func (c *CPUInfo) GetSnapshot() (*Snapshot, error) {
+	s := c.snapshot.Load()
+	if s == nil || len(s.Rankings) == 0 {
+		return nil, errEmptyCache
+	}
+	return s, nil
+}
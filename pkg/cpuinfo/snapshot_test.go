@@ -0,0 +1,62 @@
+package cpuinfo
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshot_Generations(t *testing.T) {
+	c := New(WithNUMAWeight(0.25)).(*CPUInfo)
+	c.detector = fallbackTopology
+	c.distances = nil
+	c.measurer = func(_ context.Context, cpuA, cpuB, iter int) (float64, error) {
+		return float64(10 + cpuA + cpuB), nil
+	}
+
+	_, err := c.GetSnapshot()
+	assert.Error(t, err, "no snapshot before the first update")
+
+	assert.NoError(t, c.UseTopologyFallback())
+	first, err := c.GetSnapshot()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), first.Meta.Generation)
+	assert.Equal(t, SourceTopology, first.Meta.Source)
+	assert.Empty(t, first.Clusters, "nominal latencies are not clustered")
+
+	sel, err := c.SelectCPUs(100, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), sel.Generation)
+	assert.Equal(t, sel, c.GetSelections()[100])
+
+	assert.NoError(t, c.Update(context.Background(), 2, 5, nil))
+	second, err := c.GetSnapshot()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), second.Meta.Generation)
+	assert.Equal(t, SourceMeasured, second.Meta.Source)
+	assert.Equal(t, 2, second.Meta.Rounds)
+	assert.Equal(t, 5, second.Meta.Iterations)
+	assert.Equal(t, 0.25, second.Meta.NUMAWeight)
+	assert.False(t, second.Meta.Incremental)
+	assert.False(t, second.Meta.CreatedAt.Before(first.Meta.CreatedAt))
+
+	// The old snapshot is not modified by the swap
+	assert.Equal(t, uint64(1), first.Meta.Generation)
+	assert.Equal(t, fallbackLatencySMT, first.Rankings[0].Ranking[0].LatencyNS)
+
+	_, err = c.SelectCPUs(101, 2)
+	assert.NoError(t, err)
+
+	// Incremental updates keep the selections and their generation
+	assert.NoError(t, c.UpdateIncremental(context.Background(), 1, 1, nil))
+	third, err := c.GetSnapshot()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(3), third.Meta.Generation)
+	assert.True(t, third.Meta.Incremental)
+	assert.Equal(t, uint64(2), c.GetSelections()[101].Generation)
+
+	rankings, err := c.GetCoreRanking()
+	assert.NoError(t, err)
+	assert.Equal(t, third.Rankings, rankings)
+}
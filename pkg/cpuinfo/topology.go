@@ -0,0 +1,119 @@
+package cpuinfo
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const sysfsCPUDir = "/sys/devices/system/cpu"
+
+// ScanTopology returns all present CPUs, including offline ones (Online is
+// false, their topology fields are -1 if the kernel hides them).
+func ScanTopology() ([]CoreInfo, error) {
+	return scanTopology(sysfsCPUDir)
+}
+
+// scanTopology reads the topology of all CPUs below cpuDir.
+func scanTopology(cpuDir string) ([]CoreInfo, error) {
+	// We look at the sysfs directly to find all present CPUs.
+	matches, err := filepath.Glob(filepath.Join(cpuDir, "cpu[0-9]*"))
+	if err != nil {
+		return nil, err
+	}
+
+	var cores []CoreInfo
+	for _, path := range matches {
+		// Extract CPU ID from path (e.g. /sys/devices/system/cpu/cpu0 -> 0)
+		i, err := strconv.Atoi(strings.TrimPrefix(filepath.Base(path), "cpu"))
+		if err != nil {
+			continue
+		}
+
+		core := CoreInfo{CPU: i, Online: true} // CPU matches the `taskset -c` ID
+
+		// 0. Online state (cpu0 usually has no online file and can't be taken offline)
+		if online, err := readSysFSInt(filepath.Join(path, "online")); err == nil && online == 0 {
+			core.Online = false
+		}
+
+		// 1. Socket ID (physical_package_id), 2. Physical Core ID (core_id), die and cluster
+		core.Socket = readTopologyID(path, "physical_package_id")
+		core.Core = readTopologyID(path, "core_id")
+		core.Die = readTopologyID(path, "die_id")
+		core.Cluster = readTopologyID(path, "cluster_id")
+		if core.Online && core.Socket < 0 {
+			slog.Warn("Failed to read the socket of an online CPU", "cpu", i)
+		}
+
+		// 3. NUMA Node
+		core.Node = detectCPUNode(path)
+
+		// 4. Caches
+		core.L2, core.L3 = readCacheIDs(path)
+
+		cores = append(cores, core)
+	}
+
+	// Ensure deterministic order
+	sort.Slice(cores, func(i, j int) bool {
+		return cores[i].CPU < cores[j].CPU
+	})
+	return cores, nil
+}
+
+// readTopologyID reads a file of the topology directory of a CPU, -1 if it
+// can't be read (e.g. offline CPU or older kernel).
+func readTopologyID(cpuPath, name string) int {
+	id, err := readSysFSInt(filepath.Join(cpuPath, "topology", name))
+	if err != nil {
+		return -1
+	}
+	return id
+}
+
+// readCacheIDs returns the IDs of the unified L2 and L3 caches of a CPU
+// (-1 if unknown). Kernels without cache/indexN/id use the lowest CPU
+// sharing the cache as ID.
+func readCacheIDs(cpuPath string) (l2, l3 int) {
+	l2, l3 = -1, -1
+	indexes, _ := filepath.Glob(filepath.Join(cpuPath, "cache", "index[0-9]*"))
+	for _, index := range indexes {
+		level, err := readSysFSInt(filepath.Join(index, "level"))
+		if err != nil || (level != 2 && level != 3) {
+			continue
+		}
+
+		id, err := readSysFSInt(filepath.Join(index, "id"))
+		if err != nil {
+			if id, err = readSharedCPU(filepath.Join(index, "shared_cpu_list")); err != nil {
+				continue
+			}
+		}
+		if level == 2 {
+			l2 = id
+		} else {
+			l3 = id
+		}
+	}
+	return l2, l3
+}
+
+// readSharedCPU returns the first CPU of a cpu list like "0-3,8-11".
+func readSharedCPU(path string) (int, error) {
+	data, err := os.ReadFile(path) // #nosec G304 -- path is built from a sysfs glob
+	if err != nil {
+		return 0, err
+	}
+	first, _, _ := strings.Cut(strings.TrimSpace(string(data)), ",")
+	first, _, _ = strings.Cut(first, "-")
+	cpu, err := strconv.Atoi(first)
+	if err != nil {
+		return 0, fmt.Errorf("invalid cpu list in %s: %w", path, err)
+	}
+	return cpu, nil
+}
@@ -0,0 +1,74 @@
+package cpuinfo
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeFakeSysfs writes files relative to root, creating the directories.
+func writeFakeSysfs(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	}
+}
+
+func TestScanTopology(t *testing.T) {
+	root := t.TempDir()
+	writeFakeSysfs(t, root, map[string]string{
+		// cpu0: no online file, cache IDs
+		"cpu0/topology/physical_package_id": "0\n",
+		"cpu0/topology/core_id":             "0\n",
+		"cpu0/topology/die_id":              "0\n",
+		"cpu0/topology/cluster_id":          "0\n",
+		"cpu0/cache/index0/level":           "1\n",
+		"cpu0/cache/index0/id":              "0\n",
+		"cpu0/cache/index2/level":           "2\n",
+		"cpu0/cache/index2/id":              "0\n",
+		"cpu0/cache/index3/level":           "3\n",
+		"cpu0/cache/index3/id":              "0\n",
+		// cpu1: older kernel without die, cluster and cache id files
+		"cpu1/online":                       "1\n",
+		"cpu1/topology/physical_package_id": "1\n",
+		"cpu1/topology/core_id":             "4\n",
+		"cpu1/cache/index2/level":           "2\n",
+		"cpu1/cache/index2/shared_cpu_list": "1,9\n",
+		"cpu1/cache/index3/level":           "3\n",
+		"cpu1/cache/index3/shared_cpu_list": "1-7,9-15\n",
+		// cpu2: offline, the kernel hides its topology
+		"cpu2/online": "0\n",
+		// not a CPU
+		"cpufreq/policy0/scaling_governor": "performance\n",
+	})
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "cpu0", "node0"), 0o755))
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "cpu1", "node1"), 0o755))
+
+	cores, err := scanTopology(root)
+	require.NoError(t, err)
+	assert.Equal(t, []CoreInfo{
+		{CPU: 0, Socket: 0, Core: 0, Node: 0, Die: 0, Cluster: 0, L2: 0, L3: 0, Online: true},
+		{CPU: 1, Socket: 1, Core: 4, Node: 1, Die: -1, Cluster: -1, L2: 1, L3: 1, Online: true},
+		{CPU: 2, Socket: -1, Core: -1, Node: -1, Die: -1, Cluster: -1, L2: -1, L3: -1, Online: false},
+	}, cores)
+}
+
+func TestReadSharedCPU(t *testing.T) {
+	root := t.TempDir()
+	for content, expected := range map[string]int{"3\n": 3, "8-15\n": 8, "2,10": 2, "4-5,12-13": 4} {
+		path := filepath.Join(root, "list")
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+		cpu, err := readSharedCPU(path)
+		require.NoError(t, err)
+		assert.Equal(t, expected, cpu, content)
+	}
+
+	require.NoError(t, os.WriteFile(filepath.Join(root, "bad"), []byte("\n"), 0o600))
+	_, err := readSharedCPU(filepath.Join(root, "bad"))
+	assert.Error(t, err)
+}
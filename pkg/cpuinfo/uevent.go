@@ -0,0 +1,64 @@
+package cpuinfo
+
+import (
+	"path"
+	"regexp"
+	"strings"
+)
+
+var cpuNameRegexp = regexp.MustCompile(`^cpu[0-9]+$`)
+
+// uevent is a parsed kernel uevent message.
+//
+// The kernel sends a header line "ACTION@DEVPATH" followed by NUL separated
+// KEY=VALUE pairs, e.g.:
+//
+//	online@/devices/system/cpu/cpu3\0ACTION=online\0DEVPATH=/devices/system/cpu/cpu3\0SUBSYSTEM=cpu\0SEQNUM=4711\0
+type uevent map[string]string
+
+// parseUevent splits a raw netlink uevent message into its key/value fields.
+// The header is ignored, as ACTION and DEVPATH are also sent as fields.
+// Messages that are not key/value encoded (e.g. libudev) yield an empty map.
+func parseUevent(msg []byte) uevent {
+	ev := make(uevent)
+	for i, field := range strings.Split(string(msg), "\x00") {
+		if i == 0 && strings.Contains(field, "@") {
+			continue
+		}
+		key, value, ok := strings.Cut(field, "=")
+		if !ok || key == "" {
+			continue
+		}
+		ev[key] = value
+	}
+	return ev
+}
+
+// cpuEvent converts a uevent into a CPUEvent.
+// The boolean is false if the uevent is not a CPU hotplug event we handle.
+func (ev uevent) cpuEvent() (CPUEvent, bool) {
+	if ev["SUBSYSTEM"] != "cpu" {
+		return CPUEvent{}, false
+	}
+
+	cpu := path.Base(ev["DEVPATH"])
+	if !cpuNameRegexp.MatchString(cpu) {
+		return CPUEvent{}, false
+	}
+
+	var action CPUAction
+	switch ev["ACTION"] {
+	case "add":
+		action = ActionAdd
+	case "remove":
+		action = ActionRemove
+	case "online":
+		action = ActionOnline
+	case "offline":
+		action = ActionOffline
+	default:
+		return CPUEvent{}, false
+	}
+
+	return CPUEvent{CPU: cpu, Action: action}, true
+}
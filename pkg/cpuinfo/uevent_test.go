@@ -0,0 +1,63 @@
+package cpuinfo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func rawUevent(header string, fields ...string) []byte {
+	msg := header
+	for _, f := range fields {
+		msg += "\x00" + f
+	}
+	return []byte(msg + "\x00")
+}
+
+func TestParseUevent(t *testing.T) {
+	ev := parseUevent(rawUevent("online@/devices/system/cpu/cpu3",
+		"ACTION=online", "DEVPATH=/devices/system/cpu/cpu3", "SUBSYSTEM=cpu", "SEQNUM=4711"))
+
+	assert.Equal(t, "online", ev["ACTION"])
+	assert.Equal(t, "/devices/system/cpu/cpu3", ev["DEVPATH"])
+	assert.Equal(t, "cpu", ev["SUBSYSTEM"])
+	assert.Equal(t, "4711", ev["SEQNUM"])
+	assert.Len(t, ev, 4)
+}
+
+func TestParseUevent_Garbage(t *testing.T) {
+	assert.Empty(t, parseUevent(nil))
+	assert.Empty(t, parseUevent([]byte("libudev\x00\xfe\xed")))
+	assert.Empty(t, parseUevent([]byte("=value\x00novalue")))
+}
+
+func TestUevent_CPUEvent(t *testing.T) {
+	tests := []struct {
+		name   string
+		msg    []byte
+		ok     bool
+		cpu    string
+		action CPUAction
+	}{
+		{"add", rawUevent("add@/devices/system/cpu/cpu4", "ACTION=add", "DEVPATH=/devices/system/cpu/cpu4", "SUBSYSTEM=cpu"), true, "cpu4", ActionAdd},
+		{"remove", rawUevent("remove@/devices/system/cpu/cpu4", "ACTION=remove", "DEVPATH=/devices/system/cpu/cpu4", "SUBSYSTEM=cpu"), true, "cpu4", ActionRemove},
+		{"online", rawUevent("online@/devices/system/cpu/cpu12", "ACTION=online", "DEVPATH=/devices/system/cpu/cpu12", "SUBSYSTEM=cpu"), true, "cpu12", ActionOnline},
+		{"offline", rawUevent("offline@/devices/system/cpu/cpu1", "ACTION=offline", "DEVPATH=/devices/system/cpu/cpu1", "SUBSYSTEM=cpu"), true, "cpu1", ActionOffline},
+		{"change is ignored", rawUevent("change@/devices/system/cpu/cpu1", "ACTION=change", "DEVPATH=/devices/system/cpu/cpu1", "SUBSYSTEM=cpu"), false, "", 0},
+		{"other subsystem", rawUevent("add@/devices/virtual/net/tap100i0", "ACTION=add", "DEVPATH=/devices/virtual/net/tap100i0", "SUBSYSTEM=net"), false, "", 0},
+		// Previously matched by strings.Contains("SUBSYSTEM=cpu")
+		{"cpufreq is not a cpu", rawUevent("add@/devices/system/cpu/cpufreq/policy0", "ACTION=add", "DEVPATH=/devices/system/cpu/cpufreq/policy0", "SUBSYSTEM=cpu"), false, "", 0},
+		{"subsystem prefix", rawUevent("add@/devices/x", "ACTION=add", "DEVPATH=/devices/system/cpu/cpu1", "SUBSYSTEM=cpuid"), false, "", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			evt, ok := parseUevent(tt.msg).cpuEvent()
+			assert.Equal(t, tt.ok, ok)
+			if tt.ok {
+				assert.Equal(t, tt.cpu, evt.CPU)
+				assert.Equal(t, tt.action, evt.Action)
+			}
+		})
+	}
+}
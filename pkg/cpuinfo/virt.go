@@ -0,0 +1,130 @@
+package cpuinfo
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/egandro/proxmox-cpu-affinity/pkg/config"
+)
+
+const dmiDir = "/sys/class/dmi/id"
+
+// dmiHypervisors maps a (lower case) substring of the DMI system vendor or
+// product name to the hypervisor it identifies.
+var dmiHypervisors = []struct {
+	match      string
+	hypervisor string
+}{
+	{"qemu", "qemu"},
+	{"kvm", "kvm"},
+	{"vmware", "vmware"},
+	{"virtualbox", "virtualbox"},
+	{"innotek", "virtualbox"},
+	{"xen", "xen"},
+	{"bochs", "bochs"},
+	{"parallels", "parallels"},
+	{"bhyve", "bhyve"},
+	{"virtual machine", "hyper-v"}, // Microsoft Corporation / Virtual Machine
+	{"amazon ec2", "aws"},
+	{"google compute engine", "gce"},
+	{"openstack", "openstack"},
+}
+
+// Virtualization describes whether this host itself runs inside a virtual
+// machine, e.g. a Proxmox VE in a nested lab.
+type Virtualization struct {
+	Virtualized    bool   `json:"virtualized"`
+	HypervisorFlag bool   `json:"hypervisor_flag"`      // "hypervisor" in the CPU flags
+	Hypervisor     string `json:"hypervisor,omitempty"` // from the DMI strings, empty if unknown
+	Vendor         string `json:"vendor,omitempty"`     // DMI system vendor
+	Product        string `json:"product,omitempty"`    // DMI product name
+}
+
+// WithVirtualization flags the rankings as made inside a virtual machine
+// (see DetectVirtualization). With derive, nothing is measured: Update
+// derives the ranking from the topology, vCPU-to-vCPU latencies depend on
+// where the hypervisor runs the vCPUs at the moment.
+func WithVirtualization(derive bool) Option {
+	return func(c *CPUInfo) {
+		c.virtualized = true
+		c.derived = derive
+	}
+}
+
+// DetectVirtualization checks the hypervisor CPU flag and the DMI strings.
+func DetectVirtualization() Virtualization {
+	return detectVirtualization(config.ConstantProcCpuInfo, dmiDir)
+}
+
+func detectVirtualization(cpuInfoPath, dmiPath string) Virtualization {
+	v := Virtualization{
+		HypervisorFlag: hasHypervisorFlag(cpuInfoPath),
+		Vendor:         readDMI(dmiPath, "sys_vendor"),
+		Product:        readDMI(dmiPath, "product_name"),
+	}
+
+	id := strings.ToLower(v.Vendor + " " + v.Product)
+	for _, h := range dmiHypervisors {
+		if strings.Contains(id, h.match) {
+			v.Hypervisor = h.hypervisor
+			break
+		}
+	}
+
+	v.Virtualized = v.HypervisorFlag || v.Hypervisor != ""
+	return v
+}
+
+// hasHypervisorFlag reports whether the flags of the first CPU in
+// /proc/cpuinfo contain "hypervisor" (set by x86 CPUs running in a VM).
+func hasHypervisorFlag(path string) bool {
+	f, err := os.Open(path) // #nosec G304 -- path is /proc/cpuinfo or a test file
+	if err != nil {
+		return false
+	}
+	defer func() { _ = f.Close() }()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok || strings.TrimSpace(key) != "flags" {
+			continue
+		}
+		return slices.Contains(strings.Fields(value), "hypervisor")
+	}
+	return false
+}
+
+// readDMI returns the trimmed content of a DMI id file, empty if missing.
+func readDMI(dir, name string) string {
+	data, err := os.ReadFile(filepath.Join(dir, name)) // #nosec G304 -- path is built from the DMI sysfs directory
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// updateDerived stores the ranking derived from the topology as the final
+// ranking. The selections made from the topology fallback stay valid, the
+// ranking is the same.
+func (c *CPUInfo) updateDerived(keepSelections bool) error {
+	start := time.Now()
+	topology, err := c.detector()
+	if err != nil {
+		return fmt.Errorf("error detecting topology: %w", err)
+	}
+
+	if isEstimate(c.load().Meta.Source) {
+		keepSelections = true
+	}
+	c.store(topology, estimateMatrix(topology), nil, keepSelections, RankingMeta{
+		Source:   SourceDerived,
+		Duration: time.Since(start),
+	})
+	return nil
+}
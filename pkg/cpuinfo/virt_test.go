@@ -0,0 +1,108 @@
+package cpuinfo
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectVirtualization(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    map[string]string
+		expected Virtualization
+	}{
+		{
+			name: "bare metal",
+			files: map[string]string{
+				"cpuinfo":          "processor\t: 0\nflags\t\t: fpu vme de pse tsc\n",
+				"dmi/sys_vendor":   "Supermicro\n",
+				"dmi/product_name": "AS -2024US-TRT\n",
+			},
+			expected: Virtualization{Vendor: "Supermicro", Product: "AS -2024US-TRT"},
+		},
+		{
+			name: "nested proxmox",
+			files: map[string]string{
+				"cpuinfo":          "processor\t: 0\nflags\t\t: fpu vme hypervisor lahf_lm\n",
+				"dmi/sys_vendor":   "QEMU\n",
+				"dmi/product_name": "Standard PC (Q35 + ICH9, 2009)\n",
+			},
+			expected: Virtualization{Virtualized: true, HypervisorFlag: true, Hypervisor: "qemu", Vendor: "QEMU", Product: "Standard PC (Q35 + ICH9, 2009)"},
+		},
+		{
+			name: "hyper-v without cpu flag",
+			files: map[string]string{
+				"cpuinfo":          "processor\t: 0\nFeatures\t: fp asimd\n",
+				"dmi/sys_vendor":   "Microsoft Corporation\n",
+				"dmi/product_name": "Virtual Machine\n",
+			},
+			expected: Virtualization{Virtualized: true, Hypervisor: "hyper-v", Vendor: "Microsoft Corporation", Product: "Virtual Machine"},
+		},
+		{
+			name: "hypervisor flag without dmi",
+			files: map[string]string{
+				"cpuinfo": "flags\t\t: fpu hypervisor\n",
+			},
+			expected: Virtualization{Virtualized: true, HypervisorFlag: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			writeFakeSysfs(t, root, tt.files)
+			v := detectVirtualization(filepath.Join(root, "cpuinfo"), filepath.Join(root, "dmi"))
+			assert.Equal(t, tt.expected, v)
+		})
+	}
+}
+
+func TestUpdate_Virtualized(t *testing.T) {
+	c := New(WithVirtualization(true)).(*CPUInfo)
+	c.detector = fallbackTopology
+	c.distances = nil
+	c.measurer = func(_ context.Context, cpuA, cpuB, iter int) (float64, error) {
+		t.Fatal("nothing is measured on a virtualized host")
+		return 0, nil
+	}
+
+	require.NoError(t, c.UseTopologyFallback())
+	_, err := c.SelectCPUs(100, 2)
+	require.NoError(t, err)
+
+	require.NoError(t, c.Update(context.Background(), 1, 1, nil))
+	status := c.Status()
+	assert.Equal(t, StateReady, status.State)
+	assert.Equal(t, SourceDerived, status.Source)
+	assert.True(t, status.Virtualized)
+
+	snapshot, err := c.GetSnapshot()
+	require.NoError(t, err)
+	assert.True(t, snapshot.Meta.Virtualized)
+	assert.Equal(t, 4, snapshot.Rankings[0].Ranking[0].CPU)
+
+	// The selection made from the fallback is kept, there is nothing to re-place
+	assert.Contains(t, c.GetSelections(), 100)
+	assert.Empty(t, c.TakeProvisional())
+
+	require.NoError(t, c.UpdateIncremental(context.Background(), 1, 1, nil))
+	assert.Equal(t, SourceDerived, c.Status().Source)
+}
+
+func TestUpdate_VirtualizedMeasured(t *testing.T) {
+	c := New(WithVirtualization(false)).(*CPUInfo)
+	c.detector = fallbackTopology
+	c.distances = nil
+	c.loadReader = nil
+	c.measurer = func(_ context.Context, cpuA, cpuB, iter int) (float64, error) {
+		return 10.0, nil
+	}
+
+	require.NoError(t, c.Update(context.Background(), 1, 1, nil))
+	assert.Equal(t, SourceMeasured, c.Status().Source)
+	assert.True(t, c.Status().Virtualized)
+}
@@ -0,0 +1,112 @@
+// Package events publishes what the service does (placements, rankings,
+// hotplug batches, failures) to the subscribers of the `watch` command.
+package events
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Types of events.
+const (
+	TypeAffinityApplied     = "affinity-applied"     // the affinity of a VM was set
+	TypeReleased            = "released"             // a VM lost its CPUs on hotplug and its selection was dropped
+	TypeRankingRecalculated = "ranking-recalculated" // a new ranking generation is in use
+	TypeHotplugBatch        = "hotplug-batch"        // the watchdog received a batch of CPU hotplug events
+	TypeError               = "error"                // a failure, Reason is one of the metrics reasons
+)
+
+// Event is a single line of the event stream.
+type Event struct {
+	Time       time.Time `json:"time"`
+	Type       string    `json:"type"`
+	VMID       int       `json:"vmid,omitempty"`
+	CPUs       []int     `json:"cpus,omitempty"`
+	Generation uint64    `json:"generation,omitempty"`
+	Source     string    `json:"source,omitempty"`
+	Reason     string    `json:"reason,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// SubscriberBuffer is the number of events buffered per subscriber, events
+// for a subscriber that does not keep up are dropped.
+const SubscriberBuffer = 64
+
+// Bus fans out events to its subscribers. Publishing never blocks.
+type Bus struct {
+	mu          sync.Mutex
+	subscribers map[chan Event]struct{}
+	lastFailure *Event
+}
+
+// NewBus returns a bus without subscribers.
+func NewBus() *Bus {
+	return &Bus{subscribers: make(map[chan Event]struct{})}
+}
+
+// Subscribe returns a channel receiving all events published from now on.
+// cancel unsubscribes and closes the channel.
+func (b *Bus) Subscribe() (ch <-chan Event, cancel func()) {
+	c := make(chan Event, SubscriberBuffer)
+	b.mu.Lock()
+	b.subscribers[c] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	return c, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subscribers, c)
+			b.mu.Unlock()
+			close(c)
+		})
+	}
+}
+
+// Publish sends e to all subscribers, the time is set if it is zero.
+func (b *Bus) Publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if e.Type == TypeError {
+		b.lastFailure = &e
+	}
+	for c := range b.subscribers {
+		select {
+		case c <- e:
+		default:
+			slog.Debug("Event subscriber is too slow, dropping event", "type", e.Type)
+		}
+	}
+}
+
+// LastFailure returns the last error event, nil if there was none.
+func (b *Bus) LastFailure() *Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.lastFailure == nil {
+		return nil
+	}
+	e := *b.lastFailure
+	return &e
+}
+
+// Default is the bus of the service.
+var Default = NewBus()
+
+// Publish sends e to the subscribers of the Default bus.
+func Publish(e Event) {
+	Default.Publish(e)
+}
+
+// Failure publishes an error event, vmid is 0 if the failure is not related to a VM.
+func Failure(reason string, vmid int, err error) {
+	e := Event{Type: TypeError, VMID: vmid, Reason: reason}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	Default.Publish(e)
+}
@@ -0,0 +1,69 @@
+package events
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBus_PublishSubscribe(t *testing.T) {
+	b := NewBus()
+	b.Publish(Event{Type: TypeReleased, VMID: 99}) // no subscribers
+
+	ch1, cancel1 := b.Subscribe()
+	ch2, cancel2 := b.Subscribe()
+	defer cancel2()
+
+	b.Publish(Event{Type: TypeAffinityApplied, VMID: 100, CPUs: []int{0, 1}})
+	e := <-ch1
+	assert.Equal(t, TypeAffinityApplied, e.Type)
+	assert.Equal(t, 100, e.VMID)
+	assert.False(t, e.Time.IsZero())
+	assert.Equal(t, e, <-ch2)
+
+	cancel1()
+	cancel1() // idempotent
+	_, ok := <-ch1
+	assert.False(t, ok)
+
+	b.Publish(Event{Type: TypeReleased, VMID: 100})
+	assert.Equal(t, TypeReleased, (<-ch2).Type)
+}
+
+func TestBus_SlowSubscriber(t *testing.T) {
+	b := NewBus()
+	ch, cancel := b.Subscribe()
+	defer cancel()
+
+	for i := 0; i < SubscriberBuffer+10; i++ {
+		b.Publish(Event{Type: TypeHotplugBatch, CPUs: []int{i}})
+	}
+	assert.Len(t, ch, SubscriberBuffer)
+	assert.Equal(t, []int{0}, (<-ch).CPUs)
+}
+
+func TestFailure(t *testing.T) {
+	ch, cancel := Default.Subscribe()
+	defer cancel()
+
+	Failure("vm-pid", 100, errors.New("no such process"))
+	e := <-ch
+	assert.Equal(t, TypeError, e.Type)
+	assert.Equal(t, "vm-pid", e.Reason)
+	assert.Equal(t, 100, e.VMID)
+	assert.Equal(t, "no such process", e.Error)
+}
+
+func TestBus_LastFailure(t *testing.T) {
+	b := NewBus()
+	assert.Nil(t, b.LastFailure())
+
+	b.Publish(Event{Type: TypeError, Reason: "vm-pid", VMID: 100})
+	b.Publish(Event{Type: TypeAffinityApplied, VMID: 101})
+	failure := b.LastFailure()
+	require.NotNil(t, failure)
+	assert.Equal(t, "vm-pid", failure.Reason)
+	assert.False(t, failure.Time.IsZero())
+}
@@ -0,0 +1,62 @@
+// Package health describes the state of the service reported by the `health`
+// command, so monitoring can tell a degraded service from a healthy one.
+package health
+
+import (
+	"time"
+
+	"github.com/egandro/proxmox-cpu-affinity/pkg/cpuinfo"
+	"github.com/egandro/proxmox-cpu-affinity/pkg/events"
+)
+
+// States of the service.
+const (
+	StateReady     = cpuinfo.StateReady     // the measured (or imported, derived) ranking is in use
+	StateWarmingUp = cpuinfo.StateWarmingUp // VMs are placed by topology until the measurement is done
+	StateFailed    = "failed"               // there is no ranking or the last calculation failed
+)
+
+// Health is the report of the health command.
+type Health struct {
+	State    string   `json:"state"`
+	Healthy  bool     `json:"healthy"` // ready without problems
+	Problems []string `json:"problems,omitempty"`
+
+	Ranking           *cpuinfo.RankingMeta  `json:"ranking,omitempty"` // nil if there is no ranking
+	RankingAgeSeconds float64               `json:"ranking_age_seconds,omitempty"`
+	Calculation       cpuinfo.RankingStatus `json:"calculation"`
+	// measurement parameters of the next calculation
+	Rounds     int `json:"rounds"`
+	Iterations int `json:"iterations"`
+
+	HotplugWatchdog bool          `json:"hotplug_watchdog"` // the watchdog is running
+	ManagedVMs      int           `json:"managed_vms"`      // VMs with selected CPUs
+	LastError       *events.Event `json:"last_error,omitempty"`
+	StartedAt       time.Time     `json:"started_at"`
+	UptimeSeconds   float64       `json:"uptime_seconds"`
+	Version         string        `json:"version"`
+}
+
+// Evaluate sets State, Healthy and Problems from the other fields,
+// watchdogEnabled is set if the hotplug watchdog is configured to run.
+func (h *Health) Evaluate(watchdogEnabled bool) {
+	h.Problems = nil
+	switch {
+	case h.Ranking == nil:
+		h.State = StateFailed
+		h.Problems = append(h.Problems, "no ranking available")
+	case h.Calculation.Error != "":
+		h.State = StateFailed
+		h.Problems = append(h.Problems, "last ranking calculation failed: "+h.Calculation.Error)
+	case h.Calculation.State == cpuinfo.StateWarmingUp:
+		h.State = StateWarmingUp
+	default:
+		h.State = StateReady
+	}
+
+	// The watchdog is started once the initial calculation is done
+	if watchdogEnabled && !h.HotplugWatchdog && h.State != StateWarmingUp {
+		h.Problems = append(h.Problems, "CPU hotplug watchdog is not running")
+	}
+	h.Healthy = h.State == StateReady && len(h.Problems) == 0
+}
@@ -0,0 +1,71 @@
+package health
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/egandro/proxmox-cpu-affinity/pkg/cpuinfo"
+)
+
+func TestEvaluate(t *testing.T) {
+	meta := &cpuinfo.RankingMeta{Generation: 2, Source: cpuinfo.SourceMeasured}
+
+	tests := []struct {
+		name            string
+		health          Health
+		watchdogEnabled bool
+		state           string
+		healthy         bool
+		problems        []string
+	}{
+		{
+			name:            "ready",
+			health:          Health{Ranking: meta, Calculation: cpuinfo.RankingStatus{State: cpuinfo.StateReady}, HotplugWatchdog: true},
+			watchdogEnabled: true,
+			state:           StateReady,
+			healthy:         true,
+		},
+		{
+			name:    "watchdog disabled",
+			health:  Health{Ranking: meta, Calculation: cpuinfo.RankingStatus{State: cpuinfo.StateReady}},
+			state:   StateReady,
+			healthy: true,
+		},
+		{
+			name:            "warming up, the watchdog starts later",
+			health:          Health{Ranking: meta, Calculation: cpuinfo.RankingStatus{State: cpuinfo.StateWarmingUp}},
+			watchdogEnabled: true,
+			state:           StateWarmingUp,
+		},
+		{
+			name:            "watchdog not running",
+			health:          Health{Ranking: meta, Calculation: cpuinfo.RankingStatus{State: cpuinfo.StateReady}},
+			watchdogEnabled: true,
+			state:           StateReady,
+			problems:        []string{"CPU hotplug watchdog is not running"},
+		},
+		{
+			name:     "calculation failed",
+			health:   Health{Ranking: meta, Calculation: cpuinfo.RankingStatus{State: cpuinfo.StateReady, Error: "timed out"}},
+			state:    StateFailed,
+			problems: []string{"last ranking calculation failed: timed out"},
+		},
+		{
+			name:     "no ranking",
+			health:   Health{Calculation: cpuinfo.RankingStatus{State: cpuinfo.StateWarmingUp}},
+			state:    StateFailed,
+			problems: []string{"no ranking available"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := tt.health
+			h.Evaluate(tt.watchdogEnabled)
+			assert.Equal(t, tt.state, h.State)
+			assert.Equal(t, tt.healthy, h.Healthy)
+			assert.Equal(t, tt.problems, h.Problems)
+		})
+	}
+}
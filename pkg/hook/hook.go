@@ -1,13 +1,14 @@
 package hook
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"io"
-	"net"
 	"os"
 	"time"
 
+	"github.com/egandro/proxmox-cpu-affinity/pkg/client"
 	"github.com/egandro/proxmox-cpu-affinity/pkg/config"
 )
 
@@ -76,10 +77,9 @@ func (h *hook) Handle(vmid int, phase string) error {
 // OnPreStart is executed before the guest is started.
 // Exiting with a code != 0 will abort the start.
 func (h *handler) OnPreStart(vmid int) error {
-	// Ping the server and delay the start of the VM.
-	// The server might be in its calculation loop.
-	// Performing this delay during pre-start ensures we are in an environment
-	// where the system is not running many Qemu processes yet.
+	// Ping the server and delay the start of the VM until the socket is up.
+	// While the ranking is still measured the server answers right away, the
+	// VM is placed from the topology and re-placed later.
 	if h.Config.SocketPingOnPreStart {
 		if err := h.callService("ping", vmid); err != nil {
 			_, _ = fmt.Fprintf(h.Output, "Warning: Service not reachable: %v\n", err)
@@ -106,50 +106,28 @@ func (h *handler) OnPostStop(vmid int) error {
 	return nil
 }
 
+// callService sends command to the service, retrying while it is not reachable
+// or answers with an error (e.g. the VM process is not up yet).
+// During the warm-up the affinity is applied from the topology-only ranking,
+// the service re-places the VM once it is measured.
 func (h *handler) callService(command string, vmid int) error {
+	sleep := time.Duration(h.Config.SocketSleep) * time.Second
+	c := client.New(h.Config.SocketFile,
+		client.WithTimeout(time.Duration(h.Config.SocketTimeout)*time.Second),
+		client.WithRetry(h.Config.SocketRetry, sleep, sleep),
+	)
+
 	var err error
 	for i := 0; i <= h.Config.SocketRetry; i++ {
 		if i > 0 {
-			time.Sleep(time.Duration(h.Config.SocketSleep) * time.Second)
+			time.Sleep(sleep)
 		}
-
-		err = func() error {
-			timeout := time.Duration(h.Config.SocketTimeout) * time.Second
-			conn, err := net.DialTimeout("unix", h.Config.SocketFile, timeout)
-			if err != nil {
-				return err
-			}
-			defer func() {
-				_ = conn.Close()
-			}()
-
-			_ = conn.SetDeadline(time.Now().Add(timeout))
-
-			req := map[string]interface{}{
-				"command": command,
-				"vmid":    vmid,
-			}
-			if err := json.NewEncoder(conn).Encode(req); err != nil {
-				return err
-			}
-
-			var resp struct {
-				Status string `json:"status"`
-				Error  string `json:"error"`
-			}
-			if err := json.NewDecoder(conn).Decode(&resp); err != nil {
-				return err
-			}
-
-			if resp.Status != "ok" {
-				return fmt.Errorf("service returned error: %s", resp.Error)
-			}
-			return nil
-		}()
-
-		if err == nil {
-			return nil
+		_, err = c.Do(context.Background(), client.Request{Command: command, VMID: vmid})
+		var serviceErr *client.ServiceError
+		if !errors.As(err, &serviceErr) {
+			// Success, or the client gave up reaching the service
+			return err
 		}
 	}
-	return err
+	return fmt.Errorf("service returned error: %w", err)
 }
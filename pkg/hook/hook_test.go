@@ -2,8 +2,12 @@ package hook
 
 import (
 	"bytes"
+	"encoding/json"
+	"net"
+	"path/filepath"
 	"testing"
 
+	"github.com/egandro/proxmox-cpu-affinity/pkg/client"
 	"github.com/egandro/proxmox-cpu-affinity/pkg/config"
 	"github.com/stretchr/testify/assert"
 )
@@ -108,3 +112,83 @@ func TestHook_Handle_Dispatch(t *testing.T) {
 		})
 	}
 }
+
+// serveStatuses answers one socket request per status, in order.
+func serveStatuses(t *testing.T, statuses ...string) string {
+	socketPath := filepath.Join(t.TempDir(), "hook-test.sock")
+	listener, err := net.Listen("unix", socketPath)
+	assert.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		for _, status := range statuses {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			var req map[string]interface{}
+			_ = json.NewDecoder(conn).Decode(&req)
+			_ = json.NewEncoder(conn).Encode(map[string]string{"status": status, "error": "failed"})
+			_ = conn.Close()
+		}
+	}()
+	return socketPath
+}
+
+func TestHandler_CallService_Status(t *testing.T) {
+	tests := []struct {
+		status  string
+		wantErr bool
+	}{
+		{"ok", false},
+		{"error", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.status, func(t *testing.T) {
+			h := &handler{
+				Output: &bytes.Buffer{},
+				Config: &config.Config{
+					SocketFile:    serveStatuses(t, tt.status),
+					SocketTimeout: 1,
+				},
+			}
+
+			err := h.callService("update-affinity", 100)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestHandler_CallService_RetryError(t *testing.T) {
+	h := &handler{
+		Output: &bytes.Buffer{},
+		Config: &config.Config{
+			SocketFile:    serveStatuses(t, "error", "ok"),
+			SocketTimeout: 1,
+			SocketRetry:   1,
+		},
+	}
+	assert.NoError(t, h.callService("update-affinity", 100))
+
+	h.Config.SocketFile = serveStatuses(t, "error", "error")
+	assert.ErrorContains(t, h.callService("update-affinity", 100), "service returned error: failed")
+}
+
+func TestHandler_CallService_Unavailable(t *testing.T) {
+	h := &handler{
+		Output: &bytes.Buffer{},
+		Config: &config.Config{
+			SocketFile:    filepath.Join(t.TempDir(), "missing.sock"),
+			SocketTimeout: 1,
+			SocketRetry:   2,
+		},
+	}
+
+	err := h.callService("ping", 100)
+	assert.ErrorIs(t, err, client.ErrUnavailable)
+}
@@ -0,0 +1,283 @@
+// Package metrics implements the counters and histograms of the service and
+// writes them in the Prometheus text exposition format (version 0.0.4).
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType is the content type of the text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets are the upper bounds (in seconds) of the request duration histograms.
+var DefaultBuckets = []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5}
+
+// metric is a counter or histogram of a Registry.
+type metric interface {
+	write(w *Writer)
+}
+
+// Registry holds the counters and histograms that are written on every scrape.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = append(r.metrics, m)
+}
+
+// Write writes all metrics of the registry, then collect adds the gauges
+// that are computed at scrape time (collect may be nil).
+func (r *Registry) Write(out io.Writer, collect func(*Writer)) error {
+	w := &Writer{w: bufio.NewWriter(out)}
+	r.mu.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.mu.Unlock()
+
+	for _, m := range metrics {
+		m.write(w)
+	}
+	if collect != nil {
+		collect(w)
+	}
+	return w.w.Flush()
+}
+
+// Label is a label of a gauge sample.
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Writer writes metric families in the text format.
+type Writer struct {
+	w *bufio.Writer
+}
+
+// Gauge writes a gauge with a single sample without labels.
+func (w *Writer) Gauge(name, help string, value float64) {
+	w.header(name, help, "gauge")
+	w.sample(name, nil, value)
+}
+
+// GaugeSample is a sample of a gauge with labels.
+type GaugeSample struct {
+	Labels []Label
+	Value  float64
+}
+
+// GaugeVec writes a gauge with labeled samples, nothing if there are none.
+func (w *Writer) GaugeVec(name, help string, samples []GaugeSample) {
+	if len(samples) == 0 {
+		return
+	}
+	w.header(name, help, "gauge")
+	for _, s := range samples {
+		w.sample(name, s.Labels, s.Value)
+	}
+}
+
+func (w *Writer) header(name, help, typ string) {
+	fmt.Fprintf(w.w, "# HELP %s %s\n# TYPE %s %s\n", name, strings.ReplaceAll(help, "\n", " "), name, typ)
+}
+
+func (w *Writer) sample(name string, labels []Label, value float64) {
+	_, _ = w.w.WriteString(name)
+	if len(labels) > 0 {
+		_ = w.w.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				_ = w.w.WriteByte(',')
+			}
+			fmt.Fprintf(w.w, "%s=\"%s\"", l.Name, escapeLabel(l.Value))
+		}
+		_ = w.w.WriteByte('}')
+	}
+	fmt.Fprintf(w.w, " %s\n", formatValue(value))
+}
+
+func escapeLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// labelKey joins label values, the separator can't occur in valid UTF-8.
+func labelKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+// sortedKeys returns the keys of m in order, so the output is stable.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// zipLabels pairs label names with the values of a key.
+func zipLabels(names []string, key string) []Label {
+	if len(names) == 0 {
+		return nil
+	}
+	values := strings.Split(key, "\xff")
+	labels := make([]Label, len(names))
+	for i, name := range names {
+		labels[i] = Label{Name: name, Value: values[i]}
+	}
+	return labels
+}
+
+// CounterVec is a counter with a fixed set of label names (none for a plain counter).
+type CounterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// NewCounterVec registers a counter in r.
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{name: name, help: help, labels: labels, values: make(map[string]float64)}
+	r.register(c)
+	return c
+}
+
+// Inc adds 1 to the counter of the label values.
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds v to the counter of the label values, they must match the label names.
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	if len(labelValues) != len(c.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", c.name, len(c.labels), len(labelValues)))
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[labelKey(labelValues)] += v
+}
+
+// Value returns the counter of the label values.
+func (c *CounterVec) Value(labelValues ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[labelKey(labelValues)]
+}
+
+func (c *CounterVec) write(w *Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	w.header(c.name, c.help, "counter")
+	if len(c.labels) == 0 {
+		w.sample(c.name, nil, c.values[""])
+		return
+	}
+	for _, key := range sortedKeys(c.values) {
+		w.sample(c.name, zipLabels(c.labels, key), c.values[key])
+	}
+}
+
+// HistogramVec is a histogram with a fixed set of label names.
+type HistogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	values map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+// NewHistogramVec registers a histogram with the bucket upper bounds (ascending) in r.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{name: name, help: help, labels: labels, buckets: buckets, values: make(map[string]*histogram)}
+	r.register(h)
+	return h
+}
+
+// Observe adds a value to the histogram of the label values.
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	if len(labelValues) != len(h.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", h.name, len(h.labels), len(labelValues)))
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	key := labelKey(labelValues)
+	hist, ok := h.values[key]
+	if !ok {
+		hist = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.values[key] = hist
+	}
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		hist.counts[i]++
+	}
+	hist.count++
+	hist.sum += v
+}
+
+// Count returns the number of observations of the label values.
+func (h *HistogramVec) Count(labelValues ...string) uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if hist, ok := h.values[labelKey(labelValues)]; ok {
+		return hist.count
+	}
+	return 0
+}
+
+func (h *HistogramVec) write(w *Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	w.header(h.name, h.help, "histogram")
+	for _, key := range sortedKeys(h.values) {
+		hist := h.values[key]
+		labels := zipLabels(h.labels, key)
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += hist.counts[i]
+			w.sample(h.name+"_bucket", append(labels[:len(labels):len(labels)], Label{"le", formatValue(bound)}), float64(cumulative))
+		}
+		w.sample(h.name+"_bucket", append(labels[:len(labels):len(labels)], Label{"le", "+Inf"}), float64(hist.count))
+		w.sample(h.name+"_sum", labels, hist.sum)
+		w.sample(h.name+"_count", labels, float64(hist.count))
+	}
+}
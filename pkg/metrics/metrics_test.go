@@ -0,0 +1,65 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry_Write(t *testing.T) {
+	r := NewRegistry()
+	plain := r.NewCounterVec("test_applied_total", "Applied.")
+	failures := r.NewCounterVec("test_failures_total", "Failures\nby reason.", "reason")
+	duration := r.NewHistogramVec("test_duration_seconds", "Duration.", []float64{0.1, 1}, "command")
+
+	plain.Inc()
+	failures.Inc("vm-pid")
+	failures.Add(2, `bad "quoted"`)
+	duration.Observe(0.05, "ping")
+	duration.Observe(0.5, "ping")
+	duration.Observe(3, "ping")
+
+	var buf bytes.Buffer
+	require.NoError(t, r.Write(&buf, func(w *Writer) {
+		w.Gauge("test_ready", "Ready.", 1)
+		w.GaugeVec("test_cpu_vms", "VMs per CPU.", []GaugeSample{
+			{Labels: []Label{{Name: "cpu", Value: "0"}}, Value: 2},
+			{Labels: []Label{{Name: "cpu", Value: "1"}}, Value: 0},
+		})
+		w.GaugeVec("test_empty", "Not written.", nil)
+	}))
+
+	assert.Equal(t, `# HELP test_applied_total Applied.
+# TYPE test_applied_total counter
+test_applied_total 1
+# HELP test_failures_total Failures by reason.
+# TYPE test_failures_total counter
+test_failures_total{reason="bad \"quoted\""} 2
+test_failures_total{reason="vm-pid"} 1
+# HELP test_duration_seconds Duration.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{command="ping",le="0.1"} 1
+test_duration_seconds_bucket{command="ping",le="1"} 2
+test_duration_seconds_bucket{command="ping",le="+Inf"} 3
+test_duration_seconds_sum{command="ping"} 3.55
+test_duration_seconds_count{command="ping"} 3
+# HELP test_ready Ready.
+# TYPE test_ready gauge
+test_ready 1
+# HELP test_cpu_vms VMs per CPU.
+# TYPE test_cpu_vms gauge
+test_cpu_vms{cpu="0"} 2
+test_cpu_vms{cpu="1"} 0
+`, buf.String())
+
+	assert.Equal(t, 1.0, failures.Value("vm-pid"))
+	assert.Equal(t, uint64(3), duration.Count("ping"))
+	assert.Equal(t, uint64(0), duration.Count("other"))
+}
+
+func TestCounterVec_LabelMismatch(t *testing.T) {
+	c := NewRegistry().NewCounterVec("test_total", "Test.", "reason")
+	assert.Panics(t, func() { c.Inc() })
+}
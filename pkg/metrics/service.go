@@ -0,0 +1,31 @@
+package metrics
+
+// Default is the registry of the service metrics.
+var Default = NewRegistry()
+
+// Metrics of the service. Gauges derived from the ranking and the selections
+// are computed at scrape time by the service.
+var (
+	HotplugEvents = Default.NewCounterVec("pca_hotplug_events_total",
+		"CPU hotplug events received by the watchdog (uevents and reconciliation).", "action")
+	AffinityApplied = Default.NewCounterVec("pca_affinity_applied_total",
+		"CPU affinities applied to VMs.")
+	Failures = Default.NewCounterVec("pca_failures_total",
+		"Failures by reason.", "reason")
+	SocketRequests = Default.NewCounterVec("pca_socket_requests_total",
+		"Requests served on the socket and the HTTP API by command and status.", "command", "status")
+	SocketRequestDuration = Default.NewHistogramVec("pca_socket_request_duration_seconds",
+		"Duration of the requests by command.", DefaultBuckets, "command")
+)
+
+// Reasons of Failures.
+const (
+	ReasonVMConfig     = "vm-config"      // reading the VM configuration failed
+	ReasonVMPid        = "vm-pid"         // reading the VM PID failed
+	ReasonVMNotRunning = "vm-not-running" // affinity requested for a stopped VM
+	ReasonSelectCPUs   = "select-cpus"    // no CPUs could be selected, the affinity is skipped
+	ReasonSetAffinity  = "set-affinity"   // sched_setaffinity failed for a thread
+	ReasonRanking      = "ranking"        // the ranking calculation failed (also after a hotplug event)
+	ReasonReapply      = "reapply"        // re-applying the affinity after a hotplug event failed
+	ReasonBadRequest   = "bad-request"    // a request could not be decoded
+)
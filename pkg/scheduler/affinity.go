@@ -9,12 +9,15 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/egandro/proxmox-cpu-affinity/pkg/audit"
 	"github.com/egandro/proxmox-cpu-affinity/pkg/config"
 	"github.com/egandro/proxmox-cpu-affinity/pkg/cpuinfo"
+	"github.com/egandro/proxmox-cpu-affinity/pkg/events"
+	"github.com/egandro/proxmox-cpu-affinity/pkg/metrics"
 	"github.com/egandro/proxmox-cpu-affinity/pkg/proxmox"
 )
 
-// CPUSet and schedSetaffinity are defined in affinity_linux.go for Linux
+// CPUSet, schedSetaffinity and schedGetaffinity are defined in affinity_linux.go for Linux
 // and affinity_other.go for other platforms.
 
 // affinityProvider defines the internal interface for affinity operations.
@@ -24,22 +27,31 @@ type affinityProvider interface {
 
 type cpuInfoProvider interface {
 	GetCoreRanking() ([]cpuinfo.CoreRanking, error)
-	SelectCPUs(vmid int, requestedCPUs int) ([]int, error)
+	SelectCPUs(vmid int, requestedCPUs int) (cpuinfo.Selection, error)
+	GetSnapshot() (*cpuinfo.Snapshot, error)
 }
 
 // SystemAffinityOps defines an interface for system-level affinity operations.
 type SystemAffinityOps interface {
 	SchedSetaffinity(pid int, mask *CPUSet) error
+	SchedGetaffinity(pid int, mask *CPUSet) error
 	GetProcessThreads(pid int) ([]int, error)
 	GetChildProcesses(pid int) ([]int, error)
 }
 
+// maxCPUSetSize is the number of CPUs a CPUSet holds.
+const maxCPUSetSize = 1024
+
 type defaultSystemAffinityOps struct{}
 
 func (s *defaultSystemAffinityOps) SchedSetaffinity(pid int, mask *CPUSet) error {
 	return schedSetaffinity(pid, mask)
 }
 
+func (s *defaultSystemAffinityOps) SchedGetaffinity(pid int, mask *CPUSet) error {
+	return schedGetaffinity(pid, mask)
+}
+
 func (s *defaultSystemAffinityOps) GetProcessThreads(pid int) ([]int, error) {
 	entries, err := os.ReadDir(fmt.Sprintf("/proc/%d/task", pid))
 	if err != nil {
@@ -90,17 +102,41 @@ func newAffinityProvider(cfg *config.Config, cpuInfo cpuInfoProvider) affinityPr
 
 func (a *defaultAffinityProvider) ApplyAffinity(_ context.Context, vmid int, pid int, config *proxmox.VmConfig) (string, error) {
 	count := config.Cores * config.Sockets
+	entry := audit.Entry{VMID: vmid, RequestedCPUs: count}
 	if count == 0 {
-		return "", fmt.Errorf("invalid VM configuration: cores * sockets is 0")
+		err := fmt.Errorf("invalid VM configuration: cores * sockets is 0")
+		entry.Result = audit.ResultFailed
+		entry.Error = err.Error()
+		audit.Record(entry)
+		return "", err
 	}
 
 	// SelectCPUs is thread-safe when cpu hotplug updates are running
-	cpus, err := a.cpuInfo.SelectCPUs(vmid, count)
+	sel, err := a.cpuInfo.SelectCPUs(vmid, count)
 	if err != nil {
 		slog.Warn("Skipping affinity", "vmid", vmid, "reason", err)
+		metrics.Failures.Inc(metrics.ReasonSelectCPUs)
+		events.Failure(metrics.ReasonSelectCPUs, vmid, err)
+		entry.Result = audit.ResultSkipped
+		entry.Reason = metrics.ReasonSelectCPUs
+		entry.Error = err.Error()
+		audit.Record(entry)
 		return "", nil
 	}
 
+	cpus := sel.CPUs
+	entry.CPUs = cpus
+	entry.PrimaryCPU = &cpus[0]
+	// A kept selection may be older than the current ranking
+	entry.Generation = sel.Generation
+	if snapshot, err := a.cpuInfo.GetSnapshot(); err == nil && snapshot.Meta.Generation == sel.Generation {
+		entry.RankingCreatedAt = &snapshot.Meta.CreatedAt
+	}
+	var previous CPUSet
+	if err := a.sys.SchedGetaffinity(pid, &previous); err == nil {
+		entry.PreviousMask = formatCPUSet(&previous)
+	}
+
 	var res []string
 	var mask CPUSet
 
@@ -115,20 +151,67 @@ func (a *defaultAffinityProvider) ApplyAffinity(_ context.Context, vmid int, pid
 	pidsToUpdate := a.collectPidsToUpdate(pid)
 
 	allPids := make([]int, 0, len(pidsToUpdate))
+	var failed []int
+	var lastErr error
 	for targetPID := range pidsToUpdate {
 		allPids = append(allPids, targetPID)
 		if err := a.sys.SchedSetaffinity(targetPID, &mask); err != nil {
 			slog.Error("Failed to set process affinity", "vmid", vmid, "pid", targetPID, "error", err)
+			metrics.Failures.Inc(metrics.ReasonSetAffinity)
+			events.Failure(metrics.ReasonSetAffinity, vmid, err)
+			failed = append(failed, targetPID)
+			lastErr = err
 			// We continue trying other threads even if one fails
 		}
 	}
 	sort.Ints(allPids)
+	sort.Ints(failed)
 
 	affinityStr := strings.Join(res, ",")
-	slog.Info("Successfully applied affinity", "vmid", vmid, "main_pid", pid, "tids", allPids, "affinity", affinityStr)
+	switch {
+	case len(failed) == 0:
+		slog.Info("Successfully applied affinity", "vmid", vmid, "main_pid", pid, "tids", allPids, "affinity", affinityStr)
+		metrics.AffinityApplied.Inc()
+		events.Publish(events.Event{Type: events.TypeAffinityApplied, VMID: vmid, CPUs: cpus})
+		entry.Result = audit.ResultApplied
+	case len(failed) == len(allPids):
+		entry.Result = audit.ResultFailed
+		entry.Reason = metrics.ReasonSetAffinity
+		entry.Error = lastErr.Error()
+		entry.FailedThreads = failed
+	default:
+		// Threads may exit while the affinity is set, the others are pinned
+		slog.Warn("Partially applied affinity", "vmid", vmid, "main_pid", pid, "tids", allPids, "failed_tids", failed, "affinity", affinityStr)
+		entry.Result = audit.ResultPartial
+		entry.Reason = metrics.ReasonSetAffinity
+		entry.Error = lastErr.Error()
+		entry.FailedThreads = failed
+	}
+	audit.Record(entry)
 	return affinityStr, nil
 }
 
+// formatCPUSet formats mask as a CPU list like taskset, e.g. "0-3,8".
+func formatCPUSet(mask *CPUSet) string {
+	var parts []string
+	for cpu := 0; cpu < maxCPUSetSize; cpu++ {
+		if !mask.IsSet(cpu) {
+			continue
+		}
+		last := cpu
+		for last+1 < maxCPUSetSize && mask.IsSet(last+1) {
+			last++
+		}
+		if last == cpu {
+			parts = append(parts, strconv.Itoa(cpu))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d-%d", cpu, last))
+		}
+		cpu = last
+	}
+	return strings.Join(parts, ",")
+}
+
 func (a *defaultAffinityProvider) collectPidsToUpdate(pid int) map[int]struct{} {
 	pidsToUpdate := make(map[int]struct{})
 
@@ -11,3 +11,8 @@ type CPUSet = unix.CPUSet
 func schedSetaffinity(pid int, mask *CPUSet) error {
 	return unix.SchedSetaffinity(pid, mask)
 }
+
+// schedGetaffinity wraps the Linux sched_getaffinity syscall.
+func schedGetaffinity(pid int, mask *CPUSet) error {
+	return unix.SchedGetaffinity(pid, mask)
+}
@@ -29,3 +29,8 @@ func (s *CPUSet) IsSet(cpu int) bool {
 func schedSetaffinity(pid int, mask *CPUSet) error {
 	return errors.New("CPU affinity is only supported on Linux")
 }
+
+// schedGetaffinity is a stub that returns an error on non-Linux platforms.
+func schedGetaffinity(pid int, mask *CPUSet) error {
+	return errors.New("CPU affinity is only supported on Linux")
+}
@@ -3,11 +3,15 @@ package scheduler
 import (
 	"context"
 	"errors"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 
+	"github.com/egandro/proxmox-cpu-affinity/pkg/audit"
 	"github.com/egandro/proxmox-cpu-affinity/pkg/config"
 	"github.com/egandro/proxmox-cpu-affinity/pkg/cpuinfo"
 	"github.com/egandro/proxmox-cpu-affinity/pkg/proxmox"
@@ -31,9 +35,17 @@ func (m *MockCpuInfoProvider) GetCoreRanking() ([]cpuinfo.CoreRanking, error) {
 	return args.Get(0).([]cpuinfo.CoreRanking), args.Error(1)
 }
 
-func (m *MockCpuInfoProvider) SelectCPUs(vmid int, requestedCPUs int) ([]int, error) {
+func (m *MockCpuInfoProvider) SelectCPUs(vmid int, requestedCPUs int) (cpuinfo.Selection, error) {
 	args := m.Called(vmid, requestedCPUs)
-	return args.Get(0).([]int), args.Error(1)
+	return args.Get(0).(cpuinfo.Selection), args.Error(1)
+}
+
+func (m *MockCpuInfoProvider) GetSnapshot() (*cpuinfo.Snapshot, error) {
+	args := m.Called()
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*cpuinfo.Snapshot), args.Error(1)
 }
 
 // MockSystemAffinityOps mocks the SystemAffinityOps interface.
@@ -46,6 +58,11 @@ func (m *MockSystemAffinityOps) SchedSetaffinity(pid int, mask *CPUSet) error {
 	return args.Error(0)
 }
 
+func (m *MockSystemAffinityOps) SchedGetaffinity(pid int, mask *CPUSet) error {
+	args := m.Called(pid, mask)
+	return args.Error(0)
+}
+
 func (m *MockSystemAffinityOps) GetProcessThreads(pid int) ([]int, error) {
 	args := m.Called(pid)
 	if args.Get(0) == nil {
@@ -84,9 +101,11 @@ func TestApplyAffinity(t *testing.T) {
 			},
 			expectedRes: "1,0",
 			setupMockCpu: func(m *MockCpuInfoProvider) {
-				m.On("SelectCPUs", 100, 2).Return([]int{1, 0}, nil)
+				m.On("SelectCPUs", 100, 2).Return(cpuinfo.Selection{CPUs: []int{1, 0}}, nil)
+				m.On("GetSnapshot").Return(&cpuinfo.Snapshot{}, nil)
 			},
 			setupMockSys: func(m *MockSystemAffinityOps) {
+				m.On("SchedGetaffinity", 12345, mock.Anything).Return(nil)
 				m.On("GetProcessThreads", 12345).Return([]int{12345}, nil)
 				m.On("GetChildProcesses", 12345).Return([]int{}, nil)
 				m.On("SchedSetaffinity", 12345, mock.MatchedBy(func(mask *CPUSet) bool {
@@ -113,7 +132,7 @@ func TestApplyAffinity(t *testing.T) {
 			},
 		},
 		{
-			name: "Success - SchedSetaffinity Failed (Logged Only)",
+			name: "SchedSetaffinity Failed",
 			vmid: 105,
 			pid:  12350,
 			config: &proxmox.VmConfig{
@@ -123,9 +142,11 @@ func TestApplyAffinity(t *testing.T) {
 			expectError: false,
 			expectedRes: "1",
 			setupMockCpu: func(m *MockCpuInfoProvider) {
-				m.On("SelectCPUs", 105, 1).Return([]int{1}, nil)
+				m.On("SelectCPUs", 105, 1).Return(cpuinfo.Selection{CPUs: []int{1}}, nil)
+				m.On("GetSnapshot").Return(nil, errors.New("no ranking"))
 			},
 			setupMockSys: func(m *MockSystemAffinityOps) {
+				m.On("SchedGetaffinity", 12350, mock.Anything).Return(errors.New("sys error"))
 				m.On("GetProcessThreads", 12350).Return([]int{12350}, nil)
 				m.On("GetChildProcesses", 12350).Return([]int{}, nil)
 				m.On("SchedSetaffinity", 12350, mock.Anything).Return(errors.New("sys error"))
@@ -171,3 +192,95 @@ func TestApplyAffinity(t *testing.T) {
 		})
 	}
 }
+
+func TestApplyAffinity_Audit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	require.NoError(t, audit.Default.Open(path, 0))
+	defer func() { _ = audit.Default.Close() }()
+
+	created := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	mockCpu := new(MockCpuInfoProvider)
+	mockCpu.On("SelectCPUs", 100, 2).Return(cpuinfo.Selection{CPUs: []int{5, 4}, Generation: 3}, nil)
+	mockCpu.On("SelectCPUs", 101, 2).Return(cpuinfo.Selection{}, errors.New("core ranking cache is empty"))
+	// Selected from an older ranking and kept
+	mockCpu.On("SelectCPUs", 102, 2).Return(cpuinfo.Selection{CPUs: []int{1, 0}, Generation: 2}, nil)
+	mockCpu.On("SelectCPUs", 103, 1).Return(cpuinfo.Selection{CPUs: []int{6}, Generation: 3}, nil)
+	mockCpu.On("GetSnapshot").Return(&cpuinfo.Snapshot{Meta: cpuinfo.RankingMeta{Generation: 3, CreatedAt: created}}, nil)
+	mockSys := new(MockSystemAffinityOps)
+	mockSys.On("SchedGetaffinity", 12345, mock.Anything).Run(func(args mock.Arguments) {
+		mask := args.Get(1).(*CPUSet)
+		for cpu := 0; cpu < 8; cpu++ {
+			mask.Set(cpu)
+		}
+	}).Return(nil)
+	mockSys.On("GetProcessThreads", 12345).Return([]int{12345}, nil)
+	mockSys.On("GetChildProcesses", 12345).Return([]int{}, nil)
+	mockSys.On("SchedSetaffinity", 12345, mock.Anything).Return(nil)
+	// A thread of VM 102 exits while the affinity is set
+	mockSys.On("SchedGetaffinity", 12347, mock.Anything).Return(nil)
+	mockSys.On("GetProcessThreads", 12347).Return([]int{12347, 12348, 12349}, nil)
+	mockSys.On("GetChildProcesses", 12347).Return([]int{}, nil)
+	mockSys.On("SchedSetaffinity", 12348, mock.Anything).Return(errors.New("no such process"))
+	// No thread of VM 103 can be pinned
+	mockSys.On("SchedGetaffinity", 12350, mock.Anything).Return(nil)
+	mockSys.On("GetProcessThreads", 12350).Return([]int{12350}, nil)
+	mockSys.On("GetChildProcesses", 12350).Return([]int{}, nil)
+	mockSys.On("SchedSetaffinity", 12350, mock.Anything).Return(errors.New("operation not permitted"))
+	mockSys.On("SchedSetaffinity", mock.Anything, mock.Anything).Return(nil)
+
+	p := &defaultAffinityProvider{cpuInfo: mockCpu, sys: mockSys, config: &config.Config{}}
+	_, err := p.ApplyAffinity(context.Background(), 100, 12345, &proxmox.VmConfig{Cores: 2, Sockets: 1})
+	require.NoError(t, err)
+	_, err = p.ApplyAffinity(context.Background(), 101, 12346, &proxmox.VmConfig{Cores: 2, Sockets: 1})
+	require.NoError(t, err)
+	res, err := p.ApplyAffinity(context.Background(), 102, 12347, &proxmox.VmConfig{Cores: 2, Sockets: 1})
+	require.NoError(t, err)
+	assert.Equal(t, "1,0", res)
+	res, err = p.ApplyAffinity(context.Background(), 103, 12350, &proxmox.VmConfig{Cores: 1, Sockets: 1})
+	require.NoError(t, err)
+	assert.Equal(t, "6", res)
+
+	entries, err := audit.Default.Query(audit.Query{})
+	require.NoError(t, err)
+	require.Len(t, entries, 4)
+
+	applied := entries[0]
+	assert.Equal(t, 100, applied.VMID)
+	assert.Equal(t, 2, applied.RequestedCPUs)
+	assert.Equal(t, 5, *applied.PrimaryCPU)
+	assert.Equal(t, []int{5, 4}, applied.CPUs)
+	assert.Equal(t, uint64(3), applied.Generation)
+	assert.True(t, created.Equal(*applied.RankingCreatedAt))
+	assert.Equal(t, "0-7", applied.PreviousMask)
+	assert.Equal(t, audit.ResultApplied, applied.Result)
+
+	skipped := entries[1]
+	assert.Equal(t, 101, skipped.VMID)
+	assert.Equal(t, audit.ResultSkipped, skipped.Result)
+	assert.Equal(t, "select-cpus", skipped.Reason)
+	assert.Equal(t, "core ranking cache is empty", skipped.Error)
+
+	partial := entries[2]
+	assert.Equal(t, 102, partial.VMID)
+	assert.Equal(t, uint64(2), partial.Generation)
+	assert.Nil(t, partial.RankingCreatedAt, "the current ranking is not the one the CPUs were selected from")
+	assert.Equal(t, audit.ResultPartial, partial.Result)
+	assert.Equal(t, "set-affinity", partial.Reason)
+	assert.Equal(t, []int{12348}, partial.FailedThreads)
+
+	failed := entries[3]
+	assert.Equal(t, 103, failed.VMID)
+	assert.Equal(t, audit.ResultFailed, failed.Result)
+	assert.Equal(t, "set-affinity", failed.Reason)
+	assert.Equal(t, "operation not permitted", failed.Error)
+	assert.Equal(t, []int{12350}, failed.FailedThreads)
+}
+
+func TestFormatCPUSet(t *testing.T) {
+	var mask CPUSet
+	assert.Equal(t, "", formatCPUSet(&mask))
+	for _, cpu := range []int{0, 1, 2, 3, 8, 10, 11} {
+		mask.Set(cpu)
+	}
+	assert.Equal(t, "0-3,8,10-11", formatCPUSet(&mask))
+}
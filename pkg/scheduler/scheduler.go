@@ -5,11 +5,18 @@ import (
 	"fmt"
 	"log/slog"
 
+	"github.com/egandro/proxmox-cpu-affinity/pkg/audit"
 	"github.com/egandro/proxmox-cpu-affinity/pkg/config"
 	"github.com/egandro/proxmox-cpu-affinity/pkg/cpuinfo"
+	"github.com/egandro/proxmox-cpu-affinity/pkg/events"
+	"github.com/egandro/proxmox-cpu-affinity/pkg/metrics"
 	"github.com/egandro/proxmox-cpu-affinity/pkg/proxmox"
 )
 
+// ReasonAffinityConfigured is the audit reason of a VM that is skipped
+// because it has an affinity in its Proxmox configuration.
+const ReasonAffinityConfigured = "affinity-configured"
+
 // Scheduler defines the interface for VM scheduling operations.
 type Scheduler interface {
 	UpdateAffinity(ctx context.Context, vmid int) (interface{}, error)
@@ -46,16 +53,26 @@ func (s *scheduler) UpdateAffinity(ctx context.Context, vmid int) (interface{},
 	config, err := s.proxmox.GetVmConfig(ctx, vmid)
 	if err != nil {
 		slog.Error("Error getting VM config", "vmid", vmid, "error", err)
+		metrics.Failures.Inc(metrics.ReasonVMConfig)
+		events.Failure(metrics.ReasonVMConfig, vmid, err)
+		recordFailure(vmid, metrics.ReasonVMConfig, err)
 		return nil, err
 	}
 
 	pid, err := s.proxmox.GetVmPid(ctx, vmid)
 	if err != nil {
 		slog.Error("Error checking if VM is running", "vmid", vmid, "error", err)
+		metrics.Failures.Inc(metrics.ReasonVMPid)
+		events.Failure(metrics.ReasonVMPid, vmid, err)
+		recordFailure(vmid, metrics.ReasonVMPid, err)
 		return nil, err
 	}
 	if pid == -1 {
-		return nil, fmt.Errorf("VM %d is not running", vmid)
+		err := fmt.Errorf("VM %d is not running", vmid)
+		metrics.Failures.Inc(metrics.ReasonVMNotRunning)
+		events.Failure(metrics.ReasonVMNotRunning, vmid, err)
+		recordFailure(vmid, metrics.ReasonVMNotRunning, err)
+		return nil, err
 	}
 
 	if config.HookScript == "" {
@@ -64,6 +81,7 @@ func (s *scheduler) UpdateAffinity(ctx context.Context, vmid int) (interface{},
 
 	if config.Affinity != "" {
 		slog.Info("VM has existing affinity configuration", "vmid", vmid, "affinity", config.Affinity)
+		audit.Record(audit.Entry{VMID: vmid, PreviousMask: config.Affinity, Result: audit.ResultSkipped, Reason: ReasonAffinityConfigured})
 		return map[string]interface{}{"action": fmt.Sprintf("vm has an affinity configuration %s", config.Affinity)}, nil
 	}
 
@@ -75,3 +93,9 @@ func (s *scheduler) UpdateAffinity(ctx context.Context, vmid int) (interface{},
 
 	return map[string]interface{}{"action": fmt.Sprintf("new affinity: %s", affinity)}, nil
 }
+
+// recordFailure writes a placement that failed before CPUs were selected to
+// the audit journal.
+func recordFailure(vmid int, reason string, err error) {
+	audit.Record(audit.Entry{VMID: vmid, Result: audit.ResultFailed, Reason: reason, Error: err.Error()})
+}
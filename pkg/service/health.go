@@ -0,0 +1,58 @@
+package service
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/egandro/proxmox-cpu-affinity/pkg/cpuinfo"
+	"github.com/egandro/proxmox-cpu-affinity/pkg/events"
+	"github.com/egandro/proxmox-cpu-affinity/pkg/health"
+	"github.com/egandro/proxmox-cpu-affinity/pkg/version"
+)
+
+// SetHotplugWatchdog records whether the hotplug watchdog is configured and
+// whether it is running, for the health command.
+func (s *service) SetHotplugWatchdog(enabled, running bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.watchdogEnabled = enabled
+	s.watchdogRunning = running
+}
+
+// health returns the report of the health command and the meta of the ranking.
+func (s *service) health() (health.Health, *cpuinfo.RankingMeta) {
+	now := time.Now()
+	h := health.Health{
+		Calculation: s.cpuInfo.Status(),
+		ManagedVMs:  len(s.cpuInfo.GetSelections()),
+		LastError:   events.Default.LastFailure(),
+		StartedAt:   s.started,
+		Version:     version.String(),
+	}
+	h.UptimeSeconds = now.Sub(s.started).Seconds()
+	if snapshot, err := s.cpuInfo.GetSnapshot(); err == nil {
+		h.Ranking = &snapshot.Meta
+		h.RankingAgeSeconds = now.Sub(snapshot.Meta.CreatedAt).Seconds()
+	}
+
+	s.mu.Lock()
+	h.Rounds = s.rounds
+	h.Iterations = s.iterations
+	h.HotplugWatchdog = s.watchdogRunning
+	watchdogEnabled := s.watchdogEnabled
+	s.mu.Unlock()
+
+	h.Evaluate(watchdogEnabled)
+	return h, h.Ranking
+}
+
+// handleHealthDetails serves the health command, it answers 503 if the
+// service failed.
+func (s *service) handleHealthDetails(w http.ResponseWriter, r *http.Request) {
+	resp := s.execute(r.Context(), Request{Command: "health"})
+	code := http.StatusOK
+	if h, ok := resp.Data.(health.Health); ok && h.State == health.StateFailed {
+		code = http.StatusServiceUnavailable
+	}
+	writeResponse(w, code, resp)
+}
@@ -0,0 +1,66 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/egandro/proxmox-cpu-affinity/pkg/cpuinfo"
+	"github.com/egandro/proxmox-cpu-affinity/pkg/health"
+	"github.com/egandro/proxmox-cpu-affinity/pkg/version"
+)
+
+func TestService_Health(t *testing.T) {
+	_, mockCpuInfo, socketPath := setupTestService(t, func(s *service) {
+		s.SetMeasurement(3, 1000)
+		s.SetHotplugWatchdog(true, true)
+	})
+	mockCpuInfo.On("Status").Return(cpuinfo.RankingStatus{State: cpuinfo.StateReady, Source: cpuinfo.SourceMeasured})
+	mockCpuInfo.On("GetSelections").Return(map[int]cpuinfo.Selection{100: {CPUs: []int{0}}, 101: {CPUs: []int{1}}})
+	mockCpuInfo.On("GetSnapshot").Return(&cpuinfo.Snapshot{Meta: cpuinfo.RankingMeta{Generation: 2, CreatedAt: time.Now().Add(-time.Hour)}}, nil)
+
+	resp := send(t, socketPath, Request{Command: "health"})
+	require.Equal(t, "ok", resp.Status)
+	require.NotNil(t, resp.Meta)
+	assert.Equal(t, uint64(2), resp.Meta.Generation)
+
+	data, ok := resp.Data.(map[string]interface{})
+	require.True(t, ok)
+	assert.Equal(t, health.StateReady, data["state"])
+	assert.Equal(t, true, data["healthy"])
+	assert.Equal(t, true, data["hotplug_watchdog"])
+	assert.Equal(t, 2.0, data["managed_vms"])
+	assert.Equal(t, 3.0, data["rounds"])
+	assert.Equal(t, 1000.0, data["iterations"])
+	assert.InDelta(t, time.Hour.Seconds(), data["ranking_age_seconds"], 60)
+	assert.Greater(t, data["uptime_seconds"], 0.0)
+	assert.Equal(t, version.String(), data["version"])
+}
+
+func TestHTTP_HealthDetails(t *testing.T) {
+	_, mockCpuInfo, socketPath := setupTestService(t, func(s *service) {
+		s.SetHotplugWatchdog(true, false)
+	})
+	mockCpuInfo.On("Status").Return(cpuinfo.RankingStatus{State: cpuinfo.StateReady, Source: cpuinfo.SourceMeasured}).Once()
+	mockCpuInfo.On("GetSelections").Return(map[int]cpuinfo.Selection{})
+	mockCpuInfo.On("GetSnapshot").Return(&cpuinfo.Snapshot{}, nil).Once()
+
+	// Live but degraded
+	code, resp := httpDo(t, socketPath, http.MethodGet, "/v1/health/details")
+	assert.Equal(t, http.StatusOK, code)
+	data := resp.Data.(map[string]interface{})
+	assert.Equal(t, health.StateReady, data["state"])
+	assert.Equal(t, false, data["healthy"])
+	assert.Equal(t, []interface{}{"CPU hotplug watchdog is not running"}, data["problems"])
+
+	mockCpuInfo.On("Status").Return(cpuinfo.RankingStatus{State: cpuinfo.StateWarmingUp})
+	mockCpuInfo.On("GetSnapshot").Return(nil, errors.New("cache is empty"))
+
+	code, resp = httpDo(t, socketPath, http.MethodGet, "/v1/health/details")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, health.StateFailed, resp.Data.(map[string]interface{})["state"])
+}
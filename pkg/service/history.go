@@ -0,0 +1,48 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/egandro/proxmox-cpu-affinity/pkg/audit"
+)
+
+// history returns the placements of the audit journal selected by req,
+// oldest first.
+func (s *service) history(req Request) ([]audit.Entry, error) {
+	q := audit.Query{VMID: req.VMID}
+	if req.Since != nil {
+		q.Since = *req.Since
+	}
+	return s.journal.Query(q)
+}
+
+// handleHistory serves the history command, {vmid} and ?since= (RFC 3339)
+// are optional.
+func (s *service) handleHistory(w http.ResponseWriter, r *http.Request) {
+	req := Request{Command: "history"}
+	if r.PathValue("vmid") != "" {
+		vmid, ok := pathVMID(w, r)
+		if !ok {
+			return
+		}
+		req.VMID = vmid
+	}
+	if value := r.URL.Query().Get("since"); value != "" {
+		since, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			writeResponse(w, http.StatusBadRequest, Response{Status: "error", Error: fmt.Sprintf("invalid since: %q", value)})
+			return
+		}
+		req.Since = &since
+	}
+
+	resp := s.execute(r.Context(), req)
+	code := http.StatusOK
+	if resp.Status == "error" {
+		// e.g. the journal is disabled
+		code = http.StatusServiceUnavailable
+	}
+	writeResponse(w, code, resp)
+}
@@ -0,0 +1,90 @@
+package service
+
+import (
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/egandro/proxmox-cpu-affinity/pkg/audit"
+)
+
+// testJournal returns an open journal with placements of VM 100 and 101,
+// an hour apart, starting at start.
+func testJournal(t *testing.T, start time.Time) *audit.Journal {
+	t.Helper()
+	j := &audit.Journal{}
+	require.NoError(t, j.Open(filepath.Join(t.TempDir(), "audit.jsonl"), 0))
+	t.Cleanup(func() { _ = j.Close() })
+	j.Record(audit.Entry{Time: start, VMID: 100, CPUs: []int{0, 1}, Result: audit.ResultApplied})
+	j.Record(audit.Entry{Time: start.Add(time.Hour), VMID: 101, Result: audit.ResultFailed, Reason: "vm-pid"})
+	j.Record(audit.Entry{Time: start.Add(2 * time.Hour), VMID: 100, CPUs: []int{2, 3}, Result: audit.ResultApplied})
+	return j
+}
+
+// historyVMIDs returns the VMIDs of the entries of a history response.
+func historyVMIDs(t *testing.T, resp Response) []float64 {
+	t.Helper()
+	data, ok := resp.Data.([]interface{})
+	require.True(t, ok)
+	vmids := []float64{}
+	for _, e := range data {
+		vmids = append(vmids, e.(map[string]interface{})["vmid"].(float64))
+	}
+	return vmids
+}
+
+func TestService_History(t *testing.T) {
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	journal := testJournal(t, start)
+	_, _, socketPath := setupTestService(t, func(s *service) { s.journal = journal })
+
+	resp := send(t, socketPath, Request{Command: "history"})
+	require.Equal(t, "ok", resp.Status)
+	assert.Equal(t, []float64{100, 101, 100}, historyVMIDs(t, resp))
+
+	resp = send(t, socketPath, Request{Command: "history", VMID: 100})
+	require.Equal(t, "ok", resp.Status)
+	assert.Equal(t, []float64{100, 100}, historyVMIDs(t, resp))
+
+	since := start.Add(time.Hour)
+	resp = send(t, socketPath, Request{Command: "history", Since: &since})
+	require.Equal(t, "ok", resp.Status)
+	assert.Equal(t, []float64{101, 100}, historyVMIDs(t, resp))
+
+	// No entries is an empty list
+	resp = send(t, socketPath, Request{Command: "history", VMID: 999})
+	require.Equal(t, "ok", resp.Status)
+	assert.Empty(t, historyVMIDs(t, resp))
+}
+
+func TestService_HistoryDisabled(t *testing.T) {
+	_, _, socketPath := setupTestService(t, func(s *service) { s.journal = &audit.Journal{} })
+
+	resp := send(t, socketPath, Request{Command: "history"})
+	assert.Equal(t, "error", resp.Status)
+	assert.Contains(t, resp.Error, "disabled")
+}
+
+func TestHTTP_History(t *testing.T) {
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	journal := testJournal(t, start)
+	_, _, socketPath := setupTestService(t, func(s *service) { s.journal = journal })
+
+	code, resp := httpDo(t, socketPath, http.MethodGet, "/v1/history")
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []float64{100, 101, 100}, historyVMIDs(t, resp))
+
+	code, resp = httpDo(t, socketPath, http.MethodGet, "/v1/vms/100/history?since="+url.QueryEscape(start.Add(time.Hour).Format(time.RFC3339)))
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []float64{100}, historyVMIDs(t, resp))
+
+	code, _ = httpDo(t, socketPath, http.MethodGet, "/v1/history?since=yesterday")
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = httpDo(t, socketPath, http.MethodGet, "/v1/vms/abc/history")
+	assert.Equal(t, http.StatusBadRequest, code)
+}
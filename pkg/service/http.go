@@ -0,0 +1,203 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/egandro/proxmox-cpu-affinity/pkg/config"
+)
+
+// APIVersion is the path prefix of the HTTP API.
+const APIVersion = "/v1"
+
+// newHTTPServer returns the server of the versioned HTTP API on the unix socket.
+func (s *service) newHTTPServer() *http.Server {
+	return &http.Server{
+		Handler:           s.newHTTPHandler(),
+		ReadHeaderTimeout: config.ConstantSocketTimeout,
+		ReadTimeout:       config.ConstantSocketTimeout,
+		WriteTimeout:      config.ConstantSocketTimeout,
+		BaseContext:       func(net.Listener) context.Context { return s.ctx },
+	}
+}
+
+// newHTTPHandler returns the handler of the versioned HTTP API. Every
+// endpoint answers with a Response, like the legacy protocol.
+func (s *service) newHTTPHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+APIVersion+"/health", s.handleHealth)
+	mux.HandleFunc("GET "+APIVersion+"/health/details", s.handleHealthDetails)
+	mux.HandleFunc("GET "+APIVersion+"/ranking", s.handleCommand("core-ranking"))
+	mux.HandleFunc("GET "+APIVersion+"/summary", s.handleCommand("core-ranking-summary"))
+	mux.HandleFunc("GET "+APIVersion+"/clusters", s.handleCommand("core-clusters"))
+	mux.HandleFunc("GET "+APIVersion+"/topology", s.handleCommand("core-topology"))
+	mux.HandleFunc("GET "+APIVersion+"/selections", s.handleCommand("core-vm-affinity"))
+	mux.HandleFunc("GET "+APIVersion+"/vms/{vmid}/affinity", s.handleGetAffinity)
+	mux.HandleFunc("POST "+APIVersion+"/vms/{vmid}/affinity", s.handleUpdateAffinity)
+	mux.HandleFunc("GET "+APIVersion+"/vms/{vmid}/history", s.handleHistory)
+	mux.HandleFunc("GET "+APIVersion+"/history", s.handleHistory)
+	mux.HandleFunc("GET "+APIVersion+"/events", s.handleEvents)
+	mux.HandleFunc("GET "+APIVersion+"/recalculate", s.handleCommand("recalculate-status"))
+	mux.HandleFunc("POST "+APIVersion+"/recalculate", s.handleRecalculate)
+	mux.HandleFunc("POST "+APIVersion+"/config/reload", s.handleReloadConfig)
+	mux.HandleFunc("GET /metrics", s.handleMetrics)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writeResponse(w, http.StatusNotFound, Response{Status: "error", Error: fmt.Sprintf("unknown endpoint: %s %s", r.Method, r.URL.Path)})
+	})
+	return mux
+}
+
+// handleCommand serves a read-only command of the legacy protocol.
+func (s *service) handleCommand(command string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp := s.execute(r.Context(), Request{Command: command})
+		code := http.StatusOK
+		if resp.Status == "error" {
+			// e.g. no ranking yet
+			code = http.StatusServiceUnavailable
+		}
+		writeResponse(w, code, resp)
+	}
+}
+
+// handleHealth returns the ranking status, its state is "ready" or "warming-up".
+func (s *service) handleHealth(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	resp := Response{Status: "ok", Data: s.cpuInfo.Status()}
+	if snapshot, err := s.cpuInfo.GetSnapshot(); err == nil {
+		resp.Meta = &snapshot.Meta
+	}
+	writeResponse(w, http.StatusOK, resp)
+	observeRequest("health", resp.Status, start)
+}
+
+// handleGetAffinity returns the CPUs selected for a VM.
+func (s *service) handleGetAffinity(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	vmid, ok := pathVMID(w, r)
+	if !ok {
+		return
+	}
+	selection, found := s.cpuInfo.GetSelections()[vmid]
+	if !found {
+		writeResponse(w, http.StatusNotFound, Response{Status: "error", Error: fmt.Sprintf("no CPUs selected for vmid %d", vmid)})
+		observeRequest("vm-affinity", "error", start)
+		return
+	}
+	resp := Response{Status: "ok", Data: selection}
+	if snapshot, err := s.cpuInfo.GetSnapshot(); err == nil {
+		resp.Meta = &snapshot.Meta
+	}
+	writeResponse(w, http.StatusOK, resp)
+	observeRequest("vm-affinity", resp.Status, start)
+}
+
+// handleUpdateAffinity selects CPUs for a VM and applies the affinity.
+func (s *service) handleUpdateAffinity(w http.ResponseWriter, r *http.Request) {
+	vmid, ok := pathVMID(w, r)
+	if !ok {
+		return
+	}
+	resp := s.execute(r.Context(), Request{Command: "update-affinity", VMID: vmid})
+	code := http.StatusOK
+	if resp.Status == "error" {
+		code = http.StatusInternalServerError
+	}
+	writeResponse(w, code, resp)
+}
+
+// handleReloadConfig reloads the config file and lists the changed settings.
+func (s *service) handleReloadConfig(w http.ResponseWriter, r *http.Request) {
+	resp := s.execute(r.Context(), Request{Command: "reload-config"})
+	code := http.StatusOK
+	if resp.Status == "error" {
+		code = http.StatusInternalServerError
+	}
+	writeResponse(w, code, resp)
+}
+
+// pathVMID parses the {vmid} path value, it answers 400 if it is invalid.
+func pathVMID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	vmid, err := strconv.Atoi(r.PathValue("vmid"))
+	if err != nil || vmid <= 0 {
+		writeResponse(w, http.StatusBadRequest, Response{Status: "error", Error: fmt.Sprintf("invalid vmid: %q", r.PathValue("vmid"))})
+		return 0, false
+	}
+	return vmid, true
+}
+
+func writeResponse(w http.ResponseWriter, code int, resp Response) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		slog.Error("Failed to encode response", "error", err)
+	}
+}
+
+// isHTTPMethodStart reports whether b can start an HTTP request line
+// (methods are upper case), a legacy request starts with '{'.
+func isHTTPMethodStart(b byte) bool {
+	return b >= 'A' && b <= 'Z'
+}
+
+// bufferedConn is a connection whose first bytes were already read into r.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// connListener is a net.Listener for the HTTP server, it accepts the
+// connections handleConnection hands over.
+type connListener struct {
+	addr  net.Addr
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+func newConnListener(addr net.Addr) *connListener {
+	return &connListener{
+		addr:  addr,
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+}
+
+// handOver passes conn to the HTTP server, it is closed if the server is shut down.
+func (l *connListener) handOver(conn net.Conn) {
+	select {
+	case l.conns <- conn:
+	case <-l.done:
+		_ = conn.Close()
+	}
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *connListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *connListener) Addr() net.Addr {
+	return l.addr
+}
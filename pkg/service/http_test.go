@@ -0,0 +1,166 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/egandro/proxmox-cpu-affinity/pkg/config"
+	"github.com/egandro/proxmox-cpu-affinity/pkg/cpuinfo"
+)
+
+// httpDo sends an HTTP request over the unix socket and decodes the Response.
+func httpDo(t *testing.T, socketPath, method, path string) (int, Response) {
+	t.Helper()
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socketPath)
+		},
+	}}
+	defer client.CloseIdleConnections()
+
+	req, err := http.NewRequest(method, "http://localhost"+path, nil)
+	require.NoError(t, err)
+	httpResp, err := client.Do(req)
+	require.NoError(t, err)
+	defer func() { _ = httpResp.Body.Close() }()
+
+	assert.Equal(t, "application/json", httpResp.Header.Get("Content-Type"))
+	var resp Response
+	require.NoError(t, json.NewDecoder(httpResp.Body).Decode(&resp))
+	return httpResp.StatusCode, resp
+}
+
+func TestHTTP_Health(t *testing.T) {
+	_, mockCpuInfo, socketPath := setupTestService(t)
+	mockCpuInfo.On("Status").Return(cpuinfo.RankingStatus{State: cpuinfo.StateWarmingUp, Source: cpuinfo.SourceTopology, Measured: 6, Total: 12})
+	mockCpuInfo.On("GetSnapshot").Return(&cpuinfo.Snapshot{Meta: cpuinfo.RankingMeta{Generation: 1, Source: cpuinfo.SourceTopology}}, nil)
+
+	code, resp := httpDo(t, socketPath, http.MethodGet, "/v1/health")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ok", resp.Status)
+	require.NotNil(t, resp.Meta)
+	assert.Equal(t, uint64(1), resp.Meta.Generation)
+	data, ok := resp.Data.(map[string]interface{})
+	require.True(t, ok)
+	assert.Equal(t, cpuinfo.StateWarmingUp, data["state"])
+	assert.Equal(t, 12.0, data["total"])
+}
+
+func TestHTTP_Ranking(t *testing.T) {
+	_, mockCpuInfo, socketPath := setupTestService(t)
+	mockCpuInfo.On("GetSnapshot").Return(&cpuinfo.Snapshot{
+		Meta:     cpuinfo.RankingMeta{Generation: 3},
+		Rankings: []cpuinfo.CoreRanking{{CPU: 0, Ranking: []cpuinfo.Neighbor{{CPU: 1, LatencyNS: 10}}}},
+	}, nil)
+
+	code, resp := httpDo(t, socketPath, http.MethodGet, "/v1/ranking")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ok", resp.Status)
+	assert.Len(t, resp.Data, 1)
+	assert.Equal(t, uint64(3), resp.Meta.Generation)
+
+	code, resp = httpDo(t, socketPath, http.MethodGet, "/v1/summary")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ok", resp.Status)
+}
+
+func TestHTTP_Ranking_Empty(t *testing.T) {
+	_, mockCpuInfo, socketPath := setupTestService(t)
+	mockCpuInfo.On("GetSnapshot").Return(nil, fmt.Errorf("cache is empty"))
+
+	code, resp := httpDo(t, socketPath, http.MethodGet, "/v1/ranking")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "error", resp.Status)
+	assert.Equal(t, "cache is empty", resp.Error)
+}
+
+func TestHTTP_Affinity(t *testing.T) {
+	mockSched, mockCpuInfo, socketPath := setupTestService(t)
+	mockCpuInfo.On("GetSelections").Return(map[int]cpuinfo.Selection{100: {CPUs: []int{0, 1}, Generation: 1}})
+	mockCpuInfo.On("GetSnapshot").Return(&cpuinfo.Snapshot{Meta: cpuinfo.RankingMeta{Generation: 1}}, nil)
+	mockSched.On("UpdateAffinity", mock.Anything, 100).Return(map[string]interface{}{"action": "new affinity: 0-1"}, nil)
+	mockSched.On("UpdateAffinity", mock.Anything, 102).Return(nil, fmt.Errorf("vm not running"))
+
+	code, resp := httpDo(t, socketPath, http.MethodPost, "/v1/vms/100/affinity")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ok", resp.Status)
+	assert.Equal(t, map[string]interface{}{"action": "new affinity: 0-1"}, resp.Data)
+
+	code, resp = httpDo(t, socketPath, http.MethodPost, "/v1/vms/102/affinity")
+	assert.Equal(t, http.StatusInternalServerError, code)
+	assert.Equal(t, "vm not running", resp.Error)
+
+	code, resp = httpDo(t, socketPath, http.MethodGet, "/v1/vms/100/affinity")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, map[string]interface{}{"cpus": []interface{}{0.0, 1.0}, "generation": 1.0}, resp.Data)
+
+	code, _ = httpDo(t, socketPath, http.MethodGet, "/v1/vms/101/affinity")
+	assert.Equal(t, http.StatusNotFound, code)
+
+	code, resp = httpDo(t, socketPath, http.MethodPost, "/v1/vms/abc/affinity")
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Contains(t, resp.Error, "invalid vmid")
+
+	mockSched.AssertExpectations(t)
+}
+
+func TestHTTP_Selections(t *testing.T) {
+	_, mockCpuInfo, socketPath := setupTestService(t)
+	mockCpuInfo.On("GetSelections").Return(map[int]cpuinfo.Selection{100: {CPUs: []int{0, 1}}})
+	mockCpuInfo.On("GetSnapshot").Return(&cpuinfo.Snapshot{}, nil)
+
+	code, resp := httpDo(t, socketPath, http.MethodGet, "/v1/selections")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, resp.Data, "100")
+}
+
+func TestHTTP_UnknownEndpoint(t *testing.T) {
+	_, _, socketPath := setupTestService(t)
+
+	code, resp := httpDo(t, socketPath, http.MethodGet, "/v2/ranking")
+	assert.Equal(t, http.StatusNotFound, code)
+	assert.Contains(t, resp.Error, "unknown endpoint")
+}
+
+func TestHTTP_LegacyOnSameSocket(t *testing.T) {
+	_, mockCpuInfo, socketPath := setupTestService(t)
+	mockCpuInfo.On("Status").Return(cpuinfo.RankingStatus{State: cpuinfo.StateReady})
+	mockCpuInfo.On("GetSnapshot").Return(&cpuinfo.Snapshot{}, nil)
+
+	code, _ := httpDo(t, socketPath, http.MethodGet, "/v1/health")
+	assert.Equal(t, http.StatusOK, code)
+
+	conn, err := net.Dial("unix", socketPath)
+	require.NoError(t, err)
+	defer func() { _ = conn.Close() }()
+	require.NoError(t, json.NewEncoder(conn).Encode(Request{Command: "ping"}))
+	var resp Response
+	require.NoError(t, json.NewDecoder(conn).Decode(&resp))
+	assert.Equal(t, "pong", resp.Data)
+}
+
+func TestHTTP_ReloadConfig(t *testing.T) {
+	var svc *service
+	_, _, socketPath := setupTestService(t, func(s *service) { svc = s })
+
+	svc.SetReloader(func() (*config.ReloadResult, error) { return nil, errors.New("no such file") })
+	code, resp := httpDo(t, socketPath, http.MethodPost, "/v1/config/reload")
+	assert.Equal(t, http.StatusInternalServerError, code)
+	assert.Equal(t, "error", resp.Status)
+
+	svc.SetReloader(func() (*config.ReloadResult, error) {
+		return &config.ReloadResult{Applied: []string{"PCA_ROUNDS"}, RestartRequired: []string{}}, nil
+	})
+	code, resp = httpDo(t, socketPath, http.MethodPost, "/v1/config/reload")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ok", resp.Status)
+}
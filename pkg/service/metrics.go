@@ -0,0 +1,138 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/egandro/proxmox-cpu-affinity/pkg/config"
+	"github.com/egandro/proxmox-cpu-affinity/pkg/cpuinfo"
+	"github.com/egandro/proxmox-cpu-affinity/pkg/metrics"
+)
+
+// observeRequest counts a request and its duration.
+func observeRequest(command, status string, start time.Time) {
+	metrics.SocketRequests.Inc(command, status)
+	metrics.SocketRequestDuration.Observe(time.Since(start).Seconds(), command)
+}
+
+// handleMetrics writes the metrics in the Prometheus text format.
+func (s *service) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", metrics.ContentType)
+	if err := metrics.Default.Write(w, s.collectMetrics); err != nil {
+		slog.Error("Failed to write metrics", "error", err)
+	}
+}
+
+// collectMetrics writes the gauges derived from the ranking and the selections.
+func (s *service) collectMetrics(w *metrics.Writer) {
+	status := s.cpuInfo.Status()
+	ready := 0.0
+	if status.State == cpuinfo.StateReady {
+		ready = 1
+	}
+	w.Gauge("pca_ranking_ready", "1 if the ranking is ready, 0 while warming up.", ready)
+	w.Gauge("pca_ranking_progress_ratio", "Progress of the running measurement (0.0 - 1.0).", status.Progress)
+
+	snapshot, err := s.cpuInfo.GetSnapshot()
+	if err != nil {
+		return
+	}
+	meta := snapshot.Meta
+	w.Gauge("pca_ranking_generation", "Generation of the current ranking.", float64(meta.Generation))
+	w.Gauge("pca_ranking_duration_seconds", "Duration of the calculation of the current ranking.", meta.Duration.Seconds())
+	w.Gauge("pca_ranking_age_seconds", "Age of the current ranking.", time.Since(meta.CreatedAt).Seconds())
+
+	// Nominal latencies of the topology are no measurement
+	if meta.Source != cpuinfo.SourceTopology && meta.Source != cpuinfo.SourceDerived {
+		stats := cpuinfo.SummarizeRankings(snapshot.Rankings)
+		w.Gauge("pca_latency_min_seconds", "Lowest core-to-core latency of the ranking.", stats.MinLatencyNS/1e9)
+		w.Gauge("pca_latency_median_seconds", "Median core-to-core latency of the ranking.", stats.MedianLatencyNS/1e9)
+		w.Gauge("pca_latency_max_seconds", "Highest core-to-core latency of the ranking.", stats.MaxLatencyNS/1e9)
+	}
+
+	// Sockets of the ranked CPUs, every CPU is a neighbor of the others
+	sockets := make(map[int]int)
+	assigned := make(map[int]int)
+	for _, r := range snapshot.Rankings {
+		assigned[r.CPU] = 0
+		for _, n := range r.Ranking {
+			sockets[n.CPU] = n.Socket
+		}
+	}
+
+	selections := s.cpuInfo.GetSelections()
+	w.Gauge("pca_vms_managed", "VMs with selected CPUs.", float64(len(selections)))
+
+	vmids := make([]int, 0, len(selections))
+	for vmid, sel := range selections {
+		vmids = append(vmids, vmid)
+		for _, cpu := range sel.CPUs {
+			assigned[cpu]++
+		}
+	}
+	sort.Ints(vmids)
+
+	cpus := make([]int, 0, len(assigned))
+	for cpu := range assigned {
+		cpus = append(cpus, cpu)
+	}
+	sort.Ints(cpus)
+	cpuSamples := make([]metrics.GaugeSample, len(cpus))
+	for i, cpu := range cpus {
+		cpuSamples[i] = metrics.GaugeSample{Labels: []metrics.Label{{Name: "cpu", Value: strconv.Itoa(cpu)}}, Value: float64(assigned[cpu])}
+	}
+	w.GaugeVec("pca_cpu_assigned_vms", "VMs whose selection contains the CPU.", cpuSamples)
+
+	cpuCounts := make([]metrics.GaugeSample, len(vmids))
+	spread := make([]metrics.GaugeSample, len(vmids))
+	for i, vmid := range vmids {
+		sel := selections[vmid]
+		used := make(map[int]struct{})
+		for _, cpu := range sel.CPUs {
+			if socket, ok := sockets[cpu]; ok {
+				used[socket] = struct{}{}
+			}
+		}
+		labels := []metrics.Label{{Name: "vmid", Value: strconv.Itoa(vmid)}}
+		cpuCounts[i] = metrics.GaugeSample{Labels: labels, Value: float64(len(sel.CPUs))}
+		spread[i] = metrics.GaugeSample{Labels: labels, Value: float64(len(used))}
+	}
+	w.GaugeVec("pca_vm_cpus", "CPUs selected for the VM.", cpuCounts)
+	w.GaugeVec("pca_vm_sockets", "Physical sockets the CPUs of the VM are spread across.", spread)
+}
+
+// StartMetrics serves the metrics on a plain HTTP listener (e.g. ":9245"),
+// it returns when the service is shut down.
+func (s *service) StartMetrics(addr string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /metrics", s.handleMetrics)
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: config.ConstantSocketTimeout,
+		ReadTimeout:       config.ConstantSocketTimeout,
+		WriteTimeout:      config.ConstantSocketTimeout,
+		BaseContext:       func(net.Listener) context.Context { return s.ctx },
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+
+	s.mu.Lock()
+	s.metricsServer = server
+	s.mu.Unlock()
+	slog.Info("Starting metrics listener", "address", listener.Addr().String())
+
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("metrics listener failed: %w", err)
+	}
+	return nil
+}
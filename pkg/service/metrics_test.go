@@ -0,0 +1,90 @@
+package service
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/egandro/proxmox-cpu-affinity/pkg/cpuinfo"
+	"github.com/egandro/proxmox-cpu-affinity/pkg/metrics"
+)
+
+// scrape fetches /metrics over the unix socket.
+func scrape(t *testing.T, socketPath string) string {
+	t.Helper()
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socketPath)
+		},
+	}}
+	defer client.CloseIdleConnections()
+
+	resp, err := client.Get("http://localhost/metrics")
+	require.NoError(t, err)
+	defer func() { _ = resp.Body.Close() }()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, metrics.ContentType, resp.Header.Get("Content-Type"))
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return string(body)
+}
+
+func TestMetrics(t *testing.T) {
+	_, mockCpuInfo, socketPath := setupTestService(t)
+	mockCpuInfo.On("Status").Return(cpuinfo.RankingStatus{State: cpuinfo.StateReady, Source: cpuinfo.SourceMeasured, Progress: 1})
+	mockCpuInfo.On("GetSnapshot").Return(&cpuinfo.Snapshot{
+		Meta: cpuinfo.RankingMeta{Generation: 2, Source: cpuinfo.SourceMeasured, CreatedAt: time.Now(), Duration: 3 * time.Second},
+		Rankings: []cpuinfo.CoreRanking{
+			{CPU: 0, Ranking: []cpuinfo.Neighbor{{CPU: 1, Socket: 0, LatencyNS: 20}, {CPU: 2, Socket: 1, LatencyNS: 100}}},
+			{CPU: 1, Ranking: []cpuinfo.Neighbor{{CPU: 0, Socket: 0, LatencyNS: 20}, {CPU: 2, Socket: 1, LatencyNS: 100}}},
+			{CPU: 2, Ranking: []cpuinfo.Neighbor{{CPU: 0, Socket: 0, LatencyNS: 100}, {CPU: 1, Socket: 0, LatencyNS: 100}}},
+		},
+	}, nil)
+	mockCpuInfo.On("GetSelections").Return(map[int]cpuinfo.Selection{
+		100: {CPUs: []int{0, 1}},
+		101: {CPUs: []int{1, 2}},
+	})
+
+	before := metrics.SocketRequests.Value("ping", "ok")
+	conn, err := net.Dial("unix", socketPath)
+	require.NoError(t, err)
+	_, err = conn.Write([]byte(`{"command":"ping"}`))
+	require.NoError(t, err)
+	_, _ = io.ReadAll(conn)
+	_ = conn.Close()
+	assert.Equal(t, before+1, metrics.SocketRequests.Value("ping", "ok"))
+
+	body := scrape(t, socketPath)
+	assert.Contains(t, body, "pca_ranking_ready 1\n")
+	assert.Contains(t, body, "pca_ranking_generation 2\n")
+	assert.Contains(t, body, "pca_ranking_duration_seconds 3\n")
+	assert.Contains(t, body, "pca_latency_min_seconds 2e-08\n")
+	assert.Contains(t, body, "pca_vms_managed 2\n")
+	assert.Contains(t, body, `pca_cpu_assigned_vms{cpu="1"} 2`)
+	assert.Contains(t, body, `pca_cpu_assigned_vms{cpu="2"} 1`)
+	assert.Contains(t, body, `pca_vm_cpus{vmid="100"} 2`)
+	assert.Contains(t, body, `pca_vm_sockets{vmid="100"} 1`)
+	assert.Contains(t, body, `pca_vm_sockets{vmid="101"} 2`)
+	assert.Contains(t, body, `pca_socket_requests_total{command="ping",status="ok"}`)
+	assert.Contains(t, body, `pca_socket_request_duration_seconds_count{command="ping"}`)
+}
+
+func TestMetrics_Topology(t *testing.T) {
+	_, mockCpuInfo, socketPath := setupTestService(t)
+	mockCpuInfo.On("Status").Return(cpuinfo.RankingStatus{State: cpuinfo.StateWarmingUp, Source: cpuinfo.SourceTopology, Progress: 0.5})
+	mockCpuInfo.On("GetSnapshot").Return(&cpuinfo.Snapshot{Meta: cpuinfo.RankingMeta{Generation: 1, Source: cpuinfo.SourceTopology}}, nil)
+	mockCpuInfo.On("GetSelections").Return(map[int]cpuinfo.Selection{})
+
+	body := scrape(t, socketPath)
+	assert.Contains(t, body, "pca_ranking_ready 0\n")
+	assert.Contains(t, body, "pca_ranking_progress_ratio 0.5\n")
+	assert.NotContains(t, body, "pca_latency_min_seconds")
+	assert.Contains(t, body, "pca_vms_managed 0\n")
+}
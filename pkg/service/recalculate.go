@@ -0,0 +1,147 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/egandro/proxmox-cpu-affinity/pkg/config"
+	"github.com/egandro/proxmox-cpu-affinity/pkg/cpuinfo"
+)
+
+// startRecalculation starts a full measurement in the background, rounds
+// and iterations of 0 use the values set by SetMeasurement. Larger values than
+// ConstantRecalculationMaxFactor times the adaptive defaults are rejected.
+func (s *service) startRecalculation(rounds, iterations int) (cpuinfo.Recalculation, error) {
+	maxRounds, maxIterations := config.AdaptiveCpuInfoParameters()
+	maxRounds *= config.ConstantRecalculationMaxFactor
+	maxIterations *= config.ConstantRecalculationMaxFactor
+	if rounds < 0 || iterations < 0 || rounds > maxRounds || iterations > maxIterations {
+		return cpuinfo.Recalculation{}, fmt.Errorf("invalid rounds %d or iterations %d (max %d rounds, %d iterations)", rounds, iterations, maxRounds, maxIterations)
+	}
+
+	s.mu.Lock()
+	if rounds == 0 {
+		rounds = s.rounds
+	}
+	if iterations == 0 {
+		iterations = s.iterations
+	}
+	s.mu.Unlock()
+
+	done, err := s.cpuInfo.Recalculate(s.ctx, rounds, iterations, config.ConstantMaxCalculationRankingDuration)
+	if err != nil {
+		return cpuinfo.Recalculation{}, err
+	}
+	now := time.Now()
+
+	s.mu.Lock()
+	s.recalc = cpuinfo.Recalculation{
+		State:      cpuinfo.RecalculationRunning,
+		Rounds:     rounds,
+		Iterations: iterations,
+		StartedAt:  &now,
+	}
+	recalc := s.recalc
+	s.mu.Unlock()
+
+	slog.Info("Recalculating ranking", "rounds", rounds, "iterations", iterations)
+	go s.finishRecalculation(done)
+	return recalc, nil
+}
+
+// finishRecalculation waits for the measurement and re-places the VMs whose
+// selection changed: those that used a CPU which is gone and those placed by
+// the topology fallback. All other VMs keep their CPUs.
+func (s *service) finishRecalculation(done <-chan error) {
+	err := <-done
+
+	var replaced []int
+	var generation uint64
+	if err == nil {
+		if snapshot, snapErr := s.cpuInfo.GetSnapshot(); snapErr == nil {
+			generation = snapshot.Meta.Generation
+		}
+		vmids := s.cpuInfo.TakeProvisional()
+		for _, change := range s.cpuInfo.ReplaceLostCPUs() {
+			vmids = append(vmids, change.VMID)
+		}
+		sort.Ints(vmids)
+		for _, vmid := range slices.Compact(vmids) {
+			slog.Info("Re-placing VM with recalculated ranking", "vmid", vmid)
+			if _, err := s.scheduler.UpdateAffinity(s.ctx, vmid); err != nil {
+				slog.Error("Failed to re-place VM", "vmid", vmid, "error", err)
+				continue
+			}
+			replaced = append(replaced, vmid)
+		}
+	}
+
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.recalc.FinishedAt = &now
+	s.recalc.Progress = 1
+	if err != nil {
+		s.recalc.State = cpuinfo.RecalculationFailed
+		s.recalc.Error = err.Error()
+		slog.Error("Failed to recalculate ranking", "error", err)
+		return
+	}
+	s.recalc.State = cpuinfo.RecalculationDone
+	s.recalc.Generation = generation
+	s.recalc.Replaced = replaced
+}
+
+// recalculation returns the state of the last recalculation with the
+// progress of the measurement while it is running.
+func (s *service) recalculation() cpuinfo.Recalculation {
+	s.mu.Lock()
+	recalc := s.recalc
+	s.mu.Unlock()
+
+	if recalc.State == cpuinfo.RecalculationRunning {
+		status := s.cpuInfo.Status()
+		recalc.Round = status.Round
+		recalc.Progress = status.Progress
+	}
+	return recalc
+}
+
+// handleRecalculate starts a recalculation, rounds and iterations are
+// optional query parameters.
+func (s *service) handleRecalculate(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	var params [2]int
+	for i, name := range []string{"rounds", "iterations"} {
+		value := r.URL.Query().Get(name)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			writeResponse(w, http.StatusBadRequest, Response{Status: "error", Error: fmt.Sprintf("invalid %s: %q", name, value)})
+			observeRequest("recalculate", "error", start)
+			return
+		}
+		params[i] = n
+	}
+
+	recalc, err := s.startRecalculation(params[0], params[1])
+	switch {
+	case errors.Is(err, cpuinfo.ErrCalculationRunning):
+		writeResponse(w, http.StatusConflict, Response{Status: "error", Error: err.Error()})
+	case err != nil:
+		writeResponse(w, http.StatusBadRequest, Response{Status: "error", Error: err.Error()})
+	default:
+		writeResponse(w, http.StatusAccepted, Response{Status: "ok", Data: recalc})
+		observeRequest("recalculate", "ok", start)
+		return
+	}
+	observeRequest("recalculate", "error", start)
+}
@@ -0,0 +1,143 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/egandro/proxmox-cpu-affinity/pkg/config"
+	"github.com/egandro/proxmox-cpu-affinity/pkg/cpuinfo"
+)
+
+// send sends a request of the legacy protocol and decodes the Response.
+func send(t *testing.T, socketPath string, req Request) Response {
+	t.Helper()
+	conn, err := net.Dial("unix", socketPath)
+	require.NoError(t, err)
+	defer func() { _ = conn.Close() }()
+
+	require.NoError(t, json.NewEncoder(conn).Encode(req))
+	var resp Response
+	require.NoError(t, json.NewDecoder(conn).Decode(&resp))
+	return resp
+}
+
+// recalculationState decodes the data of a recalculate response.
+func recalculationState(t *testing.T, resp Response) string {
+	t.Helper()
+	data, ok := resp.Data.(map[string]interface{})
+	require.True(t, ok)
+	return data["state"].(string)
+}
+
+func TestService_Recalculate(t *testing.T) {
+	mockSched, mockCpuInfo, socketPath := setupTestService(t)
+	done := make(chan error, 1)
+	mockCpuInfo.On("Recalculate", mock.Anything, 3, config.DefaultIterations, config.ConstantMaxCalculationRankingDuration).Return(done, nil).Once()
+	mockCpuInfo.On("Recalculate", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil, cpuinfo.ErrCalculationRunning)
+	mockCpuInfo.On("Status").Return(cpuinfo.RankingStatus{State: cpuinfo.StateReady, Running: true, Round: 2, Rounds: 3, Progress: 0.5})
+	mockCpuInfo.On("GetSnapshot").Return(&cpuinfo.Snapshot{Meta: cpuinfo.RankingMeta{Generation: 4}}, nil)
+	// VM 101 was placed during the warm-up and lost a CPU, 100 lost a CPU
+	mockCpuInfo.On("TakeProvisional").Return([]int{101})
+	mockCpuInfo.On("ReplaceLostCPUs").Return([]cpuinfo.SelectionChange{{VMID: 100, Lost: []int{3}}, {VMID: 101, Lost: []int{3}}})
+	mockSched.On("UpdateAffinity", mock.Anything, 100).Return("placed", nil)
+	mockSched.On("UpdateAffinity", mock.Anything, 101).Return("placed", nil)
+
+	resp := send(t, socketPath, Request{Command: "recalculate-status"})
+	assert.Equal(t, cpuinfo.RecalculationIdle, recalculationState(t, resp))
+
+	resp = send(t, socketPath, Request{Command: "recalculate", Rounds: 3})
+	require.Equal(t, "ok", resp.Status)
+	assert.Equal(t, cpuinfo.RecalculationRunning, recalculationState(t, resp))
+
+	resp = send(t, socketPath, Request{Command: "recalculate"})
+	assert.Equal(t, "error", resp.Status)
+	assert.Equal(t, cpuinfo.ErrCalculationRunning.Error(), resp.Error)
+
+	// The progress of the measurement is reported while it runs
+	resp = send(t, socketPath, Request{Command: "recalculate-status"})
+	data := resp.Data.(map[string]interface{})
+	assert.Equal(t, cpuinfo.RecalculationRunning, data["state"])
+	assert.Equal(t, 2.0, data["round"])
+	assert.Equal(t, 0.5, data["progress"])
+
+	done <- nil
+	require.Eventually(t, func() bool {
+		resp = send(t, socketPath, Request{Command: "recalculate-status"})
+		return recalculationState(t, resp) == cpuinfo.RecalculationDone
+	}, time.Second, 10*time.Millisecond)
+
+	data = resp.Data.(map[string]interface{})
+	assert.Equal(t, 4.0, data["generation"])
+	assert.Equal(t, []interface{}{100.0, 101.0}, data["replaced"])
+	assert.Equal(t, 1.0, data["progress"])
+	// Only the changed VMs are re-placed, each once
+	mockSched.AssertNumberOfCalls(t, "UpdateAffinity", 2)
+}
+
+func TestService_Recalculate_Failed(t *testing.T) {
+	mockSched, mockCpuInfo, socketPath := setupTestService(t)
+	done := make(chan error, 1)
+	mockCpuInfo.On("Recalculate", mock.Anything, config.DefaultRounds, config.DefaultIterations, mock.Anything).Return(done, nil)
+
+	resp := send(t, socketPath, Request{Command: "recalculate"})
+	require.Equal(t, "ok", resp.Status)
+
+	done <- errors.New("measurement failed")
+	require.Eventually(t, func() bool {
+		resp = send(t, socketPath, Request{Command: "recalculate-status"})
+		return recalculationState(t, resp) == cpuinfo.RecalculationFailed
+	}, time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, "measurement failed", resp.Data.(map[string]interface{})["error"])
+	mockSched.AssertNotCalled(t, "UpdateAffinity", mock.Anything, mock.Anything)
+}
+
+func TestService_Recalculate_Invalid(t *testing.T) {
+	_, mockCpuInfo, socketPath := setupTestService(t)
+
+	resp := send(t, socketPath, Request{Command: "recalculate", Rounds: -1})
+	assert.Equal(t, "error", resp.Status)
+
+	maxRounds, maxIterations := config.AdaptiveCpuInfoParameters()
+	resp = send(t, socketPath, Request{Command: "recalculate", Rounds: maxRounds*config.ConstantRecalculationMaxFactor + 1})
+	assert.Equal(t, "error", resp.Status)
+	assert.Contains(t, resp.Error, "max")
+	resp = send(t, socketPath, Request{Command: "recalculate", Iterations: maxIterations*config.ConstantRecalculationMaxFactor + 1})
+	assert.Equal(t, "error", resp.Status)
+	mockCpuInfo.AssertNotCalled(t, "Recalculate", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestHTTP_Recalculate(t *testing.T) {
+	_, mockCpuInfo, socketPath := setupTestService(t)
+	done := make(chan error, 1)
+	mockCpuInfo.On("TakeProvisional").Return(nil)
+	mockCpuInfo.On("ReplaceLostCPUs").Return(nil)
+	mockCpuInfo.On("Recalculate", mock.Anything, 2, 50, mock.Anything).Return(done, nil).Once()
+	mockCpuInfo.On("Recalculate", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil, cpuinfo.ErrCalculationRunning)
+	mockCpuInfo.On("Status").Return(cpuinfo.RankingStatus{State: cpuinfo.StateReady, Running: true, Round: 1, Rounds: 2})
+	mockCpuInfo.On("GetSnapshot").Return(&cpuinfo.Snapshot{}, nil)
+	t.Cleanup(func() { done <- nil })
+
+	code, _ := httpDo(t, socketPath, http.MethodPost, "/v1/recalculate?rounds=x")
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	code, resp := httpDo(t, socketPath, http.MethodPost, "/v1/recalculate?rounds=2&iterations=50")
+	assert.Equal(t, http.StatusAccepted, code)
+	assert.Equal(t, cpuinfo.RecalculationRunning, recalculationState(t, resp))
+
+	code, resp = httpDo(t, socketPath, http.MethodPost, "/v1/recalculate")
+	assert.Equal(t, http.StatusConflict, code)
+	assert.Equal(t, cpuinfo.ErrCalculationRunning.Error(), resp.Error)
+
+	code, resp = httpDo(t, socketPath, http.MethodGet, "/v1/recalculate")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 1.0, resp.Data.(map[string]interface{})["round"])
+}
@@ -0,0 +1,218 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/egandro/proxmox-cpu-affinity/pkg/config"
+)
+
+// Roles of the identities of the remote listener.
+const (
+	RoleRead  = "read"  // the endpoints in readPaths
+	RoleAdmin = "admin" // all endpoints
+)
+
+// readPaths are the endpoints of the read role, monitoring needs nothing else.
+var readPaths = map[string]bool{
+	APIVersion + "/health":         true,
+	APIVersion + "/health/details": true,
+	APIVersion + "/ranking":        true,
+	APIVersion + "/summary":        true,
+	APIVersion + "/selections":     true,
+}
+
+// RemoteConfig configures the optional TCP listener. TLS is required, the
+// clients authenticate with a bearer token or a client certificate.
+type RemoteConfig struct {
+	Listen       string   // e.g. ":8245"
+	CertFile     string   // server certificate (PEM)
+	KeyFile      string   // server key (PEM)
+	ClientCAFile string   // CA of the client certificates, the common name is the identity
+	TokensFile   string   // "name role token" per line
+	Admins       []string // common names of client certificates with the admin role
+}
+
+// identity is an authenticated client of the remote listener.
+type identity struct {
+	name string
+	role string
+}
+
+// token is a bearer token of the tokens file.
+type token struct {
+	identity
+	secret []byte
+}
+
+// StartRemote runs the TCP listener, it returns when the service is shut down.
+func (s *service) StartRemote(rc RemoteConfig) error {
+	server, err := s.newRemoteServer(rc)
+	if err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("tcp", rc.Listen)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", rc.Listen, err)
+	}
+
+	s.mu.Lock()
+	s.remoteServer = server
+	s.mu.Unlock()
+	slog.Info("Starting remote listener", "address", listener.Addr().String(), "client_ca", rc.ClientCAFile != "", "tokens", rc.TokensFile != "")
+
+	if err := server.ServeTLS(listener, "", ""); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("remote listener failed: %w", err)
+	}
+	return nil
+}
+
+// newRemoteServer loads the certificates and tokens and returns the server
+// of the remote listener.
+func (s *service) newRemoteServer(rc RemoteConfig) (*http.Server, error) {
+	if rc.CertFile == "" || rc.KeyFile == "" {
+		return nil, fmt.Errorf("remote listener requires a TLS certificate and key")
+	}
+	cert, err := tls.LoadX509KeyPair(rc.CertFile, rc.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}
+
+	if rc.ClientCAFile != "" {
+		pem, err := os.ReadFile(rc.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in client CA %s", rc.ClientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+		// Token clients don't send a certificate
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	var tokens []token
+	if rc.TokensFile != "" {
+		if tokens, err = readTokens(rc.TokensFile); err != nil {
+			return nil, err
+		}
+	}
+	if len(tokens) == 0 && rc.ClientCAFile == "" {
+		return nil, fmt.Errorf("remote listener requires tokens or a client CA")
+	}
+
+	return &http.Server{
+		Handler:           authorize(tokens, rc.Admins, s.newHTTPHandler()),
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: config.ConstantSocketTimeout,
+		ReadTimeout:       config.ConstantSocketTimeout,
+		WriteTimeout:      config.ConstantSocketTimeout,
+		BaseContext:       func(net.Listener) context.Context { return s.ctx },
+	}, nil
+}
+
+// authorize answers 401 to unauthenticated requests and 403 if the role of
+// the identity does not allow the request.
+func authorize(tokens []token, admins []string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, ok := authenticate(r, tokens, admins)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeResponse(w, http.StatusUnauthorized, Response{Status: "error", Error: "unauthorized"})
+			return
+		}
+
+		readOnly := r.Method == http.MethodGet || r.Method == http.MethodHead
+		if id.role != RoleAdmin && (!readOnly || !readPaths[r.URL.Path]) {
+			slog.Warn("Remote request denied", "identity", id.name, "role", id.role, "method", r.Method, "path", r.URL.Path)
+			writeResponse(w, http.StatusForbidden, Response{Status: "error", Error: fmt.Sprintf("%s %s requires the %s role", r.Method, r.URL.Path, RoleAdmin)})
+			return
+		}
+
+		if readOnly {
+			slog.Debug("Remote request", "identity", id.name, "method", r.Method, "path", r.URL.Path)
+		} else {
+			slog.Info("Remote request", "identity", id.name, "method", r.Method, "path", r.URL.Path)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authenticate returns the identity of a bearer token or, without an
+// Authorization header, of a verified client certificate.
+func authenticate(r *http.Request, tokens []token, admins []string) (identity, bool) {
+	if header := r.Header.Get("Authorization"); header != "" {
+		secret, ok := strings.CutPrefix(header, "Bearer ")
+		if !ok {
+			return identity{}, false
+		}
+		for _, t := range tokens {
+			if subtle.ConstantTimeCompare([]byte(secret), t.secret) == 1 {
+				return t.identity, true
+			}
+		}
+		return identity{}, false
+	}
+
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0 {
+		name := r.TLS.VerifiedChains[0][0].Subject.CommonName
+		role := RoleRead
+		if slices.Contains(admins, name) {
+			role = RoleAdmin
+		}
+		return identity{name: name, role: role}, true
+	}
+	return identity{}, false
+}
+
+// readTokens reads the tokens file: one "name role token" per line, empty
+// lines and lines starting with '#' are ignored.
+func readTokens(path string) ([]token, error) {
+	f, err := os.Open(path) // #nosec G304 -- path is PCA_REMOTE_TOKENS_FILE
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tokens file: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	if info, err := f.Stat(); err == nil && info.Mode().Perm()&0o077 != 0 {
+		slog.Warn("Tokens file is readable by other users", "file", path, "mode", info.Mode().Perm().String())
+	}
+
+	var tokens []token
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("%s:%d: expected \"name role token\"", path, line)
+		}
+		if fields[1] != RoleRead && fields[1] != RoleAdmin {
+			return nil, fmt.Errorf("%s:%d: invalid role %q, expected %s or %s", path, line, fields[1], RoleRead, RoleAdmin)
+		}
+		tokens = append(tokens, token{identity: identity{name: fields[0], role: fields[1]}, secret: []byte(fields[2])})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read tokens file: %w", err)
+	}
+	return tokens, nil
+}
@@ -0,0 +1,206 @@
+package service
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/egandro/proxmox-cpu-affinity/pkg/cpuinfo"
+)
+
+// testCert is a certificate with its key, signed by parent (self-signed if nil).
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+func newTestCert(t *testing.T, name string, parent *testCert) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+// write stores the certificate and key as PEM files in dir.
+func (c *testCert) write(t *testing.T, dir, name string) (certFile, keyFile string) {
+	t.Helper()
+	certFile = filepath.Join(dir, name+".pem")
+	keyFile = filepath.Join(dir, name+"-key.pem")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0o600))
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return certFile, keyFile
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
+
+// setupRemote starts a remote listener with a client CA and the tokens
+// "grafana" (read) and "ops" (admin), admins lists the admin client certificates.
+func setupRemote(t *testing.T, admins ...string) (*MockScheduler, *MockCpuInfo, *testCert, string) {
+	t.Helper()
+	var svc *service
+	mockSched, mockCpuInfo, _ := setupTestService(t, func(s *service) { svc = s })
+
+	dir := t.TempDir()
+	ca := newTestCert(t, "test-ca", nil)
+	caFile, _ := ca.write(t, dir, "ca")
+	certFile, keyFile := newTestCert(t, "pve1", ca).write(t, dir, "server")
+	tokensFile := filepath.Join(dir, "tokens")
+	require.NoError(t, os.WriteFile(tokensFile, []byte("# name role token\ngrafana read read-secret\n\nops admin admin-secret\n"), 0o600))
+
+	server, err := svc.newRemoteServer(RemoteConfig{
+		CertFile:     certFile,
+		KeyFile:      keyFile,
+		ClientCAFile: caFile,
+		TokensFile:   tokensFile,
+		Admins:       admins,
+	})
+	require.NoError(t, err)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() { _ = server.ServeTLS(listener, "", "") }()
+	t.Cleanup(func() { _ = server.Close() })
+
+	return mockSched, mockCpuInfo, ca, "https://" + listener.Addr().String()
+}
+
+// remoteDo sends a request to the remote listener with an optional token and client certificate.
+func remoteDo(t *testing.T, ca *testCert, url, method, path, token string, clientCert *testCert) int {
+	t.Helper()
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	tlsConfig := &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	if clientCert != nil {
+		tlsConfig.Certificates = []tls.Certificate{clientCert.tlsCertificate()}
+	}
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+	defer client.CloseIdleConnections()
+
+	req, err := http.NewRequest(method, url+path, nil)
+	require.NoError(t, err)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestRemote_Tokens(t *testing.T) {
+	mockSched, mockCpuInfo, ca, url := setupRemote(t)
+	mockCpuInfo.On("GetSnapshot").Return(&cpuinfo.Snapshot{}, nil)
+	mockSched.On("UpdateAffinity", mock.Anything, 100).Return(map[string]interface{}{"action": "new affinity: 0-1"}, nil)
+
+	assert.Equal(t, http.StatusUnauthorized, remoteDo(t, ca, url, http.MethodGet, "/v1/ranking", "", nil))
+	assert.Equal(t, http.StatusUnauthorized, remoteDo(t, ca, url, http.MethodGet, "/v1/ranking", "wrong", nil))
+
+	// read role
+	assert.Equal(t, http.StatusOK, remoteDo(t, ca, url, http.MethodGet, "/v1/ranking", "read-secret", nil))
+	assert.Equal(t, http.StatusOK, remoteDo(t, ca, url, http.MethodHead, "/v1/ranking", "read-secret", nil))
+	assert.Equal(t, http.StatusForbidden, remoteDo(t, ca, url, http.MethodPost, "/v1/vms/100/affinity", "read-secret", nil))
+	assert.Equal(t, http.StatusForbidden, remoteDo(t, ca, url, http.MethodGet, "/v1/topology", "read-secret", nil))
+	assert.Equal(t, http.StatusForbidden, remoteDo(t, ca, url, http.MethodGet, "/v1/history", "read-secret", nil))
+
+	// admin role
+	assert.Equal(t, http.StatusOK, remoteDo(t, ca, url, http.MethodPost, "/v1/vms/100/affinity", "admin-secret", nil))
+
+	mockSched.AssertExpectations(t)
+	mockCpuInfo.AssertExpectations(t)
+}
+
+func TestRemote_ClientCertificates(t *testing.T) {
+	mockSched, mockCpuInfo, ca, url := setupRemote(t, "ops-host")
+	mockCpuInfo.On("GetSnapshot").Return(&cpuinfo.Snapshot{}, nil)
+	mockSched.On("UpdateAffinity", mock.Anything, 100).Return(map[string]interface{}{"action": "new affinity: 0-1"}, nil)
+
+	monitoring := newTestCert(t, "monitoring", ca)
+	assert.Equal(t, http.StatusOK, remoteDo(t, ca, url, http.MethodGet, "/v1/summary", "", monitoring))
+	assert.Equal(t, http.StatusForbidden, remoteDo(t, ca, url, http.MethodPost, "/v1/vms/100/affinity", "", monitoring))
+
+	ops := newTestCert(t, "ops-host", ca)
+	assert.Equal(t, http.StatusOK, remoteDo(t, ca, url, http.MethodPost, "/v1/vms/100/affinity", "", ops))
+
+	// Certificates of another CA are not accepted (the client doesn't even send them)
+	foreign := newTestCert(t, "ops-host", newTestCert(t, "other-ca", nil))
+	assert.Equal(t, http.StatusUnauthorized, remoteDo(t, ca, url, http.MethodGet, "/v1/summary", "", foreign))
+}
+
+func TestNewRemoteServer_Errors(t *testing.T) {
+	svc := New(t.Context(), "", nil, nil)
+	dir := t.TempDir()
+	certFile, keyFile := newTestCert(t, "pve1", nil).write(t, dir, "server")
+
+	_, err := svc.newRemoteServer(RemoteConfig{})
+	assert.ErrorContains(t, err, "certificate and key")
+
+	_, err = svc.newRemoteServer(RemoteConfig{CertFile: certFile, KeyFile: keyFile})
+	assert.ErrorContains(t, err, "requires tokens or a client CA")
+
+	_, err = svc.newRemoteServer(RemoteConfig{CertFile: certFile, KeyFile: keyFile, ClientCAFile: keyFile})
+	assert.ErrorContains(t, err, "no certificates found")
+}
+
+func TestReadTokens(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tokens")
+
+	require.NoError(t, os.WriteFile(path, []byte("grafana read s1\nops admin s2\n"), 0o600))
+	tokens, err := readTokens(path)
+	require.NoError(t, err)
+	assert.Equal(t, []token{
+		{identity: identity{name: "grafana", role: RoleRead}, secret: []byte("s1")},
+		{identity: identity{name: "ops", role: RoleAdmin}, secret: []byte("s2")},
+	}, tokens)
+
+	require.NoError(t, os.WriteFile(path, []byte("grafana write s1\n"), 0o600))
+	_, err = readTokens(path)
+	assert.ErrorContains(t, err, "invalid role")
+
+	require.NoError(t, os.WriteFile(path, []byte("grafana s1\n"), 0o600))
+	_, err = readTokens(path)
+	assert.ErrorContains(t, err, ":1:")
+}
@@ -1,6 +1,7 @@
 package service
 
 import (
+	"bufio"
 	"context"
 	"encoding/json"
 	"errors"
@@ -8,26 +9,36 @@ import (
 	"io"
 	"log/slog"
 	"net"
+	"net/http"
 	"os"
 	"sync"
 	"time"
 
+	"github.com/egandro/proxmox-cpu-affinity/pkg/audit"
 	"github.com/egandro/proxmox-cpu-affinity/pkg/config"
 	"github.com/egandro/proxmox-cpu-affinity/pkg/cpuinfo"
+	"github.com/egandro/proxmox-cpu-affinity/pkg/events"
+	"github.com/egandro/proxmox-cpu-affinity/pkg/metrics"
 	"github.com/egandro/proxmox-cpu-affinity/pkg/scheduler"
 )
 
 // Request represents the JSON request structure.
 type Request struct {
-	Command string `json:"command"`
-	VMID    int    `json:"vmid"`
+	Command    string `json:"command"`
+	VMID       int    `json:"vmid"`
+	Rounds     int    `json:"rounds,omitempty"`     // recalculate, 0 uses the configured rounds
+	Iterations int    `json:"iterations,omitempty"` // recalculate, 0 uses the configured iterations
+	// history, nil selects all entries
+	Since *time.Time `json:"since,omitempty"`
 }
 
 // Response represents the JSON response structure.
+// Meta describes the ranking generation the data is based on.
 type Response struct {
-	Status string      `json:"status"`
-	Data   interface{} `json:"data,omitempty"`
-	Error  string      `json:"error,omitempty"`
+	Status string               `json:"status"`
+	Data   interface{}          `json:"data,omitempty"`
+	Meta   *cpuinfo.RankingMeta `json:"meta,omitempty"`
+	Error  string               `json:"error,omitempty"`
 }
 
 // service represents the socket service.
@@ -36,44 +47,128 @@ type service struct {
 	mu         sync.Mutex
 	SocketPath string
 	listener   net.Listener
+	httpServer *http.Server
+	httpConns  *connListener
 	scheduler  scheduler.Scheduler
 	cpuInfo    cpuinfo.Provider
+	// scanTopology returns all present CPUs, including offline ones
+	scanTopology func() ([]cpuinfo.CoreInfo, error)
+	// optional TCP listeners, see StartRemote and StartMetrics
+	remoteServer  *http.Server
+	metricsServer *http.Server
+	// measurement parameters of a recalculation, see SetMeasurement
+	rounds     int
+	iterations int
+	recalc     cpuinfo.Recalculation
+	// reload applies a changed config file, see SetReloader
+	reload func() (*config.ReloadResult, error)
+	// closed once the socket is listening, see Listening
+	listening chan struct{}
+	started   time.Time
+	// hotplug watchdog state for the health command, see SetHotplugWatchdog
+	watchdogEnabled bool
+	watchdogRunning bool
+	// placement journal of the history command
+	journal *audit.Journal
 }
 
 // New creates a new service instance.
 func New(ctx context.Context, socketPath string, sched scheduler.Scheduler, cpuInfo cpuinfo.Provider) *service {
 	return &service{
-		ctx:        ctx,
-		SocketPath: socketPath,
-		scheduler:  sched,
-		cpuInfo:    cpuInfo,
+		ctx:          ctx,
+		SocketPath:   socketPath,
+		scheduler:    sched,
+		cpuInfo:      cpuInfo,
+		scanTopology: cpuinfo.ScanTopology,
+		rounds:       config.DefaultRounds,
+		iterations:   config.DefaultIterations,
+		recalc:       cpuinfo.Recalculation{State: cpuinfo.RecalculationIdle},
+		listening:    make(chan struct{}),
+		started:      time.Now(),
+		journal:      audit.Default,
 	}
 }
 
-// Start runs the socket listener.
-func (s *service) Start() error {
+// SetMeasurement sets the rounds and iterations of a recalculation that
+// does not override them.
+func (s *service) SetMeasurement(rounds, iterations int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rounds = rounds
+	s.iterations = iterations
+}
+
+// SetReloader sets the function the reload-config command calls.
+func (s *service) SetReloader(reload func() (*config.ReloadResult, error)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reload = reload
+}
+
+// UseListener makes Start serve listener (e.g. passed by systemd socket
+// activation) instead of creating the socket.
+func (s *service) UseListener(listener net.Listener) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listener = listener
+}
+
+// Listening is closed once Start accepts connections.
+func (s *service) Listening() <-chan struct{} {
+	return s.listening
+}
+
+// listen creates the socket, an existing one is replaced.
+func (s *service) listen() (net.Listener, error) {
 	// Remove existing socket if it exists
 	if _, err := os.Stat(s.SocketPath); err == nil {
 		if err := os.Remove(s.SocketPath); err != nil {
-			return fmt.Errorf("failed to remove existing socket: %w", err)
+			return nil, fmt.Errorf("failed to remove existing socket: %w", err)
 		}
 	}
 
 	listener, err := net.Listen("unix", s.SocketPath)
 	if err != nil {
-		return fmt.Errorf("failed to listen on socket %s: %w", s.SocketPath, err)
+		return nil, fmt.Errorf("failed to listen on socket %s: %w", s.SocketPath, err)
 	}
 
 	// Set restrictive permissions so only root can access it
 	if err := os.Chmod(s.SocketPath, 0600); err != nil {
 		_ = listener.Close()
-		return fmt.Errorf("failed to chmod socket: %w", err)
+		return nil, fmt.Errorf("failed to chmod socket: %w", err)
+	}
+	return listener, nil
+}
+
+// Start runs the socket listener.
+func (s *service) Start() error {
+	s.mu.Lock()
+	listener := s.listener
+	s.mu.Unlock()
+	if listener == nil {
+		var err error
+		if listener, err = s.listen(); err != nil {
+			return err
+		}
 	}
 
+	// HTTP connections are sniffed by handleConnection and handed over
+	httpConns := newConnListener(listener.Addr())
+	httpServer := s.newHTTPServer()
+
 	s.mu.Lock()
 	s.listener = listener
+	s.httpConns = httpConns
+	s.httpServer = httpServer
 	s.mu.Unlock()
-	slog.Info("Starting socket service", "socket", s.SocketPath)
+	slog.Info("Starting socket service", "socket", listener.Addr().String())
+	close(s.listening)
+
+	go func() {
+		if err := httpServer.Serve(httpConns); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("HTTP API failed", "error", err)
+		}
+	}()
 
 	for {
 		conn, err := listener.Accept()
@@ -92,26 +187,48 @@ func (s *service) Shutdown(ctx context.Context) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	if s.listener != nil {
-		return s.listener.Close()
+		if err := s.listener.Close(); err != nil {
+			return err
+		}
+	}
+	for _, server := range []*http.Server{s.remoteServer, s.metricsServer} {
+		if server == nil {
+			continue
+		}
+		if err := server.Shutdown(ctx); err != nil {
+			return err
+		}
+	}
+	if s.httpServer != nil {
+		return s.httpServer.Shutdown(ctx)
 	}
 	return nil
 }
 
 func (s *service) handleConnection(ctx context.Context, conn net.Conn) {
+	// Set a deadline for the interaction
+	_ = conn.SetDeadline(time.Now().Add(config.ConstantSocketTimeout))
+
+	// HTTP requests start with the method, legacy requests with a JSON object
+	r := bufio.NewReader(conn)
+	if first, err := r.Peek(1); err == nil && isHTTPMethodStart(first[0]) && s.httpConns != nil {
+		s.httpConns.handOver(&bufferedConn{Conn: conn, r: r})
+		return
+	}
+
 	defer func() {
 		_ = conn.Close()
 	}()
 
-	// Set a deadline for the interaction
-	_ = conn.SetDeadline(time.Now().Add(config.ConstantSocketTimeout))
-
 	var req Request
-	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+	if err := json.NewDecoder(r).Decode(&req); err != nil {
 		if errors.Is(err, io.EOF) {
 			//slog.Debug("Connection closed by client (EOF)")
 			return
 		}
 		slog.Error("Failed to decode request", "error", err)
+		metrics.Failures.Inc(metrics.ReasonBadRequest)
+		events.Failure(metrics.ReasonBadRequest, 0, err)
 		resp := Response{
 			Status: "error",
 			Error:  fmt.Sprintf("failed to decode request: %v", err),
@@ -120,49 +237,140 @@ func (s *service) handleConnection(ctx context.Context, conn net.Conn) {
 		return
 	}
 
-	var resp Response
+	if req.Command == "watch" {
+		s.watch(ctx, conn, r)
+		return
+	}
+
+	resp := s.execute(ctx, req)
+	if err := json.NewEncoder(conn).Encode(resp); err != nil {
+		slog.Error("Failed to encode response", "error", err)
+	}
+}
+
+// execute runs a command of the legacy protocol, the HTTP API maps its
+// endpoints to the same commands.
+func (s *service) execute(ctx context.Context, req Request) (resp Response) {
+	start := time.Now()
+	command := req.Command
+	defer func() { observeRequest(command, resp.Status, start) }()
+
 	switch req.Command {
 	case "update-affinity":
 		result, err := s.scheduler.UpdateAffinity(ctx, req.VMID)
 		if err != nil {
 			resp.Status = "error"
 			resp.Error = err.Error()
+		} else {
+			resp.Status = "ok"
+			resp.Data = result
+			// While warming up the source is "topology", the VM is re-placed
+			// once the measured ranking is available.
+			if snapshot, err := s.cpuInfo.GetSnapshot(); err == nil {
+				resp.Meta = &snapshot.Meta
+			}
+		}
+	case "recalculate":
+		recalc, err := s.startRecalculation(req.Rounds, req.Iterations)
+		if err != nil {
+			resp.Status = "error"
+			resp.Error = err.Error()
+		} else {
+			resp.Status = "ok"
+			resp.Data = recalc
+		}
+	case "recalculate-status":
+		resp.Status = "ok"
+		resp.Data = s.recalculation()
+	case "health":
+		resp.Status = "ok"
+		resp.Data, resp.Meta = s.health()
+	case "history":
+		entries, err := s.history(req)
+		if err != nil {
+			resp.Status = "error"
+			resp.Error = err.Error()
+		} else {
+			resp.Status = "ok"
+			resp.Data = entries
+		}
+	case "reload-config":
+		s.mu.Lock()
+		reload := s.reload
+		s.mu.Unlock()
+		if reload == nil {
+			resp.Status = "error"
+			resp.Error = "configuration reload is not supported"
+			break
+		}
+		result, err := reload()
+		if err != nil {
+			resp.Status = "error"
+			resp.Error = fmt.Sprintf("failed to reload configuration: %v", err)
 		} else {
 			resp.Status = "ok"
 			resp.Data = result
 		}
 	case "ping":
 		slog.Debug("ping received")
+		// Legacy clients expect "ok" and "pong", the warm-up is reported by
+		// the source of the ranking in meta ("topology").
 		resp.Status = "ok"
 		resp.Data = "pong"
+		if snapshot, err := s.cpuInfo.GetSnapshot(); err == nil {
+			resp.Meta = &snapshot.Meta
+		}
 	case "core-ranking":
-		ranking, err := s.cpuInfo.GetCoreRanking()
+		snapshot, err := s.cpuInfo.GetSnapshot()
 		if err != nil {
 			resp.Status = "error"
 			resp.Error = err.Error()
 		} else {
 			resp.Status = "ok"
-			resp.Data = ranking
+			resp.Data = snapshot.Rankings
+			resp.Meta = &snapshot.Meta
 		}
 	case "core-ranking-summary":
-		ranking, err := s.cpuInfo.GetCoreRanking()
+		snapshot, err := s.cpuInfo.GetSnapshot()
 		if err != nil {
 			resp.Status = "error"
 			resp.Error = err.Error()
 		} else {
 			resp.Status = "ok"
-			resp.Data = cpuinfo.SummarizeRankings(ranking)
+			resp.Data = cpuinfo.SummarizeRankings(snapshot.Rankings)
+			resp.Meta = &snapshot.Meta
+		}
+	case "core-clusters":
+		snapshot, err := s.cpuInfo.GetSnapshot()
+		if err != nil {
+			resp.Status = "error"
+			resp.Error = err.Error()
+		} else {
+			resp.Status = "ok"
+			resp.Data = snapshot.Clusters
+			resp.Meta = &snapshot.Meta
+		}
+	case "core-topology":
+		topology, err := s.scanTopology()
+		if err != nil {
+			resp.Status = "error"
+			resp.Error = err.Error()
+		} else {
+			resp.Status = "ok"
+			resp.Data = topology
 		}
 	case "core-vm-affinity":
 		selections := s.cpuInfo.GetSelections()
 		resp.Status = "ok"
 		resp.Data = selections
+		if snapshot, err := s.cpuInfo.GetSnapshot(); err == nil {
+			resp.Meta = &snapshot.Meta
+		}
 	default:
+		command = "unknown" // keep the label cardinality bounded
 		resp.Status = "error"
 		resp.Error = fmt.Sprintf("unknown command: %s", req.Command)
 	}
 
-	if err := json.NewEncoder(conn).Encode(resp); err != nil {
-		slog.Error("Failed to encode response", "error", err)
-	}
+	return resp
 }
@@ -3,6 +3,7 @@ package service
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"os"
@@ -12,7 +13,9 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 
+	"github.com/egandro/proxmox-cpu-affinity/pkg/config"
 	"github.com/egandro/proxmox-cpu-affinity/pkg/cpuinfo"
 )
 
@@ -31,13 +34,23 @@ type MockCpuInfo struct {
 	mock.Mock
 }
 
-func (m *MockCpuInfo) Update(rounds int, iterations int, onProgress func(int, int)) error {
-	args := m.Called(rounds, iterations, onProgress)
+func (m *MockCpuInfo) Update(ctx context.Context, rounds int, iterations int, onProgress func(int, int)) error {
+	args := m.Called(ctx, rounds, iterations, onProgress)
 	return args.Error(0)
 }
 
-func (m *MockCpuInfo) CalculateRanking(rounds, iterations int, timeout time.Duration) error {
-	args := m.Called(rounds, iterations, timeout)
+func (m *MockCpuInfo) UpdateIncremental(ctx context.Context, rounds int, iterations int, onProgress func(int, int)) error {
+	args := m.Called(ctx, rounds, iterations, onProgress)
+	return args.Error(0)
+}
+
+func (m *MockCpuInfo) CalculateRanking(ctx context.Context, rounds, iterations int, timeout time.Duration) error {
+	args := m.Called(ctx, rounds, iterations, timeout)
+	return args.Error(0)
+}
+
+func (m *MockCpuInfo) CalculateRankingIncremental(ctx context.Context, rounds, iterations int, timeout time.Duration) error {
+	args := m.Called(ctx, rounds, iterations, timeout)
 	return args.Error(0)
 }
 
@@ -49,14 +62,56 @@ func (m *MockCpuInfo) GetCoreRanking() ([]cpuinfo.CoreRanking, error) {
 	return args.Get(0).([]cpuinfo.CoreRanking), args.Error(1)
 }
 
-func (m *MockCpuInfo) SelectCPUs(vmid int, requestedCPUs int) ([]int, error) {
+func (m *MockCpuInfo) SelectCPUs(vmid int, requestedCPUs int) (cpuinfo.Selection, error) {
 	args := m.Called(vmid, requestedCPUs)
-	return args.Get(0).([]int), args.Error(1)
+	return args.Get(0).(cpuinfo.Selection), args.Error(1)
+}
+
+func (m *MockCpuInfo) GetSelections() map[int]cpuinfo.Selection {
+	args := m.Called()
+	return args.Get(0).(map[int]cpuinfo.Selection)
+}
+
+func (m *MockCpuInfo) UseTopologyFallback() error {
+	args := m.Called()
+	return args.Error(0)
+}
+
+func (m *MockCpuInfo) Status() cpuinfo.RankingStatus {
+	args := m.Called()
+	return args.Get(0).(cpuinfo.RankingStatus)
 }
 
-func (m *MockCpuInfo) GetSelections() map[int][]int {
+func (m *MockCpuInfo) TakeProvisional() []int {
 	args := m.Called()
-	return args.Get(0).(map[int][]int)
+	if args.Get(0) == nil {
+		return nil
+	}
+	return args.Get(0).([]int)
+}
+
+func (m *MockCpuInfo) Recalculate(ctx context.Context, rounds, iterations int, timeout time.Duration) (<-chan error, error) {
+	args := m.Called(ctx, rounds, iterations, timeout)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(chan error), args.Error(1)
+}
+
+func (m *MockCpuInfo) GetSnapshot() (*cpuinfo.Snapshot, error) {
+	args := m.Called()
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*cpuinfo.Snapshot), args.Error(1)
+}
+
+func (m *MockCpuInfo) ReplaceLostCPUs() []cpuinfo.SelectionChange {
+	args := m.Called()
+	if args.Get(0) == nil {
+		return nil
+	}
+	return args.Get(0).([]cpuinfo.SelectionChange)
 }
 
 func (m *MockCpuInfo) DetectTopology() ([]cpuinfo.CoreInfo, error) {
@@ -67,7 +122,8 @@ func (m *MockCpuInfo) DetectTopology() ([]cpuinfo.CoreInfo, error) {
 	return args.Get(0).([]cpuinfo.CoreInfo), args.Error(1)
 }
 
-func setupTestService(t *testing.T) (*MockScheduler, *MockCpuInfo, string) {
+// setupTestService starts a service with mocks, opts modify it before it is started.
+func setupTestService(t *testing.T, opts ...func(*service)) (*MockScheduler, *MockCpuInfo, string) {
 	// 1. Create a temporary socket path
 	tmpDir := t.TempDir()
 	// t.TempDir() creates a unique directory for each test, so a fixed filename is safe.
@@ -81,6 +137,9 @@ func setupTestService(t *testing.T) (*MockScheduler, *MockCpuInfo, string) {
 
 	// 4. Create and Start Service
 	svc := New(t.Context(), socketPath, mockSched, mockCpuInfo)
+	for _, opt := range opts {
+		opt(svc)
+	}
 
 	// Start in a goroutine
 	errChan := make(chan error, 1)
@@ -112,7 +171,8 @@ func setupTestService(t *testing.T) (*MockScheduler, *MockCpuInfo, string) {
 }
 
 func TestService_UpdateAffinity(t *testing.T) {
-	mockSched, _, socketPath := setupTestService(t)
+	mockSched, mockCpuInfo, socketPath := setupTestService(t)
+	mockCpuInfo.On("GetSnapshot").Return(&cpuinfo.Snapshot{Meta: cpuinfo.RankingMeta{Generation: 2, Source: cpuinfo.SourceMeasured}}, nil)
 
 	expectedResult := map[string]interface{}{
 		"vmid":   100,
@@ -202,7 +262,9 @@ func TestService_HandleConnection_EOF(t *testing.T) {
 }
 
 func TestService_Ping(t *testing.T) {
-	_, _, socketPath := setupTestService(t)
+	_, mockCpuInfo, socketPath := setupTestService(t)
+	mockCpuInfo.On("Status").Return(cpuinfo.RankingStatus{State: cpuinfo.StateReady, Source: cpuinfo.SourceDerived, Virtualized: true})
+	mockCpuInfo.On("GetSnapshot").Return(&cpuinfo.Snapshot{Meta: cpuinfo.RankingMeta{Generation: 1, Source: cpuinfo.SourceDerived, Virtualized: true}}, nil)
 
 	conn, err := net.Dial("unix", socketPath)
 	assert.NoError(t, err)
@@ -218,6 +280,56 @@ func TestService_Ping(t *testing.T) {
 
 	assert.Equal(t, "ok", resp.Status)
 	assert.Equal(t, "pong", resp.Data)
+	require.NotNil(t, resp.Meta)
+	assert.True(t, resp.Meta.Virtualized)
+	assert.Equal(t, cpuinfo.SourceDerived, resp.Meta.Source)
+}
+
+func TestService_Ping_WarmingUp(t *testing.T) {
+	_, mockCpuInfo, socketPath := setupTestService(t)
+	mockCpuInfo.On("GetSnapshot").Return(&cpuinfo.Snapshot{Meta: cpuinfo.RankingMeta{Generation: 1, Source: cpuinfo.SourceTopology}}, nil)
+
+	conn, err := net.Dial("unix", socketPath)
+	assert.NoError(t, err)
+	defer func() { _ = conn.Close() }()
+
+	req := Request{Command: "ping"}
+	err = json.NewEncoder(conn).Encode(req)
+	assert.NoError(t, err)
+
+	var resp Response
+	err = json.NewDecoder(conn).Decode(&resp)
+	assert.NoError(t, err)
+
+	// Legacy clients only check the status and the pong
+	assert.Equal(t, "ok", resp.Status)
+	assert.Equal(t, "pong", resp.Data)
+	require.NotNil(t, resp.Meta)
+	assert.Equal(t, cpuinfo.SourceTopology, resp.Meta.Source)
+}
+
+func TestService_UpdateAffinity_WarmingUp(t *testing.T) {
+	mockSched, mockCpuInfo, socketPath := setupTestService(t)
+	mockCpuInfo.On("GetSnapshot").Return(&cpuinfo.Snapshot{Meta: cpuinfo.RankingMeta{Generation: 1, Source: cpuinfo.SourceTopology}}, nil)
+	mockSched.On("UpdateAffinity", mock.Anything, 100).Return(map[string]interface{}{"action": "new affinity: 0-1"}, nil)
+
+	conn, err := net.Dial("unix", socketPath)
+	assert.NoError(t, err)
+	defer func() { _ = conn.Close() }()
+
+	req := Request{Command: "update-affinity", VMID: 100}
+	err = json.NewEncoder(conn).Encode(req)
+	assert.NoError(t, err)
+
+	var resp Response
+	err = json.NewDecoder(conn).Decode(&resp)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "ok", resp.Status)
+	assert.NotNil(t, resp.Data)
+	require.NotNil(t, resp.Meta)
+	assert.Equal(t, cpuinfo.SourceTopology, resp.Meta.Source)
+	mockSched.AssertExpectations(t)
 }
 
 func TestService_CoreRanking(t *testing.T) {
@@ -227,7 +339,10 @@ func TestService_CoreRanking(t *testing.T) {
 		{CPU: 0, Ranking: []cpuinfo.Neighbor{}},
 		{CPU: 1, Ranking: []cpuinfo.Neighbor{}},
 	}
-	mockCpuInfo.On("GetCoreRanking").Return(expectedRanking, nil)
+	mockCpuInfo.On("GetSnapshot").Return(&cpuinfo.Snapshot{
+		Meta:     cpuinfo.RankingMeta{Generation: 3, Source: cpuinfo.SourceMeasured, Rounds: 2, Iterations: 100},
+		Rankings: expectedRanking,
+	}, nil)
 
 	conn, err := net.Dial("unix", socketPath)
 	assert.NoError(t, err)
@@ -250,9 +365,35 @@ func TestService_CoreRanking(t *testing.T) {
 	assert.NoError(t, err)
 	assert.JSONEq(t, string(expectedBytes), string(dataBytes))
 
+	if assert.NotNil(t, resp.Meta) {
+		assert.Equal(t, uint64(3), resp.Meta.Generation)
+		assert.Equal(t, cpuinfo.SourceMeasured, resp.Meta.Source)
+		assert.Equal(t, 2, resp.Meta.Rounds)
+	}
+
 	mockCpuInfo.AssertExpectations(t)
 }
 
+func TestService_CoreRanking_Empty(t *testing.T) {
+	_, mockCpuInfo, socketPath := setupTestService(t)
+	mockCpuInfo.On("GetSnapshot").Return(nil, fmt.Errorf("cache is empty"))
+
+	conn, err := net.Dial("unix", socketPath)
+	assert.NoError(t, err)
+	defer func() { _ = conn.Close() }()
+
+	req := Request{Command: "core-ranking"}
+	err = json.NewEncoder(conn).Encode(req)
+	assert.NoError(t, err)
+
+	var resp Response
+	err = json.NewDecoder(conn).Decode(&resp)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "error", resp.Status)
+	assert.Nil(t, resp.Meta)
+}
+
 func TestService_CoreRankingSummary(t *testing.T) {
 	_, mockCpuInfo, socketPath := setupTestService(t)
 
@@ -260,7 +401,10 @@ func TestService_CoreRankingSummary(t *testing.T) {
 		{CPU: 0, Ranking: []cpuinfo.Neighbor{}},
 		{CPU: 1, Ranking: []cpuinfo.Neighbor{}},
 	}
-	mockCpuInfo.On("GetCoreRanking").Return(expectedRanking, nil)
+	mockCpuInfo.On("GetSnapshot").Return(&cpuinfo.Snapshot{
+		Meta:     cpuinfo.RankingMeta{Generation: 3, Source: cpuinfo.SourceMeasured, Rounds: 2, Iterations: 100},
+		Rankings: expectedRanking,
+	}, nil)
 
 	conn, err := net.Dial("unix", socketPath)
 	assert.NoError(t, err)
@@ -287,14 +431,82 @@ func TestService_CoreRankingSummary(t *testing.T) {
 	mockCpuInfo.AssertExpectations(t)
 }
 
+func TestService_CoreClusters(t *testing.T) {
+	_, mockCpuInfo, socketPath := setupTestService(t)
+
+	expectedClusters := []cpuinfo.ClusterLevel{
+		{Level: 1, MaxLatencyNS: 10, Clusters: []cpuinfo.LatencyCluster{
+			{CPUs: []int{0, 2}, LatencyNS: 10},
+			{CPUs: []int{1, 3}, LatencyNS: 10},
+		}},
+	}
+	mockCpuInfo.On("GetSnapshot").Return(&cpuinfo.Snapshot{
+		Meta:     cpuinfo.RankingMeta{Generation: 4},
+		Clusters: expectedClusters,
+	}, nil)
+
+	conn, err := net.Dial("unix", socketPath)
+	assert.NoError(t, err)
+	defer func() { _ = conn.Close() }()
+
+	req := Request{Command: "core-clusters"}
+	err = json.NewEncoder(conn).Encode(req)
+	assert.NoError(t, err)
+
+	var resp Response
+	err = json.NewDecoder(conn).Decode(&resp)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "ok", resp.Status)
+	dataBytes, err := json.Marshal(resp.Data)
+	assert.NoError(t, err)
+	expectedBytes, err := json.Marshal(expectedClusters)
+	assert.NoError(t, err)
+	assert.JSONEq(t, string(expectedBytes), string(dataBytes))
+	if assert.NotNil(t, resp.Meta) {
+		assert.Equal(t, uint64(4), resp.Meta.Generation)
+	}
+
+	mockCpuInfo.AssertExpectations(t)
+}
+
+func TestService_CoreTopology(t *testing.T) {
+	expected := []cpuinfo.CoreInfo{
+		{CPU: 0, Socket: 0, Core: 0, Node: 0, Die: 0, Cluster: -1, L2: 0, L3: 0, Online: true},
+		{CPU: 1, Socket: -1, Core: -1, Node: -1, Die: -1, Cluster: -1, L2: -1, L3: -1, Online: false},
+	}
+	_, _, socketPath := setupTestService(t, func(s *service) {
+		s.scanTopology = func() ([]cpuinfo.CoreInfo, error) { return expected, nil }
+	})
+
+	conn, err := net.Dial("unix", socketPath)
+	assert.NoError(t, err)
+	defer func() { _ = conn.Close() }()
+
+	err = json.NewEncoder(conn).Encode(Request{Command: "core-topology"})
+	assert.NoError(t, err)
+
+	var resp Response
+	err = json.NewDecoder(conn).Decode(&resp)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "ok", resp.Status)
+	dataBytes, err := json.Marshal(resp.Data)
+	assert.NoError(t, err)
+	expectedBytes, err := json.Marshal(expected)
+	assert.NoError(t, err)
+	assert.JSONEq(t, string(expectedBytes), string(dataBytes))
+}
+
 func TestService_CoreVMAffinity(t *testing.T) {
 	_, mockCpuInfo, socketPath := setupTestService(t)
 
-	expectedSelections := map[int][]int{
-		100: {0, 1},
-		101: {2, 3},
+	expectedSelections := map[int]cpuinfo.Selection{
+		100: {CPUs: []int{0, 1}, Generation: 1},
+		101: {CPUs: []int{2, 3}, Generation: 2},
 	}
 	mockCpuInfo.On("GetSelections").Return(expectedSelections)
+	mockCpuInfo.On("GetSnapshot").Return(&cpuinfo.Snapshot{Meta: cpuinfo.RankingMeta{Generation: 2}}, nil)
 
 	conn, err := net.Dial("unix", socketPath)
 	assert.NoError(t, err)
@@ -338,3 +550,52 @@ func TestService_UnknownCommand(t *testing.T) {
 	assert.Equal(t, "error", resp.Status)
 	assert.Contains(t, resp.Error, "unknown command")
 }
+
+func TestService_ReloadConfig(t *testing.T) {
+	var svc *service
+	_, _, socketPath := setupTestService(t, func(s *service) { svc = s })
+
+	resp := send(t, socketPath, Request{Command: "reload-config"})
+	assert.Equal(t, "error", resp.Status)
+	assert.Equal(t, "configuration reload is not supported", resp.Error)
+
+	svc.SetReloader(func() (*config.ReloadResult, error) {
+		return &config.ReloadResult{Applied: []string{"PCA_LOG_LEVEL"}, RestartRequired: []string{"PCA_SOCKET_FILE"}}, nil
+	})
+	resp = send(t, socketPath, Request{Command: "reload-config"})
+	assert.Equal(t, "ok", resp.Status)
+	data, ok := resp.Data.(map[string]interface{})
+	require.True(t, ok)
+	assert.Equal(t, []interface{}{"PCA_LOG_LEVEL"}, data["applied"])
+	assert.Equal(t, []interface{}{"PCA_SOCKET_FILE"}, data["restart_required"])
+
+	svc.SetReloader(func() (*config.ReloadResult, error) { return nil, errors.New("no such file") })
+	resp = send(t, socketPath, Request{Command: "reload-config"})
+	assert.Equal(t, "error", resp.Status)
+	assert.Equal(t, "failed to reload configuration: no such file", resp.Error)
+}
+
+func TestService_UseListener(t *testing.T) {
+	// e.g. passed by systemd socket activation, the socket path of the service is not used
+	socketPath := filepath.Join(t.TempDir(), "activated.sock")
+	listener, err := net.Listen("unix", socketPath)
+	require.NoError(t, err)
+
+	var svc *service
+	_, mockCpuInfo, unusedPath := setupTestService(t, func(s *service) {
+		s.UseListener(listener)
+		svc = s
+	})
+	mockCpuInfo.On("Status").Return(cpuinfo.RankingStatus{State: cpuinfo.StateReady})
+	mockCpuInfo.On("GetSnapshot").Return(&cpuinfo.Snapshot{}, nil)
+
+	select {
+	case <-svc.Listening():
+	case <-time.After(time.Second):
+		t.Fatal("service is not listening")
+	}
+	assert.NoFileExists(t, unusedPath)
+
+	resp := send(t, socketPath, Request{Command: "ping"})
+	assert.Equal(t, "pong", resp.Data)
+}
@@ -0,0 +1,79 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/egandro/proxmox-cpu-affinity/pkg/events"
+)
+
+// watch streams events as newline-delimited JSON until the client closes the
+// connection or the service is shut down. The first line is a Response, so a
+// client can tell an unknown command from an empty stream.
+func (s *service) watch(ctx context.Context, conn net.Conn, r io.Reader) {
+	start := time.Now()
+	_ = conn.SetDeadline(time.Time{})
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// The client sends nothing after the request, EOF means it is gone
+	go func() {
+		_, _ = io.Copy(io.Discard, r)
+		cancel()
+	}()
+
+	ch, unsubscribe := events.Default.Subscribe()
+	defer unsubscribe()
+
+	enc := json.NewEncoder(conn)
+	if err := enc.Encode(Response{Status: "ok", Data: "watching"}); err != nil {
+		return
+	}
+	observeRequest("watch", "ok", start)
+	slog.Debug("Event subscriber connected")
+
+	streamEvents(ctx, ch, enc, func() {})
+	slog.Debug("Event subscriber disconnected")
+}
+
+// handleEvents streams events as newline-delimited JSON over HTTP.
+func (s *service) handleEvents(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	// The stream outlives the timeouts of the server
+	rc := http.NewResponseController(w)
+	_ = rc.SetReadDeadline(time.Time{})
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	ch, unsubscribe := events.Default.Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	_ = rc.Flush()
+	observeRequest("watch", "ok", start)
+
+	streamEvents(r.Context(), ch, json.NewEncoder(w), func() { _ = rc.Flush() })
+}
+
+// streamEvents writes the events of ch until ctx is done or a write fails.
+func streamEvents(ctx context.Context, ch <-chan events.Event, enc *json.Encoder, flush func()) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e, ok := <-ch:
+			if !ok {
+				return
+			}
+			if err := enc.Encode(e); err != nil {
+				return
+			}
+			flush()
+		}
+	}
+}
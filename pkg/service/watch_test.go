@@ -0,0 +1,106 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/egandro/proxmox-cpu-affinity/pkg/events"
+)
+
+// waitForSubscriber publishes probe events until the stream delivers one,
+// the subscription is registered before the response is written but the
+// test can't tell when that happened.
+func waitForSubscriber(t *testing.T, dec *json.Decoder) {
+	t.Helper()
+	received := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-received:
+				return
+			case <-time.After(10 * time.Millisecond):
+				events.Publish(events.Event{Type: "probe"})
+			}
+		}
+	}()
+	defer close(received)
+
+	var e events.Event
+	require.NoError(t, dec.Decode(&e))
+	require.Equal(t, "probe", e.Type)
+}
+
+// nextEvent skips remaining probe events.
+func nextEvent(t *testing.T, dec *json.Decoder) events.Event {
+	t.Helper()
+	for {
+		var e events.Event
+		require.NoError(t, dec.Decode(&e))
+		if e.Type != "probe" {
+			return e
+		}
+	}
+}
+
+func TestService_Watch(t *testing.T) {
+	_, _, socketPath := setupTestService(t)
+
+	conn, err := net.Dial("unix", socketPath)
+	require.NoError(t, err)
+	defer func() { _ = conn.Close() }()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	require.NoError(t, json.NewEncoder(conn).Encode(Request{Command: "watch"}))
+	dec := json.NewDecoder(conn)
+	var resp Response
+	require.NoError(t, dec.Decode(&resp))
+	assert.Equal(t, "ok", resp.Status)
+	waitForSubscriber(t, dec)
+
+	events.Publish(events.Event{Type: events.TypeReleased, VMID: 100})
+	e := nextEvent(t, dec)
+	assert.Equal(t, events.TypeReleased, e.Type)
+	assert.Equal(t, 100, e.VMID)
+
+	events.Publish(events.Event{Type: events.TypeRankingRecalculated, Generation: 4, Source: "measured"})
+	e = nextEvent(t, dec)
+	assert.Equal(t, events.TypeRankingRecalculated, e.Type)
+	assert.Equal(t, uint64(4), e.Generation)
+}
+
+func TestHTTP_Events(t *testing.T) {
+	_, _, socketPath := setupTestService(t)
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socketPath)
+		},
+	}}
+	defer client.CloseIdleConnections()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost/v1/events", nil)
+	require.NoError(t, err)
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	defer func() { _ = resp.Body.Close() }()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/x-ndjson", resp.Header.Get("Content-Type"))
+
+	dec := json.NewDecoder(bufio.NewReader(resp.Body))
+	waitForSubscriber(t, dec)
+
+	events.Failure("vm-pid", 100, nil)
+	e := nextEvent(t, dec)
+	assert.Equal(t, events.TypeError, e.Type)
+	assert.Equal(t, "vm-pid", e.Reason)
+}
@@ -0,0 +1,119 @@
+// Package systemd implements the parts of the systemd service protocol used
+// by the service without linking libsystemd: readiness and status
+// notifications (sd_notify(3)), watchdog keepalives and socket activation
+// (sd_listen_fds(3)).
+package systemd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Notification states, see sd_notify(3).
+const (
+	Ready    = "READY=1"
+	Stopping = "STOPPING=1"
+	Watchdog = "WATCHDOG=1"
+)
+
+// listenFDsStart is the first file descriptor passed by socket activation.
+const listenFDsStart = 3
+
+// Status returns the notification of a free-form status text.
+func Status(text string) string {
+	return "STATUS=" + text
+}
+
+// NotifyEnabled reports if the service manager listens for notifications
+// (Type=notify or NotifyAccess in the unit).
+func NotifyEnabled() bool {
+	return os.Getenv("NOTIFY_SOCKET") != ""
+}
+
+// Notify sends state to the service manager. It returns false without an
+// error if the service was not started with a notification socket.
+func Notify(state string) (bool, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false, nil
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = conn.Close() }()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// WatchdogInterval returns the interval the service manager expects
+// keepalives in (WatchdogSec of the unit), 0 if the watchdog is disabled.
+func WatchdogInterval() (time.Duration, error) {
+	usec := os.Getenv("WATCHDOG_USEC")
+	if usec == "" {
+		return 0, nil
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		// meant for another process
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(usec, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid WATCHDOG_USEC: %q", usec)
+	}
+	return time.Duration(n) * time.Microsecond, nil
+}
+
+// Listeners returns the sockets passed by socket activation in the order of
+// the socket unit, nil if there are none. The LISTEN_* variables are unset,
+// so the commands run by the service (qm, taskset, ...) don't inherit them.
+func Listeners() ([]net.Listener, error) {
+	return listeners(listenFDsStart)
+}
+
+func listeners(start int) ([]net.Listener, error) {
+	defer func() {
+		_ = os.Unsetenv("LISTEN_PID")
+		_ = os.Unsetenv("LISTEN_FDS")
+		_ = os.Unsetenv("LISTEN_FDNAMES")
+	}()
+
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS: %q", os.Getenv("LISTEN_FDS"))
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	var result []net.Listener
+	for i := 0; i < n; i++ {
+		fd := start + i
+		syscall.CloseOnExec(fd)
+		name := "fd" + strconv.Itoa(fd)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+
+		// FileListener duplicates the descriptor
+		f := os.NewFile(uintptr(fd), name)
+		l, err := net.FileListener(f)
+		_ = f.Close()
+		if err != nil {
+			for _, l := range result {
+				_ = l.Close()
+			}
+			return nil, fmt.Errorf("passed socket %s is not a listener: %w", name, err)
+		}
+		result = append(result, l)
+	}
+	return result, nil
+}
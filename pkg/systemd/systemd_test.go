@@ -0,0 +1,98 @@
+package systemd
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNotify(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	assert.False(t, NotifyEnabled())
+	sent, err := Notify(Ready)
+	assert.NoError(t, err)
+	assert.False(t, sent)
+
+	socket := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	require.NoError(t, err)
+	defer func() { _ = conn.Close() }()
+
+	t.Setenv("NOTIFY_SOCKET", socket)
+	assert.True(t, NotifyEnabled())
+	sent, err = Notify(Status("warming up"))
+	require.NoError(t, err)
+	assert.True(t, sent)
+
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "STATUS=warming up", string(buf[:n]))
+
+	t.Setenv("NOTIFY_SOCKET", filepath.Join(t.TempDir(), "missing.sock"))
+	_, err = Notify(Ready)
+	assert.Error(t, err)
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	t.Setenv("WATCHDOG_USEC", "")
+	t.Setenv("WATCHDOG_PID", "")
+	interval, err := WatchdogInterval()
+	assert.NoError(t, err)
+	assert.Zero(t, interval)
+
+	t.Setenv("WATCHDOG_USEC", "30000000")
+	interval, err = WatchdogInterval()
+	assert.NoError(t, err)
+	assert.Equal(t, 30*time.Second, interval)
+
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()+1))
+	interval, err = WatchdogInterval()
+	assert.NoError(t, err)
+	assert.Zero(t, interval)
+
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("WATCHDOG_USEC", "soon")
+	_, err = WatchdogInterval()
+	assert.Error(t, err)
+}
+
+func TestListeners(t *testing.T) {
+	l, err := net.Listen("unix", filepath.Join(t.TempDir(), "activated.sock"))
+	require.NoError(t, err)
+	defer func() { _ = l.Close() }()
+	// The passed descriptor is owned by listeners, like the ones of systemd
+	f, err := l.(*net.UnixListener).File()
+	require.NoError(t, err)
+	fd := int(f.Fd())
+	defer func() { _ = f.Close() }()
+	passedFD, err := syscall.Dup(fd)
+	require.NoError(t, err)
+
+	// Passed to another process
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	t.Setenv("LISTEN_FDS", "1")
+	passed, err := listeners(passedFD)
+	assert.NoError(t, err)
+	assert.Nil(t, passed)
+
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "1")
+	t.Setenv("LISTEN_FDNAMES", "proxmox-cpu-affinity.socket")
+	passed, err = listeners(passedFD)
+	require.NoError(t, err)
+	require.Len(t, passed, 1)
+	defer func() { _ = passed[0].Close() }()
+	assert.Equal(t, l.Addr().String(), passed[0].Addr().String())
+
+	// The variables are not inherited by child processes
+	_, ok := os.LookupEnv("LISTEN_FDS")
+	assert.False(t, ok)
+}
@@ -0,0 +1,34 @@
+// Package version reports the build version of the binaries.
+package version
+
+import "runtime/debug"
+
+// Version is set at build time by the Makefile
+// (-ldflags "-X github.com/egandro/proxmox-cpu-affinity/pkg/version.Version=0.0.10").
+var Version = "dev"
+
+// String returns Version with the VCS revision the binary was built from, if known.
+func String() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return Version
+	}
+	var revision, modified string
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			revision = setting.Value
+		case "vcs.modified":
+			if setting.Value == "true" {
+				modified = "-dirty"
+			}
+		}
+	}
+	if revision == "" {
+		return Version
+	}
+	if len(revision) > 7 {
+		revision = revision[:7]
+	}
+	return Version + " (" + revision + modified + ")"
+}
@@ -0,0 +1,12 @@
+package version
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestString(t *testing.T) {
+	assert.True(t, strings.HasPrefix(String(), Version))
+}